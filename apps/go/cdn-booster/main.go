@@ -22,6 +22,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/valyala/ybc/bindings/go/ybc"
@@ -73,17 +74,15 @@ func (ph *ProxyHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 
 	key := []byte(req.RequestURI)
-	item, err := ph.Cache.GetDeItem(key, time.Second)
+	item, err := ph.Cache.GetOrLoad(key, time.Second, ybc.MaxTtl, func(w io.Writer) error {
+		return fetchFromUpstream(w, ph.UpstreamHost, key)
+	})
 	if err != nil {
-		if err != ybc.ErrCacheMiss {
-			log.Fatalf("Unexpeced error=[%s] when obtaining cache value by key=[%s]\n", err, key)
-		}
-
-		item = fetchFromUpstream(ph.Cache, w, ph.UpstreamHost, key)
-		if item == nil {
-			w.WriteHeader(http.StatusServiceUnavailable)
-			return
+		if err != errUpstream {
+			log.Printf("Error=[%s] when obtaining cache value by key=[%s]\n", err, key)
 		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
 	}
 	defer item.Close()
 
@@ -103,65 +102,59 @@ func (ph *ProxyHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func fetchFromUpstream(cache ybc.Cacher, w http.ResponseWriter, upstreamHost string, key []byte) *ybc.Item {
+var errUpstream = errors.New("cannot fetch the item from upstream")
+
+func fetchFromUpstream(w io.Writer, upstreamHost string, key []byte) error {
 	requestUrl := fmt.Sprintf("http://%s%s", upstreamHost, key)
 	resp, err := http.Get(requestUrl)
 	if err != nil {
 		log.Printf("Error=[%s] when doing request to %s\n", err, requestUrl)
-		return nil
+		return errUpstream
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		log.Printf("Unexpected status code=[%d]. request to %s\n", resp.StatusCode, requestUrl)
-		return nil
+		return errUpstream
 	}
 
 	contentLength := resp.Header.Get("Content-Length")
 	if contentLength == "" {
 		log.Printf("Cannot cache response for requestUrl=[%s] without content-length\n", requestUrl)
-		return nil
+		return errUpstream
 	}
 	contentLengthN, err := strconv.Atoi(contentLength)
 	if err != nil {
 		log.Printf("Error=[%s] when parsing contentLength=[%s] for request to [%s]\n", err, contentLength, requestUrl)
-		return nil
+		return errUpstream
 	}
 
 	contentType := resp.Header.Get("Content-Type")
 	if contentType == "" {
 		contentType = "application/octet-stream"
 	}
-	itemSize := contentLengthN + len(contentType) + 1
-	txn, err := cache.NewSetTxn(key, itemSize, ybc.MaxTtl)
-	if err != nil {
+	// Stream the response directly to the cache and reject responses,
+	// which don't fit the cache, before reading them.
+	itemSize := 1 + len(contentType) + contentLengthN
+	if err = w.(ybc.LoadWriter).SetValueSize(itemSize); err != nil {
 		log.Printf("Error=[%s] when starting set txn for key=[%s]. itemSize=[%d]\n", err, key, itemSize)
-		return nil
+		return errUpstream
 	}
-
-	if err = storeContentType(txn, contentType); err != nil {
+	if err = storeContentType(w, contentType); err != nil {
 		log.Printf("Cannot store content-type for key=[%s] in cache\n", key)
-		txn.Rollback()
-		return nil
+		return errUpstream
 	}
 
-	n, err := txn.ReadFrom(resp.Body)
+	n, err := io.Copy(w, resp.Body)
 	if err != nil {
 		log.Printf("Error=[%s] when copying body with size=%d to cache. key=[%s]\n", err, contentLengthN, key)
-		txn.Rollback()
-		return nil
+		return errUpstream
 	}
 	if n != int64(contentLengthN) {
 		log.Printf("Unexpected number of bytes copied=%d from response to requestUrl=[%s]. Expected %d\n", n, requestUrl, contentLengthN)
-		txn.Rollback()
-		return nil
-	}
-	item, err := txn.CommitItem()
-	if err != nil {
-		log.Printf("Error=[%s] when commiting set txn for key=[%s]\n", err, key)
-		return nil
+		return errUpstream
 	}
-	return item
+	return nil
 }
 
 func storeContentType(w io.Writer, contentType string) (err error) {
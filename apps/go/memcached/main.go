@@ -13,6 +13,8 @@
 //     at github.com/valyala/ybc/libs/go/memcache for details.
 //   * Support for 'conditional get' command - see Client.Cget()
 //     at github.com/valyala/ybc/libs/go/memcache for details.
+//
+// The server can export metrics in Prometheus text format via -metricsAddr.
 package main
 
 import (
@@ -20,6 +22,7 @@ import (
 	"github.com/valyala/ybc/bindings/go/ybc"
 	"github.com/valyala/ybc/libs/go/memcache"
 	"log"
+	"net/http"
 	"runtime"
 	"strings"
 	"time"
@@ -42,6 +45,7 @@ var (
 	hotItemsCount     = flag.Uint64("hotItemsCount", 0, "The number of hot items. 0 disables hot items optimization")
 	listenAddr        = flag.String("listenAddr", ":11211", "TCP address the server will listen to")
 	maxItemsCount     = flag.Uint64("maxItemsCount", 1000*1000, "Maximum number of items the server can cache")
+	metricsAddr       = flag.String("metricsAddr", "", "TCP address for serving Prometheus metrics at /metrics. Leave empty for disabling metrics")
 	syncInterval      = flag.Duration("syncInterval", time.Second*10, "Interval for data syncing. 0 disables data syncing")
 	osReadBufferSize  = flag.Int("osReadBufferSize", 224*1024, "Buffer size in bytes for incoming requests in OS")
 	osWriteBufferSize = flag.Int("osWriteBufferSize", 224*1024, "Buffer size in bytes for outgoing responses in OS")
@@ -110,7 +114,25 @@ func main() {
 		OSWriteBufferSize: *osWriteBufferSize,
 	}
 	log.Printf("Starting the server")
-	if err := s.Serve(); err != nil {
+	s.Start()
+	if *metricsAddr != "" {
+		go serveMetrics(&s, *metricsAddr)
+	}
+	if err := s.Wait(); err != nil {
 		log.Fatalf("Cannot serve traffic: [%s]", err)
 	}
 }
+
+func serveMetrics(s *memcache.Server, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := s.WriteMetrics(w); err != nil {
+			log.Printf("Cannot write metrics: [%s]", err)
+		}
+	})
+	log.Printf("Serving metrics at [%s/metrics]", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Fatalf("Cannot serve metrics at [%s]: [%s]", addr, err)
+	}
+}
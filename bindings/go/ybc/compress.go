@@ -0,0 +1,318 @@
+package ybc
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+)
+
+// Compression algorithm for items' values. See Config.Compression.
+type Compression int
+
+const (
+	// Values are stored as is.
+	CompressionNone = Compression(iota)
+
+	// Values are compressed with Snappy block format.
+	// It is fast, but has moderate compression ratio.
+	CompressionSnappy
+
+	// Values are compressed with deflate (RFC 1951) at the best speed level.
+	// It has better compression ratio than CompressionSnappy, but is slower.
+	CompressionFlate
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionSnappy:
+		return "snappy"
+	case CompressionFlate:
+		return "flate"
+	}
+	return "unknown"
+}
+
+/*******************************************************************************
+ * Compressed value format.
+ *
+ * The codec is stored in the encoded value header. See Cache.encode().
+ * The compressed value depends on the codec:
+ *   * codecRaw    - the value as is.
+ *   * codecSnappy - Snappy block format, which starts with uvarint value size.
+ *   * codecFlate  - size:uvarint deflate stream.
+ *
+ * Values, which cannot be compressed, are stored with codecRaw, so
+ * they don't grow. Since the codec is stored per item, caches may contain
+ * items compressed with distinct codecs.
+ ******************************************************************************/
+
+const (
+	codecRaw    = byte(0)
+	codecSnappy = byte(1)
+	codecFlate  = byte(2)
+
+	// Values smaller than this size are stored with codecRaw, since
+	// they barely compress.
+	minCompressSize = 64
+)
+
+func compressValue(c Compression, value []byte) (codec byte, buf []byte) {
+	if len(value) >= minCompressSize && c != CompressionNone {
+		switch c {
+		case CompressionSnappy:
+			codec = codecSnappy
+			buf = snappyEncode(make([]byte, 0, len(value)), value)
+		case CompressionFlate:
+			codec = codecFlate
+			buf = flateEncode(make([]byte, 0, len(value)), value)
+		}
+		if len(buf) < len(value) {
+			return
+		}
+	}
+	return codecRaw, value
+}
+
+func decompressValue(codec byte, buf []byte) ([]byte, error) {
+	switch codec {
+	case codecRaw:
+		return buf, nil
+	case codecSnappy:
+		return snappyDecode(buf)
+	case codecFlate:
+		return flateDecode(buf)
+	}
+	return nil, ErrCorrupted
+}
+
+/*******************************************************************************
+ * Flate codec.
+ ******************************************************************************/
+
+// The maximum compression ratio for deflate.
+// See http://www.zlib.net/zlib_tech.html .
+const maxFlateRatio = 1032
+
+const flateWritersPoolSize = 64
+
+// flate.Writer allocates big internal buffers, so cache them.
+var flateWritersPool = make(chan *flate.Writer, flateWritersPoolSize)
+
+func flateEncode(dst, src []byte) []byte {
+	dst = appendUvarint(dst, uint64(len(src)))
+	w := &appendWriter{
+		buf: dst,
+	}
+
+	var fw *flate.Writer
+	select {
+	case fw = <-flateWritersPool:
+		fw.Reset(w)
+	default:
+		fw, _ = flate.NewWriter(w, flate.BestSpeed)
+	}
+	// Writes to appendWriter cannot fail.
+	fw.Write(src)
+	fw.Close()
+	select {
+	case flateWritersPool <- fw:
+	default:
+	}
+	return w.buf
+}
+
+func flateDecode(src []byte) ([]byte, error) {
+	size, n := binary.Uvarint(src)
+	if n <= 0 || size > uint64(len(src))*maxFlateRatio {
+		return nil, ErrCorrupted
+	}
+	dst := make([]byte, int(size))
+	// bytes.Reader implements io.ByteReader, so flate reader doesn't read
+	// past the end of deflate stream. This allows detecting trailing garbage.
+	r := bytes.NewReader(src[n:])
+	fr := flate.NewReader(r)
+	defer fr.Close()
+	if _, err := io.ReadFull(fr, dst); err != nil {
+		return nil, ErrCorrupted
+	}
+	var tail [1]byte
+	if nn, err := fr.Read(tail[:]); nn != 0 || err != io.EOF || r.Len() != 0 {
+		return nil, ErrCorrupted
+	}
+	return dst, nil
+}
+
+type appendWriter struct {
+	buf []byte
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+/*******************************************************************************
+ * Snappy codec.
+ *
+ * Implements Snappy block format - see
+ * https://github.com/google/snappy/blob/master/format_description.txt .
+ * The encoder is a simplified greedy version of the original encoder,
+ * so it compresses slightly worse.
+ ******************************************************************************/
+
+const (
+	snappyTagLiteral = 0
+	snappyTagCopy1   = 1
+	snappyTagCopy2   = 2
+	snappyTagCopy4   = 3
+
+	snappyMinMatch  = 4
+	snappyMaxOffset = 1<<16 - 1
+	snappyHashBits  = 14
+
+	// The maximum compression ratio for Snappy block format: copy
+	// of 64 bytes occupies 3 bytes.
+	maxSnappyRatio = 22
+)
+
+func snappyEncode(dst, src []byte) []byte {
+	dst = appendUvarint(dst, uint64(len(src)))
+
+	var table [1 << snappyHashBits]int32
+	s, lit := 0, 0
+	for s+snappyMinMatch <= len(src) {
+		x := binary.LittleEndian.Uint32(src[s:])
+		h := (x * 0x1e35a7bd) >> (32 - snappyHashBits)
+		c := int(table[h])
+		table[h] = int32(s)
+		if c >= s || s-c > snappyMaxOffset || binary.LittleEndian.Uint32(src[c:]) != x {
+			// Skip incompressible data faster.
+			s += 1 + (s-lit)>>5
+			continue
+		}
+
+		n := snappyMinMatch
+		for s+n < len(src) && src[c+n] == src[s+n] {
+			n++
+		}
+		dst = snappyEmitLiteral(dst, src[lit:s])
+		dst = snappyEmitCopy(dst, s-c, n)
+		s += n
+		lit = s
+	}
+	return snappyEmitLiteral(dst, src[lit:])
+}
+
+func snappyEmitLiteral(dst, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+	n := uint32(len(lit) - 1)
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n<<2)|snappyTagLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|snappyTagLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|snappyTagLiteral, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, lit...)
+}
+
+func snappyEmitCopy(dst []byte, offset, length int) []byte {
+	for length >= 68 {
+		dst = append(dst, 63<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+		length -= 64
+	}
+	if length > 64 {
+		dst = append(dst, 59<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+		length -= 60
+	}
+	if length >= 12 || offset >= 2048 {
+		return append(dst, byte(length-1)<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+	}
+	return append(dst, byte(offset>>8)<<5|byte(length-4)<<2|snappyTagCopy1, byte(offset))
+}
+
+func snappyDecode(src []byte) ([]byte, error) {
+	size, s := binary.Uvarint(src)
+	if s <= 0 || size > uint64(len(src))*maxSnappyRatio {
+		return nil, ErrCorrupted
+	}
+	dst := make([]byte, int(size))
+	d := 0
+	for s < len(src) {
+		tag := src[s]
+		var offset, length int
+		switch tag & 3 {
+		case snappyTagLiteral:
+			x := int(tag >> 2)
+			s++
+			if x >= 60 {
+				n := x - 59
+				if n > len(src)-s {
+					return nil, ErrCorrupted
+				}
+				x = 0
+				for i := n - 1; i >= 0; i-- {
+					x = x<<8 | int(src[s+i])
+				}
+				s += n
+			}
+			length = x + 1
+			if length > len(dst)-d || length > len(src)-s {
+				return nil, ErrCorrupted
+			}
+			copy(dst[d:], src[s:s+length])
+			d += length
+			s += length
+			continue
+		case snappyTagCopy1:
+			if len(src)-s < 2 {
+				return nil, ErrCorrupted
+			}
+			length = 4 + int(tag>>2)&7
+			offset = int(tag&0xe0)<<3 | int(src[s+1])
+			s += 2
+		case snappyTagCopy2:
+			if len(src)-s < 3 {
+				return nil, ErrCorrupted
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[s+1:]))
+			s += 3
+		case snappyTagCopy4:
+			if len(src)-s < 5 {
+				return nil, ErrCorrupted
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[s+1:]))
+			s += 5
+		}
+		if offset <= 0 || offset > d || length > len(dst)-d {
+			return nil, ErrCorrupted
+		}
+		// The copy may overlap with its' source, so copy byte by byte.
+		for i := 0; i < length; i++ {
+			dst[d+i] = dst[d-offset+i]
+		}
+		d += length
+	}
+	if d != len(dst) {
+		return nil, ErrCorrupted
+	}
+	return dst, nil
+}
+
+func appendUvarint(dst []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], x)
+	return append(dst, buf[:n]...)
+}
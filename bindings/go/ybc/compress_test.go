@@ -0,0 +1,378 @@
+package ybc
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+var compressions = []Compression{CompressionSnappy, CompressionFlate}
+
+func compressTestValues() [][]byte {
+	random := make([]byte, 100*1000)
+	rand.New(rand.NewSource(0)).Read(random)
+	return [][]byte{
+		[]byte{},
+		[]byte("short value"),
+		bytes.Repeat([]byte("a"), 100*1000),
+		bytes.Repeat([]byte("foobar baz "), 1000),
+		[]byte(fmt.Sprintf("%v", random[:1000])),
+		random,
+	}
+}
+
+func TestCompressValue(t *testing.T) {
+	for _, c := range append(compressions, CompressionNone) {
+		for _, value := range compressTestValues() {
+			codec, buf := compressValue(c, value)
+			if len(buf) > len(value) {
+				t.Fatalf("compression=%s: compressed value size=%d exceeds value size=%d", c, len(buf), len(value))
+			}
+			v, err := decompressValue(codec, buf)
+			if err != nil {
+				t.Fatalf("compression=%s: unexpected error=[%s]", c, err)
+			}
+			checkValue(t, value, v)
+		}
+	}
+}
+
+func TestCompressValue_Ratio(t *testing.T) {
+	value := bytes.Repeat([]byte("foobar baz "), 1000)
+	for _, c := range compressions {
+		_, buf := compressValue(c, value)
+		if len(buf) > len(value)/10 {
+			t.Fatalf("compression=%s: too big compressed value size=%d for value size=%d", c, len(buf), len(value))
+		}
+	}
+}
+
+func TestDecompressValue_Corrupted(t *testing.T) {
+	expectCorrupted := func(codec byte, buf []byte) {
+		if _, err := decompressValue(codec, buf); err != ErrCorrupted {
+			t.Fatalf("Unexpected error=[%v] for codec=%d, buf=%v. Expected [%s]", err, codec, buf, ErrCorrupted)
+		}
+	}
+
+	expectCorrupted(123, nil)
+	for _, c := range compressions {
+		value := bytes.Repeat([]byte("foobar baz "), 100)
+		codec, buf := compressValue(c, value)
+		expectCorrupted(codec, nil)
+		expectCorrupted(codec, buf[:len(buf)-1])
+		expectCorrupted(codec, append(buf, 0))
+		for i := 0; i < len(buf); i++ {
+			corrupted := append([]byte{}, buf...)
+			corrupted[i] ^= 0xff
+			// Corrupted values mustn't crash the decoder.
+			if v, err := decompressValue(codec, corrupted); err == nil && bytes.Equal(v, value) {
+				t.Fatalf("compression=%s: corruption at offset %d isn't detected", c, i)
+			}
+		}
+	}
+}
+
+func newCompressedCache(t *testing.T, c Compression) *Cache {
+	config := newConfig()
+	config.Compression = c
+	cache, err := config.OpenCache(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache
+}
+
+func TestCache_Compression(t *testing.T) {
+	for _, c := range compressions {
+		cache := newCompressedCache(t, c)
+		defer cache.Close()
+
+		for i, value := range compressTestValues() {
+			key := []byte(fmt.Sprintf("%d_key", i))
+			if err := cache.Set(key, value, MaxTtl); err != nil {
+				t.Fatal(err)
+			}
+			v, err := cache.Get(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkValue(t, value, v)
+
+			item, err := cache.GetItem(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if item.Size() != len(value) {
+				t.Fatalf("compression=%s: unexpected item size=%d. Expected %d", c, item.Size(), len(value))
+			}
+			buf := &bytes.Buffer{}
+			if _, err = item.WriteTo(buf); err != nil {
+				t.Fatal(err)
+			}
+			checkValue(t, value, buf.Bytes())
+			item.Close()
+
+			item, err = cache.SetItem(key, value, MaxTtl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if item.Size() != len(value) {
+				t.Fatalf("compression=%s: unexpected item size=%d. Expected %d", c, item.Size(), len(value))
+			}
+			checkValue(t, value, item.Value())
+			item.Close()
+		}
+	}
+}
+
+func TestCache_Compression_SetTxn(t *testing.T) {
+	for _, c := range compressions {
+		cache := newCompressedCache(t, c)
+		defer cache.Close()
+
+		key := []byte("key")
+		value := bytes.Repeat([]byte("foobar baz "), 1000)
+		txn, err := cache.NewSetTxn(key, len(value), MaxTtl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = txn.ReadFrom(bytes.NewReader(value)); err != nil {
+			t.Fatal(err)
+		}
+		if err = txn.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		v, err := cache.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkValue(t, value, v)
+
+		// truncated commit
+		txn, err = cache.NewSetTxn(key, len(value), MaxTtl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = txn.Write(value[:100]); err != nil {
+			t.Fatal(err)
+		}
+		item, err := txn.CommitItemTruncated()
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkValue(t, value[:100], item.Value())
+		version := item.Version()
+		item.Close()
+
+		// conditional commits
+		txn, err = cache.NewSetTxn(key, len(value), MaxTtl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		txn.Write(value)
+		if err = txn.CommitAdd(); err != ErrAlreadyExists {
+			t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrAlreadyExists)
+		}
+		txn, err = cache.NewSetTxn(key, len(value), MaxTtl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		txn.Write(value)
+		if err = txn.CompareAndCommit(version); err != nil {
+			t.Fatal(err)
+		}
+		v, err = cache.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkValue(t, value, v)
+
+		// rollback
+		txn, err = cache.NewSetTxn([]byte("aaa"), len(value), MaxTtl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		txn.Write(value)
+		txn.Rollback()
+		if _, err = cache.Get([]byte("aaa")); err != ErrCacheMiss {
+			t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+		}
+	}
+}
+
+func TestCache_Compression_Reopen(t *testing.T) {
+	config := newConfig()
+	config.IndexFile = "foobar.index.compression_reopen"
+	config.DataFile = "foobar.data.compression_reopen"
+	defer config.RemoveCache()
+
+	values := append(compressTestValues(), []byte(valueHeaderMagic+"value with header magic"))
+	allCompressions := append(compressions, CompressionNone)
+	config.EnableValueHeaders = true
+	for i, c := range allCompressions {
+		config.Compression = c
+		cache := openCacheWithConfig(t, config)
+		for j, value := range values {
+			key := []byte(fmt.Sprintf("%d_%d_key", i, j))
+			if err := cache.Set(key, value, MaxTtl); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cache.Close()
+	}
+
+	// Items stored with any compression must be readable with any compression.
+	for _, c := range allCompressions {
+		config.Compression = c
+		cache := openCacheWithConfig(t, config)
+		for i := range allCompressions {
+			for j, value := range values {
+				key := []byte(fmt.Sprintf("%d_%d_key", i, j))
+				v, err := cache.Get(key)
+				if err != nil {
+					t.Fatalf("compression=%s: cannot obtain item stored with compression=%s: [%s]", c, allCompressions[i], err)
+				}
+				checkValue(t, value, v)
+
+				vs := cache.GetMulti([][]byte{key}, nil)
+				checkValue(t, value, vs[0])
+			}
+		}
+		cache.Close()
+	}
+}
+
+func TestCache_ValueHeaderMagic_NoCodecs(t *testing.T) {
+	cache := newCache(t)
+	defer cache.Close()
+
+	// Values starting with the header magic must be returned as is
+	// in caches without codecs.
+	key := []byte("key")
+	value := []byte(valueHeaderMagic + "\x10value")
+	txn, err := cache.NewSetTxn(key, len(value), MaxTtl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = txn.Write(value); err != nil {
+		t.Fatal(err)
+	}
+	if err = txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	v, err := cache.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, value, v)
+	checkValue(t, value, cache.GetMulti([][]byte{key}, nil)[0])
+
+	sc := newSimpleCache(100, t)
+	defer sc.Close()
+	if err = sc.Set(key, value, MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	if v, err = sc.Get(key); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, value, v)
+}
+
+func TestCache_Compression_Incr(t *testing.T) {
+	for _, c := range compressions {
+		cache := newCompressedCache(t, c)
+		defer cache.Close()
+
+		key := []byte("counter")
+		if err := cache.Set(key, []byte("10"), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		value, err := cache.Incr(key, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != 15 {
+			t.Fatalf("Unexpected value=%d. Expected 15", value)
+		}
+		if value, err = cache.Decr(key, 20); err != nil {
+			t.Fatal(err)
+		}
+		if value != 0 {
+			t.Fatalf("Unexpected value=%d. Expected 0", value)
+		}
+		v, err := cache.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkValue(t, []byte("0"), v)
+
+		if err = cache.Set(key, []byte("foobar"), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = cache.Incr(key, 1); err != ErrNotNumeric {
+			t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrNotNumeric)
+		}
+		if _, err = cache.Incr([]byte("missing"), 1); err != ErrCacheMiss {
+			t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+		}
+	}
+}
+
+func TestCache_Compression_Export_Import(t *testing.T) {
+	value := bytes.Repeat([]byte("foobar baz "), 100)
+	src := newCompressedCache(t, CompressionSnappy)
+	defer src.Close()
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("%d_key", i))
+		if err := src.Set(key, value, MaxTtl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := src.Export(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newCompressedCache(t, CompressionFlate)
+	defer dst.Close()
+	if err := dst.Import(buf); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	dst.Iterate(func(key []byte, item *Item) bool {
+		checkValue(t, value, item.Value())
+		n++
+		return true
+	})
+	if n != 100 {
+		t.Fatalf("Unexpected number of imported items=%d. Expected 100", n)
+	}
+}
+
+func TestCache_Compression_GetMulti(t *testing.T) {
+	for _, c := range compressions {
+		cache := newCompressedCache(t, c)
+		defer cache.Close()
+
+		values := compressTestValues()
+		var items []KV
+		var keys [][]byte
+		for i, value := range values {
+			key := []byte(fmt.Sprintf("%d_key", i))
+			items = append(items, KV{Key: key, Value: value})
+			keys = append(keys, key)
+		}
+		if err := cache.SetMulti(items, MaxTtl); err != nil {
+			t.Fatal(err)
+		}
+		actualValues := cache.GetMulti(keys, nil)
+		if len(actualValues) != len(values) {
+			t.Fatalf("compression=%s: unexpected number of values=%d. Expected %d", c, len(actualValues), len(values))
+		}
+		for i, value := range values {
+			checkValue(t, value, actualValues[i])
+		}
+	}
+}
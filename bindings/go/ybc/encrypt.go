@@ -0,0 +1,49 @@
+package ybc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+)
+
+/*******************************************************************************
+ * Encrypted value format.
+ *
+ * value := nonce ciphertext
+ *
+ * ciphertext is AES-GCM sealed original value with the item's key
+ * as additional authenticated data. nonce is random.
+ ******************************************************************************/
+
+func newAead(encryptionKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, ErrBadKeySize
+	}
+	return cipher.NewGCM(block)
+}
+
+func encryptValue(aead cipher.AEAD, key, value []byte) []byte {
+	nonceSize := aead.NonceSize()
+	buf := make([]byte, nonceSize, nonceSize+len(value)+aead.Overhead())
+	if _, err := rand.Read(buf); err != nil {
+		panic("ybc: cannot generate random nonce")
+	}
+	return aead.Seal(buf, buf, value, key)
+}
+
+// Returns ErrCacheMiss if the value cannot be authenticated, since
+// such values are usually stored with another encryption key.
+func decryptValue(aead cipher.AEAD, key, buf []byte) ([]byte, error) {
+	nonceSize := aead.NonceSize()
+	if len(buf) < nonceSize+aead.Overhead() {
+		return nil, ErrCacheMiss
+	}
+	nonce := buf[:nonceSize]
+	ciphertext := buf[nonceSize:]
+	value, err := aead.Open(make([]byte, 0, len(ciphertext)), nonce, ciphertext, key)
+	if err != nil {
+		return nil, ErrCacheMiss
+	}
+	return value, nil
+}
@@ -0,0 +1,204 @@
+package ybc
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+func newEncryptionConfig(name string, encryptionKey []byte) *Config {
+	config := newConfig()
+	config.IndexFile = "foobar.index." + name
+	config.DataFile = "foobar.data." + name
+	config.EncryptionKey = encryptionKey
+	return config
+}
+
+func openCacheWithConfig(t *testing.T, config *Config) *Cache {
+	cache, err := config.OpenCache(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache
+}
+
+func TestCache_Encryption(t *testing.T) {
+	for _, c := range append(compressions, CompressionNone) {
+		for _, keySize := range []int{16, 24, 32} {
+			config := newEncryptionConfig("encryption", bytes.Repeat([]byte("k"), keySize))
+			config.Compression = c
+			cache := openCacheWithConfig(t, config)
+
+			for i, value := range compressTestValues() {
+				key := []byte(fmt.Sprintf("%d_key", i))
+				if err := cache.Set(key, value, MaxTtl); err != nil {
+					t.Fatal(err)
+				}
+				v, err := cache.Get(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkValue(t, value, v)
+
+				item, err := cache.GetItem(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if item.Size() != len(value) {
+					t.Fatalf("Unexpected item size=%d. Expected %d", item.Size(), len(value))
+				}
+				item.Close()
+			}
+
+			counter := []byte("counter")
+			if err := cache.Set(counter, []byte("123"), MaxTtl); err != nil {
+				t.Fatal(err)
+			}
+			n, err := cache.Incr(counter, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 124 {
+				t.Fatalf("Unexpected counter value=%d. Expected 124", n)
+			}
+			cache.Close()
+			config.RemoveCache()
+		}
+	}
+}
+
+func TestCache_Encryption_Plaintext(t *testing.T) {
+	config := newEncryptionConfig("encryption_plaintext", []byte("0123456789abcdef"))
+	defer config.RemoveCache()
+
+	key := []byte("key")
+	value := []byte("secret value")
+	cache := openCacheWithConfig(t, config)
+	if err := cache.Set(key, value, MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	cache.Close()
+
+	data, err := ioutil.ReadFile(config.DataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, value) {
+		t.Fatalf("The value=[%s] is stored in plaintext", value)
+	}
+
+	// Encrypted items are invisible without the encryption key.
+	config.EncryptionKey = nil
+	config.EnableValueHeaders = true
+	cache = openCacheWithConfig(t, config)
+	defer cache.Close()
+	if _, err = cache.Get(key); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
+	if vs := cache.GetMulti([][]byte{key}, nil); vs[0] != nil {
+		t.Fatalf("Unexpected value=[%s] for encrypted item", vs[0])
+	}
+	cache.Iterate(func(key []byte, item *Item) bool {
+		t.Fatalf("Unexpected item with key=[%s]", key)
+		return false
+	})
+}
+
+func TestCache_Encryption_KeyMismatch(t *testing.T) {
+	config := newEncryptionConfig("encryption_key_mismatch", nil)
+	defer config.RemoveCache()
+
+	// unencrypted item
+	cache := openCacheWithConfig(t, config)
+	if err := cache.Set([]byte("aaa"), []byte("plaintext"), MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	cache.Close()
+
+	// encrypted item
+	config.EncryptionKey = []byte("0123456789abcdef")
+	cache = openCacheWithConfig(t, config)
+	if _, err := cache.Get([]byte("aaa")); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
+	if err := cache.Set([]byte("bbb"), []byte("secret"), MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	cache.Close()
+
+	// another encryption key
+	config.EncryptionKey = []byte("fedcba9876543210")
+	cache = openCacheWithConfig(t, config)
+	defer cache.Close()
+	for _, key := range []string{"aaa", "bbb"} {
+		if _, err := cache.Get([]byte(key)); err != ErrCacheMiss {
+			t.Fatalf("Unexpected error=[%v] for key=[%s]. Expected [%s]", err, key, ErrCacheMiss)
+		}
+	}
+	cache.Iterate(func(key []byte, item *Item) bool {
+		t.Fatalf("Unexpected item with key=[%s]", key)
+		return false
+	})
+}
+
+func TestCache_Encryption_SwappedValues(t *testing.T) {
+	config := newEncryptionConfig("encryption_swapped_values", []byte("0123456789abcdef"))
+	defer config.RemoveCache()
+
+	cache := openCacheWithConfig(t, config)
+	defer cache.Close()
+	if err := cache.Set([]byte("aaa"), []byte("value"), MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+
+	// Copy the encrypted value to another key.
+	if err := cache.setEncoded([]byte("bbb"), cache.encode([]byte("aaa"), []byte("value")), MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cache.Get([]byte("bbb")); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
+	v, err := cache.Get([]byte("aaa"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, []byte("value"), v)
+}
+
+func TestCache_Encryption_BadKeySize(t *testing.T) {
+	config := newConfig()
+	config.EncryptionKey = []byte("short key")
+	if _, err := config.OpenCache(true); err != ErrBadKeySize {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrBadKeySize)
+	}
+}
+
+func TestSimpleCache_Encryption(t *testing.T) {
+	config := newEncryptionConfig("simple_cache_encryption", []byte("0123456789abcdef"))
+	defer config.RemoveCache()
+
+	if _, err := config.OpenSimpleCache(1000, true); err != ErrEncryptionUnsupported {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrEncryptionUnsupported)
+	}
+
+	key := []byte("key")
+	cache := openCacheWithConfig(t, config)
+	if err := cache.Set(key, []byte("secret value"), MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	cache.Close()
+
+	// Encrypted items are invisible in SimpleCache.
+	config.EncryptionKey = nil
+	config.EnableValueHeaders = true
+	sc, err := config.OpenSimpleCache(1000, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	if _, err = sc.Get(key); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
+}
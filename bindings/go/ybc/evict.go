@@ -0,0 +1,88 @@
+package ybc
+
+/*
+#include "ybc.h"
+#include <stdint.h> // uintptr_t
+
+void go_config_set_evict_callback(struct ybc_config *config,
+    uintptr_t callback_id);
+*/
+import "C"
+
+import (
+	"sync"
+)
+
+// Reason for item eviction passed to Config.OnEvict.
+type EvictReason int
+
+const (
+	// The item has been overwritten by newer items, since the cache
+	// is a ring buffer.
+	EvictWrap = EvictReason(C.YBC_EVICT_WRAP)
+
+	// The item has been expired.
+	EvictExpired = EvictReason(C.YBC_EVICT_EXPIRED)
+
+	// The item has been deleted via Delete() call.
+	EvictDelete = EvictReason(C.YBC_EVICT_REMOVE)
+
+	// The item has been deleted via Clear() call.
+	EvictClear = EvictReason(C.YBC_EVICT_CLEAR)
+)
+
+func (reason EvictReason) String() string {
+	switch reason {
+	case EvictWrap:
+		return "wrap"
+	case EvictExpired:
+		return "expired"
+	case EvictDelete:
+		return "delete"
+	case EvictClear:
+		return "clear"
+	}
+	return "unknown"
+}
+
+/*******************************************************************************
+ * Evict callbacks' registry.
+ *
+ * Go functions cannot be passed to C, so C code refers to evict callbacks
+ * via ids in the registry.
+ ******************************************************************************/
+
+var (
+	evictCallbacksLock   sync.Mutex
+	evictCallbacks       = make(map[uintptr]func(key []byte, reason EvictReason))
+	evictCallbacksLastId uintptr
+)
+
+func setEvictCallback(c *configInternal, f func(key []byte, reason EvictReason)) (callbackId uintptr) {
+	evictCallbacksLock.Lock()
+	evictCallbacksLastId++
+	callbackId = evictCallbacksLastId
+	evictCallbacks[callbackId] = f
+	evictCallbacksLock.Unlock()
+
+	C.go_config_set_evict_callback(c.ctx, C.uintptr_t(callbackId))
+	return
+}
+
+func removeEvictCallback(callbackId uintptr) {
+	if callbackId == 0 {
+		return
+	}
+	evictCallbacksLock.Lock()
+	delete(evictCallbacks, callbackId)
+	evictCallbacksLock.Unlock()
+}
+
+//export goEvictCallback
+func goEvictCallback(callbackId C.uintptr_t, key *C.struct_ybc_key, reason C.enum_ybc_evict_reason) {
+	evictCallbacksLock.Lock()
+	f := evictCallbacks[uintptr(callbackId)]
+	evictCallbacksLock.Unlock()
+
+	f(C.GoBytes(key.ptr, C.int(key.size)), EvictReason(reason))
+}
@@ -0,0 +1,277 @@
+package ybc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+var (
+	ErrExportCorrupted   = errors.New("ybc: corrupted export stream")
+	ErrExportUnsupported = errors.New("ybc: unsupported export stream version")
+)
+
+/*******************************************************************************
+ * Export stream format.
+ *
+ * The stream doesn't depend on cache geometry (Config.MaxItemsCount,
+ * Config.DataFileSize), so it can be imported into arbitrary cache.
+ *
+ * All numbers are little-endian.
+ *
+ * stream := header record* end
+ * header := "ybcdump\x00" version:uint32
+ * record := recordItem keySize:uint32 valueSize:uint64 ttl:uint64 key value crc:uint32
+ * end    := recordEnd itemsCount:uint64 crc:uint32
+ *
+ * ttl is the remaining time to live in milliseconds at export time.
+ * crc is CRC-32 (IEEE) of all the preceding bytes in the record including
+ * record type.
+ ******************************************************************************/
+
+const (
+	exportVersion = 1
+
+	exportRecordItem = byte(1)
+	exportRecordEnd  = byte(0)
+
+	exportItemHeaderSize = 1 + 4 + 8 + 8
+	exportEndSize        = 1 + 8
+	exportCrcSize        = 4
+)
+
+var exportMagic = []byte("ybcdump\x00")
+
+// Writes all the live items from the cache to w.
+//
+// The written stream can be loaded into arbitrary cache via Cache.Import().
+// Unlike a copy of cache files, the stream doesn't depend on cache geometry,
+// so it may be used for moving cache contents between hosts and caches
+// with distinct Config.MaxItemsCount and Config.DataFileSize.
+//
+// Export doesn't block concurrent cache operations. Items added, deleted
+// or evicted during the export may be either skipped or exported.
+func (cache *Cache) Export(w io.Writer) error {
+	return exportItems(cache, w)
+}
+
+// Loads items from the stream written by Cache.Export() into the cache.
+//
+// Items are stored with the remaining ttl they had at export time.
+// Items, which cannot be stored in the cache due to ErrNoSpace, are skipped.
+// So are items with values bigger than Config.DataFileSize.
+//
+// Returns ErrExportCorrupted if the stream is truncated or corrupted.
+// Items loaded before the corruption is detected remain in the cache.
+func (cache *Cache) Import(r io.Reader) error {
+	dataFileSize := cache.Occupancy().DataFileSize
+	return importItems(cache, r, func(key []byte) uint64 {
+		return dataFileSize
+	})
+}
+
+// See Cache.Export()
+func (cluster *Cluster) Export(w io.Writer) error {
+	return exportItems(cluster, w)
+}
+
+// See Cache.Import()
+//
+// Items with values bigger than Config.DataFileSize of the cache responsible
+// for item's key are skipped.
+func (cluster *Cluster) Import(r io.Reader) error {
+	dataFileSizes := make([]uint64, len(cluster.caches))
+	for i, cache := range cluster.caches {
+		dataFileSizes[i] = cache.Occupancy().DataFileSize
+	}
+	return importItems(cluster, r, func(key []byte) uint64 {
+		return dataFileSizes[cluster.cacheIndex(key)]
+	})
+}
+
+func exportItems(cache fullCacher, w io.Writer) (err error) {
+	bw := bufio.NewWriter(w)
+
+	var buf [exportItemHeaderSize]byte
+	copy(buf[:], exportMagic)
+	binary.LittleEndian.PutUint32(buf[len(exportMagic):], exportVersion)
+	if _, err = bw.Write(buf[:len(exportMagic)+4]); err != nil {
+		return
+	}
+
+	h := crc32.NewIEEE()
+	cw := io.MultiWriter(bw, h)
+	itemsCount := uint64(0)
+	cache.Iterate(func(key []byte, item *Item) bool {
+		h.Reset()
+		buf[0] = exportRecordItem
+		binary.LittleEndian.PutUint32(buf[1:], uint32(len(key)))
+		binary.LittleEndian.PutUint64(buf[5:], uint64(item.Size()))
+		binary.LittleEndian.PutUint64(buf[13:], item.ttlMillis())
+		if _, err = cw.Write(buf[:exportItemHeaderSize]); err != nil {
+			return false
+		}
+		if _, err = cw.Write(key); err != nil {
+			return false
+		}
+		if _, err = item.WriteTo(cw); err != nil {
+			return false
+		}
+		if err = writeExportCrc(bw, h); err != nil {
+			return false
+		}
+		itemsCount++
+		return true
+	})
+	if err != nil {
+		return
+	}
+
+	h.Reset()
+	buf[0] = exportRecordEnd
+	binary.LittleEndian.PutUint64(buf[1:], itemsCount)
+	if _, err = cw.Write(buf[:exportEndSize]); err != nil {
+		return
+	}
+	if err = writeExportCrc(bw, h); err != nil {
+		return
+	}
+	return bw.Flush()
+}
+
+func writeExportCrc(w io.Writer, h hash.Hash32) error {
+	var buf [exportCrcSize]byte
+	binary.LittleEndian.PutUint32(buf[:], h.Sum32())
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// maxValueSize must return the maximum value size, which can be stored
+// in the cache under the given key.
+func importItems(cache fullCacher, r io.Reader, maxValueSize func(key []byte) uint64) (err error) {
+	br := bufio.NewReader(r)
+
+	var buf [exportItemHeaderSize]byte
+	if _, err = io.ReadFull(br, buf[:len(exportMagic)+4]); err != nil {
+		return importError(err)
+	}
+	if !bytes.Equal(buf[:len(exportMagic)], exportMagic) {
+		return ErrExportCorrupted
+	}
+	if binary.LittleEndian.Uint32(buf[len(exportMagic):]) != exportVersion {
+		return ErrExportUnsupported
+	}
+
+	h := crc32.NewIEEE()
+	cr := io.TeeReader(br, h)
+	itemsCount := uint64(0)
+	var key []byte
+	for {
+		h.Reset()
+		if _, err = io.ReadFull(cr, buf[:1]); err != nil {
+			return importError(err)
+		}
+		switch buf[0] {
+		case exportRecordItem:
+			if key, err = importItem(cache, br, cr, h, buf[:], key, maxValueSize); err != nil {
+				return
+			}
+			itemsCount++
+		case exportRecordEnd:
+			if _, err = io.ReadFull(cr, buf[1:exportEndSize]); err != nil {
+				return importError(err)
+			}
+			if err = checkExportCrc(br, h); err != nil {
+				return
+			}
+			if binary.LittleEndian.Uint64(buf[1:]) != itemsCount {
+				return ErrExportCorrupted
+			}
+			return nil
+		default:
+			return ErrExportCorrupted
+		}
+	}
+}
+
+func importItem(cache fullCacher, br io.Reader, cr io.Reader, h hash.Hash32, buf []byte, key []byte, maxValueSize func(key []byte) uint64) ([]byte, error) {
+	if _, err := io.ReadFull(cr, buf[1:exportItemHeaderSize]); err != nil {
+		return key, importError(err)
+	}
+	keySize := int64(binary.LittleEndian.Uint32(buf[1:]))
+	valueSize64 := binary.LittleEndian.Uint64(buf[5:])
+	ttlMillis := binary.LittleEndian.Uint64(buf[13:])
+	valueSize := int(valueSize64)
+	if valueSize < 0 || uint64(valueSize) != valueSize64 {
+		return key, ErrExportCorrupted
+	}
+
+	// Do not pre-allocate keySize bytes for the key, since keySize may be
+	// corrupted. Grow the key buffer as the data arrives instead.
+	kb := bytes.NewBuffer(key[:0])
+	if _, err := io.CopyN(kb, cr, keySize); err != nil {
+		return key, importError(err)
+	}
+	key = kb.Bytes()
+
+	ttl := MaxTtl
+	if ttlMillis < uint64(MaxTtl/time.Millisecond) {
+		ttl = time.Duration(ttlMillis) * time.Millisecond
+	}
+	// valueSize isn't verified by crc yet, so do not start the transaction
+	// for values, which cannot fit the cache. The transaction may allocate
+	// valueSize bytes in advance. Items bigger than the data file cannot
+	// be stored in the cache.
+	if valueSize64 > maxValueSize(key) {
+		return key, skipImportValue(br, cr, h, valueSize)
+	}
+	txn, err := cache.NewSetTxn(key, valueSize, ttl)
+	if err != nil {
+		if err != ErrNoSpace {
+			return key, err
+		}
+		return key, skipImportValue(br, cr, h, valueSize)
+	}
+
+	if _, err = txn.ReadFrom(cr); err != nil {
+		txn.Rollback()
+		return key, importError(err)
+	}
+	if err = checkExportCrc(br, h); err != nil {
+		txn.Rollback()
+		return key, err
+	}
+	return key, txn.Commit()
+}
+
+// Skips the item value, which cannot be stored in the cache.
+func skipImportValue(br io.Reader, cr io.Reader, h hash.Hash32, valueSize int) error {
+	if _, err := io.CopyN(ioutil.Discard, cr, int64(valueSize)); err != nil {
+		return importError(err)
+	}
+	return checkExportCrc(br, h)
+}
+
+func checkExportCrc(r io.Reader, h hash.Hash32) error {
+	var buf [exportCrcSize]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return importError(err)
+	}
+	if binary.LittleEndian.Uint32(buf[:]) != h.Sum32() {
+		return ErrExportCorrupted
+	}
+	return nil
+}
+
+func importError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrExportCorrupted
+	}
+	return err
+}
@@ -0,0 +1,218 @@
+package ybc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func exportTestCache(t *testing.T, itemsCount int) (cache *Cache, buf *bytes.Buffer) {
+	cache = newCache(t)
+	for i := 0; i < itemsCount; i++ {
+		key := []byte(fmt.Sprintf("%d_key", i))
+		value := []byte(fmt.Sprintf("value_%d", i))
+		if err := cache.Set(key, value, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cache.Set([]byte("max_ttl"), []byte("forever"), MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+
+	buf = &bytes.Buffer{}
+	if err := cache.Export(buf); err != nil {
+		t.Fatalf("Error in Cache.Export(): [%s]", err)
+	}
+	return
+}
+
+func TestCache_Export_Import(t *testing.T) {
+	src, buf := exportTestCache(t, 1000)
+	defer src.Close()
+
+	config := &Config{
+		MaxItemsCount: 1000 * 100,
+		DataFileSize:  1000 * 1000 * 10,
+	}
+	dst, err := config.OpenCache(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	if err = dst.Import(buf); err != nil {
+		t.Fatalf("Error in Cache.Import(): [%s]", err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("%d_key", i))
+		item, err := dst.GetItem(key)
+		if err != nil {
+			t.Fatalf("Cannot find imported item with key=[%s]: [%s]", key, err)
+		}
+		checkValue(t, []byte(fmt.Sprintf("value_%d", i)), item.Value())
+		if item.Ttl() > time.Hour || item.Ttl() < time.Minute {
+			t.Fatalf("Unexpected ttl=%s for imported item with key=[%s]", item.Ttl(), key)
+		}
+		item.Close()
+	}
+
+	value, err := dst.Get([]byte("max_ttl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, []byte("forever"), value)
+}
+
+func TestCache_Export_Empty(t *testing.T) {
+	src := newCache(t)
+	defer src.Close()
+	dst := newCache(t)
+	defer dst.Close()
+
+	buf := &bytes.Buffer{}
+	if err := src.Export(buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Import(buf); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCache_Import_Corrupted(t *testing.T) {
+	src, buf := exportTestCache(t, 10)
+	defer src.Close()
+	data := buf.Bytes()
+
+	expectImportError := func(data []byte, expectedErr error) {
+		dst := newCache(t)
+		defer dst.Close()
+		if err := dst.Import(bytes.NewReader(data)); err != expectedErr {
+			t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, expectedErr)
+		}
+	}
+
+	// truncated streams
+	expectImportError(data[:0], ErrExportCorrupted)
+	expectImportError(data[:len(data)/2], ErrExportCorrupted)
+	expectImportError(data[:len(data)-1], ErrExportCorrupted)
+
+	// corrupted value
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)/2] ^= 0xff
+	expectImportError(corrupted, ErrExportCorrupted)
+
+	// invalid magic
+	corrupted = append([]byte{}, data...)
+	corrupted[0] ^= 0xff
+	expectImportError(corrupted, ErrExportCorrupted)
+
+	// unsupported version
+	corrupted = append([]byte{}, data...)
+	corrupted[len(exportMagic)]++
+	expectImportError(corrupted, ErrExportUnsupported)
+}
+
+func TestCache_Import_HugeValueSize(t *testing.T) {
+	src, buf := exportTestCache(t, 10)
+	defer src.Close()
+	data := buf.Bytes()
+
+	// Caches with compression buffer values in memory, so corrupted
+	// value sizes mustn't result in huge allocations.
+	config := newConfig()
+	config.IndexFile = "foobar.index.import_huge_value_size"
+	config.DataFile = "foobar.data.import_huge_value_size"
+	config.Compression = compressions[0]
+	defer config.RemoveCache()
+	dst := openCacheWithConfig(t, config)
+	defer dst.Close()
+
+	valueSizeOffset := len(exportMagic) + 4 + 1 + 4
+	binary.LittleEndian.PutUint64(data[valueSizeOffset:], 1<<50)
+	if err := dst.Import(bytes.NewReader(data)); err != ErrExportCorrupted {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrExportCorrupted)
+	}
+}
+
+func TestCluster_Export_Import(t *testing.T) {
+	src := newCluster(t)
+	defer src.Close()
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("%d_key", i))
+		value := []byte(fmt.Sprintf("value_%d", i))
+		if err := src.Set(key, value, MaxTtl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := src.Export(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newCache(t)
+	defer dst.Close()
+	if err := dst.Import(buf); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("%d_key", i))
+		value, err := dst.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkValue(t, []byte(fmt.Sprintf("value_%d", i)), value)
+	}
+}
+
+func TestCluster_Import_TooBigForShard(t *testing.T) {
+	config := &Config{
+		MaxItemsCount: 1000,
+		DataFileSize:  1000 * 1000 * 10,
+	}
+	src, err := config.OpenCache(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	// The value fits the whole cluster, but doesn't fit any of its' caches.
+	bigValue := make([]byte, 1000*1000*2)
+	rand.New(rand.NewSource(0)).Read(bigValue)
+	if err = src.Set([]byte("big"), bigValue, MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	if err = src.Set([]byte("small"), []byte("value"), MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err = src.Export(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Caches with compression buffer values in memory, so the value
+	// must be skipped before starting set txn.
+	cfg := newClusterConfig(3)
+	for _, c := range cfg {
+		c.Compression = compressions[0]
+	}
+	dst, err := cfg.OpenCluster(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if err = dst.Import(buf); err != nil {
+		t.Fatalf("Error in Cluster.Import(): [%s]", err)
+	}
+	if _, err = dst.Get([]byte("big")); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
+	value, err := dst.Get([]byte("small"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, []byte("value"), value)
+}
@@ -0,0 +1,162 @@
+package ybc
+
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
+// Pending GetOrLoad() call for the given key.
+type loadCall struct {
+	done chan struct{}
+	err  error
+}
+
+// The writer passed to GetOrLoad() loader implements this interface.
+//
+// The loader should call SetValueSize() before writing the value if the value
+// size is known in advance. Then the value is written directly to the cache
+// via SetTxn instead of being buffered in memory, and values, which don't fit
+// the cache, are rejected with ErrNoSpace before loading them.
+// The loader must write exactly valueSize bytes after SetValueSize() call.
+type LoadWriter interface {
+	io.Writer
+	SetValueSize(valueSize int) error
+}
+
+type loadWriter struct {
+	cache *Cache
+	key   []byte
+	ttl   time.Duration
+	txn   *SetTxn
+	buf   bytes.Buffer
+}
+
+func (w *loadWriter) SetValueSize(valueSize int) error {
+	if w.txn != nil || w.buf.Len() > 0 {
+		panic("SetValueSize() must be called only once before writing the value")
+	}
+	txn, err := w.cache.NewSetTxn(w.key, valueSize, w.ttl)
+	if err != nil {
+		return err
+	}
+	w.txn = txn
+	return nil
+}
+
+func (w *loadWriter) Write(p []byte) (n int, err error) {
+	if w.txn != nil {
+		return w.txn.Write(p)
+	}
+	return w.buf.Write(p)
+}
+
+func (w *loadWriter) commit() (item *Item, err error) {
+	if w.txn != nil {
+		txn := w.txn
+		w.txn = nil
+		return txn.CommitItem()
+	}
+	return w.cache.SetItem(w.key, w.buf.Bytes(), w.ttl)
+}
+
+func (w *loadWriter) rollback() {
+	if w.txn != nil {
+		w.txn.Rollback()
+		w.txn = nil
+	}
+}
+
+// Returns the item with the given key from the cache. Loads the item
+// via loader and stores it with the given ttl in the cache on cache miss.
+//
+// loader must write item's value to w. The value is buffered in memory
+// until the loader returns unless the loader sets the value size in advance.
+// See LoadWriter for details.
+//
+// Only a single loader runs per key at a time. Concurrent GetOrLoad() callers
+// for the same key wait until the loader finishes and then receive the stored
+// item. If the loader
+// returns an error, then the error is returned to all the waiting callers
+// and the item isn't stored in the cache. If the loader panics, then
+// the panic is propagated to the caller running the loader, while waiting
+// callers receive ErrLoaderFailed.
+//
+// graceDuration is the expected time required for loading the item.
+// It protects from dogpile effect callers using Cache.GetDe*() for the key.
+// See Cache.GetDe() for details. Since the loader failed to create the item
+// during graceDuration, callers arriving after the loader's error wait for
+// the remaining graceDuration before running the loader again. This limits
+// the rate of loader calls for failing loaders.
+//
+// The returned item must be closed with item.Close() call!
+func (cache *Cache) GetOrLoad(key []byte, graceDuration, ttl time.Duration, loader func(w io.Writer) error) (item *Item, err error) {
+	for {
+		if item, err = cache.GetItem(key); err != ErrCacheMiss {
+			if err != nil {
+				item = nil
+			}
+			return
+		}
+
+		cache.loadsLock.Lock()
+		call := cache.loads[string(key)]
+		if call == nil {
+			call = &loadCall{
+				done: make(chan struct{}),
+			}
+			cache.loads[string(key)] = call
+			cache.loadsLock.Unlock()
+			return cache.load(call, key, graceDuration, ttl, loader)
+		}
+		cache.loadsLock.Unlock()
+
+		<-call.done
+		if call.err != nil {
+			return nil, call.err
+		}
+		// The item has been loaded. Obtain it from the cache.
+	}
+}
+
+func (cache *Cache) load(call *loadCall, key []byte, graceDuration, ttl time.Duration, loader func(w io.Writer) error) (item *Item, err error) {
+	defer func() {
+		cache.loadsLock.Lock()
+		delete(cache.loads, string(key))
+		cache.loadsLock.Unlock()
+
+		call.err = err
+		close(call.done)
+	}()
+
+	// The item may be created by somebody else, who doesn't use
+	// GetOrLoad(), so wait for it.
+	if item, err = cache.GetDeItem(key, graceDuration); err != ErrCacheMiss {
+		if err != nil {
+			item = nil
+		}
+		return
+	}
+
+	// Waiting callers receive ErrLoaderFailed if the loader panics.
+	err = ErrLoaderFailed
+	w := &loadWriter{
+		cache: cache,
+		key:   key,
+		ttl:   ttl,
+	}
+	defer w.rollback()
+	if err = loader(w); err != nil {
+		item = nil
+		return
+	}
+	if item, err = w.commit(); err != nil {
+		item = nil
+	}
+	return
+}
+
+// See Cache.GetOrLoad()
+func (cluster *Cluster) GetOrLoad(key []byte, graceDuration, ttl time.Duration, loader func(w io.Writer) error) (item *Item, err error) {
+	return cluster.cache(key).GetOrLoad(key, graceDuration, ttl, loader)
+}
@@ -0,0 +1,233 @@
+package ybc
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func cacher_GetOrLoad(cache fullCacher, t *testing.T) {
+	defer cache.Close()
+
+	key := []byte("key")
+	value := []byte("value")
+	loadsCount := 0
+	loader := func(w io.Writer) error {
+		loadsCount++
+		_, err := w.Write(value)
+		return err
+	}
+	for i := 0; i < 3; i++ {
+		item, err := cache.GetOrLoad(key, time.Second, MaxTtl, loader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkValue(t, value, item.Value())
+		item.Close()
+	}
+	if loadsCount != 1 {
+		t.Fatalf("Unexpected number of loader calls=%d. Expected 1", loadsCount)
+	}
+
+	// Loader errors mustn't be cached.
+	loaderErr := errors.New("loader error")
+	key = []byte("error_key")
+	for i := 0; i < 3; i++ {
+		item, err := cache.GetOrLoad(key, 100*time.Millisecond, MaxTtl, func(w io.Writer) error {
+			return loaderErr
+		})
+		if err != loaderErr {
+			t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, loaderErr)
+		}
+		if item != nil {
+			t.Fatalf("Unexpected non-nil item")
+		}
+	}
+	if _, err := cache.Get(key); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
+
+	// Values with known size are written directly to the cache.
+	key = []byte("sized_key")
+	item, err := cache.GetOrLoad(key, time.Second, MaxTtl, func(w io.Writer) error {
+		if err := w.(LoadWriter).SetValueSize(len(value)); err != nil {
+			return err
+		}
+		_, err := w.Write(value)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, value, item.Value())
+	item.Close()
+
+	// Values, which don't fit the cache, mustn't be stored.
+	key = []byte("huge_key")
+	hugeValue := make([]byte, 10*1000*1000)
+	for i := 0; i < 3; i++ {
+		item, err := cache.GetOrLoad(key, 100*time.Millisecond, MaxTtl, func(w io.Writer) error {
+			if err := w.(LoadWriter).SetValueSize(len(hugeValue)); err != nil {
+				return err
+			}
+			_, err := w.Write(hugeValue)
+			return err
+		})
+		if err != ErrNoSpace {
+			t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrNoSpace)
+		}
+		if item != nil {
+			t.Fatalf("Unexpected non-nil item")
+		}
+	}
+
+	// Partially written values mustn't be stored.
+	key = []byte("partial_key")
+	_, err = cache.GetOrLoad(key, 100*time.Millisecond, MaxTtl, func(w io.Writer) error {
+		if err := w.(LoadWriter).SetValueSize(len(value) + 1); err != nil {
+			return err
+		}
+		_, err := w.Write(value)
+		return err
+	})
+	if err != ErrPartialCommit {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrPartialCommit)
+	}
+	if _, err := cache.Get(key); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
+}
+
+func TestCache_GetOrLoad(t *testing.T) {
+	cache := newCache(t)
+	cacher_GetOrLoad(cache, t)
+}
+
+func TestCache_GetOrLoad_Concurrent(t *testing.T) {
+	cache := newCache(t)
+	defer cache.Close()
+
+	key := []byte("key")
+	value := []byte("value")
+	var loadsCount uint32
+	loader := func(w io.Writer) error {
+		atomic.AddUint32(&loadsCount, 1)
+		time.Sleep(100 * time.Millisecond)
+		_, err := w.Write(value)
+		return err
+	}
+
+	const workersCount = 10
+	var wg sync.WaitGroup
+	for i := 0; i < workersCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			item, err := cache.GetOrLoad(key, time.Second, MaxTtl, loader)
+			if err != nil {
+				t.Errorf("Unexpected error=[%s]", err)
+				return
+			}
+			if v := item.Value(); string(v) != string(value) {
+				t.Errorf("Unexpected value=[%s]. Expected [%s]", v, value)
+			}
+			item.Close()
+		}()
+	}
+	wg.Wait()
+	if loadsCount != 1 {
+		t.Fatalf("Unexpected number of loader calls=%d. Expected 1", loadsCount)
+	}
+}
+
+func TestCache_GetOrLoad_ErrorPropagation(t *testing.T) {
+	cache := newCache(t)
+	defer cache.Close()
+
+	key := []byte("key")
+	loaderErr := errors.New("loader error")
+	loaderStarted := make(chan struct{})
+	loaderRelease := make(chan struct{})
+	loaderDone := make(chan error)
+	go func() {
+		_, err := cache.GetOrLoad(key, time.Second, MaxTtl, func(w io.Writer) error {
+			close(loaderStarted)
+			<-loaderRelease
+			return loaderErr
+		})
+		loaderDone <- err
+	}()
+	<-loaderStarted
+
+	const waitersCount = 10
+	waitersDone := make(chan error, waitersCount)
+	for i := 0; i < waitersCount; i++ {
+		go func() {
+			_, err := cache.GetOrLoad(key, time.Second, MaxTtl, func(w io.Writer) error {
+				return errors.New("unexpected loader call")
+			})
+			waitersDone <- err
+		}()
+	}
+
+	// Give waiters a chance to start waiting for the loader.
+	time.Sleep(100 * time.Millisecond)
+	close(loaderRelease)
+
+	if err := <-loaderDone; err != loaderErr {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, loaderErr)
+	}
+	for i := 0; i < waitersCount; i++ {
+		if err := <-waitersDone; err != loaderErr {
+			t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, loaderErr)
+		}
+	}
+}
+
+func TestCache_GetOrLoad_LoaderPanic(t *testing.T) {
+	cache := newCache(t)
+	defer cache.Close()
+
+	key := []byte("key")
+	loaderStarted := make(chan struct{})
+	loaderRelease := make(chan struct{})
+	loaderDone := make(chan interface{})
+	go func() {
+		defer func() {
+			loaderDone <- recover()
+		}()
+		cache.GetOrLoad(key, time.Second, MaxTtl, func(w io.Writer) error {
+			close(loaderStarted)
+			<-loaderRelease
+			panic("loader panic")
+		})
+	}()
+	<-loaderStarted
+
+	waiterDone := make(chan error)
+	go func() {
+		_, err := cache.GetOrLoad(key, time.Second, MaxTtl, func(w io.Writer) error {
+			return errors.New("unexpected loader call")
+		})
+		waiterDone <- err
+	}()
+
+	// Give the waiter a chance to start waiting for the loader.
+	time.Sleep(100 * time.Millisecond)
+	close(loaderRelease)
+
+	if v := <-loaderDone; v != "loader panic" {
+		t.Fatalf("Unexpected panic=[%v]. Expected [loader panic]", v)
+	}
+	if err := <-waiterDone; err != ErrLoaderFailed {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrLoaderFailed)
+	}
+}
+
+func TestCluster_GetOrLoad(t *testing.T) {
+	cluster := newCluster(t)
+	cacher_GetOrLoad(cluster, t)
+}
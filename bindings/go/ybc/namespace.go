@@ -0,0 +1,297 @@
+package ybc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Namespace is a Cacher, which stores items under namespaced keys
+// in the underlying Cache or Cluster.
+//
+// Namespace.Clear() invalidates all the items in the namespace without
+// touching other items in the underlying cache. See Cache.Namespace()
+// for details.
+type Namespace struct {
+	cache  fullCacher
+	name   string
+	genKey []byte
+}
+
+// Returns a namespace with the given name in the cache.
+//
+// Items in the namespace are stored in the cache under keys prefixed by
+// the namespace name and the namespace generation. The generation is stored
+// in the cache too, so namespaces with the same name obtained from the same
+// cache share items.
+//
+// Namespace.Clear() bumps the generation, so old items in the namespace
+// become instantly unreachable without scanning the cache. Unreachable items
+// are evicted from the cache eventually like any other items.
+//
+// If the generation is evicted from the cache, then the namespace is cleared.
+// The generation is stored with ttl exceeding MaxTtl, so it isn't evicted
+// in favour of items with ttl up to MaxTtl competing with it for index slots.
+// But it is lost like any other item when the data file wraps around its'
+// end, unless Config.HotDataSize is set. Frequently accessed generations
+// are moved to the front of the data file in this case, so they survive wraps.
+//
+// Each operation in the namespace obtains the generation from the cache,
+// so it is slightly slower than the corresponding operation in the cache.
+func (cache *Cache) Namespace(name string) Cacher {
+	return newNamespace(cache, name)
+}
+
+// See Cache.Namespace()
+func (cluster *Cluster) Namespace(name string) Cacher {
+	return newNamespace(cluster, name)
+}
+
+// Namespace generation keys are prefixed by this prefix.
+var namespaceGenKeyPrefix = []byte("\x00ybc.namespace.generation.")
+
+func newNamespace(cache fullCacher, name string) *Namespace {
+	return &Namespace{
+		cache:  cache,
+		name:   name,
+		genKey: append(append([]byte{}, namespaceGenKeyPrefix...), name...),
+	}
+}
+
+/*******************************************************************************
+ * Generations.
+ *
+ * A generation is stored in the cache under genKey as a counter compatible
+ * with Cache.Incr(). A missing generation is initialized with the current
+ * time in nanoseconds, so items bound to the generation, which has been
+ * evicted from the cache, don't become reachable again.
+ *
+ * When all the slots for the key in the cache index are occupied, the item
+ * with the earliest expiration is evicted. Generations are stored with
+ * generationTtl exceeding MaxTtl, so they aren't evicted in favour of items
+ * with ttl up to MaxTtl.
+ ******************************************************************************/
+
+const generationTtl = time.Duration(math.MaxInt64)
+
+// Returns the generation stored under genKey in the cache.
+func loadGeneration(cache fullCacher, genKey []byte) uint64 {
+	for {
+		value, err := cache.Get(genKey)
+		if err == nil {
+			if gen, err := strconv.ParseUint(string(value), 10, 64); err == nil {
+				return gen
+			}
+		}
+		gen := uint64(time.Now().UnixNano())
+		value = []byte(strconv.FormatUint(gen, 10))
+		if err == nil {
+			// The generation is corrupted. Overwrite it.
+			cache.Set(genKey, value, generationTtl)
+			return gen
+		}
+		if err = cache.Add(genKey, value, generationTtl); err != ErrAlreadyExists {
+			// The generation may be lost if it cannot be stored in the cache.
+			// This is OK, since items bound to the generation cannot be
+			// stored there too.
+			return gen
+		}
+		// The generation has been concurrently initialized. Re-read it.
+	}
+}
+
+// Changes the generation stored under genKey in the cache.
+func bumpGeneration(cache fullCacher, genKey []byte) {
+	if _, err := cache.Incr(genKey, 1); err != nil {
+		// The generation is missing or corrupted. Initialize new generation.
+		cache.Set(genKey, []byte(strconv.FormatUint(uint64(time.Now().UnixNano()), 10)), generationTtl)
+	}
+}
+
+/*******************************************************************************
+ * Namespaced key format.
+ *
+ * key := nameSize:uvarint name generation:uint64BE originalKey
+ ******************************************************************************/
+
+func (ns *Namespace) prefix() []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], loadGeneration(ns.cache, ns.genKey))
+	prefix := appendUvarint(nil, uint64(len(ns.name)))
+	prefix = append(prefix, ns.name...)
+	return append(prefix, buf[:]...)
+}
+
+func (ns *Namespace) key(key []byte) []byte {
+	return append(ns.prefix(), key...)
+}
+
+/*******************************************************************************
+ * Cacher interfaces.
+ ******************************************************************************/
+
+// Invalidates all the items in the namespace.
+//
+// Items in the underlying cache outside the namespace remain untouched.
+func (ns *Namespace) Clear() {
+	bumpGeneration(ns.cache, ns.genKey)
+}
+
+// Does nothing, since the namespace doesn't own the underlying cache.
+//
+// The underlying cache must be closed separately.
+func (ns *Namespace) Close() error {
+	return nil
+}
+
+// Returns stats for the underlying cache.
+func (ns *Namespace) Stats() Stats {
+	return ns.cache.Stats()
+}
+
+// Returns occupancy of the underlying cache.
+func (ns *Namespace) Occupancy() Occupancy {
+	return ns.cache.Occupancy()
+}
+
+// Calls f for each live item in the namespace. Keys are passed to f
+// without namespace prefix.
+//
+// This method iterates all the items in the underlying cache.
+// See Cache.Iterate() for details.
+func (ns *Namespace) Iterate(f func(key []byte, item *Item) bool) {
+	prefix := ns.prefix()
+	ns.cache.Iterate(func(key []byte, item *Item) bool {
+		if !bytes.HasPrefix(key, prefix) {
+			return true
+		}
+		return f(key[len(prefix):], item)
+	})
+}
+
+// See Cache.Set()
+func (ns *Namespace) Set(key []byte, value []byte, ttl time.Duration) error {
+	return ns.cache.Set(ns.key(key), value, ttl)
+}
+
+// See Cache.Get()
+func (ns *Namespace) Get(key []byte) (value []byte, err error) {
+	return ns.cache.Get(ns.key(key))
+}
+
+// See Cache.Delete()
+func (ns *Namespace) Delete(key []byte) bool {
+	return ns.cache.Delete(ns.key(key))
+}
+
+// See Cache.GetDe()
+func (ns *Namespace) GetDe(key []byte, graceDuration time.Duration) (value []byte, err error) {
+	return ns.cache.GetDe(ns.key(key), graceDuration)
+}
+
+// See Cache.GetDeAsync()
+func (ns *Namespace) GetDeAsync(key []byte, graceDuration time.Duration) (value []byte, err error) {
+	return ns.cache.GetDeAsync(ns.key(key), graceDuration)
+}
+
+// See Cache.GetDeCtx()
+func (ns *Namespace) GetDeCtx(ctx context.Context, key []byte, graceDuration time.Duration) (value []byte, err error) {
+	return ns.cache.GetDeCtx(ctx, ns.key(key), graceDuration)
+}
+
+// See Cache.SetItem()
+func (ns *Namespace) SetItem(key []byte, value []byte, ttl time.Duration) (item *Item, err error) {
+	return ns.cache.SetItem(ns.key(key), value, ttl)
+}
+
+// See Cache.GetItem()
+func (ns *Namespace) GetItem(key []byte) (item *Item, err error) {
+	return ns.cache.GetItem(ns.key(key))
+}
+
+// See Cache.GetDeItem()
+func (ns *Namespace) GetDeItem(key []byte, graceDuration time.Duration) (item *Item, err error) {
+	return ns.cache.GetDeItem(ns.key(key), graceDuration)
+}
+
+// See Cache.GetDeAsyncItem()
+func (ns *Namespace) GetDeAsyncItem(key []byte, graceDuration time.Duration) (item *Item, err error) {
+	return ns.cache.GetDeAsyncItem(ns.key(key), graceDuration)
+}
+
+// See Cache.GetDeItemCtx()
+func (ns *Namespace) GetDeItemCtx(ctx context.Context, key []byte, graceDuration time.Duration) (item *Item, err error) {
+	return ns.cache.GetDeItemCtx(ctx, ns.key(key), graceDuration)
+}
+
+// See Cache.GetStaleItem()
+func (ns *Namespace) GetStaleItem(key []byte, staleFor time.Duration) (item *Item, isStale bool, err error) {
+	return ns.cache.GetStaleItem(ns.key(key), staleFor)
+}
+
+// See Cache.GetMulti()
+func (ns *Namespace) GetMulti(keys [][]byte, dst [][]byte) [][]byte {
+	prefix := ns.prefix()
+	nsKeys := make([][]byte, len(keys))
+	for i, key := range keys {
+		nsKeys[i] = append(prefix[:len(prefix):len(prefix)], key...)
+	}
+	return ns.cache.GetMulti(nsKeys, dst)
+}
+
+// See Cache.SetMulti()
+func (ns *Namespace) SetMulti(items []KV, ttl time.Duration) error {
+	prefix := ns.prefix()
+	nsItems := make([]KV, len(items))
+	for i, kv := range items {
+		nsItems[i] = KV{
+			Key:   append(prefix[:len(prefix):len(prefix)], kv.Key...),
+			Value: kv.Value,
+		}
+	}
+	return ns.cache.SetMulti(nsItems, ttl)
+}
+
+// See Cache.NewSetTxn()
+func (ns *Namespace) NewSetTxn(key []byte, valueSize int, ttl time.Duration) (txn *SetTxn, err error) {
+	return ns.cache.NewSetTxn(ns.key(key), valueSize, ttl)
+}
+
+// See Cache.CompareAndSet()
+func (ns *Namespace) CompareAndSet(key []byte, value []byte, ttl time.Duration, version uint64) error {
+	return ns.cache.CompareAndSet(ns.key(key), value, ttl, version)
+}
+
+// See Cache.CompareAndDelete()
+func (ns *Namespace) CompareAndDelete(key []byte, version uint64) error {
+	return ns.cache.CompareAndDelete(ns.key(key), version)
+}
+
+// See Cache.Add()
+func (ns *Namespace) Add(key []byte, value []byte, ttl time.Duration) error {
+	return ns.cache.Add(ns.key(key), value, ttl)
+}
+
+// See Cache.Incr()
+func (ns *Namespace) Incr(key []byte, delta uint64) (value uint64, err error) {
+	return ns.cache.Incr(ns.key(key), delta)
+}
+
+// See Cache.Decr()
+func (ns *Namespace) Decr(key []byte, delta uint64) (value uint64, err error) {
+	return ns.cache.Decr(ns.key(key), delta)
+}
+
+// See Cache.Touch()
+func (ns *Namespace) Touch(key []byte, ttl time.Duration) error {
+	return ns.cache.Touch(ns.key(key), ttl)
+}
+
+// See Cache.GetOrLoad()
+func (ns *Namespace) GetOrLoad(key []byte, graceDuration, ttl time.Duration, loader func(w io.Writer) error) (item *Item, err error) {
+	return ns.cache.GetOrLoad(ns.key(key), graceDuration, ttl, loader)
+}
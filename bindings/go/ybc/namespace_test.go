@@ -0,0 +1,154 @@
+package ybc
+
+import (
+	"fmt"
+	"testing"
+)
+
+type namespacer interface {
+	Cacher
+	Namespace(name string) Cacher
+}
+
+func expectNamespaceItems(t *testing.T, cache Cacher, n int, valuePrefix string) {
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("%d_key", i))
+		value, err := cache.Get(key)
+		if err != nil {
+			t.Fatalf("Cannot find item with key=[%s]: [%s]", key, err)
+		}
+		checkValue(t, []byte(fmt.Sprintf("%s_%d", valuePrefix, i)), value)
+	}
+}
+
+func expectNamespaceMisses(t *testing.T, cache Cacher, n int) {
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("%d_key", i))
+		if _, err := cache.Get(key); err != ErrCacheMiss {
+			t.Fatalf("Unexpected error=[%v] for key=[%s]. Expected [%s]", err, key, ErrCacheMiss)
+		}
+	}
+}
+
+func setNamespaceItems(t *testing.T, cache Cacher, n int, valuePrefix string) {
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("%d_key", i))
+		value := []byte(fmt.Sprintf("%s_%d", valuePrefix, i))
+		if err := cache.Set(key, value, MaxTtl); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func namespacer_Clear(cache namespacer, t *testing.T) {
+	defer cache.Close()
+	n := 100
+	foo := cache.Namespace("foo")
+	bar := cache.Namespace("bar")
+
+	setNamespaceItems(t, cache, n, "root")
+	expectNamespaceMisses(t, foo, n)
+	setNamespaceItems(t, foo, n, "foo")
+	setNamespaceItems(t, bar, n, "bar")
+	expectNamespaceItems(t, cache, n, "root")
+	expectNamespaceItems(t, foo, n, "foo")
+	expectNamespaceItems(t, bar, n, "bar")
+
+	// Namespaces with the same name must share items.
+	expectNamespaceItems(t, cache.Namespace("foo"), n, "foo")
+
+	foo.Clear()
+	expectNamespaceMisses(t, foo, n)
+	expectNamespaceMisses(t, cache.Namespace("foo"), n)
+	expectNamespaceItems(t, cache, n, "root")
+	expectNamespaceItems(t, bar, n, "bar")
+
+	setNamespaceItems(t, foo, n, "new_foo")
+	expectNamespaceItems(t, foo, n, "new_foo")
+
+	// Namespace items must become unreachable if the generation is evicted.
+	if !cache.Delete(foo.(*Namespace).genKey) {
+		t.Fatalf("Cannot delete namespace generation")
+	}
+	expectNamespaceMisses(t, foo, n)
+	setNamespaceItems(t, foo, n, "foo")
+	expectNamespaceItems(t, foo, n, "foo")
+
+	m := 0
+	foo.(ScanCacher).Iterate(func(key []byte, item *Item) bool {
+		var i int
+		if _, err := fmt.Sscanf(string(key), "%d_key", &i); err != nil {
+			t.Fatalf("Unexpected key=[%s] in the namespace", key)
+		}
+		checkValue(t, []byte(fmt.Sprintf("foo_%d", i)), item.Value())
+		m++
+		return true
+	})
+	if m != n {
+		t.Fatalf("Unexpected number of items=%d in the namespace. Expected %d", m, n)
+	}
+
+	// Clearing the cache clears all the namespaces.
+	cache.Clear()
+	expectNamespaceMisses(t, foo, n)
+	expectNamespaceMisses(t, bar, n)
+}
+
+func TestCache_Namespace_Clear(t *testing.T) {
+	cache := newCache(t)
+	namespacer_Clear(cache, t)
+}
+
+func TestCluster_Namespace_Clear(t *testing.T) {
+	cluster := newCluster(t)
+	namespacer_Clear(cluster, t)
+}
+
+func TestCache_Namespace_GenerationEviction(t *testing.T) {
+	// The cache index consists of a single bucket, so each new item
+	// evicts another item.
+	config := &Config{
+		MaxItemsCount: 8,
+		DataFileSize:  1000 * 1000,
+	}
+	cache := openCacheWithConfig(t, config)
+	defer cache.Close()
+
+	ns := cache.Namespace("foo")
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("%d_key", i))
+		value := []byte(fmt.Sprintf("foo_%d", i))
+		if err := ns.Set(key, value, MaxTtl); err != nil {
+			t.Fatal(err)
+		}
+		// Items mustn't evict the namespace generation.
+		v, err := ns.Get(key)
+		if err != nil {
+			t.Fatalf("Cannot find item with key=[%s]: [%s]", key, err)
+		}
+		checkValue(t, value, v)
+	}
+}
+
+func TestCache_Namespace_Cacher(t *testing.T) {
+	for _, f := range []func(fullCacher, *testing.T){
+		cacher_GetDe,
+		cacher_GetStaleItem,
+		cacher_GetMulti,
+		cacher_SetItem,
+		cacher_GetItem,
+		cacher_GetDeItem,
+		cacher_NewSetTxn,
+		cacher_Iterate,
+		cacher_CompareAndSet,
+		cacher_CompareAndDelete,
+		cacher_Add,
+		cacher_Incr,
+		cacher_Touch,
+		cacher_GetOrLoad,
+	} {
+		cache := newCache(t)
+		f(cache.Namespace("foo").(fullCacher), t)
+		cache.Close()
+	}
+}
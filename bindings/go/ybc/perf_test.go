@@ -1,6 +1,7 @@
 package ybc
 
 import (
+	"fmt"
 	"sync"
 	"testing"
 )
@@ -225,3 +226,45 @@ func benchmarkCache_Set(b *testing.B, workersCount int, isSimpleCache bool) {
 
 	runBenchmark(isSimpleCache, initFunc, iterationFunc, workersCount, b)
 }
+
+func benchmarkCache_GetMulti(b *testing.B, batchSize int, isMulti bool) {
+	b.StopTimer()
+	cache, err := newConfig().OpenCache(true)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cache.Close()
+
+	var items []KV
+	var keys [][]byte
+	for i := 0; i < batchSize; i++ {
+		key := []byte(fmt.Sprintf("%d_key", i))
+		items = append(items, KV{Key: key, Value: []byte("value")})
+		keys = append(keys, key)
+	}
+	if err = cache.SetMulti(items, MaxTtl); err != nil {
+		b.Fatal(err)
+	}
+
+	var values [][]byte
+	b.StartTimer()
+	for i := 0; i < b.N; i += batchSize {
+		if isMulti {
+			values = cache.GetMulti(keys, values[:0])
+			continue
+		}
+		for _, key := range keys {
+			if _, err = cache.Get(key); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkCache_GetMulti_Loop(b *testing.B) {
+	benchmarkCache_GetMulti(b, 100, false)
+}
+
+func BenchmarkCache_GetMulti_Batch(b *testing.B) {
+	benchmarkCache_GetMulti(b, 100, true)
+}
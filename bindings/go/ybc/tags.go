@@ -0,0 +1,135 @@
+package ybc
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Stores value with the given key, the given ttl and the given tags
+// in the cache.
+//
+// All the items with the given tag may be invalidated at once
+// via Cache.InvalidateTag(). Each tag has a generation stored in the cache.
+// Item's value is stored together with the current generations of item's
+// tags, while Cache.InvalidateTag() just bumps the tag's generation.
+// Lookups treat items with outdated tag generations as missing. So tag
+// invalidation is O(1) and doesn't depend on the number of tagged items.
+// Invalidated items are evicted from the cache eventually like any other
+// items. If tag's generation is evicted from the cache, then all the items
+// with this tag become invalidated.
+//
+// Other methods store items without tags. Items imported via Cache.Import()
+// lose their tags.
+//
+// Returns ErrTagsDisabled if Config.EnableTags isn't set.
+func (cache *Cache) SetWithTags(key []byte, value []byte, ttl time.Duration, tags ...string) error {
+	cache.dg.CheckLive()
+	if !cache.enableTags {
+		return ErrTagsDisabled
+	}
+	tagsHeader := cache.tagsHeader(tags)
+	return cache.setEncoded(key, cache.encodeWithTags(key, value, tagsHeader), ttl)
+}
+
+// Invalidates all the items with the given tag in the cache.
+//
+// See Cache.SetWithTags() for details.
+func (cache *Cache) InvalidateTag(tag string) {
+	cache.dg.CheckLive()
+	bumpGeneration(cache, tagGenKey(tag))
+}
+
+// See Cache.SetWithTags()
+func (cluster *Cluster) SetWithTags(key []byte, value []byte, ttl time.Duration, tags ...string) error {
+	return cluster.cache(key).SetWithTags(key, value, ttl, tags...)
+}
+
+// See Cache.InvalidateTag()
+//
+// Each cache in the cluster keeps its' own tag generations, so the tag
+// is invalidated in all the caches.
+func (cluster *Cluster) InvalidateTag(tag string) {
+	cluster.dg.CheckLive()
+	for _, cache := range cluster.onlineCaches {
+		cache.InvalidateTag(tag)
+	}
+}
+
+// See Cache.SetWithTags()
+func (ns *Namespace) SetWithTags(key []byte, value []byte, ttl time.Duration, tags ...string) error {
+	return ns.cache.SetWithTags(ns.key(key), value, ttl, tags...)
+}
+
+// See Cache.InvalidateTag()
+//
+// Tags aren't namespaced, so the tag is invalidated in the underlying cache.
+func (ns *Namespace) InvalidateTag(tag string) {
+	ns.cache.InvalidateTag(tag)
+}
+
+/*******************************************************************************
+ * Tagged value format.
+ *
+ * value := tagsCount:uvarint tag* originalValue
+ * tag := tagSize:uvarint tag generation:uint64BE
+ *
+ * Tag's generation is stored in the cache under tagGenKeyPrefix + tag.
+ * See loadGeneration() for details.
+ ******************************************************************************/
+
+// Tag generation keys are prefixed by this prefix.
+var tagGenKeyPrefix = []byte("\x00ybc.tag.generation.")
+
+// Header for values without tags.
+var emptyTagsHeader = []byte{0}
+
+func tagGenKey(tag string) []byte {
+	return append(append([]byte{}, tagGenKeyPrefix...), tag...)
+}
+
+func (cache *Cache) tagsHeader(tags []string) []byte {
+	header := appendUvarint(nil, uint64(len(tags)))
+	var buf [8]byte
+	for _, tag := range tags {
+		header = appendUvarint(header, uint64(len(tag)))
+		header = append(header, tag...)
+		binary.BigEndian.PutUint64(buf[:], loadGeneration(cache, tagGenKey(tag)))
+		header = append(header, buf[:]...)
+	}
+	return header
+}
+
+func appendTaggedValue(tagsHeader, value []byte) []byte {
+	if tagsHeader == nil {
+		tagsHeader = emptyTagsHeader
+	}
+	buf := make([]byte, 0, len(tagsHeader)+len(value))
+	buf = append(buf, tagsHeader...)
+	return append(buf, value...)
+}
+
+// Verifies tag generations for the given tagged value and returns
+// the original value.
+//
+// Returns ErrCacheMiss if any of value's tags has been invalidated.
+func (cache *Cache) checkTags(buf []byte) ([]byte, error) {
+	tagsCount, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, ErrCorrupted
+	}
+	buf = buf[n:]
+	for i := uint64(0); i < tagsCount; i++ {
+		tagSize, n := binary.Uvarint(buf)
+		if n <= 0 || tagSize > uint64(len(buf)-n) || len(buf)-n-int(tagSize) < 8 {
+			return nil, ErrCorrupted
+		}
+		buf = buf[n:]
+		tag := string(buf[:tagSize])
+		gen := binary.BigEndian.Uint64(buf[tagSize:])
+		buf = buf[tagSize+8:]
+		if loadGeneration(cache, tagGenKey(tag)) != gen {
+			return nil, ErrCacheMiss
+		}
+	}
+	return buf, nil
+}
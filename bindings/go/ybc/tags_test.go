@@ -0,0 +1,193 @@
+package ybc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTagsConfig() *Config {
+	config := newConfig()
+	config.EnableTags = true
+	return config
+}
+
+func expectTaggedItems(t *testing.T, cache Cacher, n int, tag string, isMissing bool) {
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("%d_%s", i, tag))
+		value, err := cache.Get(key)
+		if isMissing {
+			if err != ErrCacheMiss {
+				t.Fatalf("Unexpected error=[%v] for key=[%s]. Expected [%s]", err, key, ErrCacheMiss)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Cannot find item with key=[%s]: [%s]", key, err)
+		}
+		checkValue(t, []byte(fmt.Sprintf("value_%d", i)), value)
+	}
+}
+
+func tagger_InvalidateTag(cache fullCacher, t *testing.T) {
+	defer cache.Close()
+	n := 100
+	for i := 0; i < n; i++ {
+		value := []byte(fmt.Sprintf("value_%d", i))
+		if err := cache.SetWithTags([]byte(fmt.Sprintf("%d_foo", i)), value, MaxTtl, "foo"); err != nil {
+			t.Fatal(err)
+		}
+		if err := cache.SetWithTags([]byte(fmt.Sprintf("%d_foo_bar", i)), value, MaxTtl, "foo", "bar"); err != nil {
+			t.Fatal(err)
+		}
+		if err := cache.SetWithTags([]byte(fmt.Sprintf("%d_bar", i)), value, MaxTtl, "bar"); err != nil {
+			t.Fatal(err)
+		}
+		if err := cache.Set([]byte(fmt.Sprintf("%d_untagged", i)), value, MaxTtl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectTaggedItems(t, cache, n, "foo", false)
+	expectTaggedItems(t, cache, n, "foo_bar", false)
+	expectTaggedItems(t, cache, n, "bar", false)
+	expectTaggedItems(t, cache, n, "untagged", false)
+
+	cache.InvalidateTag("foo")
+	expectTaggedItems(t, cache, n, "foo", true)
+	expectTaggedItems(t, cache, n, "foo_bar", true)
+	expectTaggedItems(t, cache, n, "bar", false)
+	expectTaggedItems(t, cache, n, "untagged", false)
+
+	// Invalidating unknown tag mustn't affect other items.
+	cache.InvalidateTag("baz")
+	expectTaggedItems(t, cache, n, "bar", false)
+
+	// Items with invalidated tag may be stored again.
+	for i := 0; i < n; i++ {
+		value := []byte(fmt.Sprintf("value_%d", i))
+		if err := cache.SetWithTags([]byte(fmt.Sprintf("%d_foo", i)), value, MaxTtl, "foo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectTaggedItems(t, cache, n, "foo", false)
+
+	key := []byte("0_bar")
+	item, err := cache.GetItem(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, []byte("value_0"), item.Value())
+	item.Close()
+	values := cache.GetMulti([][]byte{key}, nil)
+	checkValue(t, []byte("value_0"), values[0])
+
+	cache.InvalidateTag("bar")
+	if _, err = cache.GetItem(key); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
+	if values = cache.GetMulti([][]byte{key}, nil); values[0] != nil {
+		t.Fatalf("Unexpected value=[%s] for invalidated item", values[0])
+	}
+	expectTaggedItems(t, cache, n, "foo", false)
+	expectTaggedItems(t, cache, n, "untagged", false)
+}
+
+func TestCache_InvalidateTag(t *testing.T) {
+	cache := openCacheWithConfig(t, newTagsConfig())
+	tagger_InvalidateTag(cache, t)
+}
+
+func TestCluster_InvalidateTag(t *testing.T) {
+	config := newClusterConfig(3)
+	for _, c := range config {
+		c.EnableTags = true
+	}
+	cluster, err := config.OpenCluster(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagger_InvalidateTag(cluster, t)
+}
+
+func TestCache_InvalidateTag_Codecs(t *testing.T) {
+	for _, c := range compressions {
+		config := newTagsConfig()
+		config.Compression = c
+		config.EncryptionKey = []byte("0123456789abcdef")
+		cache := openCacheWithConfig(t, config)
+		tagger_InvalidateTag(cache, t)
+	}
+}
+
+func TestCache_InvalidateTag_Iterate(t *testing.T) {
+	cache := openCacheWithConfig(t, newTagsConfig())
+	defer cache.Close()
+
+	value := []byte("value")
+	if err := cache.SetWithTags([]byte("foo"), value, MaxTtl, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SetWithTags([]byte("bar"), value, MaxTtl, "bar"); err != nil {
+		t.Fatal(err)
+	}
+	cache.InvalidateTag("foo")
+
+	var keys []string
+	cache.Iterate(func(key []byte, item *Item) bool {
+		if key[0] != 0 {
+			// Skip tag generations.
+			checkValue(t, value, item.Value())
+			keys = append(keys, string(key))
+		}
+		return true
+	})
+	if len(keys) != 1 || keys[0] != "bar" {
+		t.Fatalf("Unexpected keys=%q. Expected [bar]", keys)
+	}
+}
+
+func TestCache_SetWithTags_Disabled(t *testing.T) {
+	cache := newCache(t)
+	defer cache.Close()
+	if err := cache.SetWithTags([]byte("key"), []byte("value"), time.Hour, "foo"); err != ErrTagsDisabled {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrTagsDisabled)
+	}
+}
+
+func TestCache_CheckTags_Corrupted(t *testing.T) {
+	cache := openCacheWithConfig(t, newTagsConfig())
+	defer cache.Close()
+
+	value := appendTaggedValue(cache.tagsHeader([]string{"foo", "bar"}), []byte("value"))
+	v, err := cache.checkTags(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, []byte("value"), v)
+
+	for _, buf := range [][]byte{nil, {0x80}, {1}, {1, 3, 'f'}, value[:10]} {
+		if _, err = cache.checkTags(buf); err != ErrCorrupted {
+			t.Fatalf("Unexpected error=[%v] for buf=%v. Expected [%s]", err, buf, ErrCorrupted)
+		}
+	}
+}
+
+func TestCache_EnableTags_Cacher(t *testing.T) {
+	for _, f := range []func(fullCacher, *testing.T){
+		cacher_GetDe,
+		cacher_GetStaleItem,
+		cacher_GetMulti,
+		cacher_SetItem,
+		cacher_GetItem,
+		cacher_GetDeItem,
+		cacher_NewSetTxn,
+		cacher_CompareAndSet,
+		cacher_CompareAndDelete,
+		cacher_Add,
+		cacher_Incr,
+		cacher_Touch,
+		cacher_GetOrLoad,
+	} {
+		f(openCacheWithConfig(t, newTagsConfig()), t)
+	}
+}
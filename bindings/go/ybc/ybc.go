@@ -22,10 +22,16 @@ package ybc
 import "C"
 
 import (
+	"context"
+	"crypto/cipher"
 	"errors"
+	"fmt"
 	"hash/fnv"
 	"io"
+	"math"
 	"reflect"
+	"strconv"
+	"sync"
 	"time"
 	"unsafe"
 )
@@ -38,6 +44,16 @@ var (
 	ErrOutOfRange    = errors.New("ybc: out of range offset")
 	ErrPartialCommit = errors.New("ybc: partial commit")
 	ErrWouldBlock    = errors.New("ybc: the operation would block")
+	ErrCorrupted     = errors.New("ybc: the item is corrupted")
+	ErrBadKeySize    = errors.New("ybc: encryption key must be 16, 24 or 32 bytes long")
+
+	ErrVersionMismatch = errors.New("ybc: the item has been modified")
+	ErrAlreadyExists   = errors.New("ybc: the item already exists in the cache")
+	ErrNotNumeric      = errors.New("ybc: the item's value isn't a decimal number")
+	ErrTagsDisabled    = errors.New("ybc: tags are disabled in the cache. See Config.EnableTags")
+	ErrLoaderFailed    = errors.New("ybc: the loader panicked")
+
+	ErrEncryptionUnsupported = errors.New("ybc: SimpleCache doesn't support encryption. See Config.EncryptionKey")
 
 	// Errors for internal use only
 	errPanic = errors.New("ybc: panic")
@@ -77,6 +93,63 @@ type Cacher interface {
 	GetDeItem(key []byte, graceDuration time.Duration) (item *Item, err error)
 	GetDeAsyncItem(key []byte, graceDuration time.Duration) (item *Item, err error)
 	NewSetTxn(key []byte, valueSize int, ttl time.Duration) (txn *SetTxn, err error)
+	GetOrLoad(key []byte, graceDuration, ttl time.Duration, loader func(w io.Writer) error) (item *Item, err error)
+}
+
+// SimpleCache, Cache, Cluster and Namespace implement this interface
+type StatsCacher interface {
+	Stats() Stats
+}
+
+// Cache, Cluster and Namespace implement this interface
+type ScanCacher interface {
+	Iterate(f func(key []byte, item *Item) bool)
+	Occupancy() Occupancy
+}
+
+// Cache, Cluster and Namespace implement this interface
+type CasCacher interface {
+	CompareAndSet(key []byte, value []byte, ttl time.Duration, version uint64) error
+	CompareAndDelete(key []byte, version uint64) error
+	Add(key []byte, value []byte, ttl time.Duration) error
+	Incr(key []byte, delta uint64) (value uint64, err error)
+	Decr(key []byte, delta uint64) (value uint64, err error)
+	Touch(key []byte, ttl time.Duration) error
+}
+
+// Cache, Cluster and Namespace implement this interface
+type CtxCacher interface {
+	GetDeCtx(ctx context.Context, key []byte, graceDuration time.Duration) (value []byte, err error)
+	GetDeItemCtx(ctx context.Context, key []byte, graceDuration time.Duration) (item *Item, err error)
+}
+
+// Cache, Cluster and Namespace implement this interface
+type StaleCacher interface {
+	GetStaleItem(key []byte, staleFor time.Duration) (item *Item, isStale bool, err error)
+}
+
+// Cache, Cluster and Namespace implement this interface
+type MultiCacher interface {
+	GetMulti(keys [][]byte, dst [][]byte) [][]byte
+	SetMulti(items []KV, ttl time.Duration) error
+}
+
+// Cache, Cluster and Namespace implement this interface
+type TagCacher interface {
+	SetWithTags(key []byte, value []byte, ttl time.Duration, tags ...string) error
+	InvalidateTag(tag string)
+}
+
+// Cache, Cluster and Namespace implement all the Cacher interfaces.
+type fullCacher interface {
+	Cacher
+	StatsCacher
+	ScanCacher
+	CasCacher
+	CtxCacher
+	StaleCacher
+	MultiCacher
+	TagCacher
 }
 
 /*******************************************************************************
@@ -185,6 +258,117 @@ type Config struct {
 	//
 	// Leave this field empty (set to 0) if you are in doubt.
 	SyncInterval time.Duration
+
+	// Whether to verify checksums for items' values.
+	//
+	// If set, then a checksum of each item's value is stored in the cache
+	// and is verified on each item lookup. Lookups for items with checksum
+	// mismatch return ErrCorrupted and such items are evicted from the cache.
+	//
+	// Checksums require additional 8 bytes per item in the data file
+	// and additional CPU time for reading and hashing the whole value
+	// on each item's store and lookup.
+	//
+	// Items stored with distinct VerifyChecksums value are invisible
+	// in the cache, i.e. they are treated as missing.
+	VerifyChecksums bool
+
+	// Optional callback, which is called for each evicted item.
+	//
+	// The callback is called synchronously by the goroutine noticing
+	// the eviction:
+	//   * Delete() reports the deleted item.
+	//   * Clear() reports all the live items. This requires walking
+	//     the whole cache, so Clear() becomes slower.
+	//   * Item lookups report expired items and items overwritten by newer
+	//     items. The cache doesn't keep keys for overwritten items, so such
+	//     items are reported lazily on the first lookup of their keys.
+	//     Items, which aren't looked up after the eviction, aren't reported.
+	//
+	// Each evicted item is reported at most once. Items replaced by newer
+	// values under the same key aren't reported.
+	//
+	// The callback may be called concurrently from multiple goroutines.
+	// The callback may access the cache.
+	OnEvict func(key []byte, reason EvictReason)
+
+	// Compression algorithm for items' values.
+	//
+	// Values are compressed on store and are decompressed on lookup, so
+	// Item.Size() and Item's io.* interfaces work with uncompressed values.
+	// The codec is stored in each item, so the compression algorithm may be
+	// changed between cache openings without losing items. Switching
+	// to CompressionNone requires Config.EnableValueHeaders if the cache
+	// has no other codecs.
+	//
+	// Compression has the following drawbacks:
+	//   * Values are decompressed into memory on each lookup, so the whole
+	//     value occupies memory while the item is open.
+	//   * Values written via SetTxn are buffered in memory until the commit,
+	//     so ErrNoSpace is returned by the commit instead of NewSetTxn().
+	//   * Counters are updated via compare-and-set loop, so Incr() and Decr()
+	//     become slower.
+	//
+	// SimpleCache ignores this setting.
+	//
+	// Leave this field empty (set to CompressionNone) if you are in doubt.
+	Compression Compression
+
+	// AES key for encrypting items' values stored in the cache.
+	//
+	// The key must be 16, 24 or 32 bytes long for AES-128, AES-192
+	// or AES-256 respectively. Each value is encrypted and authenticated
+	// with AES-GCM. Item's key is authenticated together with the value,
+	// so values cannot be swapped between items. Item's keys aren't
+	// encrypted.
+	//
+	// Lookups treat items, which cannot be authenticated, as missing.
+	// So all the items stored with another encryption key or without
+	// encryption become invisible after changing the key. Encrypted items
+	// are invisible in caches opened without the key too.
+	//
+	// Encryption has the same drawbacks as Config.Compression plus
+	// additional 28 bytes per item in the data file.
+	//
+	// SimpleCache doesn't support encryption, so Config.OpenSimpleCache()
+	// returns ErrEncryptionUnsupported if this field is set.
+	//
+	// Leave this field empty if you don't need encryption.
+	EncryptionKey []byte
+
+	// Whether to enable tags for items in the cache.
+	//
+	// Tags allow invalidating all the items with the given tag at once
+	// via Cache.InvalidateTag(). See Cache.SetWithTags() for details.
+	//
+	// Each value is stored with a header containing item's tags,
+	// so tags have the same drawbacks as Config.Compression. Lookups
+	// for items with tags additionally look up the current generation
+	// for each tag in the cache. Tagged items remain readable after
+	// disabling tags if the cache has Config.EnableValueHeaders or other
+	// codecs.
+	//
+	// SimpleCache ignores this setting.
+	//
+	// Leave this field empty if you don't need tags.
+	EnableTags bool
+
+	// Whether to interpret the per-item header describing value's codecs
+	// in caches without codecs.
+	//
+	// Caches with Config.Compression, Config.EncryptionKey or
+	// Config.EnableTags always store values with the header. Other caches
+	// store and return values as is unless this field is set. Set it when
+	// opening the cache containing items stored with codecs after disabling
+	// all the codecs, so such items remain readable. Encrypted items remain
+	// invisible. SimpleCache treats items with the header as missing if this
+	// field is set.
+	//
+	// Values stored as is, which start with 0xff 'y' 'b' 'c' bytes, are
+	// misinterpreted after setting this field or enabling codecs.
+	//
+	// Leave this field empty if you are in doubt.
+	EnableValueHeaders bool
 }
 
 type configInternal struct {
@@ -237,7 +421,12 @@ func (cfg *Config) OpenCache(force bool) (cache *Cache, err error) {
 //   }
 //   defer sc.Close()
 //
+// Returns ErrEncryptionUnsupported if Config.EncryptionKey is set.
 func (cfg *Config) OpenSimpleCache(maxItemSize int, force bool) (sc *SimpleCache, err error) {
+	if len(cfg.EncryptionKey) > 0 {
+		err = ErrEncryptionUnsupported
+		return
+	}
 	cache, err := cfg.openCacheInternal(force, true)
 	if err != nil {
 		return
@@ -250,6 +439,13 @@ func (cfg *Config) OpenSimpleCache(maxItemSize int, force bool) (sc *SimpleCache
 }
 
 func (cfg *Config) openCacheInternal(force, isSimpleCache bool) (cache *Cache, err error) {
+	var aead cipher.AEAD
+	if len(cfg.EncryptionKey) > 0 {
+		if aead, err = newAead(cfg.EncryptionKey); err != nil {
+			return
+		}
+	}
+
 	c := cfg.internal(isSimpleCache)
 	defer C.ybc_config_destroy(c.ctx)
 
@@ -262,14 +458,26 @@ func (cfg *Config) openCacheInternal(force, isSimpleCache bool) (cache *Cache, e
 	}()
 
 	cache = &Cache{
-		buf: make([]byte, cacheSize),
-		cg:  c.cg,
+		buf:                make([]byte, cacheSize),
+		cg:                 c.cg,
+		aead:               aead,
+		enableValueHeaders: cfg.EnableValueHeaders,
+		loads:              make(map[string]*loadCall),
+	}
+	if !isSimpleCache {
+		// SimpleCache ignores these settings.
+		cache.compression = cfg.Compression
+		cache.enableTags = cfg.EnableTags
+	}
+	if cfg.OnEvict != nil {
+		cache.evictCallbackId = setEvictCallback(c, cfg.OnEvict)
 	}
 	mForce := C.int(0)
 	if force {
 		mForce = 1
 	}
 	if C.ybc_open(cache.ctx(), c.ctx, mForce) == 0 {
+		removeEvictCallback(cache.evictCallbackId)
 		cache = nil
 		err = ErrOpenFailed
 		return
@@ -287,6 +495,41 @@ func (cfg *Config) RemoveCache() {
 	C.ybc_remove(c.ctx)
 }
 
+// Copies all the live items from the cache with oldCfg into the cache
+// with newCfg.
+//
+// This allows changing cache geometry (i.e. Config.MaxItemsCount
+// and Config.DataFileSize) without losing cached items, while opening
+// existing cache with distinct geometry via Config.OpenCache(true) drops
+// all the items.
+//
+// The old cache must exist. The new cache is created if it is missing.
+// Both caches mustn't be opened during the migration. The old cache
+// remains intact, so it may be removed with oldCfg.RemoveCache() after
+// successful migration.
+//
+// Migrated items retain their ttl. Items, which don't fit the new cache,
+// are skipped.
+//
+// Returns ErrOpenFailed if the old cache cannot be opened, the new cache
+// cannot be created or both caches share the same files.
+func Migrate(oldCfg, newCfg *Config) error {
+	oldc := oldCfg.internal(false)
+	defer C.ybc_config_destroy(oldc.ctx)
+	newc := newCfg.internal(false)
+	defer C.ybc_config_destroy(newc.ctx)
+
+	oldc.cg.Acquire()
+	defer oldc.cg.Release()
+	newc.cg.Acquire()
+	defer newc.cg.Release()
+
+	if C.ybc_migrate(oldc.ctx, newc.ctx) == 0 {
+		return ErrOpenFailed
+	}
+	return nil
+}
+
 func (cfg *Config) internal(isSimpleCache bool) *configInternal {
 	c := &configInternal{
 		buf: make([]byte, configSize),
@@ -325,6 +568,9 @@ func (cfg *Config) internal(isSimpleCache bool) *configInternal {
 		}
 		C.ybc_config_set_sync_interval(ctx, C.uint64_t(syncInterval/time.Millisecond))
 	}
+	if cfg.VerifyChecksums {
+		C.ybc_config_enable_value_checksums(ctx)
+	}
 	if isSimpleCache {
 		C.ybc_config_disable_overwrite_protection(ctx)
 	}
@@ -333,6 +579,97 @@ func (cfg *Config) internal(isSimpleCache bool) *configInternal {
 	return c
 }
 
+/*******************************************************************************
+ * Stats
+ ******************************************************************************/
+
+// Cache statistics returned by Stats() methods.
+//
+// All the counters are accumulated since the cache has been opened.
+// Counters may be slightly inaccurate under concurrent access.
+type Stats struct {
+	// The number of item lookups. Gets = Hits + Misses.
+	Gets uint64
+
+	// The number of lookups, which found the item in the cache.
+	Hits uint64
+
+	// The number of lookups, which didn't find the item in the cache.
+	Misses uint64
+
+	// The number of items stored in the cache.
+	//
+	// Items moved by 'hot data' defragmentation are counted here too.
+	// See Config.HotDataSize for details.
+	Sets uint64
+
+	// The number of items deleted from the cache via Delete().
+	Deletes uint64
+
+	// The number of rolled back 'set transactions'.
+	SetTxnRollbacks uint64
+
+	// The number of times dogpile effect-aware functions had to wait
+	// for an item, which is being created by somebody else.
+	DeWaits uint64
+
+	// The number of items moved by 'hot data' defragmentation.
+	Defragmentations uint64
+
+	// The number of items evicted due to checksum mismatch.
+	// See Config.VerifyChecksums for details.
+	ChecksumMismatches uint64
+
+	// The number of items evicted by newer items, since the data file
+	// is a ring buffer.
+	//
+	// Evicted items are counted lazily on the first lookup of their keys,
+	// so items, which aren't looked up after the eviction, aren't counted.
+	// See also StorageWraps.
+	Evictions uint64
+
+	// The number of times the data file wrapped around its' end.
+	// Each wrap evicts the oldest items from the cache.
+	StorageWraps uint64
+
+	// The number of data file flushes. See Config.SyncInterval.
+	SyncFlushes uint64
+}
+
+func (s *Stats) add(other *Stats) {
+	s.Gets += other.Gets
+	s.Hits += other.Hits
+	s.Misses += other.Misses
+	s.Sets += other.Sets
+	s.Deletes += other.Deletes
+	s.SetTxnRollbacks += other.SetTxnRollbacks
+	s.DeWaits += other.DeWaits
+	s.Defragmentations += other.Defragmentations
+	s.ChecksumMismatches += other.ChecksumMismatches
+	s.Evictions += other.Evictions
+	s.StorageWraps += other.StorageWraps
+	s.SyncFlushes += other.SyncFlushes
+}
+
+// Cache occupancy. See Cache.Occupancy() for details.
+type Occupancy struct {
+	// The number of live items in the cache.
+	ItemsCount uint64
+
+	// The total size in bytes of live items in the data file, including
+	// keys and items' metadata.
+	UsedSize uint64
+
+	// The data file size in bytes.
+	DataFileSize uint64
+}
+
+func (o *Occupancy) add(other *Occupancy) {
+	o.ItemsCount += other.ItemsCount
+	o.UsedSize += other.UsedSize
+	o.DataFileSize += other.DataFileSize
+}
+
 /*******************************************************************************
  * Simple Cache - cache with simplified interface.
  ******************************************************************************/
@@ -398,6 +735,12 @@ func (sc *SimpleCache) Get(key []byte) (value []byte, err error) {
 		return
 	}
 	value = value[:int(v.size)]
+	if sc.cache.hasValueHeaders() && hasValueHeader(value) {
+		// SimpleCache cannot decode values stored by Cache with codecs,
+		// so treat them as missing. See Cache.encode() for details.
+		value = nil
+		err = ErrCacheMiss
+	}
 	return
 }
 
@@ -414,6 +757,11 @@ func (sc *SimpleCache) Clear() {
 	sc.cache.Clear()
 }
 
+// Returns cache statistics.
+func (sc *SimpleCache) Stats() Stats {
+	return sc.cache.Stats()
+}
+
 /*******************************************************************************
  * Cache
  ******************************************************************************/
@@ -429,6 +777,16 @@ type Cache struct {
 	dg  debugGuard
 	cg  cacheGuard
 	buf []byte
+
+	evictCallbackId    uintptr
+	compression        Compression
+	aead               cipher.AEAD
+	enableTags         bool
+	enableValueHeaders bool
+
+	// Pending GetOrLoad() calls. See Cache.GetOrLoad() for details.
+	loadsLock sync.Mutex
+	loads     map[string]*loadCall
 }
 
 // Closes the cache.
@@ -439,6 +797,7 @@ func (cache *Cache) Close() error {
 	cache.dg.Close()
 	cache.cg.Release()
 	C.ybc_close(cache.ctx())
+	removeEvictCallback(cache.evictCallbackId)
 	return nil
 }
 
@@ -448,6 +807,10 @@ func (cache *Cache) Close() error {
 // files - use Cache.NewSetTxn() instead.
 func (cache *Cache) Set(key []byte, value []byte, ttl time.Duration) error {
 	cache.dg.CheckLive()
+	return cache.setEncoded(key, cache.encode(key, value), ttl)
+}
+
+func (cache *Cache) setEncoded(key []byte, value []byte, ttl time.Duration) error {
 	var k C.struct_ybc_key
 	initKey(&k, key)
 	var v C.struct_ybc_value
@@ -458,9 +821,169 @@ func (cache *Cache) Set(key []byte, value []byte, ttl time.Duration) error {
 	return nil
 }
 
+// Key-value pair for Cache.SetMulti().
+type KV struct {
+	Key   []byte
+	Value []byte
+}
+
+// Stores the given items with the given ttl in the cache.
+//
+// The method crosses cgo boundary once per call instead of once per item,
+// so it is faster than Cache.Set() calls in a loop for small values.
+//
+// Items are stored in order. Returns ErrNoSpace on the first item, which
+// cannot be stored. Preceding items remain stored in this case.
+func (cache *Cache) SetMulti(items []KV, ttl time.Duration) error {
+	cache.dg.CheckLive()
+	if len(items) == 0 {
+		return nil
+	}
+	ks := make([]C.struct_ybc_key, len(items))
+	vs := make([]C.struct_ybc_value, len(items))
+	for i := range items {
+		kv := &items[i]
+		initKey(&ks[i], kv.Key)
+		initValue(&vs[i], cache.encode(kv.Key, kv.Value), ttl)
+	}
+	if int(C.go_set_multi(cache.ctx(), &ks[0], &vs[0], C.size_t(len(items)))) != len(items) {
+		return ErrNoSpace
+	}
+	return nil
+}
+
+// Stores value with the given key and the given ttl in the cache only if
+// the item with the given key has the given version.
+//
+// The version may be obtained via Item.Version() call. The check
+// and the store are performed atomically, so this method may be used
+// for implementing optimistic concurrency control (aka compare-and-swap).
+//
+// Returns ErrCacheMiss if the item is missing in the cache.
+// Returns ErrVersionMismatch if the item has been modified, i.e. it has
+// distinct version.
+func (cache *Cache) CompareAndSet(key []byte, value []byte, ttl time.Duration, version uint64) error {
+	cache.dg.CheckLive()
+	var k C.struct_ybc_key
+	initKey(&k, key)
+	var v C.struct_ybc_value
+	initValue(&v, cache.encode(key, value), ttl)
+	return setStatusToError(C.ybc_item_set_if_version(cache.ctx(), &k, &v, C.uint64_t(version)), ErrVersionMismatch)
+}
+
+// Deletes the item with the given key from the cache only if the item
+// has the given version.
+//
+// The version may be obtained via Item.Version() call. The check
+// and the deletion are performed atomically.
+//
+// Returns ErrCacheMiss if the item is missing in the cache.
+// Returns ErrVersionMismatch if the item has been modified, i.e. it has
+// distinct version.
+func (cache *Cache) CompareAndDelete(key []byte, version uint64) error {
+	cache.dg.CheckLive()
+	var k C.struct_ybc_key
+	initKey(&k, key)
+	return setStatusToError(C.ybc_item_remove_if_version(cache.ctx(), &k, C.uint64_t(version)), ErrVersionMismatch)
+}
+
+// Stores value with the given key and the given ttl in the cache only if
+// there is no live item with the given key in the cache.
+//
+// Expired items aren't considered live, so they are overwritten. The check
+// and the store are performed atomically.
+//
+// Returns ErrAlreadyExists if the item with the given key exists
+// in the cache.
+func (cache *Cache) Add(key []byte, value []byte, ttl time.Duration) error {
+	cache.dg.CheckLive()
+	var k C.struct_ybc_key
+	initKey(&k, key)
+	var v C.struct_ybc_value
+	initValue(&v, cache.encode(key, value), ttl)
+	return setStatusToError(C.ybc_item_add(cache.ctx(), &k, &v), ErrAlreadyExists)
+}
+
+// Atomically adds delta to the counter stored under the given key
+// and returns the new counter's value.
+//
+// The counter's value must be an unsigned 64-bit integer in decimal
+// representation, i.e. it is compatible with memcache's incr command.
+// The counter wraps around on overflow. Concurrent updates of the counter
+// are never lost. The counter retains its' ttl.
+//
+// Returns ErrCacheMiss if the counter is missing in the cache.
+// Returns ErrNotNumeric if the item's value isn't a decimal number.
+func (cache *Cache) Incr(key []byte, delta uint64) (value uint64, err error) {
+	return cache.incr(key, delta, false)
+}
+
+// Atomically subtracts delta from the counter stored under the given key
+// and returns the new counter's value.
+//
+// Unlike Cache.Incr(), the counter never underflows - it stops at zero.
+// See Cache.Incr() for details.
+func (cache *Cache) Decr(key []byte, delta uint64) (value uint64, err error) {
+	return cache.incr(key, delta, true)
+}
+
+func (cache *Cache) incr(key []byte, delta uint64, isDecrement bool) (value uint64, err error) {
+	cache.dg.CheckLive()
+	if cache.hasValueHeaders() {
+		return cache.incrEncoded(key, delta, isDecrement)
+	}
+	var k C.struct_ybc_key
+	initKey(&k, key)
+	mIsDecrement := C.int(0)
+	if isDecrement {
+		mIsDecrement = 1
+	}
+	var v C.uint64_t
+	err = setStatusToError(C.ybc_item_incr(cache.ctx(), &k, C.uint64_t(delta), mIsDecrement, &v), nil)
+	value = uint64(v)
+	return
+}
+
+// ybc_item_incr() cannot parse encoded values, so encoded counters
+// are updated via compare-and-set loop.
+func (cache *Cache) incrEncoded(key []byte, delta uint64, isDecrement bool) (value uint64, err error) {
+	for {
+		var item *Item
+		if item, err = cache.GetItem(key); err != nil {
+			return
+		}
+		value, err = strconv.ParseUint(string(item.unsafeBuf()), 10, 64)
+		version := item.Version()
+		ttl := item.Ttl()
+		item.Close()
+		if err != nil {
+			value = 0
+			err = ErrNotNumeric
+			return
+		}
+
+		if !isDecrement {
+			value += delta
+		} else if value > delta {
+			value -= delta
+		} else {
+			value = 0
+		}
+		err = cache.CompareAndSet(key, strconv.AppendUint(nil, value, 10), ttl, version)
+		if err != ErrVersionMismatch {
+			if err != nil {
+				value = 0
+			}
+			return
+		}
+	}
+}
+
 // Returns value associated with the given key from the cache.
 //
 // Sets err to ErrCacheMiss on cache miss.
+// Sets err to ErrCorrupted if item's value is corrupted. See
+// Config.VerifyChecksums for details.
 //
 // Do not use this method for obtaining big values from the cache such as video
 // files - use Cache.GetItem() instead.
@@ -476,6 +999,62 @@ func (cache *Cache) Get(key []byte) (value []byte, err error) {
 	return
 }
 
+// The expected average value size used for the initial buffer size
+// in Cache.GetMulti().
+const getMultiValueSizeHint = 64
+
+// Appends values for the given keys to dst and returns the result.
+//
+// Values follow in the order of keys. Values for missing items are nil.
+//
+// The method crosses cgo boundary once per call instead of once per key,
+// so it is faster than Cache.Get() calls in a loop for small values.
+func (cache *Cache) GetMulti(keys [][]byte, dst [][]byte) [][]byte {
+	cache.dg.CheckLive()
+	if len(keys) == 0 {
+		return dst
+	}
+	ks := make([]C.struct_ybc_key, len(keys))
+	for i, key := range keys {
+		initKey(&ks[i], key)
+	}
+	sizes := make([]C.size_t, len(keys))
+	bufSize := len(keys) * getMultiValueSizeHint
+	for len(keys) > 0 {
+		buf := make([]byte, bufSize)
+		n := int(C.go_get_multi(cache.ctx(), &ks[0], C.size_t(len(ks)), unsafe.Pointer(&buf[0]), C.size_t(len(buf)), &sizes[0]))
+		offset := 0
+		for i := 0; i < n; i++ {
+			if sizes[i] == ^C.size_t(0) {
+				dst = append(dst, nil)
+				continue
+			}
+			size := int(sizes[i])
+			value := buf[offset : offset+size : offset+size]
+			offset += size
+			if cache.hasValueHeaders() {
+				var err error
+				if value, err = cache.decodeValue(keys[i], value); err != nil {
+					value = nil
+				}
+			}
+			dst = append(dst, value)
+		}
+		if n == len(keys) {
+			break
+		}
+
+		// The value for keys[n] doesn't fit the buffer.
+		// Obtain the remaining values into a bigger buffer.
+		keys, ks, sizes = keys[n:], ks[n:], sizes[n:]
+		bufSize *= 2
+		if size := int(sizes[0]); size > bufSize {
+			bufSize = size
+		}
+	}
+	return dst
+}
+
 // Returns value associated with the given key from the cache using automatic
 // protection against dogpile effect during graceDuration interval.
 //
@@ -501,6 +1080,23 @@ func (cache *Cache) GetDe(key []byte, graceDuration time.Duration) (value []byte
 	return
 }
 
+// The same as Cache.GetDe(), but stops waiting for the value affected
+// by dogpile effect when ctx is done. Sets err to ctx.Err() in this case.
+//
+// Do not use this method for obtaining big values from the cache such as video
+// files - use Cache.GetDeItemCtx() instead.
+func (cache *Cache) GetDeCtx(ctx context.Context, key []byte, graceDuration time.Duration) (value []byte, err error) {
+	item, err := cache.GetDeItemCtx(ctx, key, graceDuration)
+	if err != nil {
+		return
+	}
+	value = item.Value()
+
+	// do not use defer item.Close() for performance reasons
+	item.Close()
+	return
+}
+
 // The same as Cache.GetDe(), but sets err to ErrWouldBlock instead of waiting
 // for the value affected by dogpile effect.
 //
@@ -528,6 +1124,26 @@ func (cache *Cache) Delete(key []byte) bool {
 	return C.ybc_item_remove(cache.ctx(), &k) != C.int(0)
 }
 
+// Sets ttl for the item with the given key.
+//
+// Unlike Cache.Set(), this method doesn't copy item's value, so it is cheap
+// even for large items. This may be used for sliding expiration.
+// Item's value and version remain unchanged.
+//
+// Returns ErrCacheMiss if the item is missing in the cache.
+func (cache *Cache) Touch(key []byte, ttl time.Duration) error {
+	cache.dg.CheckLive()
+	if ttl < 0 {
+		ttl = 0
+	}
+	var k C.struct_ybc_key
+	initKey(&k, key)
+	if C.ybc_item_touch(cache.ctx(), &k, C.uint64_t(ttl/time.Millisecond)) == 0 {
+		return ErrCacheMiss
+	}
+	return nil
+}
+
 // The same as Cache.Set(), but additionally returns item object associated
 // with just addded item.
 //
@@ -537,12 +1153,15 @@ func (cache *Cache) SetItem(key []byte, value []byte, ttl time.Duration) (item *
 	item = acquireItem()
 	var k C.struct_ybc_key
 	initKey(&k, key)
-	initValue(&item.value, value, ttl)
+	initValue(&item.value, cache.encode(key, value), ttl)
 	if C.go_set_item_and_value(cache.ctx(), item.ctx(), &k, &item.value) == 0 {
 		releaseItem(item)
 		err = ErrNoSpace
 		return
 	}
+	if cache.hasValueHeaders() {
+		item.decoded = append([]byte{}, value...)
+	}
 	item.dg.Init()
 	return
 }
@@ -550,6 +1169,8 @@ func (cache *Cache) SetItem(key []byte, value []byte, ttl time.Duration) (item *
 // The same as Cache.Get(), but returns item instead of item's value.
 //
 // Sets err to ErrCacheMiss on cache miss.
+// Sets err to ErrCorrupted if item's value is corrupted. See
+// Config.VerifyChecksums for details.
 //
 // The returned item must be closed with item.Close() call!
 //
@@ -560,12 +1181,20 @@ func (cache *Cache) GetItem(key []byte) (item *Item, err error) {
 	item = acquireItem()
 	var k C.struct_ybc_key
 	initKey(&k, key)
-	if C.go_get_item_and_value(cache.ctx(), item.ctx(), &item.value, &k) == 0 {
+	switch C.go_get_item_and_value(cache.ctx(), item.ctx(), &item.value, &k) {
+	case 0:
 		releaseItem(item)
 		err = ErrCacheMiss
 		return
+	case -1:
+		releaseItem(item)
+		err = ErrCorrupted
+		return
 	}
 	item.dg.Init()
+	if err = cache.decode(key, item); err != nil {
+		item.Close()
+	}
 	return
 }
 
@@ -576,10 +1205,26 @@ func (cache *Cache) GetItem(key []byte) (item *Item, err error) {
 // Use this method instead of Cache.GetDe() for obtaining big values
 // from the cache such as video files.
 func (cache *Cache) GetDeItem(key []byte, graceDuration time.Duration) (item *Item, err error) {
+	return cache.GetDeItemCtx(context.Background(), key, graceDuration)
+}
+
+// The same as Cache.GetDeCtx(), but returns item instead of item's value.
+//
+// The returned item must be closed with item.Close() call!
+//
+// Use this method instead of Cache.GetDeCtx() for obtaining big values
+// from the cache such as video files.
+func (cache *Cache) GetDeItemCtx(ctx context.Context, key []byte, graceDuration time.Duration) (item *Item, err error) {
+	// The wait is counted in cache stats only once by the first attempt.
+	isRetry := false
 	for {
-		item, err = cache.GetDeAsyncItem(key, graceDuration)
+		item, err = cache.getDeAsyncItem(key, graceDuration, isRetry)
 		if err == ErrWouldBlock {
-			time.Sleep(time.Millisecond * 100)
+			isRetry = true
+			if err = sleepCtx(ctx, time.Millisecond*100); err != nil {
+				item = nil
+				return
+			}
 			continue
 		}
 		return
@@ -594,6 +1239,10 @@ func (cache *Cache) GetDeItem(key []byte, graceDuration time.Duration) (item *It
 // Use this method instead of Cache.GetDeAsync() for obtaining big values
 // from the cache such as video files.
 func (cache *Cache) GetDeAsyncItem(key []byte, graceDuration time.Duration) (item *Item, err error) {
+	return cache.getDeAsyncItem(key, graceDuration, false)
+}
+
+func (cache *Cache) getDeAsyncItem(key []byte, graceDuration time.Duration, isRetry bool) (item *Item, err error) {
 	cache.dg.CheckLive()
 	if graceDuration < 0 {
 		graceDuration = 0
@@ -602,7 +1251,11 @@ func (cache *Cache) GetDeAsyncItem(key []byte, graceDuration time.Duration) (ite
 	var k C.struct_ybc_key
 	initKey(&k, key)
 	mGraceTtl := C.uint64_t(graceDuration / time.Millisecond)
-	switch C.go_get_item_and_value_de_async(cache.ctx(), item.ctx(), &item.value, &k, mGraceTtl) {
+	mIsRetry := C.int(0)
+	if isRetry {
+		mIsRetry = 1
+	}
+	switch C.go_get_item_and_value_de_async(cache.ctx(), item.ctx(), &item.value, &k, mGraceTtl, mIsRetry) {
 	case C.YBC_DE_WOULDBLOCK:
 		releaseItem(item)
 		err = ErrWouldBlock
@@ -613,11 +1266,64 @@ func (cache *Cache) GetDeAsyncItem(key []byte, graceDuration time.Duration) (ite
 		return
 	case C.YBC_DE_SUCCESS:
 		item.dg.Init()
+		if err = cache.decode(key, item); err != nil {
+			item.Close()
+		}
 		return
 	}
 	panic("unreachable")
 }
 
+// Obtains an item with the given key. Items, which expired less than staleFor
+// ago, are returned too.
+//
+// isStale is set to true for expired items. Item.Ttl() returns 0 for them.
+//
+// Item.ShouldRefresh() returns true only for the first caller obtaining
+// an expired item. The caller must refresh the item, i.e. store new value
+// under the given key. Other callers obtain the expired item, for which
+// Item.ShouldRefresh() returns false, until the item is refreshed or staleFor
+// passes, so they don't have to wait for the refreshed item. This works
+// in tandem with Cache.GetDe*() methods, i.e. Item.ShouldRefresh() returns
+// false while the item is being refreshed by Cache.GetDe*() caller.
+//
+// Expired items are evicted on lookup if Config.OnEvict is set, so they cannot
+// be obtained via this method after that.
+//
+// The returned item must be closed with item.Close() call!
+func (cache *Cache) GetStaleItem(key []byte, staleFor time.Duration) (item *Item, isStale bool, err error) {
+	cache.dg.CheckLive()
+	if staleFor < 0 {
+		staleFor = 0
+	}
+	item = acquireItem()
+	var k C.struct_ybc_key
+	initKey(&k, key)
+	mStaleTtl := C.uint64_t(staleFor / time.Millisecond)
+	var cIsStale, cShouldRefresh C.int
+	switch C.go_get_item_and_value_stale(cache.ctx(), item.ctx(), &item.value, &k, mStaleTtl, &cIsStale, &cShouldRefresh) {
+	case 0:
+		releaseItem(item)
+		item = nil
+		err = ErrCacheMiss
+		return
+	case -1:
+		releaseItem(item)
+		item = nil
+		err = ErrCorrupted
+		return
+	}
+	item.dg.Init()
+	if err = cache.decode(key, item); err != nil {
+		item.Close()
+		item = nil
+		return
+	}
+	isStale = (cIsStale != 0)
+	item.shouldRefresh = (cShouldRefresh != 0)
+	return
+}
+
 // Starts new 'set transaction' for storing an item in the cache
 // with the given valueSize size, the given ttl and the given key.
 //
@@ -632,6 +1338,16 @@ func (cache *Cache) NewSetTxn(key []byte, valueSize int, ttl time.Duration) (txn
 		ttl = 0
 	}
 	txn = acquireSetTxn()
+	if cache.hasValueHeaders() {
+		// The size of encoded value is unknown until the whole value
+		// is written, so buffer the value until the commit.
+		txn.cache = cache
+		txn.key = append(txn.key[:0], key...)
+		txn.ttl = ttl
+		txn.unsafeBufCache = make([]byte, valueSize)
+		txn.dg.Init()
+		return
+	}
 	var k C.struct_ybc_key
 	initKey(&k, key)
 	if C.ybc_set_txn_begin(cache.ctx(), txn.ctx(), &k, C.size_t(valueSize), C.uint64_t(ttl/time.Millisecond)) == 0 {
@@ -642,6 +1358,42 @@ func (cache *Cache) NewSetTxn(key []byte, valueSize int, ttl time.Duration) (txn
 	return
 }
 
+// Calls f for each live item in the cache.
+//
+// The iteration stops when f returns false.
+//
+// The item passed to f is valid only until f returns. Do not close it -
+// it is closed automatically.
+//
+// Items added, deleted or evicted during the iteration may be either skipped
+// or passed to f.
+func (cache *Cache) Iterate(f func(key []byte, item *Item) bool) {
+	cache.dg.CheckLive()
+	var cursor C.size_t
+	for {
+		item := acquireItem()
+		var k C.struct_ybc_key
+		if C.go_iterate(cache.ctx(), item.ctx(), &item.value, &k, &cursor) == 0 {
+			releaseItem(item)
+			return
+		}
+		item.dg.Init()
+		key := C.GoBytes(k.ptr, C.int(k.size))
+		if cache.decode(key, item) != nil {
+			// Skip corrupted items.
+			item.Close()
+			continue
+		}
+		ok := f(key, item)
+
+		// do not use defer item.Close() for performance reasons
+		item.Close()
+		if !ok {
+			return
+		}
+	}
+}
+
 // Instantly removes all the cache contents.
 //
 // This method is very fast - its' speed doesn't depend on the number of items
@@ -651,6 +1403,169 @@ func (cache *Cache) Clear() {
 	C.ybc_clear(cache.ctx())
 }
 
+// Returns cache statistics.
+func (cache *Cache) Stats() Stats {
+	cache.dg.CheckLive()
+	var s C.struct_ybc_stats
+	C.ybc_get_stats(cache.ctx(), &s)
+	return Stats{
+		Gets:               uint64(s.gets),
+		Hits:               uint64(s.hits),
+		Misses:             uint64(s.misses),
+		Sets:               uint64(s.sets),
+		Deletes:            uint64(s.removes),
+		SetTxnRollbacks:    uint64(s.set_txn_rollbacks),
+		DeWaits:            uint64(s.de_waits),
+		Defragmentations:   uint64(s.defragmentations),
+		ChecksumMismatches: uint64(s.checksum_mismatches),
+		Evictions:          uint64(s.evictions),
+		StorageWraps:       uint64(s.storage_wraps),
+		SyncFlushes:        uint64(s.sync_flushes),
+	}
+}
+
+// Returns cache occupancy.
+//
+// Unlike Cache.Stats(), this method scans the whole cache index, so it may
+// be slow for caches with big MaxItemsCount. Avoid calling it in hot paths.
+func (cache *Cache) Occupancy() Occupancy {
+	cache.dg.CheckLive()
+	var o C.struct_ybc_occupancy
+	C.ybc_get_occupancy(cache.ctx(), &o)
+	return Occupancy{
+		ItemsCount:   uint64(o.items_count),
+		UsedSize:     uint64(o.used_size),
+		DataFileSize: uint64(o.data_file_size),
+	}
+}
+
+// Returns true if values are stored with the header in the cache.
+// See Config.EnableValueHeaders for details.
+func (cache *Cache) hasValueHeaders() bool {
+	return cache.enableValueHeaders || cache.compression != CompressionNone || cache.aead != nil || cache.enableTags
+}
+
+/*******************************************************************************
+ * Encoded value format.
+ *
+ * value := valueHeaderMagic flags:byte payload
+ *
+ * flags describe codecs applied to the original value:
+ *   * flags & valueFlagCodecMask - compression codec. See compressValue().
+ *   * valueFlagTagged - the value is prepended by tags header.
+ *     See appendTaggedValue().
+ *   * valueFlagEncrypted - the value is encrypted. See encryptValue().
+ *
+ * Codecs are applied in the following order: tags, compression, encryption.
+ *
+ * Values without codecs are stored as is, without the header, unless they
+ * start with valueHeaderMagic. Since flags are stored per item, items remain
+ * readable after changing Config.Compression or Config.EnableTags, while items
+ * stored with and without encryption are told apart after changing
+ * Config.EncryptionKey.
+ *
+ * The header is interpreted only in caches with Config.EnableValueHeaders
+ * or with codecs. Other caches store and return values as is, since arbitrary
+ * binary values, e.g. memcache server's values prefixed by casid, may start
+ * with valueHeaderMagic. Values stored as is in such caches are misinterpreted
+ * after enabling the header if they start with valueHeaderMagic.
+ ******************************************************************************/
+
+const valueHeaderMagic = "\xffybc"
+
+const (
+	valueHeaderSize = len(valueHeaderMagic) + 1
+
+	valueFlagCodecMask = byte(3)
+	valueFlagTagged    = byte(4)
+	valueFlagEncrypted = byte(8)
+)
+
+func hasValueHeader(value []byte) bool {
+	return len(value) >= len(valueHeaderMagic) && string(value[:len(valueHeaderMagic)]) == valueHeaderMagic
+}
+
+// Converts value into the form stored in the cache.
+func (cache *Cache) encode(key, value []byte) []byte {
+	return cache.encodeWithTags(key, value, nil)
+}
+
+// Converts value with the given tags header into the form stored
+// in the cache. See Cache.SetWithTags() for details.
+func (cache *Cache) encodeWithTags(key, value, tagsHeader []byte) []byte {
+	var flags byte
+	if cache.enableTags {
+		value = appendTaggedValue(tagsHeader, value)
+		flags |= valueFlagTagged
+	}
+	if cache.compression != CompressionNone {
+		var codec byte
+		codec, value = compressValue(cache.compression, value)
+		flags |= codec
+	}
+	if cache.aead != nil {
+		value = encryptValue(cache.aead, key, value)
+		flags |= valueFlagEncrypted
+	}
+	if flags == 0 && (!cache.hasValueHeaders() || !hasValueHeader(value)) {
+		return value
+	}
+	buf := make([]byte, 0, valueHeaderSize+len(value))
+	buf = append(buf, valueHeaderMagic...)
+	buf = append(buf, flags)
+	return append(buf, value...)
+}
+
+// Converts item's value stored in the cache into the original form.
+func (cache *Cache) decode(key []byte, item *Item) (err error) {
+	buf := item.unsafeBuf()
+	if !cache.hasValueHeaders() {
+		return
+	}
+	item.decoded, err = cache.decodeValue(key, buf)
+	return
+}
+
+// Converts value stored in the cache into the original form.
+//
+// Returns ErrCacheMiss if the value cannot be authenticated. This includes
+// unencrypted values in caches with encryption and encrypted values in caches
+// without encryption.
+func (cache *Cache) decodeValue(key, value []byte) (v []byte, err error) {
+	var flags byte
+	if hasValueHeader(value) {
+		if len(value) < valueHeaderSize {
+			err = ErrCorrupted
+			return
+		}
+		flags = value[len(valueHeaderMagic)]
+		value = value[valueHeaderSize:]
+		if flags&^(valueFlagCodecMask|valueFlagTagged|valueFlagEncrypted) != 0 {
+			err = ErrCorrupted
+			return
+		}
+	}
+	if (flags&valueFlagEncrypted != 0) != (cache.aead != nil) {
+		err = ErrCacheMiss
+		return
+	}
+	if cache.aead != nil {
+		if value, err = decryptValue(cache.aead, key, value); err != nil {
+			return
+		}
+	}
+	if value, err = decompressValue(flags&valueFlagCodecMask, value); err != nil {
+		return
+	}
+	if flags&valueFlagTagged != 0 {
+		if value, err = cache.checkTags(value); err != nil {
+			return
+		}
+	}
+	v = value
+	return
+}
+
 func (cache *Cache) ctx() *C.struct_ybc {
 	return (*C.struct_ybc)(unsafe.Pointer(&cache.buf[0]))
 }
@@ -667,6 +1582,12 @@ type SetTxn struct {
 	buf            []byte
 	unsafeBufCache []byte
 	offset         int
+
+	// The following fields are set only for caches with compression
+	// or encryption. See Config.Compression and Config.EncryptionKey.
+	cache *Cache
+	key   []byte
+	ttl   time.Duration
 }
 
 // Commits the truncated transaction.
@@ -689,7 +1610,55 @@ func (txn *SetTxn) Commit() (err error) {
 		txn.Rollback()
 		return
 	}
-	C.ybc_set_txn_commit(txn.ctx())
+	if txn.cache != nil {
+		err = txn.cache.Set(txn.key, buf, txn.ttl)
+	} else {
+		C.ybc_set_txn_commit(txn.ctx())
+	}
+	txn.finish()
+	return
+}
+
+// Commits the transaction only if the item with the transaction's key
+// has the given version in the cache.
+//
+// The transaction is rolled back if the item is missing or has distinct
+// version. See Cache.CompareAndSet() for details.
+func (txn *SetTxn) CompareAndCommit(version uint64) (err error) {
+	txn.dg.CheckLive()
+	buf := txn.unsafeBuf()
+	if txn.offset != len(buf) {
+		err = ErrPartialCommit
+		txn.Rollback()
+		return
+	}
+	if txn.cache != nil {
+		err = txn.cache.CompareAndSet(txn.key, buf, txn.ttl, version)
+	} else {
+		err = setStatusToError(C.ybc_set_txn_commit_if_version(txn.ctx(), C.uint64_t(version)), ErrVersionMismatch)
+	}
+	txn.finish()
+	return
+}
+
+// Commits the transaction only if there is no live item with
+// the transaction's key in the cache.
+//
+// The transaction is rolled back if the item exists. See Cache.Add()
+// for details.
+func (txn *SetTxn) CommitAdd() (err error) {
+	txn.dg.CheckLive()
+	buf := txn.unsafeBuf()
+	if txn.offset != len(buf) {
+		err = ErrPartialCommit
+		txn.Rollback()
+		return
+	}
+	if txn.cache != nil {
+		err = txn.cache.Add(txn.key, buf, txn.ttl)
+	} else {
+		err = setStatusToError(C.ybc_set_txn_commit_add(txn.ctx()), ErrAlreadyExists)
+	}
 	txn.finish()
 	return
 }
@@ -697,7 +1666,9 @@ func (txn *SetTxn) Commit() (err error) {
 // Rolls back the transaction.
 func (txn *SetTxn) Rollback() {
 	txn.dg.CheckLive()
-	C.ybc_set_txn_rollback(txn.ctx())
+	if txn.cache == nil {
+		C.ybc_set_txn_rollback(txn.ctx())
+	}
 	txn.finish()
 }
 
@@ -745,6 +1716,11 @@ func (txn *SetTxn) CommitItem() (item *Item, err error) {
 		txn.Rollback()
 		return
 	}
+	if txn.cache != nil {
+		item, err = txn.cache.SetItem(txn.key, buf, txn.ttl)
+		txn.finish()
+		return
+	}
 	item = acquireItem()
 	C.go_commit_item_and_value(txn.ctx(), item.ctx(), &item.value)
 	txn.finish()
@@ -754,6 +1730,10 @@ func (txn *SetTxn) CommitItem() (item *Item, err error) {
 
 func (txn *SetTxn) truncateValue() {
 	txn.dg.CheckLive()
+	if txn.cache != nil {
+		txn.unsafeBufCache = txn.unsafeBufCache[:txn.offset]
+		return
+	}
 	txn.unsafeBufCache = nil
 	C.ybc_set_txn_update_value_size(txn.ctx(), C.size_t(txn.offset))
 }
@@ -762,6 +1742,7 @@ func (txn *SetTxn) finish() {
 	txn.dg.Close()
 	txn.unsafeBufCache = nil
 	txn.offset = 0
+	txn.cache = nil
 	releaseSetTxn(txn)
 }
 
@@ -788,6 +1769,14 @@ type Item struct {
 	buf        []byte
 	value      C.struct_ybc_value
 	offset     int
+
+	// Decoded value for caches with compression or encryption.
+	// See Config.Compression and Config.EncryptionKey for details.
+	decoded []byte
+
+	// Whether the caller must refresh the stale item.
+	// See Cache.GetStaleItem() for details.
+	shouldRefresh bool
 }
 
 // Closes the item.
@@ -799,6 +1788,8 @@ func (item *Item) Close() error {
 	item.value.ptr = nil
 	item.value.size = 0
 	item.offset = 0
+	item.decoded = nil
+	item.shouldRefresh = false
 	releaseItem(item)
 	return nil
 }
@@ -809,12 +1800,18 @@ func (item *Item) Close() error {
 // use io.* interface implementations provided by the Item instead.
 func (item *Item) Value() []byte {
 	item.dg.CheckLive()
+	if item.decoded != nil {
+		return append([]byte{}, item.decoded...)
+	}
 	mValue := &item.value
 	return C.GoBytes(mValue.ptr, C.int(mValue.size))
 }
 
 // Returns the size of value associated with the item.
 func (item *Item) Size() int {
+	if item.decoded != nil {
+		return len(item.decoded)
+	}
 	return int(item.value.size)
 }
 
@@ -829,6 +1826,29 @@ func (item *Item) Ttl() time.Duration {
 	return time.Duration(item.value.ttl) * time.Millisecond
 }
 
+// Returns true if the stale item obtained via Cache.GetStaleItem() must be
+// refreshed by the caller.
+//
+// See Cache.GetStaleItem() for details.
+func (item *Item) ShouldRefresh() bool {
+	item.dg.CheckLive()
+	return item.shouldRefresh
+}
+
+// Returns item's version.
+//
+// The version changes whenever a new value is stored under the item's key.
+// See Cache.CompareAndSet() for details.
+func (item *Item) Version() uint64 {
+	item.dg.CheckLive()
+	return uint64(C.ybc_item_get_version(item.ctx()))
+}
+
+func (item *Item) ttlMillis() uint64 {
+	item.dg.CheckLive()
+	return uint64(item.value.ttl)
+}
+
 // io.Seeker interface implementation
 func (item *Item) Seek(offset int64, whence int) (ret int64, err error) {
 	bufSize := int64(len(item.unsafeBuf()))
@@ -901,6 +1921,9 @@ func (item *Item) WriteTo(w io.Writer) (n int64, err error) {
 
 func (item *Item) unsafeBuf() []byte {
 	item.dg.CheckLive()
+	if item.decoded != nil {
+		return item.decoded
+	}
 	mValue := &item.value
 	return newUnsafeSlice(mValue.ptr, int(mValue.size))
 }
@@ -929,6 +1952,14 @@ type ClusterConfig []*Config
 //   * Backing files for distinct caches in the cluster are located on distinct
 //     physical storages.
 //
+// Items are distributed among caches with weighted consistent hashing.
+// Each cache receives a share of items proportional to its' MaxItemsCount.
+// Caches are identified by Config.DataFile, so adding or removing a cache
+// with ClusterConfig.AddCache() or ClusterConfig.RemoveCache() remaps only
+// items belonging to the added or removed cache. Other items remain
+// in their caches. Caches with empty Config.DataFile are identified by their
+// position in the cluster config.
+//
 // The returned cluster must be closed with cluster.Close() call!
 //
 // Do not open the same cluster more than once at the same time!
@@ -945,27 +1976,94 @@ func (cfg ClusterConfig) OpenCluster(force bool) (cluster *Cluster, err error) {
 		}
 	}()
 
-	slotsCount := SizeT(0)
-	maxSlotIndexes := make([]SizeT, cachesCount)
 	for i := 0; i < cachesCount; i++ {
 		caches[i], err = cfg[i].OpenCache(force)
 		if err != nil {
 			return
 		}
 		openedCachesCount++
-		slotsCount += cfg[i].MaxItemsCount
-		maxSlotIndexes[i] = slotsCount
 	}
 
-	cluster = &Cluster{
-		caches:         caches,
-		slotsCount:     slotsCount,
-		maxSlotIndexes: maxSlotIndexes,
+	cluster = newClusterFromCaches(cfg, caches, make([]error, cachesCount))
+	return
+}
+
+// Opens a cluster of caches in degraded mode.
+//
+// Unlike ClusterConfig.OpenCluster(), caches that cannot be opened
+// (for instance, due to a failed disk) don't prevent opening the cluster.
+// Such caches are marked offline. Items belonging to offline caches
+// are rerouted to online caches with the same consistent hashing, so only
+// these items are remapped. Items are remapped back after re-opening
+// the cluster with all the caches online.
+//
+// Use Cluster.ShardStatus() for determining offline caches.
+//
+// Returns ErrOpenFailed if no caches can be opened.
+//
+// The returned cluster must be closed with cluster.Close() call!
+//
+// Do not open the same cluster more than once at the same time!
+func (cfg ClusterConfig) OpenClusterDegraded(force bool) (cluster *Cluster, err error) {
+	cachesCount := len(cfg)
+	caches := make([]*Cache, cachesCount)
+	errs := make([]error, cachesCount)
+	openedCachesCount := 0
+	for i := 0; i < cachesCount; i++ {
+		caches[i], errs[i] = cfg[i].OpenCache(force)
+		if errs[i] == nil {
+			openedCachesCount++
+		}
 	}
-	cluster.dg.Init()
+	if openedCachesCount == 0 {
+		err = ErrOpenFailed
+		return
+	}
+
+	cluster = newClusterFromCaches(cfg, caches, errs)
 	return
 }
 
+// Adds the cache with the given config to the cluster config.
+//
+// Only items belonging to the added cache are remapped after re-opening
+// the cluster. See ClusterConfig.OpenCluster() for details.
+func (cfg *ClusterConfig) AddCache(config *Config) {
+	*cfg = append(*cfg, config)
+}
+
+// Removes the cache with the given config from the cluster config.
+//
+// Caches are matched by Config.DataFile or by the config pointer for caches
+// with empty Config.DataFile. Cache files aren't removed -
+// use Config.RemoveCache() for this.
+//
+// Only items belonging to the removed cache are remapped after re-opening
+// the cluster. See ClusterConfig.OpenCluster() for details.
+//
+// Returns false if the cluster config doesn't contain the given cache.
+func (cfg *ClusterConfig) RemoveCache(config *Config) bool {
+	c := *cfg
+	for i, cc := range c {
+		if cc == config || (cc.DataFile != "" && cc.DataFile == config.DataFile) {
+			*cfg = append(c[:i], c[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the seed identifying the cache at the given position in the cluster.
+func (cfg *Config) clusterSeed(position int) uint64 {
+	h := fnv.New64a()
+	if cfg.DataFile != "" {
+		h.Write([]byte(cfg.DataFile))
+	} else {
+		fmt.Fprintf(h, "anonymous cache #%d", position)
+	}
+	return h.Sum64()
+}
+
 // Removes all files associated with the cluster.
 func (cfg ClusterConfig) RemoveCluster() {
 	for _, c := range cfg {
@@ -979,10 +2077,58 @@ func (cfg ClusterConfig) RemoveCluster() {
 
 // Cluster of caches.
 type Cluster struct {
-	dg             debugGuard
-	caches         []*Cache
-	slotsCount     SizeT
-	maxSlotIndexes []SizeT
+	dg debugGuard
+
+	// All the caches in the cluster. Offline caches are nil.
+	caches []*Cache
+
+	// Online caches in the cluster.
+	onlineCaches []*Cache
+
+	// Per-cache status. Stats are filled by Cluster.ShardStatus().
+	shards []ShardStatus
+
+	// Seeds and weights for caches used by weighted rendezvous hashing.
+	// See Cluster.cacheIndex() for details.
+	seeds   []uint64
+	weights []float64
+}
+
+// Status of a cache in the cluster. See Cluster.ShardStatus().
+type ShardStatus struct {
+	// Paths to files for the cache. Empty for anonymous caches.
+	DataFile  string
+	IndexFile string
+
+	// Whether the cache is online.
+	Online bool
+
+	// The reason for offline cache. Always nil for online caches.
+	Err error
+
+	// Cache statistics. Zero for offline caches.
+	Stats Stats
+}
+
+func newClusterFromCaches(cfg ClusterConfig, caches []*Cache, errs []error) *Cluster {
+	cluster := &Cluster{
+		caches: caches,
+		shards: make([]ShardStatus, len(cfg)),
+	}
+	for i, c := range cfg {
+		cluster.shards[i] = ShardStatus{
+			DataFile:  c.DataFile,
+			IndexFile: c.IndexFile,
+			Online:    errs[i] == nil,
+			Err:       errs[i],
+		}
+		if errs[i] == nil {
+			cluster.onlineCaches = append(cluster.onlineCaches, caches[i])
+		}
+	}
+	cluster.initHashing(cfg)
+	cluster.dg.Init()
+	return cluster
 }
 
 // Closes the cluster.
@@ -990,13 +2136,28 @@ type Cluster struct {
 // Each opened cluster must be closed only once!
 func (cluster *Cluster) Close() error {
 	cluster.dg.Close()
-	cachesCount := len(cluster.caches)
-	for i := 0; i < cachesCount; i++ {
-		cluster.caches[i].Close()
+	for _, cache := range cluster.onlineCaches {
+		cache.Close()
 	}
 	return nil
 }
 
+// Returns the status for each cache in the cluster in the order of
+// caches in the cluster config.
+//
+// Caches may be offline only in clusters opened with
+// ClusterConfig.OpenClusterDegraded().
+func (cluster *Cluster) ShardStatus() []ShardStatus {
+	cluster.dg.CheckLive()
+	shards := append([]ShardStatus{}, cluster.shards...)
+	for i, cache := range cluster.caches {
+		if shards[i].Online {
+			shards[i].Stats = cache.Stats()
+		}
+	}
+	return shards
+}
+
 // See Cache.Set()
 func (cluster *Cluster) Set(key []byte, value []byte, ttl time.Duration) error {
 	return cluster.cache(key).Set(key, value, ttl)
@@ -1012,6 +2173,11 @@ func (cluster *Cluster) GetDe(key []byte, graceDuration time.Duration) (value []
 	return cluster.cache(key).GetDe(key, graceDuration)
 }
 
+// See Cache.GetDeCtx()
+func (cluster *Cluster) GetDeCtx(ctx context.Context, key []byte, graceDuration time.Duration) (value []byte, err error) {
+	return cluster.cache(key).GetDeCtx(ctx, key, graceDuration)
+}
+
 // See Cache.GetDeAsync()
 func (cluster *Cluster) GetDeAsync(key []byte, graceDuration time.Duration) (value []byte, err error) {
 	return cluster.cache(key).GetDeAsync(key, graceDuration)
@@ -1022,6 +2188,11 @@ func (cluster *Cluster) Delete(key []byte) bool {
 	return cluster.cache(key).Delete(key)
 }
 
+// See Cache.Touch()
+func (cluster *Cluster) Touch(key []byte, ttl time.Duration) error {
+	return cluster.cache(key).Touch(key, ttl)
+}
+
 // See Cache.SetItem()
 func (cluster *Cluster) SetItem(key []byte, value []byte, ttl time.Duration) (item *Item, err error) {
 	return cluster.cache(key).SetItem(key, value, ttl)
@@ -1037,35 +2208,214 @@ func (cluster *Cluster) GetDeItem(key []byte, graceDuration time.Duration) (item
 	return cluster.cache(key).GetDeItem(key, graceDuration)
 }
 
+// See Cache.GetDeItemCtx()
+func (cluster *Cluster) GetDeItemCtx(ctx context.Context, key []byte, graceDuration time.Duration) (item *Item, err error) {
+	return cluster.cache(key).GetDeItemCtx(ctx, key, graceDuration)
+}
+
 // See Cache.GetDeAsyncItem()
 func (cluster *Cluster) GetDeAsyncItem(key []byte, graceDuration time.Duration) (item *Item, err error) {
 	return cluster.cache(key).GetDeAsyncItem(key, graceDuration)
 }
 
+// See Cache.GetMulti()
+//
+// Keys are grouped per cache, so each cache is queried with a single batch.
+func (cluster *Cluster) GetMulti(keys [][]byte, dst [][]byte) [][]byte {
+	n := len(dst)
+	dst = append(dst, make([][]byte, len(keys))...)
+	var shardKeys, values [][]byte
+	for i, idxs := range cluster.groupKeys(len(keys), func(j int) []byte { return keys[j] }) {
+		if len(idxs) == 0 {
+			continue
+		}
+		shardKeys = shardKeys[:0]
+		for _, j := range idxs {
+			shardKeys = append(shardKeys, keys[j])
+		}
+		values = cluster.caches[i].GetMulti(shardKeys, values[:0])
+		for k, j := range idxs {
+			dst[n+j] = values[k]
+		}
+	}
+	return dst
+}
+
+// See Cache.SetMulti()
+//
+// Items are grouped per cache, so each cache is updated with a single batch.
+// Items may be partially stored on error.
+func (cluster *Cluster) SetMulti(items []KV, ttl time.Duration) error {
+	var shardItems []KV
+	for i, idxs := range cluster.groupKeys(len(items), func(j int) []byte { return items[j].Key }) {
+		if len(idxs) == 0 {
+			continue
+		}
+		shardItems = shardItems[:0]
+		for _, j := range idxs {
+			shardItems = append(shardItems, items[j])
+		}
+		if err := cluster.caches[i].SetMulti(shardItems, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// See Cache.GetStaleItem()
+func (cluster *Cluster) GetStaleItem(key []byte, staleFor time.Duration) (item *Item, isStale bool, err error) {
+	return cluster.cache(key).GetStaleItem(key, staleFor)
+}
+
 // See Cache.NewSetTxn()
 func (cluster *Cluster) NewSetTxn(key []byte, valueSize int, ttl time.Duration) (txn *SetTxn, err error) {
 	return cluster.cache(key).NewSetTxn(key, valueSize, ttl)
 }
 
+// See Cache.CompareAndSet()
+func (cluster *Cluster) CompareAndSet(key []byte, value []byte, ttl time.Duration, version uint64) error {
+	return cluster.cache(key).CompareAndSet(key, value, ttl, version)
+}
+
+// See Cache.CompareAndDelete()
+func (cluster *Cluster) CompareAndDelete(key []byte, version uint64) error {
+	return cluster.cache(key).CompareAndDelete(key, version)
+}
+
+// See Cache.Add()
+func (cluster *Cluster) Add(key []byte, value []byte, ttl time.Duration) error {
+	return cluster.cache(key).Add(key, value, ttl)
+}
+
+// See Cache.Incr()
+func (cluster *Cluster) Incr(key []byte, delta uint64) (value uint64, err error) {
+	return cluster.cache(key).Incr(key, delta)
+}
+
+// See Cache.Decr()
+func (cluster *Cluster) Decr(key []byte, delta uint64) (value uint64, err error) {
+	return cluster.cache(key).Decr(key, delta)
+}
+
 // See Cache.Clear()
 func (cluster *Cluster) Clear() {
-	for _, cache := range cluster.caches {
+	for _, cache := range cluster.onlineCaches {
 		cache.Clear()
 	}
 }
 
+// See Cache.Iterate()
+func (cluster *Cluster) Iterate(f func(key []byte, item *Item) bool) {
+	cluster.dg.CheckLive()
+	isStopped := false
+	for _, cache := range cluster.onlineCaches {
+		cache.Iterate(func(key []byte, item *Item) bool {
+			isStopped = !f(key, item)
+			return !isStopped
+		})
+		if isStopped {
+			return
+		}
+	}
+}
+
+// Returns statistics aggregated over all the caches in the cluster.
+func (cluster *Cluster) Stats() Stats {
+	cluster.dg.CheckLive()
+	var stats Stats
+	for _, cache := range cluster.onlineCaches {
+		s := cache.Stats()
+		stats.add(&s)
+	}
+	return stats
+}
+
+// Returns the total occupancy of online caches in the cluster.
+//
+// See Cache.Occupancy() for details.
+func (cluster *Cluster) Occupancy() Occupancy {
+	cluster.dg.CheckLive()
+	var occupancy Occupancy
+	for _, cache := range cluster.onlineCaches {
+		o := cache.Occupancy()
+		occupancy.add(&o)
+	}
+	return occupancy
+}
+
 func (cluster *Cluster) cache(key []byte) *Cache {
 	cluster.dg.CheckLive()
+	return cluster.caches[cluster.cacheIndex(key)]
+}
+
+func (cluster *Cluster) initHashing(cfg ClusterConfig) {
+	cluster.seeds = make([]uint64, len(cfg))
+	cluster.weights = make([]float64, len(cfg))
+	for i, c := range cfg {
+		cluster.seeds[i] = c.clusterSeed(i)
+		cluster.weights[i] = float64(c.MaxItemsCount)
+		if cluster.weights[i] == 0 {
+			cluster.weights[i] = 1
+		}
+		if cluster.shards != nil && !cluster.shards[i].Online {
+			// Offline caches never win keys due to zero score.
+			cluster.weights[i] = 0
+		}
+	}
+}
+
+// Returns the index of the cache for the given key.
+//
+// Uses weighted rendezvous hashing - see
+// https://en.wikipedia.org/wiki/Rendezvous_hashing . Each cache scores
+// the key and the cache with the highest score wins. Scores for the given
+// cache don't depend on other caches, so adding or removing a cache
+// remaps only keys won or lost by this cache.
+func (cluster *Cluster) cacheIndex(key []byte) int {
+	seeds := cluster.seeds
+	if len(seeds) == 1 {
+		return 0
+	}
+
 	h := fnv.New64a()
 	h.Write(key)
-	idx := SizeT(h.Sum64()) % cluster.slotsCount
+	keyHash := h.Sum64()
+
+	idx := 0
+	maxScore := 0.0
+	for i, seed := range seeds {
+		// Convert the hash into a uniform number in (0, 1) range.
+		u := (float64(mixHash(keyHash^seed)>>11) + 0.5) / (1 << 53)
+		score := cluster.weights[i] / -math.Log(u)
+		if score > maxScore {
+			idx = i
+			maxScore = score
+		}
+	}
+	return idx
+}
+
+// Mixes bits of the given hash (splitmix64 finalizer).
+func mixHash(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
 
-	maxSlotIndexes := cluster.maxSlotIndexes
-	i := 0
-	for idx >= maxSlotIndexes[i] {
-		i++
+// Groups keysCount keys returned by getKey per cache.
+//
+// Returns indexes of keys for each cache in the cluster.
+func (cluster *Cluster) groupKeys(keysCount int, getKey func(i int) []byte) [][]int {
+	cluster.dg.CheckLive()
+	idxs := make([][]int, len(cluster.caches))
+	for i := 0; i < keysCount; i++ {
+		j := cluster.cacheIndex(getKey(i))
+		idxs[j] = append(idxs[j], i)
 	}
-	return cluster.caches[i]
+	return idxs
 }
 
 /*******************************************************************************
@@ -1081,6 +2431,24 @@ func initKey(k *C.struct_ybc_key, key []byte) {
 	k.size = C.size_t(len(key))
 }
 
+// existsErr is returned on YBC_SET_EXISTS status, since its' meaning depends
+// on the condition.
+func setStatusToError(status C.enum_ybc_set_status, existsErr error) error {
+	switch status {
+	case C.YBC_SET_SUCCESS:
+		return nil
+	case C.YBC_SET_NOSPACE:
+		return ErrNoSpace
+	case C.YBC_SET_NOTFOUND:
+		return ErrCacheMiss
+	case C.YBC_SET_EXISTS:
+		return existsErr
+	case C.YBC_SET_NOTNUMERIC:
+		return ErrNotNumeric
+	}
+	panic("unreachable")
+}
+
 func initValue(v *C.struct_ybc_value, value []byte, ttl time.Duration) {
 	if ttl < 0 {
 		ttl = 0
@@ -1094,6 +2462,18 @@ func initValue(v *C.struct_ybc_value, value []byte, ttl time.Duration) {
 	v.ttl = C.uint64_t(ttl / time.Millisecond)
 }
 
+// Sleeps for the given duration. Returns ctx.Err() if ctx is done earlier.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	select {
+	case <-ctx.Done():
+		t.Stop()
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
 func newUnsafeSlice(ptr unsafe.Pointer, size int) (buf []byte) {
 	// This trick is stolen from http://code.google.com/p/go-wiki/wiki/cgo .
 	hdr := (*reflect.SliceHeader)(unsafe.Pointer(&buf))
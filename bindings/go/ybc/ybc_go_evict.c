@@ -0,0 +1,17 @@
+#include "ybc.h"
+#include "_cgo_export.h"
+
+#include <stdint.h>  /* uintptr_t */
+
+static void go_evict_callback(void *const ctx,
+    const struct ybc_key *const key, const enum ybc_evict_reason reason)
+{
+  goEvictCallback((uintptr_t)ctx, (struct ybc_key *)key, reason);
+}
+
+void go_config_set_evict_callback(struct ybc_config *const config,
+    const uintptr_t callback_id)
+{
+  ybc_config_set_evict_callback(config, go_evict_callback,
+      (void *)callback_id);
+}
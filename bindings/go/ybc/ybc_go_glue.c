@@ -1,12 +1,14 @@
 #include "ybc.h"
 
+#include <string.h>  /* memcpy */
+
 static int go_get_item_and_value(struct ybc *const cache,
     struct ybc_item *const item, struct ybc_value *const value,
     const struct ybc_key *const key)
 {
   const int rv = ybc_item_get(cache, item, key);
-  if (rv == 0) {
-    return 0;
+  if (rv != 1) {
+    return rv;
   }
   ybc_item_get_value(item, value);
   return rv;
@@ -15,10 +17,12 @@ static int go_get_item_and_value(struct ybc *const cache,
 static enum ybc_de_status go_get_item_and_value_de_async(
     struct ybc *const cache,
     struct ybc_item *const item, struct ybc_value *const value,
-    const struct ybc_key *const key, const uint64_t grace_ttl)
+    const struct ybc_key *const key, const uint64_t grace_ttl,
+    const int is_retry)
 {
-  const enum ybc_de_status rv = ybc_item_get_de_async(cache, item, key,
-      grace_ttl);
+  const enum ybc_de_status rv = is_retry ?
+      ybc_item_get_de_async_retry(cache, item, key, grace_ttl) :
+      ybc_item_get_de_async(cache, item, key, grace_ttl);
   if (rv != YBC_DE_SUCCESS) {
     return rv;
   }
@@ -26,6 +30,75 @@ static enum ybc_de_status go_get_item_and_value_de_async(
   return rv;
 }
 
+static int go_get_item_and_value_stale(struct ybc *const cache,
+    struct ybc_item *const item, struct ybc_value *const value,
+    const struct ybc_key *const key, const uint64_t stale_ttl,
+    int *const is_stale, int *const should_refresh)
+{
+  const int rv = ybc_item_get_stale(cache, item, key, stale_ttl, is_stale,
+      should_refresh);
+  if (rv != 1) {
+    return rv;
+  }
+  ybc_item_get_value(item, value);
+  return rv;
+}
+
+/*
+ * Copies values for the given keys into buf one after another.
+ *
+ * sizes[i] is set to the size of the value for keys[i] or to SIZE_MAX
+ * if the item is missing in the cache.
+ *
+ * Returns the number of processed keys. The processing stops at the value,
+ * which doesn't fit the remaining space in buf. sizes[i] is set to its' size
+ * for this value, so the caller can grow buf accordingly.
+ */
+static size_t go_get_multi(struct ybc *const cache,
+    const struct ybc_key *const keys, const size_t keys_count,
+    void *const buf, const size_t buf_size, size_t *const sizes)
+{
+  char item_buf[ybc_item_get_size()];
+  struct ybc_item *const item = (struct ybc_item *)item_buf;
+  struct ybc_value value;
+  size_t offset = 0;
+
+  for (size_t i = 0; i < keys_count; ++i) {
+    if (ybc_item_get(cache, item, &keys[i]) != 1) {
+      sizes[i] = SIZE_MAX;
+      continue;
+    }
+    ybc_item_get_value(item, &value);
+    sizes[i] = value.size;
+    if (value.size > buf_size - offset) {
+      ybc_item_release(item);
+      return i;
+    }
+    memcpy((char *)buf + offset, value.ptr, value.size);
+    offset += value.size;
+    ybc_item_release(item);
+  }
+  return keys_count;
+}
+
+/*
+ * Stores the given items in the cache.
+ *
+ * Returns the number of stored items. The items are stored in order
+ * until the first failure.
+ */
+static size_t go_set_multi(struct ybc *const cache,
+    const struct ybc_key *const keys, const struct ybc_value *const values,
+    const size_t items_count)
+{
+  for (size_t i = 0; i < items_count; ++i) {
+    if (!ybc_item_set(cache, &keys[i], &values[i])) {
+      return i;
+    }
+  }
+  return items_count;
+}
+
 static int go_set_item_and_value(struct ybc *const cache,
     struct ybc_item *const item, const struct ybc_key *const key,
     struct ybc_value *const value)
@@ -45,3 +118,15 @@ static void go_commit_item_and_value(struct ybc_set_txn *const txn,
   ybc_item_get_value(item, value);
 }
 
+
+static int go_iterate(struct ybc *const cache, struct ybc_item *const item,
+    struct ybc_value *const value, struct ybc_key *const key,
+    size_t *const cursor)
+{
+  const int rv = ybc_iterate(cache, item, key, cursor);
+  if (rv == 0) {
+    return 0;
+  }
+  ybc_item_get_value(item, value);
+  return rv;
+}
@@ -2,8 +2,12 @@ package ybc
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
 	"testing"
 	"time"
 )
@@ -110,6 +114,61 @@ func TestConfig_OpenSimpleCache(t *testing.T) {
 	c.Close()
 }
 
+func TestMigrate(t *testing.T) {
+	oldConfig := &Config{
+		MaxItemsCount: 100,
+		DataFileSize:  10 * 1000,
+		DataFile:      "foobar.data.migrate_old",
+		IndexFile:     "foobar.index.migrate_old",
+	}
+	defer oldConfig.RemoveCache()
+	newConfig := &Config{
+		MaxItemsCount: 1000,
+		DataFileSize:  100 * 1000,
+		DataFile:      "foobar.data.migrate_new",
+		IndexFile:     "foobar.index.migrate_new",
+	}
+	defer newConfig.RemoveCache()
+
+	if err := Migrate(oldConfig, newConfig); err != ErrOpenFailed {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrOpenFailed)
+	}
+
+	cache, err := oldConfig.OpenCache(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("%d_key", i))
+		value := []byte(fmt.Sprintf("value_%d", i))
+		if err = cache.Set(key, value, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache.Close()
+
+	if err = Migrate(oldConfig, newConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	if cache, err = newConfig.OpenCache(false); err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("%d_key", i))
+		item, err := cache.GetItem(key)
+		if err != nil {
+			t.Fatalf("Cannot find migrated item with key=[%s]: [%s]", key, err)
+		}
+		checkValue(t, []byte(fmt.Sprintf("value_%d", i)), item.Value())
+		if item.Ttl() > time.Hour || item.Ttl() < time.Minute {
+			t.Fatalf("Unexpected ttl=%s for migrated item with key=[%s]", item.Ttl(), key)
+		}
+		item.Close()
+	}
+}
+
 /*******************************************************************************
  * SimpleCache
  ******************************************************************************/
@@ -204,6 +263,53 @@ func TestSimpleCache_Clear(t *testing.T) {
 	simple_cacher_Clear(sc, t)
 }
 
+type statsSimpleCacher interface {
+	SimpleCacher
+	StatsCacher
+}
+
+func simple_cacher_Stats(cache statsSimpleCacher, t *testing.T) {
+	defer cache.Close()
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		value := []byte(fmt.Sprintf("value_%d", i))
+		if _, err := cache.Get(key); err != ErrCacheMiss {
+			t.Fatal(err)
+		}
+		if err := cache.Set(key, value, MaxTtl); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cache.Get(key); err != nil {
+			t.Fatal(err)
+		}
+		if !cache.Delete(key) {
+			t.Fatalf("Cannot delete item with key=[%s]", key)
+		}
+	}
+
+	s := cache.Stats()
+	if s.Gets != 200 {
+		t.Fatalf("Unexpected Gets=%d. Expected 200", s.Gets)
+	}
+	if s.Hits != 100 {
+		t.Fatalf("Unexpected Hits=%d. Expected 100", s.Hits)
+	}
+	if s.Misses != 100 {
+		t.Fatalf("Unexpected Misses=%d. Expected 100", s.Misses)
+	}
+	if s.Sets != 100 {
+		t.Fatalf("Unexpected Sets=%d. Expected 100", s.Sets)
+	}
+	if s.Deletes != 100 {
+		t.Fatalf("Unexpected Deletes=%d. Expected 100", s.Deletes)
+	}
+}
+
+func TestSimpleCache_Stats(t *testing.T) {
+	sc := newSimpleCache(1000, t)
+	simple_cacher_Stats(sc, t)
+}
+
 /*******************************************************************************
  * Cache
  ******************************************************************************/
@@ -228,7 +334,7 @@ func TestCache_Set_Get_Remove(t *testing.T) {
 	simple_cacher_Set_Get_Remove(cache, t)
 }
 
-func cacher_GetDe(cache Cacher, t *testing.T) {
+func cacher_GetDe(cache fullCacher, t *testing.T) {
 	defer cache.Close()
 	key := []byte("test")
 	_, err := cache.GetDe(key, time.Millisecond*time.Duration(100))
@@ -258,12 +364,247 @@ func TestCache_GetDe(t *testing.T) {
 	cacher_GetDe(cache, t)
 }
 
+func cacher_GetDeCtx(cache fullCacher, t *testing.T) {
+	defer cache.Close()
+	key := []byte("test")
+	grace := time.Hour
+	_, err := cache.GetDeCtx(context.Background(), key, grace)
+	if err != ErrCacheMiss {
+		t.Fatal(err)
+	}
+
+	// The item is pending for an hour, so the waiting must be interrupted
+	// by the context.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	startTime := time.Now()
+	_, err = cache.GetDeCtx(ctx, key, grace)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(startTime); d > time.Second {
+		t.Fatalf("Too long waiting for the context deadline: %s", d)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	item, err := cache.GetDeItemCtx(ctx, key, grace)
+	if err != context.Canceled {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, context.Canceled)
+	}
+	if item != nil {
+		t.Fatalf("Unexpected non-nil item")
+	}
+
+	value := []byte("aaa")
+	if err = cache.Set(key, value, MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	actualValue, err := cache.GetDeCtx(context.Background(), key, grace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, value, actualValue)
+
+	item, err = cache.GetDeItemCtx(context.Background(), key, grace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer item.Close()
+	checkValue(t, value, item.Value())
+}
+
+func TestCache_GetDeCtx(t *testing.T) {
+	cache := newCache(t)
+	cacher_GetDeCtx(cache, t)
+}
+
+func expectStaleItem(cache fullCacher, t *testing.T, key, expectedValue []byte, staleFor time.Duration, expectedIsStale, expectedShouldRefresh bool) {
+	item, isStale, err := cache.GetStaleItem(key, staleFor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer item.Close()
+	if isStale != expectedIsStale {
+		t.Fatalf("Unexpected isStale=%v. Expected %v", isStale, expectedIsStale)
+	}
+	if shouldRefresh := item.ShouldRefresh(); shouldRefresh != expectedShouldRefresh {
+		t.Fatalf("Unexpected shouldRefresh=%v. Expected %v", shouldRefresh, expectedShouldRefresh)
+	}
+	checkValue(t, expectedValue, item.Value())
+	if isStale && item.Ttl() != 0 {
+		t.Fatalf("Unexpected ttl=%s for stale item. Expected 0", item.Ttl())
+	}
+}
+
+func cacher_GetStaleItem(cache fullCacher, t *testing.T) {
+	defer cache.Close()
+	key := []byte("test")
+	value := []byte("aaa")
+	if _, _, err := cache.GetStaleItem(key, time.Hour); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
+
+	if err := cache.Set(key, value, time.Millisecond*100); err != nil {
+		t.Fatal(err)
+	}
+	expectStaleItem(cache, t, key, value, time.Hour, false, false)
+
+	time.Sleep(time.Millisecond * 200)
+	if _, err := cache.Get(key); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
+
+	// Only the first caller must refresh the item.
+	expectStaleItem(cache, t, key, value, time.Hour, true, true)
+	for i := 0; i < 10; i++ {
+		expectStaleItem(cache, t, key, value, time.Hour, true, false)
+	}
+
+	// The item expired more than staleFor ago.
+	if _, _, err := cache.GetStaleItem(key, time.Millisecond*10); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
+
+	value = []byte("bbb")
+	if err := cache.Set(key, value, MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	expectStaleItem(cache, t, key, value, time.Hour, false, false)
+}
+
+func TestCache_GetStaleItem(t *testing.T) {
+	cache := newCache(t)
+	cacher_GetStaleItem(cache, t)
+}
+
+func cacher_GetMulti(cache fullCacher, t *testing.T) {
+	defer cache.Close()
+	if err := cache.SetMulti(nil, MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	if values := cache.GetMulti(nil, nil); len(values) != 0 {
+		t.Fatalf("Unexpected values=%v for empty keys", values)
+	}
+
+	// Keep the number of keys small enough, so index buckets in caches
+	// don't overflow.
+	var items []KV
+	var keys [][]byte
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("%d_key", i))
+		keys = append(keys, key)
+		if i%3 == 0 {
+			// missing item
+			continue
+		}
+		// Mix empty, small and big values, so the buffer for values
+		// is re-allocated.
+		value := bytes.Repeat([]byte(fmt.Sprintf("%d_value", i)), i%10*10)
+		items = append(items, KV{Key: key, Value: value})
+	}
+	if err := cache.SetMulti(items, MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := [][]byte{[]byte("foo")}
+	values := cache.GetMulti(keys, dst)
+	if len(values) != len(keys)+1 {
+		t.Fatalf("Unexpected number of values=%d. Expected %d", len(values), len(keys)+1)
+	}
+	checkValue(t, []byte("foo"), values[0])
+	values = values[1:]
+	for i, key := range keys {
+		if i%3 == 0 {
+			if values[i] != nil {
+				t.Fatalf("Unexpected value=[%s] for missing key=[%s]", values[i], key)
+			}
+			continue
+		}
+		if values[i] == nil {
+			t.Fatalf("Cannot find value for key=[%s]", key)
+		}
+		checkValue(t, items[i-i/3-1].Value, values[i])
+
+		value, err := cache.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkValue(t, value, values[i])
+	}
+
+	// Values mustn't overlap.
+	values[1] = append(values[1], "foobar"...)
+	checkValue(t, items[1].Value, values[2])
+
+	items = []KV{
+		{Key: []byte("too_large"), Value: make([]byte, 2*1000*1000)},
+	}
+	if err := cache.SetMulti(items, MaxTtl); err != ErrNoSpace {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrNoSpace)
+	}
+}
+
+func TestCache_GetMulti(t *testing.T) {
+	cache := newCache(t)
+	cacher_GetMulti(cache, t)
+}
+
 func TestCache_Clear(t *testing.T) {
 	cache := newCache(t)
 	simple_cacher_Clear(cache, t)
 }
 
-func cacher_SetItem(cache Cacher, t *testing.T) {
+func TestCache_Stats(t *testing.T) {
+	cache := newCache(t)
+	simple_cacher_Stats(cache, t)
+}
+
+func TestCache_Stats_SetTxnRollback(t *testing.T) {
+	cache := newCache(t)
+	defer cache.Close()
+
+	txn, err := cache.NewSetTxn([]byte("key"), 10, MaxTtl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn.Rollback()
+
+	s := cache.Stats()
+	if s.SetTxnRollbacks != 1 {
+		t.Fatalf("Unexpected SetTxnRollbacks=%d. Expected 1", s.SetTxnRollbacks)
+	}
+	if s.Sets != 0 {
+		t.Fatalf("Unexpected Sets=%d. Expected 0", s.Sets)
+	}
+}
+
+func TestCache_Stats_DeWaits(t *testing.T) {
+	cache := newCache(t)
+	defer cache.Close()
+
+	key := []byte("key")
+	if _, err := cache.GetDeAsync(key, time.Second); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
+
+	// The wait must be counted only once regardless of the number of polls.
+	ctx, cancel := context.WithTimeout(context.Background(), 350*time.Millisecond)
+	defer cancel()
+	if _, err := cache.GetDeCtx(ctx, key, time.Second); err != context.DeadlineExceeded {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, context.DeadlineExceeded)
+	}
+
+	s := cache.Stats()
+	if s.DeWaits != 1 {
+		t.Fatalf("Unexpected DeWaits=%d. Expected 1", s.DeWaits)
+	}
+	if s.Gets != 2 || s.Misses != 2 {
+		t.Fatalf("Unexpected Gets=%d, Misses=%d. Expected 2, 2", s.Gets, s.Misses)
+	}
+}
+
+func cacher_SetItem(cache fullCacher, t *testing.T) {
 	defer cache.Close()
 	for i := 0; i < 1000; i++ {
 		key := []byte(fmt.Sprintf("key_%d", i))
@@ -277,118 +618,768 @@ func cacher_SetItem(cache Cacher, t *testing.T) {
 	}
 }
 
-func TestCache_SetItem(t *testing.T) {
-	cache := newCache(t)
-	cacher_SetItem(cache, t)
-}
+func TestCache_SetItem(t *testing.T) {
+	cache := newCache(t)
+	cacher_SetItem(cache, t)
+}
+
+func cacher_GetItem(cache fullCacher, t *testing.T) {
+	defer cache.Close()
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		_, err := cache.GetItem(key)
+		if err != ErrCacheMiss {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		value := []byte(fmt.Sprintf("value_%d", i))
+		err := cache.Set(key, value, MaxTtl)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		item, err := cache.GetItem(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer item.Close()
+		checkValue(t, value, item.Value())
+	}
+}
+
+func TestCache_GetItem(t *testing.T) {
+	cache := newCache(t)
+	cacher_GetItem(cache, t)
+}
+
+func cacher_GetDeItem(cache fullCacher, t *testing.T) {
+	defer cache.Close()
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		_, err := cache.GetDeItem(key, time.Second)
+		if err != ErrCacheMiss {
+			t.Fatal(err)
+		}
+		_, err = cache.GetDeAsyncItem(key, time.Second)
+		if err != ErrWouldBlock {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		value := []byte(fmt.Sprintf("value_%d", i))
+		err := cache.Set(key, value, MaxTtl)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		item, err := cache.GetDeItem(key, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer item.Close()
+		checkValue(t, value, item.Value())
+	}
+}
+
+func TestCache_GetDeItem(t *testing.T) {
+	cache := newCache(t)
+	cacher_GetDeItem(cache, t)
+}
+
+func cacher_NewSetTxn(cache fullCacher, t *testing.T) {
+	defer cache.Close()
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		value := []byte(fmt.Sprintf("value_%d", i))
+		txn, err := cache.NewSetTxn(key, len(value), MaxTtl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := txn.Write(value)
+		if err != nil {
+			txn.Rollback()
+			t.Fatal(err)
+		}
+		if n != len(value) {
+			t.Fatalf("unexpected number of bytes written=%d. Expected %d", n, len(value))
+		}
+		err = txn.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actualValue, err := cache.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkValue(t, value, actualValue)
+	}
+}
+
+func TestCache_NewSetTxn(t *testing.T) {
+	cache := newCache(t)
+	cacher_NewSetTxn(cache, t)
+}
+
+func cacher_Iterate(cache fullCacher, t *testing.T) {
+	defer cache.Close()
+	for i := 0; i < 100; i++ {
+		// Keys with common suffix are spread evenly among cache's buckets,
+		// so none of them is evicted by bucket overflow.
+		key := []byte(fmt.Sprintf("%d_key", i))
+		value := []byte(fmt.Sprintf("value_%d", i))
+		if err := cache.Set(key, value, MaxTtl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := make(map[string]bool)
+	cache.Iterate(func(key []byte, item *Item) bool {
+		var i int
+		if _, err := fmt.Sscanf(string(key), "%d_key", &i); err != nil {
+			t.Fatalf("Unexpected key=[%s]", key)
+		}
+		checkValue(t, []byte(fmt.Sprintf("value_%d", i)), item.Value())
+		m[string(key)] = true
+		return true
+	})
+	if len(m) != 100 {
+		t.Fatalf("Unexpected number of items=%d. Expected 100", len(m))
+	}
+
+	n := 0
+	cache.Iterate(func(key []byte, item *Item) bool {
+		n++
+		return n < 10
+	})
+	if n != 10 {
+		t.Fatalf("Unexpected number of items=%d. Expected 10", n)
+	}
+
+	cache.Clear()
+	cache.Iterate(func(key []byte, item *Item) bool {
+		t.Fatalf("Unexpected item with key=[%s] in the cleared cache", key)
+		return false
+	})
+}
+
+func TestCache_Iterate(t *testing.T) {
+	cache := newCache(t)
+	cacher_Iterate(cache, t)
+}
+
+func cacher_Occupancy(cache fullCacher, t *testing.T) {
+	defer cache.Close()
+	o := cache.Occupancy()
+	if o.ItemsCount != 0 || o.UsedSize != 0 {
+		t.Fatalf("Unexpected occupancy=%+v for empty cache", o)
+	}
+	if o.DataFileSize == 0 {
+		t.Fatalf("Unexpected zero DataFileSize")
+	}
+
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("%d_key", i))
+		value := []byte(fmt.Sprintf("value_%d", i))
+		if err := cache.Set(key, value, MaxTtl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	o = cache.Occupancy()
+	if o.ItemsCount != 100 {
+		t.Fatalf("Unexpected ItemsCount=%d. Expected 100", o.ItemsCount)
+	}
+	if o.UsedSize == 0 || o.UsedSize > o.DataFileSize {
+		t.Fatalf("Unexpected UsedSize=%d. Expected (0..%d]", o.UsedSize, o.DataFileSize)
+	}
+
+	cache.Clear()
+	o = cache.Occupancy()
+	if o.ItemsCount != 0 || o.UsedSize != 0 {
+		t.Fatalf("Unexpected occupancy=%+v for cleared cache", o)
+	}
+}
+
+func TestCache_Occupancy(t *testing.T) {
+	cache := newCache(t)
+	cacher_Occupancy(cache, t)
+}
+
+func getItemVersion(cache Cacher, key []byte, t *testing.T) uint64 {
+	item, err := cache.GetItem(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer item.Close()
+	return item.Version()
+}
+
+func cacher_CompareAndSet(cache fullCacher, t *testing.T) {
+	defer cache.Close()
+	key := []byte("key")
+	value1 := []byte("value1")
+	value2 := []byte("value2")
+
+	if err := cache.CompareAndSet(key, value1, MaxTtl, 0); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
+
+	if err := cache.Set(key, value1, MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	version := getItemVersion(cache, key, t)
+	if err := cache.Set(key, value1, MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.CompareAndSet(key, value2, MaxTtl, version); err != ErrVersionMismatch {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrVersionMismatch)
+	}
+
+	version = getItemVersion(cache, key, t)
+	if err := cache.CompareAndSet(key, value2, MaxTtl, version); err != nil {
+		t.Fatal(err)
+	}
+	actualValue, err := cache.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, value2, actualValue)
+	if getItemVersion(cache, key, t) == version {
+		t.Fatalf("The version must change after CompareAndSet()")
+	}
+}
+
+func cacher_CompareAndDelete(cache fullCacher, t *testing.T) {
+	defer cache.Close()
+	key := []byte("key")
+	value := []byte("value")
+
+	if err := cache.CompareAndDelete(key, 0); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
+
+	if err := cache.Set(key, value, MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	version := getItemVersion(cache, key, t)
+	if err := cache.Set(key, value, MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.CompareAndDelete(key, version); err != ErrVersionMismatch {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrVersionMismatch)
+	}
+	if _, err := cache.Get(key); err != nil {
+		t.Fatal(err)
+	}
+
+	version = getItemVersion(cache, key, t)
+	if err := cache.CompareAndDelete(key, version); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Get(key); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
+	if err := cache.CompareAndDelete(key, version); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
+}
+
+func TestCache_CompareAndSet(t *testing.T) {
+	cache := newCache(t)
+	cacher_CompareAndSet(cache, t)
+}
+
+func TestCache_CompareAndDelete(t *testing.T) {
+	cache := newCache(t)
+	cacher_CompareAndDelete(cache, t)
+}
+
+func TestCache_CompareAndSet_Concurrent(t *testing.T) {
+	cache := newCache(t)
+	defer cache.Close()
+
+	key := []byte("counter")
+	var buf [8]byte
+	if err := cache.Set(key, buf[:], MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+
+	const workersCount = 10
+	const incrementsCount = 1000
+	ch := make(chan error, workersCount)
+	for i := 0; i < workersCount; i++ {
+		go func() {
+			var buf [8]byte
+			for j := 0; j < incrementsCount; j++ {
+				for {
+					item, err := cache.GetItem(key)
+					if err != nil {
+						ch <- err
+						return
+					}
+					version := item.Version()
+					n := binary.LittleEndian.Uint64(item.Value())
+					item.Close()
+
+					binary.LittleEndian.PutUint64(buf[:], n+1)
+					err = cache.CompareAndSet(key, buf[:], MaxTtl, version)
+					if err == nil {
+						break
+					}
+					if err != ErrVersionMismatch {
+						ch <- err
+						return
+					}
+				}
+			}
+			ch <- nil
+		}()
+	}
+	for i := 0; i < workersCount; i++ {
+		if err := <-ch; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	value, err := cache.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := binary.LittleEndian.Uint64(value); n != workersCount*incrementsCount {
+		t.Fatalf("Unexpected counter value=%d. Expected %d", n, workersCount*incrementsCount)
+	}
+}
+
+func cacher_Add(cache fullCacher, t *testing.T) {
+	defer cache.Close()
+	key := []byte("key")
+	value1 := []byte("value1")
+	value2 := []byte("value2")
+
+	if err := cache.Add(key, value1, MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Add(key, value2, MaxTtl); err != ErrAlreadyExists {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrAlreadyExists)
+	}
+	actualValue, err := cache.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, value1, actualValue)
+
+	cache.Delete(key)
+	if err := cache.Add(key, value2, MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	if actualValue, err = cache.Get(key); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, value2, actualValue)
+
+	// Expired items must be overwritten.
+	key = []byte("expired")
+	if err := cache.Set(key, value1, 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if err := cache.Add(key, value2, MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	if actualValue, err = cache.Get(key); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, value2, actualValue)
+}
+
+func TestCache_Add(t *testing.T) {
+	cache := newCache(t)
+	cacher_Add(cache, t)
+}
+
+func TestCache_Add_Concurrent(t *testing.T) {
+	cache := newCache(t)
+	defer cache.Close()
+
+	const workersCount = 10
+	const keysCount = 1000
+	ch := make(chan int, workersCount)
+	for i := 0; i < workersCount; i++ {
+		go func() {
+			addedCount := 0
+			for j := 0; j < keysCount; j++ {
+				key := []byte(fmt.Sprintf("%d_key", j))
+				err := cache.Add(key, key, MaxTtl)
+				if err == nil {
+					addedCount++
+				} else if err != ErrAlreadyExists {
+					t.Error(err)
+				}
+			}
+			ch <- addedCount
+		}()
+	}
+	addedCount := 0
+	for i := 0; i < workersCount; i++ {
+		addedCount += <-ch
+	}
+	if addedCount != keysCount {
+		t.Fatalf("Unexpected number of added items=%d. Expected %d", addedCount, keysCount)
+	}
+}
+
+func cacher_Incr(cache fullCacher, t *testing.T) {
+	defer cache.Close()
+	key := []byte("counter")
+
+	if _, err := cache.Incr(key, 1); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
+
+	if err := cache.Set(key, []byte("10"), MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	expectCounter := func(n uint64, err error, expectedN uint64) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != expectedN {
+			t.Fatalf("Unexpected counter value=%d. Expected %d", n, expectedN)
+		}
+	}
+	n, err := cache.Incr(key, 5)
+	expectCounter(n, err, 15)
+	n, err = cache.Decr(key, 3)
+	expectCounter(n, err, 12)
+	n, err = cache.Decr(key, 100)
+	expectCounter(n, err, 0)
+	n, err = cache.Incr(key, math.MaxUint64)
+	expectCounter(n, err, math.MaxUint64)
+	n, err = cache.Incr(key, 2)
+	expectCounter(n, err, 1)
+
+	value, err := cache.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, []byte("1"), value)
+
+	if err = cache.Set(key, []byte("foobar"), MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cache.Incr(key, 1); err != ErrNotNumeric {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrNotNumeric)
+	}
+}
+
+func TestCache_Incr(t *testing.T) {
+	cache := newCache(t)
+	cacher_Incr(cache, t)
+}
+
+func TestCache_Incr_Concurrent(t *testing.T) {
+	cache := newCache(t)
+	defer cache.Close()
+
+	key := []byte("counter")
+	if err := cache.Set(key, []byte("0"), MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+
+	const workersCount = 10
+	const incrementsCount = 1000
+	ch := make(chan error, workersCount)
+	for i := 0; i < workersCount; i++ {
+		go func() {
+			for j := 0; j < incrementsCount; j++ {
+				if _, err := cache.Incr(key, 1); err != nil {
+					ch <- err
+					return
+				}
+			}
+			ch <- nil
+		}()
+	}
+	for i := 0; i < workersCount; i++ {
+		if err := <-ch; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := cache.Incr(key, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != workersCount*incrementsCount {
+		t.Fatalf("Unexpected counter value=%d. Expected %d", n, workersCount*incrementsCount)
+	}
+}
+
+func cacher_Touch(cache fullCacher, t *testing.T) {
+	defer cache.Close()
+	key := []byte("key")
+	value := []byte("value")
+
+	if err := cache.Touch(key, MaxTtl); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
+
+	if err := cache.Set(key, value, 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	version := getItemVersion(cache, key, t)
+	if err := cache.Touch(key, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	item, err := cache.GetItem(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, value, item.Value())
+	if item.Ttl() < time.Minute || item.Ttl() > time.Hour {
+		t.Fatalf("Unexpected ttl=%s after Touch()", item.Ttl())
+	}
+	if item.Version() != version {
+		t.Fatalf("The version mustn't change after Touch()")
+	}
+	item.Close()
+
+	if err = cache.Touch(key, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, err = cache.Get(key); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
+}
+
+func TestCache_Touch(t *testing.T) {
+	cache := newCache(t)
+	cacher_Touch(cache, t)
+}
+
+type evictedItem struct {
+	key    string
+	reason EvictReason
+}
+
+func TestCache_OnEvict(t *testing.T) {
+	var evictedItems []evictedItem
+	config := &Config{
+		MaxItemsCount: 1000,
+		DataFileSize:  4096,
+		OnEvict: func(key []byte, reason EvictReason) {
+			evictedItems = append(evictedItems, evictedItem{string(key), reason})
+		},
+	}
+	cache, err := config.OpenCache(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	expectEvicted := func(expectedItems ...evictedItem) {
+		if len(evictedItems) != len(expectedItems) {
+			t.Fatalf("Unexpected evicted items=%v. Expected %v", evictedItems, expectedItems)
+		}
+		for i := range expectedItems {
+			if evictedItems[i] != expectedItems[i] {
+				t.Fatalf("Unexpected evicted items=%v. Expected %v", evictedItems, expectedItems)
+			}
+		}
+		evictedItems = nil
+	}
+	value := []byte("value")
+
+	// Deleted items must be reported.
+	if err = cache.Set([]byte("aaa"), value, MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	cache.Delete([]byte("aaa"))
+	expectEvicted(evictedItem{"aaa", EvictDelete})
+
+	// Expired items must be reported on lookup only once.
+	if err = cache.Set([]byte("bbb"), value, 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if _, err = cache.Get([]byte("bbb")); err != ErrCacheMiss {
+			t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+		}
+	}
+	expectEvicted(evictedItem{"bbb", EvictExpired})
+
+	// Overwritten items must be reported on lookup.
+	if err = cache.Set([]byte("ccc"), value, MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 100)
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("%d_key", i))
+		if err = cache.Set(key, buf, MaxTtl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = cache.Get([]byte("ccc")); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
+	expectEvicted(evictedItem{"ccc", EvictWrap})
+	if s := cache.Stats(); s.Evictions != 1 {
+		t.Fatalf("Unexpected Evictions=%d. Expected 1", s.Evictions)
+	}
+
+	// Cleared items must be reported.
+	cache.Clear()
+	if len(evictedItems) == 0 {
+		t.Fatalf("Cleared items must be reported")
+	}
+	for _, item := range evictedItems {
+		if item.reason != EvictClear {
+			t.Fatalf("Unexpected evict reason=%s. Expected %s", item.reason, EvictClear)
+		}
+	}
+}
+
+func TestCache_VerifyChecksums(t *testing.T) {
+	config := newConfig()
+	config.DataFile = "foobar.data.verify_checksums"
+	config.IndexFile = "foobar.index.verify_checksums"
+	config.VerifyChecksums = true
+	defer config.RemoveCache()
+
+	cache, err := config.OpenCache(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("key")
+	value := []byte("some value, which will be corrupted")
+	if err = cache.Set(key, value, MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	cache.Close()
+
+	// Corrupt the value directly in the data file.
+	data, err := ioutil.ReadFile(config.DataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := bytes.Index(data, value)
+	if n < 0 {
+		t.Fatalf("Cannot find the value in the data file")
+	}
+	data[n+len(value)/2] ^= 0xff
+	if err = ioutil.WriteFile(config.DataFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
 
-func cacher_GetItem(cache Cacher, t *testing.T) {
-	defer cache.Close()
-	for i := 0; i < 1000; i++ {
-		key := []byte(fmt.Sprintf("key_%d", i))
-		_, err := cache.GetItem(key)
-		if err != ErrCacheMiss {
-			t.Fatal(err)
-		}
+	if cache, err = config.OpenCache(false); err != nil {
+		t.Fatal(err)
 	}
+	defer cache.Close()
 
-	for i := 0; i < 1000; i++ {
-		key := []byte(fmt.Sprintf("key_%d", i))
-		value := []byte(fmt.Sprintf("value_%d", i))
-		err := cache.Set(key, value, MaxTtl)
-		if err != nil {
-			t.Fatal(err)
-		}
+	if _, err = cache.Get(key); err != ErrCorrupted {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCorrupted)
+	}
+	// The corrupted item must be evicted.
+	if _, err = cache.Get(key); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
+	if s := cache.Stats(); s.ChecksumMismatches != 1 {
+		t.Fatalf("Unexpected ChecksumMismatches=%d. Expected 1", s.ChecksumMismatches)
+	}
 
-		item, err := cache.GetItem(key)
-		if err != nil {
-			t.Fatal(err)
-		}
-		defer item.Close()
-		checkValue(t, value, item.Value())
+	if err = cache.Set(key, value, MaxTtl); err != nil {
+		t.Fatal(err)
+	}
+	actualValue, err := cache.Get(key)
+	if err != nil {
+		t.Fatal(err)
 	}
+	checkValue(t, value, actualValue)
 }
 
-func TestCache_GetItem(t *testing.T) {
-	cache := newCache(t)
-	cacher_GetItem(cache, t)
-}
+/*******************************************************************************
+ * SetTxn
+ ******************************************************************************/
 
-func cacher_GetDeItem(cache Cacher, t *testing.T) {
+func TestSetTxn_CompareAndCommit(t *testing.T) {
+	cache := newCache(t)
 	defer cache.Close()
-	for i := 0; i < 1000; i++ {
-		key := []byte(fmt.Sprintf("key_%d", i))
-		_, err := cache.GetDeItem(key, time.Second)
-		if err != ErrCacheMiss {
-			t.Fatal(err)
-		}
-		_, err = cache.GetDeAsyncItem(key, time.Second)
-		if err != ErrWouldBlock {
-			t.Fatal(err)
-		}
+
+	key := []byte("key")
+	value := []byte("value")
+	if err := cache.Set(key, []byte("old value"), MaxTtl); err != nil {
+		t.Fatal(err)
 	}
+	version := getItemVersion(cache, key, t)
 
-	for i := 0; i < 1000; i++ {
-		key := []byte(fmt.Sprintf("key_%d", i))
-		value := []byte(fmt.Sprintf("value_%d", i))
-		err := cache.Set(key, value, MaxTtl)
+	commit := func(version uint64) error {
+		txn, err := cache.NewSetTxn(key, len(value), MaxTtl)
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		item, err := cache.GetDeItem(key, time.Second)
-		if err != nil {
+		if _, err = txn.Write(value); err != nil {
 			t.Fatal(err)
 		}
-		defer item.Close()
-		checkValue(t, value, item.Value())
+		return txn.CompareAndCommit(version)
 	}
-}
 
-func TestCache_GetDeItem(t *testing.T) {
-	cache := newCache(t)
-	cacher_GetDeItem(cache, t)
+	if err := commit(version + 1); err != ErrVersionMismatch {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrVersionMismatch)
+	}
+	if err := commit(version); err != nil {
+		t.Fatal(err)
+	}
+	actualValue, err := cache.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, value, actualValue)
+
+	cache.Delete(key)
+	if err := commit(version); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
 }
 
-func cacher_NewSetTxn(cache Cacher, t *testing.T) {
+func TestSetTxn_CommitAdd(t *testing.T) {
+	cache := newCache(t)
 	defer cache.Close()
-	for i := 0; i < 1000; i++ {
-		key := []byte(fmt.Sprintf("key_%d", i))
-		value := []byte(fmt.Sprintf("value_%d", i))
+
+	key := []byte("key")
+	value := []byte("value")
+	commit := func() error {
 		txn, err := cache.NewSetTxn(key, len(value), MaxTtl)
 		if err != nil {
 			t.Fatal(err)
 		}
-		n, err := txn.Write(value)
-		if err != nil {
-			txn.Rollback()
-			t.Fatal(err)
-		}
-		if n != len(value) {
-			t.Fatalf("unexpected number of bytes written=%d. Expected %d", n, len(value))
-		}
-		err = txn.Commit()
-		if err != nil {
-			t.Fatal(err)
-		}
-
-		actualValue, err := cache.Get(key)
-		if err != nil {
+		if _, err = txn.Write(value); err != nil {
 			t.Fatal(err)
 		}
-		checkValue(t, value, actualValue)
+		return txn.CommitAdd()
 	}
-}
 
-func TestCache_NewSetTxn(t *testing.T) {
-	cache := newCache(t)
-	cacher_NewSetTxn(cache, t)
+	if err := commit(); err != nil {
+		t.Fatal(err)
+	}
+	actualValue, err := cache.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, value, actualValue)
+	if err = commit(); err != ErrAlreadyExists {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrAlreadyExists)
+	}
 }
 
-/*******************************************************************************
- * SetTxn
- ******************************************************************************/
-
 func TestSetTxn_Commit(t *testing.T) {
 	cache := newCache(t)
 	defer cache.Close()
@@ -930,6 +1921,258 @@ func newCluster(t *testing.T) *Cluster {
 	return cluster
 }
 
+func newPersistentClusterConfig(cachesCount, maxItemsCount int) ClusterConfig {
+	var cfg ClusterConfig
+	for i := 0; i < cachesCount; i++ {
+		cfg.AddCache(&Config{
+			DataFileSize:  1000 * 1000,
+			MaxItemsCount: SizeT(maxItemsCount),
+			IndexFile:     fmt.Sprintf("cache.index.%d", i),
+			DataFile:      fmt.Sprintf("cache.data.%d", i),
+		})
+	}
+	return cfg
+}
+
+// Returns DataFile for the cache, which owns each of the given keys.
+func clusterKeysOwners(cfg ClusterConfig, keysCount int) []string {
+	cluster := &Cluster{}
+	cluster.initHashing(cfg)
+	owners := make([]string, keysCount)
+	for i := range owners {
+		owners[i] = cfg[cluster.cacheIndex([]byte(fmt.Sprintf("%d_key", i)))].DataFile
+	}
+	return owners
+}
+
+func TestCluster_ConsistentHashing(t *testing.T) {
+	keysCount := 10000
+	cfg := newPersistentClusterConfig(4, 1000)
+	owners := clusterKeysOwners(cfg, keysCount)
+
+	counts := make(map[string]int)
+	for _, owner := range owners {
+		counts[owner]++
+	}
+	for _, c := range cfg {
+		if n := counts[c.DataFile]; n < keysCount/4*8/10 || n > keysCount/4*12/10 {
+			t.Fatalf("Unexpected number of keys=%d for cache [%s]. Expected around %d", n, c.DataFile, keysCount/4)
+		}
+	}
+
+	// Only keys moved to the added cache must be remapped.
+	added := &Config{
+		MaxItemsCount: 1000,
+		DataFile:      "cache.data.added",
+	}
+	cfg.AddCache(added)
+	newOwners := clusterKeysOwners(cfg, keysCount)
+	moved := 0
+	for i, owner := range newOwners {
+		if owner != owners[i] {
+			if owner != added.DataFile {
+				t.Fatalf("Key moved from [%s] to [%s] instead of [%s]", owners[i], owner, added.DataFile)
+			}
+			moved++
+		}
+	}
+	if moved < keysCount/5*8/10 || moved > keysCount/5*12/10 {
+		t.Fatalf("Unexpected number of moved keys=%d. Expected around %d", moved, keysCount/5)
+	}
+
+	// Only keys from the removed cache must be remapped.
+	removed := &Config{
+		DataFile: "cache.data.1",
+	}
+	if !cfg.RemoveCache(removed) {
+		t.Fatalf("Cannot remove cache [%s]", removed.DataFile)
+	}
+	if cfg.RemoveCache(removed) {
+		t.Fatalf("Cache [%s] mustn't be found after removal", removed.DataFile)
+	}
+	if len(cfg) != 4 {
+		t.Fatalf("Unexpected number of caches=%d. Expected 4", len(cfg))
+	}
+	owners = newOwners
+	newOwners = clusterKeysOwners(cfg, keysCount)
+	for i, owner := range newOwners {
+		if owners[i] != removed.DataFile && owner != owners[i] {
+			t.Fatalf("Key moved from [%s] to [%s] after removing [%s]", owners[i], owner, removed.DataFile)
+		}
+		if owner == removed.DataFile {
+			t.Fatalf("Key is owned by removed cache [%s]", removed.DataFile)
+		}
+	}
+
+	// Caches must receive keys proportionally to MaxItemsCount.
+	cfg = ClusterConfig{
+		&Config{MaxItemsCount: 1000},
+		&Config{MaxItemsCount: 3000},
+	}
+	cluster := &Cluster{}
+	cluster.initHashing(cfg)
+	n := 0
+	for i := 0; i < keysCount; i++ {
+		n += cluster.cacheIndex([]byte(fmt.Sprintf("%d_key", i)))
+	}
+	if n < keysCount*3/4*9/10 || n > keysCount*3/4*11/10 {
+		t.Fatalf("Unexpected number of keys=%d for the cache with bigger weight. Expected around %d", n, keysCount*3/4)
+	}
+}
+
+func TestCluster_AddCache(t *testing.T) {
+	keysCount := 1000
+	// Caches evict items on index bucket overflow, so give them enough
+	// spare index slots for storing all the keys.
+	maxItemsCount := keysCount * 100
+	cfg := newPersistentClusterConfig(3, maxItemsCount)
+	defer func() {
+		// cfg is modified below, so do not bind it to defer directly.
+		cfg.RemoveCluster()
+	}()
+
+	cluster, err := cfg.OpenCluster(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < keysCount; i++ {
+		key := []byte(fmt.Sprintf("%d_key", i))
+		if err = cluster.Set(key, []byte(fmt.Sprintf("value_%d", i)), MaxTtl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cluster.Close()
+
+	cfg.AddCache(&Config{
+		DataFileSize:  1000 * 1000,
+		MaxItemsCount: SizeT(maxItemsCount),
+		IndexFile:     "cache.index.added",
+		DataFile:      "cache.data.added",
+	})
+	if cluster, err = cfg.OpenCluster(true); err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+
+	owners := clusterKeysOwners(cfg, keysCount)
+	hits := 0
+	for i := 0; i < keysCount; i++ {
+		key := []byte(fmt.Sprintf("%d_key", i))
+		value, err := cluster.Get(key)
+		if owners[i] == "cache.data.added" {
+			if err != ErrCacheMiss {
+				t.Fatalf("Unexpected error=[%v] for key=[%s] moved to the added cache. Expected [%s]", err, key, ErrCacheMiss)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Cannot find key=[%s] after adding the cache: [%s]", key, err)
+		}
+		checkValue(t, []byte(fmt.Sprintf("value_%d", i)), value)
+		hits++
+	}
+	if hits < keysCount/2 {
+		t.Fatalf("Too few keys=%d survived adding the cache", hits)
+	}
+}
+
+func TestClusterConfig_OpenClusterDegraded(t *testing.T) {
+	config := newClusterConfig(3)
+	if _, err := config.OpenClusterDegraded(false); err != ErrOpenFailed {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrOpenFailed)
+	}
+
+	cluster, err := config.OpenClusterDegraded(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+	for i, s := range cluster.ShardStatus() {
+		if !s.Online || s.Err != nil {
+			t.Fatalf("Unexpected status for the cache #%d: online=%v, err=[%v]", i, s.Online, s.Err)
+		}
+	}
+}
+
+func TestCluster_Degraded(t *testing.T) {
+	keysCount := 300
+	// Caches evict items on index bucket overflow, so give them enough
+	// spare index slots. All the keys must be found in the degraded cluster.
+	cfg := newPersistentClusterConfig(3, keysCount*100)
+	defer cfg.RemoveCluster()
+
+	// Create files only for the first two caches, so the last cache
+	// cannot be opened without force.
+	cluster, err := cfg[:2].OpenCluster(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster.Close()
+
+	if _, err = cfg.OpenCluster(false); err != ErrOpenFailed {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrOpenFailed)
+	}
+	if cluster, err = cfg.OpenClusterDegraded(false); err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+
+	shards := cluster.ShardStatus()
+	if len(shards) != 3 {
+		t.Fatalf("Unexpected number of shards=%d. Expected 3", len(shards))
+	}
+	for i, s := range shards {
+		if s.DataFile != cfg[i].DataFile || s.IndexFile != cfg[i].IndexFile {
+			t.Fatalf("Unexpected files=[%s, %s] for the cache #%d", s.DataFile, s.IndexFile, i)
+		}
+		isOnline := i < 2
+		if s.Online != isOnline || (s.Err == nil) != isOnline {
+			t.Fatalf("Unexpected status for the cache #%d: online=%v, err=[%v]", i, s.Online, s.Err)
+		}
+	}
+
+	// Keys belonging to the offline cache must be rerouted to online caches.
+	for i := 0; i < keysCount; i++ {
+		key := []byte(fmt.Sprintf("%d_key", i))
+		if err = cluster.Set(key, []byte(fmt.Sprintf("value_%d", i)), MaxTtl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shards = cluster.ShardStatus()
+	if shards[0].Stats.Sets+shards[1].Stats.Sets != uint64(keysCount) || shards[2].Stats.Sets != 0 {
+		t.Fatalf("Unexpected sets distribution: %d, %d, %d", shards[0].Stats.Sets, shards[1].Stats.Sets, shards[2].Stats.Sets)
+	}
+	if stats := cluster.Stats(); stats.Sets != uint64(keysCount) {
+		t.Fatalf("Unexpected Sets=%d. Expected %d", stats.Sets, keysCount)
+	}
+	for i := 0; i < keysCount; i++ {
+		key := []byte(fmt.Sprintf("%d_key", i))
+		value, err := cluster.Get(key)
+		if err != nil {
+			t.Fatalf("Cannot find key=[%s] in degraded cluster: [%s]", key, err)
+		}
+		checkValue(t, []byte(fmt.Sprintf("value_%d", i)), value)
+	}
+
+	// Only keys belonging to the offline cache may be rerouted.
+	owners := clusterKeysOwners(cfg, keysCount)
+	online := clusterKeysOwners(cfg[:2], keysCount)
+	for i := 0; i < keysCount; i++ {
+		if owners[i] != cfg[2].DataFile && owners[i] != online[i] {
+			t.Fatalf("Key #%d belonging to online cache [%s] has been rerouted to [%s]", i, owners[i], online[i])
+		}
+	}
+
+	values := cluster.GetMulti([][]byte{[]byte("0_key"), []byte("1_key")}, nil)
+	checkValue(t, []byte("value_0"), values[0])
+	checkValue(t, []byte("value_1"), values[1])
+
+	cluster.Clear()
+	if _, err = cluster.Get([]byte("0_key")); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, ErrCacheMiss)
+	}
+}
+
 func TestCluster_Ops(t *testing.T) {
 	config := ClusterConfig{
 		&Config{
@@ -984,11 +2227,31 @@ func TestCluster_GetDe(t *testing.T) {
 	cacher_GetDe(cluster, t)
 }
 
+func TestCluster_GetDeCtx(t *testing.T) {
+	cluster := newCluster(t)
+	cacher_GetDeCtx(cluster, t)
+}
+
+func TestCluster_GetStaleItem(t *testing.T) {
+	cluster := newCluster(t)
+	cacher_GetStaleItem(cluster, t)
+}
+
+func TestCluster_GetMulti(t *testing.T) {
+	cluster := newCluster(t)
+	cacher_GetMulti(cluster, t)
+}
+
 func TestCluster_Clear(t *testing.T) {
 	cluster := newCluster(t)
 	simple_cacher_Clear(cluster, t)
 }
 
+func TestCluster_Stats(t *testing.T) {
+	cluster := newCluster(t)
+	simple_cacher_Stats(cluster, t)
+}
+
 func TestCluster_SetItem(t *testing.T) {
 	cluster := newCluster(t)
 	cacher_SetItem(cluster, t)
@@ -1004,6 +2267,41 @@ func TestCluster_GetDeItem(t *testing.T) {
 	cacher_GetDeItem(cluster, t)
 }
 
+func TestCluster_Iterate(t *testing.T) {
+	cluster := newCluster(t)
+	cacher_Iterate(cluster, t)
+}
+
+func TestCluster_Occupancy(t *testing.T) {
+	cluster := newCluster(t)
+	cacher_Occupancy(cluster, t)
+}
+
+func TestCluster_CompareAndSet(t *testing.T) {
+	cluster := newCluster(t)
+	cacher_CompareAndSet(cluster, t)
+}
+
+func TestCluster_CompareAndDelete(t *testing.T) {
+	cluster := newCluster(t)
+	cacher_CompareAndDelete(cluster, t)
+}
+
+func TestCluster_Add(t *testing.T) {
+	cluster := newCluster(t)
+	cacher_Add(cluster, t)
+}
+
+func TestCluster_Incr(t *testing.T) {
+	cluster := newCluster(t)
+	cacher_Incr(cluster, t)
+}
+
+func TestCluster_Touch(t *testing.T) {
+	cluster := newCluster(t)
+	cacher_Touch(cluster, t)
+}
+
 func TestCluster_NewSetTxn(t *testing.T) {
 	cluster := newCluster(t)
 	cacher_NewSetTxn(cluster, t)
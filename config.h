@@ -124,6 +124,19 @@
  */
 #define C_DE_ITEM_SLEEP_TIME 100
 
+/*
+ * The number of locks used for serializing index updates.
+ *
+ * Each key is mapped to a single lock, so index updates for distinct keys
+ * rarely contend with each other.
+ *
+ * Too low number of locks may result in lock contention on concurrent updates.
+ *
+ * Too high number of locks may waste memory, since the locks are embedded
+ * into each ybc instance.
+ */
+#define C_COMMIT_LOCKS_COUNT 64
+
 #define C_CLUSTER_INITIAL_HASH_SEED 0xDEADBEEFDEADBEEF
 
 #endif  /* YBC_CONFIG_H_INCLUDED */
@@ -3,6 +3,7 @@ package memcache
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"github.com/valyala/ybc/bindings/go/ybc"
 	"io"
 	"log"
@@ -29,10 +30,13 @@ const (
 
 var (
 	strAdd                 = []byte("add ")
+	strAppend              = []byte("append ")
 	strCas                 = []byte("cas ")
 	strCget                = []byte("cget ")
 	strCgetDe              = []byte("cgetde ")
+	strClientError         = []byte("CLIENT_ERROR ")
 	strCrLf                = []byte("\r\n")
+	strDecr                = []byte("decr ")
 	strDelete              = []byte("delete ")
 	strDeleted             = []byte("DELETED")
 	strDeletedCrLf         = []byte("DELETED\r\n")
@@ -44,9 +48,25 @@ var (
 	strFlushAllCrLf        = []byte("flush_all\r\n")
 	strFlushAllWs          = []byte("flush_all ")
 	strFlushAllNoreplyCrLf = []byte("flush_all noreply\r\n")
+	strGat                 = []byte("gat ")
+	strGats                = []byte("gats ")
 	strGet                 = []byte("get ")
 	strGetDe               = []byte("getde ")
 	strGets                = []byte("gets ")
+	strIncr                = []byte("incr ")
+	strMetaArithmetic      = []byte("ma ")
+	strMetaDelete          = []byte("md ")
+	strMetaExists          = []byte("EX")
+	strMetaGet             = []byte("mg ")
+	strMetaHit             = []byte("HD")
+	strMetaMissCrLf        = []byte("EN\r\n")
+	strMetaNoop            = []byte("mn")
+	strMetaNoopCrLf        = []byte("MN\r\n")
+	strMetaNotFound        = []byte("NF")
+	strMetaNotStored       = []byte("NS")
+	strMetaSet             = []byte("ms ")
+	strMetaValue           = []byte("VA ")
+	strNonNumericValueCrLf = []byte("cannot increment or decrement non-numeric value\r\n")
 	strNoreply             = []byte("noreply")
 	strNotFound            = []byte("NOT_FOUND")
 	strNotFoundCrLf        = []byte("NOT_FOUND\r\n")
@@ -55,10 +75,23 @@ var (
 	strNotStored           = []byte("NOT_STORED")
 	strNotStoredCrLf       = []byte("NOT_STORED\r\n")
 	strOkCrLf              = []byte("OK\r\n")
+	strPrepend             = []byte("prepend ")
+	strQuit                = []byte("quit")
+	strReplace             = []byte("replace ")
 	strSet                 = []byte("set ")
+	strStat                = []byte("STAT ")
+	strStats               = []byte("stats")
+	strStatsWs             = []byte("stats ")
 	strStored              = []byte("STORED")
 	strStoredCrLf          = []byte("STORED\r\n")
+	strTooLargeCrLf        = []byte("SERVER_ERROR object too large for cache\r\n")
+	strTouch               = []byte("touch ")
+	strTouched             = []byte("TOUCHED")
+	strTouchedCrLf         = []byte("TOUCHED\r\n")
 	strValue               = []byte("VALUE ")
+	strVerbosity           = []byte("verbosity ")
+	strVersion             = []byte("VERSION ")
+	strVersionCmd          = []byte("version")
 	strWouldBlock          = []byte("WB")
 	strWouldBlockCrLf      = []byte("WB\r\n")
 	strWsNoreplyCrLf       = []byte(" noreply\r\n")
@@ -69,8 +102,17 @@ const (
 	flagsSize              = 4
 	validateExpirationSize = 8
 	validateTtlSize        = 4
+
+	// The maximum size of decimal counter value for incr/decr commands.
+	maxCounterSize = 32
+
+	// The size of chunks used for reading payloads into memory.
+	payloadChunkSize = 64 * 1024
 )
 
+// Server version reported to clients.
+const serverVersion = "ybc"
+
 func validateKey(key []byte) bool {
 	// Disallow empty keys.
 	if len(key) == 0 {
@@ -265,15 +307,22 @@ func parseExpiration(s []byte) (expiration time.Duration, ok bool) {
 	if !ok {
 		return
 	}
+	expiration = secondsToExpiration(int64(t))
+	return
+}
+
+// Converts memcache expiration time to duration.
+//
+// Zero means 'no expiration'. Values exceeding 30 days are treated
+// as unix timestamps.
+func secondsToExpiration(t int64) time.Duration {
 	if t == 0 {
-		expiration = maxExpiration
-	} else if t > maxExpirationSeconds {
-		expiration = time.Unix(int64(t), 0).Sub(time.Now())
-	} else {
-		expiration = time.Second * time.Duration(t)
+		return maxExpiration
 	}
-	ok = true
-	return
+	if t > maxExpirationSeconds {
+		return time.Unix(t, 0).Sub(time.Now())
+	}
+	return time.Second * time.Duration(t)
 }
 
 func parseFlagsToken(line []byte, n *int) (flags uint32, ok bool) {
@@ -292,7 +341,13 @@ func parseSizeToken(line []byte, n *int) (size int, ok bool) {
 		ok = false
 		return
 	}
-	size, ok = parseInt(sizeStr)
+	if size, ok = parseInt(sizeStr); !ok {
+		return
+	}
+	if size < 0 {
+		log.Printf("Negative size=%d", size)
+		ok = false
+	}
 	return
 }
 
@@ -411,6 +466,18 @@ func writeMilliseconds(w *bufio.Writer, duration time.Duration, scratchBuf *[]by
 	return writeUint32(w, uint32(t), scratchBuf)
 }
 
+// Sleeps for the given duration. Returns ctx.Err() if ctx is done earlier.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	select {
+	case <-ctx.Done():
+		t.Stop()
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
 func cacheClearFunc(cache ybc.Cacher) func() {
 	return func() { cache.Clear() }
 }
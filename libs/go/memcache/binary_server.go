@@ -0,0 +1,505 @@
+package memcache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/valyala/ybc/bindings/go/ybc"
+	"io"
+	"log"
+	"time"
+)
+
+// Server-side implementation of memcache binary protocol.
+//
+// See https://github.com/memcached/memcached/wiki/BinaryProtocolRevamped
+// for protocol description.
+
+const (
+	binaryMagicRequest  = 0x80
+	binaryMagicResponse = 0x81
+
+	binaryHeaderSize = 24
+)
+
+// Binary protocol opcodes.
+const (
+	binaryOpGet        = 0x00
+	binaryOpSet        = 0x01
+	binaryOpAdd        = 0x02
+	binaryOpReplace    = 0x03
+	binaryOpDelete     = 0x04
+	binaryOpIncrement  = 0x05
+	binaryOpDecrement  = 0x06
+	binaryOpQuit       = 0x07
+	binaryOpFlush      = 0x08
+	binaryOpGetQ       = 0x09
+	binaryOpNoop       = 0x0a
+	binaryOpVersion    = 0x0b
+	binaryOpGetK       = 0x0c
+	binaryOpGetKQ      = 0x0d
+	binaryOpStat       = 0x10
+	binaryOpSetQ       = 0x11
+	binaryOpAddQ       = 0x12
+	binaryOpReplaceQ   = 0x13
+	binaryOpDeleteQ    = 0x14
+	binaryOpIncrementQ = 0x15
+	binaryOpDecrementQ = 0x16
+	binaryOpQuitQ      = 0x17
+	binaryOpFlushQ     = 0x18
+	binaryOpTouch      = 0x1c
+)
+
+// Binary protocol response statuses.
+const (
+	binaryStatusOk               = 0x0000
+	binaryStatusKeyNotFound      = 0x0001
+	binaryStatusKeyExists        = 0x0002
+	binaryStatusValueTooLarge    = 0x0003
+	binaryStatusInvalidArguments = 0x0004
+	binaryStatusNotStored        = 0x0005
+	binaryStatusNonNumeric       = 0x0006
+	binaryStatusUnknownCommand   = 0x0081
+	binaryStatusOutOfMemory      = 0x0082
+)
+
+// Error messages sent in response values for non-ok statuses.
+var binaryStatusMessages = map[uint16][]byte{
+	binaryStatusKeyNotFound:      []byte("Not found"),
+	binaryStatusKeyExists:        []byte("Data exists for key"),
+	binaryStatusValueTooLarge:    []byte("Too large"),
+	binaryStatusInvalidArguments: []byte("Invalid arguments"),
+	binaryStatusNotStored:        []byte("Not stored"),
+	binaryStatusNonNumeric:       []byte("Non-numeric server-side value for incr or decr"),
+	binaryStatusUnknownCommand:   []byte("Unknown command"),
+	binaryStatusOutOfMemory:      []byte("Out of memory"),
+}
+
+var binaryStoreModes = map[byte]int{
+	binaryOpSet:     storeModeSet,
+	binaryOpAdd:     storeModeAdd,
+	binaryOpReplace: storeModeReplace,
+}
+
+// Incr/decr requests with this expiration mustn't create missing counters.
+const binaryNoCreateExpiration = 0xffffffff
+
+type binaryHeader struct {
+	opcode     byte
+	keySize    int
+	extrasSize int
+	dataType   byte
+	bodySize   int
+	opaque     uint32
+	casid      uint64
+}
+
+// Returns true if the client connected to r speaks binary protocol.
+//
+// Binary requests start with the magic byte, which cannot start
+// text protocol commands.
+func isBinaryConn(r *bufio.Reader) bool {
+	buf, err := r.Peek(1)
+	return err == nil && buf[0] == binaryMagicRequest
+}
+
+// Returns the opcode of non-quiet command for the given opcode.
+//
+// Quiet commands don't send responses on success (or on cache miss
+// for get commands).
+func binaryLoudOpcode(opcode byte) (loudOpcode byte, isQuiet bool) {
+	switch opcode {
+	case binaryOpGetQ:
+		return binaryOpGet, true
+	case binaryOpGetKQ:
+		return binaryOpGetK, true
+	case binaryOpSetQ:
+		return binaryOpSet, true
+	case binaryOpAddQ:
+		return binaryOpAdd, true
+	case binaryOpReplaceQ:
+		return binaryOpReplace, true
+	case binaryOpDeleteQ:
+		return binaryOpDelete, true
+	case binaryOpIncrementQ:
+		return binaryOpIncrement, true
+	case binaryOpDecrementQ:
+		return binaryOpDecrement, true
+	case binaryOpQuitQ:
+		return binaryOpQuit, true
+	case binaryOpFlushQ:
+		return binaryOpFlush, true
+	}
+	return opcode, false
+}
+
+func readBinaryHeader(r *bufio.Reader, h *binaryHeader) bool {
+	var buf [binaryHeaderSize]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		if err != io.EOF {
+			log.Printf("Error when reading binary request header: [%s]", err)
+		}
+		return false
+	}
+	if buf[0] != binaryMagicRequest {
+		log.Printf("Unexpected magic byte=[%d] in binary request header. Expected [%d]", buf[0], binaryMagicRequest)
+		return false
+	}
+	h.opcode = buf[1]
+	h.keySize = int(binary.BigEndian.Uint16(buf[2:]))
+	h.extrasSize = int(buf[4])
+	h.dataType = buf[5]
+	h.bodySize = int(binary.BigEndian.Uint32(buf[8:]))
+	h.opaque = binary.BigEndian.Uint32(buf[12:])
+	h.casid = binary.BigEndian.Uint64(buf[16:])
+	if h.keySize+h.extrasSize > h.bodySize {
+		log.Printf("Too small body size=[%d] in binary request header. Expected at least [%d]", h.bodySize, h.keySize+h.extrasSize)
+		return false
+	}
+	return true
+}
+
+func readBinaryBody(r *bufio.Reader, size int, scratchBuf *[]byte) bool {
+	buf := *scratchBuf
+	if cap(buf) < size {
+		buf = make([]byte, size)
+	}
+	buf = buf[:size]
+	*scratchBuf = buf
+	if _, err := io.ReadFull(r, buf); err != nil {
+		log.Printf("Error when reading binary request body with size=[%d]: [%s]", size, err)
+		return false
+	}
+	return true
+}
+
+func discardBinaryBody(r *bufio.Reader, size int) bool {
+	if _, err := r.Discard(size); err != nil {
+		log.Printf("Error when skipping binary request body with size=[%d]: [%s]", size, err)
+		return false
+	}
+	return true
+}
+
+func writeBinaryResponseHeader(w *bufio.Writer, h *binaryHeader, status uint16, extrasSize, keySize, valueSize int, casid uint64) bool {
+	var buf [binaryHeaderSize]byte
+	buf[0] = binaryMagicResponse
+	buf[1] = h.opcode
+	binary.BigEndian.PutUint16(buf[2:], uint16(keySize))
+	buf[4] = byte(extrasSize)
+	binary.BigEndian.PutUint16(buf[6:], status)
+	binary.BigEndian.PutUint32(buf[8:], uint32(extrasSize+keySize+valueSize))
+	binary.BigEndian.PutUint32(buf[12:], h.opaque)
+	binary.BigEndian.PutUint64(buf[16:], casid)
+	return writeStr(w, buf[:])
+}
+
+func writeBinaryResponse(w *bufio.Writer, h *binaryHeader, status uint16, extras, key, value []byte, casid uint64) bool {
+	return writeBinaryResponseHeader(w, h, status, len(extras), len(key), len(value), casid) &&
+		writeStr(w, extras) && writeStr(w, key) && writeStr(w, value)
+}
+
+// Writes response with the given status.
+//
+// Ok responses aren't written for quiet commands.
+func writeBinaryStatus(w *bufio.Writer, h *binaryHeader, status uint16, casid uint64, isQuiet bool) bool {
+	if status == binaryStatusOk {
+		if isQuiet {
+			return true
+		}
+		return writeBinaryResponse(w, h, status, nil, nil, nil, casid)
+	}
+	return writeBinaryResponse(w, h, status, nil, nil, binaryStatusMessages[status], 0)
+}
+
+func checkBinaryArgs(h *binaryHeader, extrasSize int, hasKey bool) bool {
+	if h.extrasSize != extrasSize {
+		log.Printf("Unexpected extras size=[%d] for binary command with opcode=[%d]. Expected [%d]", h.extrasSize, h.opcode, extrasSize)
+		return false
+	}
+	if (h.keySize > 0) != hasKey {
+		log.Printf("Unexpected key size=[%d] for binary command with opcode=[%d]", h.keySize, h.opcode)
+		return false
+	}
+	return true
+}
+
+func writeBinaryGetResponse(w *bufio.Writer, h *binaryHeader, key []byte, item *ybc.Item, shouldWriteKey bool) bool {
+	casid, flags, ok := readItemCasidFlags(item)
+	if !ok {
+		return false
+	}
+	if !shouldWriteKey {
+		key = nil
+	}
+	var extras [flagsSize]byte
+	binary.BigEndian.PutUint32(extras[:], flags)
+	size := item.Available()
+	return writeBinaryResponseHeader(w, h, binaryStatusOk, len(extras), len(key), size, casid) &&
+		writeStr(w, extras[:]) && writeStr(w, key) && writeItemPayload(w, item, size)
+}
+
+func processBinaryGetCmd(w *bufio.Writer, s *Server, h *binaryHeader, key []byte, shouldWriteKey, isQuiet bool) bool {
+	if !checkBinaryArgs(h, 0, true) {
+		return writeBinaryStatus(w, h, binaryStatusInvalidArguments, 0, isQuiet)
+	}
+	item, err := s.cache.GetItem(key)
+	if err != nil {
+		if corruptedToCacheMiss(err, key) == ybc.ErrCacheMiss {
+			s.counters.countGet(false)
+			if isQuiet {
+				return true
+			}
+			return writeBinaryStatus(w, h, binaryStatusKeyNotFound, 0, isQuiet)
+		}
+		log.Fatalf("Unexpected error returned by cache.GetItem(key=[%s]): [%s]", key, err)
+	}
+	// do not use defer item.Close() for performance reasons
+
+	s.counters.countGet(true)
+	ok := writeBinaryGetResponse(w, h, key, item, shouldWriteKey)
+	item.Close()
+	return ok
+}
+
+func processBinarySetCmd(c *bufio.ReadWriter, cache serverCacher, h *binaryHeader, opcode byte, isQuiet bool, scratchBuf *[]byte) bool {
+	valueSize := h.bodySize - h.extrasSize - h.keySize
+	if !checkBinaryArgs(h, flagsSize+4, true) {
+		if !discardBinaryBody(c.Reader, h.bodySize) {
+			return false
+		}
+		return writeBinaryStatus(c.Writer, h, binaryStatusInvalidArguments, 0, isQuiet)
+	}
+	if !readBinaryBody(c.Reader, h.extrasSize+h.keySize, scratchBuf) {
+		return false
+	}
+	body := *scratchBuf
+	flags := binary.BigEndian.Uint32(body)
+	expiration := secondsToExpiration(int64(binary.BigEndian.Uint32(body[flagsSize:])))
+	key := body[h.extrasSize:]
+
+	casid := getCasid()
+	if opcode == binaryOpReplace && h.casid == 0 {
+		return processBinaryReplaceCmd(c, cache, h, key, casid, flags, expiration, valueSize, isQuiet)
+	}
+	txn := startSetTxnWithCasid(cache, key, casid, flags, expiration, valueSize)
+	if txn == nil {
+		if !discardBinaryBody(c.Reader, valueSize) {
+			return false
+		}
+		return writeBinaryStatus(c.Writer, h, binaryStatusValueTooLarge, 0, isQuiet)
+	}
+	if !readPayloadToTxn(c.Reader, txn, valueSize) {
+		txn.Rollback()
+		return false
+	}
+
+	status := uint16(binaryStatusOk)
+	switch commitStoreTxn(cache, txn, key, binaryStoreModes[opcode], h.casid) {
+	case ybc.ErrAlreadyExists, errCasidMismatch:
+		status = binaryStatusKeyExists
+	case ybc.ErrCacheMiss:
+		status = binaryStatusKeyNotFound
+	case errNotStored:
+		status = binaryStatusNotStored
+	}
+	return writeBinaryStatus(c.Writer, h, status, casid, isQuiet)
+}
+
+func processBinaryReplaceCmd(c *bufio.ReadWriter, cache serverCacher, h *binaryHeader, key []byte, casid uint64, flags uint32, expiration time.Duration, valueSize int, isQuiet bool) bool {
+	value := appendItemMetadata(nil, casid, flags)
+	value, ok := appendPayload(value, c.Reader, valueSize)
+	if !ok {
+		return false
+	}
+
+	status := uint16(binaryStatusOk)
+	switch err := replaceItem(cache, key, value, expiration); err {
+	case nil:
+	case ybc.ErrCacheMiss:
+		status = binaryStatusKeyNotFound
+	case ybc.ErrItemTooLarge, ybc.ErrNoSpace:
+		status = binaryStatusValueTooLarge
+	default:
+		log.Printf("Cannot replace the item with key=[%s]: [%s]", key, err)
+		status = binaryStatusNotStored
+	}
+	return writeBinaryStatus(c.Writer, h, status, casid, isQuiet)
+}
+
+func processBinaryDeleteCmd(w *bufio.Writer, cache serverCacher, h *binaryHeader, key []byte, isQuiet bool) bool {
+	if !checkBinaryArgs(h, 0, true) {
+		return writeBinaryStatus(w, h, binaryStatusInvalidArguments, 0, isQuiet)
+	}
+	status := uint16(binaryStatusOk)
+	if h.casid != 0 {
+		casid, version, cacheMiss, ok := getCasidForCachedItem(cache, key)
+		if !ok {
+			return false
+		}
+		if cacheMiss {
+			return writeBinaryStatus(w, h, binaryStatusKeyNotFound, 0, isQuiet)
+		}
+		if casid != h.casid {
+			return writeBinaryStatus(w, h, binaryStatusKeyExists, 0, isQuiet)
+		}
+		// The item may be modified after reading its' casid, so delete it
+		// only if it has the same version.
+		switch cache.CompareAndDelete(key, version) {
+		case ybc.ErrCacheMiss:
+			status = binaryStatusKeyNotFound
+		case ybc.ErrVersionMismatch:
+			status = binaryStatusKeyExists
+		}
+	} else if !cache.Delete(key) {
+		status = binaryStatusKeyNotFound
+	}
+	return writeBinaryStatus(w, h, status, 0, isQuiet)
+}
+
+func processBinaryIncrCmd(w *bufio.Writer, cache serverCacher, h *binaryHeader, extras, key []byte, isDecr, isQuiet bool) bool {
+	if !checkBinaryArgs(h, 20, true) {
+		return writeBinaryStatus(w, h, binaryStatusInvalidArguments, 0, isQuiet)
+	}
+	delta := binary.BigEndian.Uint64(extras)
+	initial := binary.BigEndian.Uint64(extras[8:])
+	t := binary.BigEndian.Uint32(extras[16:])
+	createIfMissing := (t != binaryNoCreateExpiration)
+
+	value, casid, err := updateCounter(cache, key, delta, isDecr, initial, createIfMissing, secondsToExpiration(int64(t)))
+	switch err {
+	case nil:
+	case ybc.ErrCacheMiss:
+		return writeBinaryStatus(w, h, binaryStatusKeyNotFound, 0, isQuiet)
+	case ybc.ErrNotNumeric:
+		return writeBinaryStatus(w, h, binaryStatusNonNumeric, 0, isQuiet)
+	default:
+		log.Printf("Cannot update counter for key=[%s]: [%s]", key, err)
+		return writeBinaryStatus(w, h, binaryStatusNotStored, 0, isQuiet)
+	}
+	if isQuiet {
+		return true
+	}
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], value)
+	return writeBinaryResponse(w, h, binaryStatusOk, nil, nil, buf[:], casid)
+}
+
+func processBinaryTouchCmd(w *bufio.Writer, cache serverCacher, h *binaryHeader, extras, key []byte) bool {
+	if !checkBinaryArgs(h, 4, true) {
+		return writeBinaryStatus(w, h, binaryStatusInvalidArguments, 0, false)
+	}
+	expiration := secondsToExpiration(int64(binary.BigEndian.Uint32(extras)))
+	status := uint16(binaryStatusOk)
+	if err := cache.Touch(key, expiration); err != nil {
+		if err != ybc.ErrCacheMiss {
+			log.Printf("Unexpected error returned by cache.Touch(key=[%s]): [%s]", key, err)
+		}
+		status = binaryStatusKeyNotFound
+	}
+	return writeBinaryStatus(w, h, status, 0, false)
+}
+
+func processBinaryFlushCmd(w *bufio.Writer, cache ybc.Cacher, h *binaryHeader, extras []byte, isQuiet bool, flushAllTimer **time.Timer) bool {
+	var expiration time.Duration
+	if len(extras) > 0 {
+		if !checkBinaryArgs(h, 4, false) {
+			return writeBinaryStatus(w, h, binaryStatusInvalidArguments, 0, isQuiet)
+		}
+		// Zero expiration means 'flush immediately' here.
+		if t := binary.BigEndian.Uint32(extras); t > 0 {
+			expiration = secondsToExpiration(int64(t))
+		}
+	} else if !checkBinaryArgs(h, 0, false) {
+		return writeBinaryStatus(w, h, binaryStatusInvalidArguments, 0, isQuiet)
+	}
+	flushAll(cache, expiration, flushAllTimer)
+	return writeBinaryStatus(w, h, binaryStatusOk, 0, isQuiet)
+}
+
+func processBinaryStatCmd(w *bufio.Writer, s *Server, h *binaryHeader, key []byte) bool {
+	if !checkBinaryArgs(h, 0, len(key) > 0) {
+		return writeBinaryStatus(w, h, binaryStatusInvalidArguments, 0, false)
+	}
+	stats, ok := serverStatsGroup(s, key)
+	if !ok {
+		return writeBinaryStatus(w, h, binaryStatusKeyNotFound, 0, false)
+	}
+	for _, st := range stats {
+		if !writeBinaryResponse(w, h, binaryStatusOk, nil, []byte(st.name), []byte(st.value), 0) {
+			return false
+		}
+	}
+	// Empty response terminates stats.
+	return writeBinaryStatus(w, h, binaryStatusOk, 0, false)
+}
+
+func processBinaryRequest(c *bufio.ReadWriter, s *Server, scratchBuf *[]byte, flushAllTimer **time.Timer) bool {
+	var h binaryHeader
+	if !readBinaryHeader(c.Reader, &h) {
+		return false
+	}
+	opcode, isQuiet := binaryLoudOpcode(h.opcode)
+	latency := s.latencies.binary[opcode]
+	if latency == nil {
+		return processBinaryCmd(c, s, &h, opcode, isQuiet, scratchBuf, flushAllTimer)
+	}
+	startTime := time.Now()
+	ok := processBinaryCmd(c, s, &h, opcode, isQuiet, scratchBuf, flushAllTimer)
+	latency.update(time.Since(startTime))
+	return ok
+}
+
+func processBinaryCmd(c *bufio.ReadWriter, s *Server, h *binaryHeader, opcode byte, isQuiet bool, scratchBuf *[]byte, flushAllTimer **time.Timer) bool {
+	cache := s.cache
+	if h.dataType != 0 {
+		log.Printf("Unsupported data type=[%d] in binary request", h.dataType)
+		if !discardBinaryBody(c.Reader, h.bodySize) {
+			return false
+		}
+		return writeBinaryStatus(c.Writer, h, binaryStatusInvalidArguments, 0, isQuiet)
+	}
+	switch opcode {
+	case binaryOpSet, binaryOpAdd, binaryOpReplace:
+		s.counters.countSet()
+		return processBinarySetCmd(c, cache, h, opcode, isQuiet, scratchBuf)
+	}
+
+	// Other commands have no value, so read the whole body at once.
+	if h.bodySize != h.extrasSize+h.keySize {
+		log.Printf("Unexpected value with size=[%d] for binary command with opcode=[%d]", h.bodySize-h.extrasSize-h.keySize, h.opcode)
+		if !discardBinaryBody(c.Reader, h.bodySize) {
+			return false
+		}
+		return writeBinaryStatus(c.Writer, h, binaryStatusInvalidArguments, 0, isQuiet)
+	}
+	if !readBinaryBody(c.Reader, h.bodySize, scratchBuf) {
+		return false
+	}
+	body := *scratchBuf
+	extras := body[:h.extrasSize]
+	key := body[h.extrasSize:]
+
+	switch opcode {
+	case binaryOpGet, binaryOpGetK:
+		return processBinaryGetCmd(c.Writer, s, h, key, opcode == binaryOpGetK, isQuiet)
+	case binaryOpDelete:
+		return processBinaryDeleteCmd(c.Writer, cache, h, key, isQuiet)
+	case binaryOpIncrement, binaryOpDecrement:
+		return processBinaryIncrCmd(c.Writer, cache, h, extras, key, opcode == binaryOpDecrement, isQuiet)
+	case binaryOpTouch:
+		return processBinaryTouchCmd(c.Writer, cache, h, extras, key)
+	case binaryOpFlush:
+		return processBinaryFlushCmd(c.Writer, cache, h, extras, isQuiet, flushAllTimer)
+	case binaryOpNoop:
+		return writeBinaryStatus(c.Writer, h, binaryStatusOk, 0, false)
+	case binaryOpVersion:
+		return writeBinaryResponse(c.Writer, h, binaryStatusOk, nil, nil, []byte(serverVersion), 0)
+	case binaryOpStat:
+		return processBinaryStatCmd(c.Writer, s, h, key)
+	case binaryOpQuit:
+		writeBinaryStatus(c.Writer, h, binaryStatusOk, 0, isQuiet)
+		return false
+	}
+	log.Printf("Unrecognized binary command with opcode=[%d]", h.opcode)
+	return writeBinaryStatus(c.Writer, h, binaryStatusUnknownCommand, 0, false)
+}
@@ -0,0 +1,447 @@
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type binaryResponse struct {
+	opcode byte
+	status uint16
+	opaque uint32
+	casid  uint64
+	extras []byte
+	key    []byte
+	value  []byte
+}
+
+type binaryTestConn struct {
+	t      *testing.T
+	conn   net.Conn
+	r      *bufio.Reader
+	opaque uint32
+}
+
+func newBinaryTestConn(t *testing.T) *binaryTestConn {
+	conn, err := net.Dial("tcp", testAddr)
+	if err != nil {
+		t.Fatalf("Cannot connect to [%s]: [%s]", testAddr, err)
+	}
+	return &binaryTestConn{
+		t:    t,
+		conn: conn,
+		r:    bufio.NewReader(conn),
+	}
+}
+
+func (c *binaryTestConn) Close() {
+	c.conn.Close()
+}
+
+// Sends binary request and returns its' opaque.
+func (c *binaryTestConn) send(opcode byte, casid uint64, extras, key, value []byte) uint32 {
+	c.opaque++
+	var buf [binaryHeaderSize]byte
+	buf[0] = binaryMagicRequest
+	buf[1] = opcode
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(key)))
+	buf[4] = byte(len(extras))
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(buf[12:], c.opaque)
+	binary.BigEndian.PutUint64(buf[16:], casid)
+	packet := append(append(append(buf[:], extras...), key...), value...)
+	if _, err := c.conn.Write(packet); err != nil {
+		c.t.Fatalf("Cannot send binary request: [%s]", err)
+	}
+	return c.opaque
+}
+
+func (c *binaryTestConn) recv() *binaryResponse {
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	var buf [binaryHeaderSize]byte
+	if _, err := io.ReadFull(c.r, buf[:]); err != nil {
+		c.t.Fatalf("Cannot read binary response header: [%s]", err)
+	}
+	if buf[0] != binaryMagicResponse {
+		c.t.Fatalf("Unexpected magic byte=[%d] in binary response. Expected [%d]", buf[0], binaryMagicResponse)
+	}
+	keySize := int(binary.BigEndian.Uint16(buf[2:]))
+	extrasSize := int(buf[4])
+	body := make([]byte, binary.BigEndian.Uint32(buf[8:]))
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		c.t.Fatalf("Cannot read binary response body: [%s]", err)
+	}
+	return &binaryResponse{
+		opcode: buf[1],
+		status: binary.BigEndian.Uint16(buf[6:]),
+		opaque: binary.BigEndian.Uint32(buf[12:]),
+		casid:  binary.BigEndian.Uint64(buf[16:]),
+		extras: body[:extrasSize],
+		key:    body[extrasSize : extrasSize+keySize],
+		value:  body[extrasSize+keySize:],
+	}
+}
+
+// Sends binary request and returns the response for it.
+func (c *binaryTestConn) call(opcode byte, casid uint64, extras, key, value []byte) *binaryResponse {
+	opaque := c.send(opcode, casid, extras, key, value)
+	resp := c.recv()
+	if resp.opcode != opcode || resp.opaque != opaque {
+		c.t.Fatalf("Unexpected opcode=[%d], opaque=[%d] in binary response. Expected [%d], [%d]", resp.opcode, resp.opaque, opcode, opaque)
+	}
+	return resp
+}
+
+func (c *binaryTestConn) expectStatus(resp *binaryResponse, status uint16) {
+	if resp.status != status {
+		c.t.Fatalf("Unexpected status=[%d] for binary command with opcode=[%d]: [%s]. Expected [%d]", resp.status, resp.opcode, resp.value, status)
+	}
+}
+
+func binarySetExtras(flags, expiration uint32) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint32(buf[:], flags)
+	binary.BigEndian.PutUint32(buf[4:], expiration)
+	return buf[:]
+}
+
+func binaryIncrExtras(delta, initial uint64, expiration uint32) []byte {
+	var buf [20]byte
+	binary.BigEndian.PutUint64(buf[:], delta)
+	binary.BigEndian.PutUint64(buf[8:], initial)
+	binary.BigEndian.PutUint32(buf[16:], expiration)
+	return buf[:]
+}
+
+func binaryExpirationExtras(expiration uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], expiration)
+	return buf[:]
+}
+
+func binaryTest_Run(testFunc func(c *binaryTestConn, t *testing.T), t *testing.T) {
+	s, cache := newServerCache(t)
+	defer cache.Close()
+	s.Start()
+	defer s.Stop()
+
+	c := newBinaryTestConn(t)
+	defer c.Close()
+	testFunc(c, t)
+}
+
+func (c *binaryTestConn) expectValue(key []byte, value []byte, flags uint32) *binaryResponse {
+	resp := c.call(binaryOpGet, 0, nil, key, nil)
+	c.expectStatus(resp, binaryStatusOk)
+	if len(resp.key) != 0 {
+		c.t.Fatalf("Unexpected key=[%s] in get response", resp.key)
+	}
+	if !bytes.Equal(resp.value, value) {
+		c.t.Fatalf("Unexpected value=[%s] for key=[%s]. Expected [%s]", resp.value, key, value)
+	}
+	if len(resp.extras) != 4 || binary.BigEndian.Uint32(resp.extras) != flags {
+		c.t.Fatalf("Unexpected extras=%v for key=[%s]. Expected flags=[%d]", resp.extras, key, flags)
+	}
+	return resp
+}
+
+func (c *binaryTestConn) expectMiss(key []byte) {
+	c.expectStatus(c.call(binaryOpGet, 0, nil, key, nil), binaryStatusKeyNotFound)
+}
+
+func binaryTest_GetSet(c *binaryTestConn, t *testing.T) {
+	key := []byte("key")
+	value := []byte("value")
+	c.expectMiss(key)
+
+	resp := c.call(binaryOpSet, 0, binarySetExtras(12345, 0), key, value)
+	c.expectStatus(resp, binaryStatusOk)
+	casid := resp.casid
+	if casid == 0 {
+		t.Fatalf("Set response must contain non-zero casid")
+	}
+	if resp = c.expectValue(key, value, 12345); resp.casid != casid {
+		t.Fatalf("Unexpected casid=[%d]. Expected [%d]", resp.casid, casid)
+	}
+
+	resp = c.call(binaryOpGetK, 0, nil, key, nil)
+	c.expectStatus(resp, binaryStatusOk)
+	if !bytes.Equal(resp.key, key) || !bytes.Equal(resp.value, value) {
+		t.Fatalf("Unexpected key=[%s], value=[%s] in getk response. Expected [%s], [%s]", resp.key, resp.value, key, value)
+	}
+
+	// Empty values must be supported.
+	c.expectStatus(c.call(binaryOpSet, 0, binarySetExtras(0, 0), key, nil), binaryStatusOk)
+	c.expectValue(key, nil, 0)
+
+	c.expectStatus(c.call(binaryOpGet, 0, nil, nil, nil), binaryStatusInvalidArguments)
+	c.expectStatus(c.call(binaryOpSet, 0, nil, key, value), binaryStatusInvalidArguments)
+	c.expectStatus(c.call(binaryOpGet, 0, binaryExpirationExtras(0), key, nil), binaryStatusInvalidArguments)
+}
+
+func TestServer_Binary_GetSet(t *testing.T) {
+	binaryTest_Run(binaryTest_GetSet, t)
+}
+
+func binaryTest_AddReplace(c *binaryTestConn, t *testing.T) {
+	key := []byte("key")
+	c.expectStatus(c.call(binaryOpReplace, 0, binarySetExtras(0, 0), key, []byte("foo")), binaryStatusKeyNotFound)
+	c.expectMiss(key)
+
+	c.expectStatus(c.call(binaryOpAdd, 0, binarySetExtras(1, 0), key, []byte("bar")), binaryStatusOk)
+	c.expectStatus(c.call(binaryOpAdd, 0, binarySetExtras(2, 0), key, []byte("baz")), binaryStatusKeyExists)
+	c.expectValue(key, []byte("bar"), 1)
+
+	c.expectStatus(c.call(binaryOpReplace, 0, binarySetExtras(3, 0), key, []byte("foo")), binaryStatusOk)
+	c.expectValue(key, []byte("foo"), 3)
+}
+
+func TestServer_Binary_AddReplace(t *testing.T) {
+	binaryTest_Run(binaryTest_AddReplace, t)
+}
+
+func binaryTest_Cas(c *binaryTestConn, t *testing.T) {
+	key := []byte("key")
+	c.expectStatus(c.call(binaryOpSet, 123, binarySetExtras(0, 0), key, []byte("foo")), binaryStatusKeyNotFound)
+
+	resp := c.call(binaryOpSet, 0, binarySetExtras(0, 0), key, []byte("foo"))
+	c.expectStatus(resp, binaryStatusOk)
+	casid := resp.casid
+
+	c.expectStatus(c.call(binaryOpSet, casid+1, binarySetExtras(0, 0), key, []byte("bar")), binaryStatusKeyExists)
+	c.expectStatus(c.call(binaryOpReplace, casid+1, binarySetExtras(0, 0), key, []byte("bar")), binaryStatusKeyExists)
+	c.expectValue(key, []byte("foo"), 0)
+
+	resp = c.call(binaryOpSet, casid, binarySetExtras(0, 0), key, []byte("bar"))
+	c.expectStatus(resp, binaryStatusOk)
+	c.expectValue(key, []byte("bar"), 0)
+
+	// Casid must change after each modification.
+	c.expectStatus(c.call(binaryOpDelete, casid, nil, key, nil), binaryStatusKeyExists)
+	c.expectStatus(c.call(binaryOpDelete, resp.casid, nil, key, nil), binaryStatusOk)
+	c.expectMiss(key)
+}
+
+func TestServer_Binary_Cas(t *testing.T) {
+	binaryTest_Run(binaryTest_Cas, t)
+}
+
+func binaryTest_Delete(c *binaryTestConn, t *testing.T) {
+	key := []byte("key")
+	c.expectStatus(c.call(binaryOpDelete, 0, nil, key, nil), binaryStatusKeyNotFound)
+	c.expectStatus(c.call(binaryOpSet, 0, binarySetExtras(0, 0), key, []byte("foo")), binaryStatusOk)
+	c.expectStatus(c.call(binaryOpDelete, 0, nil, key, nil), binaryStatusOk)
+	c.expectMiss(key)
+}
+
+func TestServer_Binary_Delete(t *testing.T) {
+	binaryTest_Run(binaryTest_Delete, t)
+}
+
+func (c *binaryTestConn) expectCounter(resp *binaryResponse, value uint64) {
+	c.expectStatus(resp, binaryStatusOk)
+	if len(resp.value) != 8 || binary.BigEndian.Uint64(resp.value) != value {
+		c.t.Fatalf("Unexpected counter value=%v. Expected [%d]", resp.value, value)
+	}
+}
+
+func binaryTest_IncrDecr(c *binaryTestConn, t *testing.T) {
+	key := []byte("counter")
+	c.expectStatus(c.call(binaryOpIncrement, 0, binaryIncrExtras(1, 10, binaryNoCreateExpiration), key, nil), binaryStatusKeyNotFound)
+	c.expectMiss(key)
+
+	c.expectCounter(c.call(binaryOpIncrement, 0, binaryIncrExtras(1, 10, 0), key, nil), 10)
+	c.expectCounter(c.call(binaryOpIncrement, 0, binaryIncrExtras(5, 10, 0), key, nil), 15)
+	c.expectCounter(c.call(binaryOpDecrement, 0, binaryIncrExtras(3, 10, 0), key, nil), 12)
+	c.expectValue(key, []byte("12"), 0)
+
+	// Counters cannot go below zero.
+	c.expectCounter(c.call(binaryOpDecrement, 0, binaryIncrExtras(100, 10, 0), key, nil), 0)
+
+	// Counters must wrap around on overflow.
+	c.expectCounter(c.call(binaryOpIncrement, 0, binaryIncrExtras(1<<64-1, 0, 0), key, nil), 1<<64-1)
+	c.expectCounter(c.call(binaryOpIncrement, 0, binaryIncrExtras(2, 0, 0), key, nil), 1)
+
+	// Flags must remain unchanged.
+	c.expectStatus(c.call(binaryOpSet, 0, binarySetExtras(42, 0), key, []byte("100")), binaryStatusOk)
+	c.expectCounter(c.call(binaryOpIncrement, 0, binaryIncrExtras(1, 0, 0), key, nil), 101)
+	c.expectValue(key, []byte("101"), 42)
+
+	c.expectStatus(c.call(binaryOpSet, 0, binarySetExtras(0, 0), key, []byte("foo")), binaryStatusOk)
+	c.expectStatus(c.call(binaryOpIncrement, 0, binaryIncrExtras(1, 0, 0), key, nil), binaryStatusNonNumeric)
+	c.expectValue(key, []byte("foo"), 0)
+}
+
+func TestServer_Binary_IncrDecr(t *testing.T) {
+	binaryTest_Run(binaryTest_IncrDecr, t)
+}
+
+func binaryTest_Touch(c *binaryTestConn, t *testing.T) {
+	key := []byte("key")
+	c.expectStatus(c.call(binaryOpTouch, 0, binaryExpirationExtras(1), key, nil), binaryStatusKeyNotFound)
+	c.expectStatus(c.call(binaryOpSet, 0, binarySetExtras(0, 0), key, []byte("foo")), binaryStatusOk)
+	c.expectStatus(c.call(binaryOpTouch, 0, binaryExpirationExtras(1), key, nil), binaryStatusOk)
+	c.expectValue(key, []byte("foo"), 0)
+	time.Sleep(time.Millisecond * 1100)
+	c.expectMiss(key)
+}
+
+func TestServer_Binary_Touch(t *testing.T) {
+	binaryTest_Run(binaryTest_Touch, t)
+}
+
+func binaryTest_Flush(c *binaryTestConn, t *testing.T) {
+	key := []byte("key")
+	c.expectStatus(c.call(binaryOpSet, 0, binarySetExtras(0, 0), key, []byte("foo")), binaryStatusOk)
+	c.expectStatus(c.call(binaryOpFlush, 0, nil, nil, nil), binaryStatusOk)
+	c.expectMiss(key)
+
+	c.expectStatus(c.call(binaryOpSet, 0, binarySetExtras(0, 0), key, []byte("foo")), binaryStatusOk)
+	c.expectStatus(c.call(binaryOpFlush, 0, binaryExpirationExtras(1), nil, nil), binaryStatusOk)
+	c.expectValue(key, []byte("foo"), 0)
+	time.Sleep(time.Millisecond * 1100)
+	c.expectMiss(key)
+}
+
+func TestServer_Binary_Flush(t *testing.T) {
+	binaryTest_Run(binaryTest_Flush, t)
+}
+
+func binaryTest_Quiet(c *binaryTestConn, t *testing.T) {
+	n := 10
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("%d_key", i))
+		c.send(binaryOpSetQ, 0, binarySetExtras(uint32(i), 0), key, []byte(fmt.Sprintf("value_%d", i)))
+	}
+	// Quiet errors must be reported.
+	addOpaque := c.send(binaryOpAddQ, 0, binarySetExtras(0, 0), []byte("0_key"), []byte("foo"))
+
+	// Quiet gets mustn't report misses.
+	var getOpaques []uint32
+	for i := 0; i < 2*n; i++ {
+		key := []byte(fmt.Sprintf("%d_key", i))
+		getOpaques = append(getOpaques, c.send(binaryOpGetKQ, 0, nil, key, nil))
+	}
+	noopOpaque := c.send(binaryOpNoop, 0, nil, nil, nil)
+
+	resp := c.recv()
+	if resp.opcode != binaryOpAddQ || resp.opaque != addOpaque || resp.status != binaryStatusKeyExists {
+		t.Fatalf("Unexpected response opcode=[%d], opaque=[%d], status=[%d] for addq", resp.opcode, resp.opaque, resp.status)
+	}
+	for i := 0; i < n; i++ {
+		resp = c.recv()
+		if resp.opcode != binaryOpGetKQ || resp.opaque != getOpaques[i] {
+			t.Fatalf("Unexpected response opcode=[%d], opaque=[%d] for getkq #%d", resp.opcode, resp.opaque, i)
+		}
+		c.expectStatus(resp, binaryStatusOk)
+		if !bytes.Equal(resp.key, []byte(fmt.Sprintf("%d_key", i))) || !bytes.Equal(resp.value, []byte(fmt.Sprintf("value_%d", i))) {
+			t.Fatalf("Unexpected key=[%s], value=[%s] for getkq #%d", resp.key, resp.value, i)
+		}
+	}
+	resp = c.recv()
+	if resp.opcode != binaryOpNoop || resp.opaque != noopOpaque {
+		t.Fatalf("Unexpected response opcode=[%d], opaque=[%d] for noop", resp.opcode, resp.opaque)
+	}
+	c.expectStatus(resp, binaryStatusOk)
+
+	c.send(binaryOpDeleteQ, 0, nil, []byte("0_key"), nil)
+	c.send(binaryOpIncrementQ, 0, binaryIncrExtras(1, 5, 0), []byte("counter"), nil)
+	c.send(binaryOpFlushQ, 0, binaryExpirationExtras(100), nil, nil)
+	c.call(binaryOpNoop, 0, nil, nil, nil)
+	c.expectMiss([]byte("0_key"))
+	c.expectValue([]byte("counter"), []byte("5"), 0)
+
+	c.send(binaryOpQuitQ, 0, nil, nil, nil)
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("Unexpected error=[%v] after quitq. Expected [%s]", err, io.EOF)
+	}
+}
+
+func TestServer_Binary_Quiet(t *testing.T) {
+	binaryTest_Run(binaryTest_Quiet, t)
+}
+
+func binaryTest_Misc(c *binaryTestConn, t *testing.T) {
+	c.expectStatus(c.call(binaryOpNoop, 0, nil, nil, nil), binaryStatusOk)
+
+	resp := c.call(binaryOpVersion, 0, nil, nil, nil)
+	c.expectStatus(resp, binaryStatusOk)
+	if string(resp.value) != serverVersion {
+		t.Fatalf("Unexpected version=[%s]. Expected [%s]", resp.value, serverVersion)
+	}
+
+	c.expectStatus(c.call(binaryOpSet, 0, binarySetExtras(0, 0), []byte("key"), []byte("value")), binaryStatusOk)
+	opaque := c.send(binaryOpStat, 0, nil, nil, nil)
+	stats := make(map[string]string)
+	for {
+		resp = c.recv()
+		if resp.opaque != opaque {
+			t.Fatalf("Unexpected opaque=[%d] in stat response. Expected [%d]", resp.opaque, opaque)
+		}
+		c.expectStatus(resp, binaryStatusOk)
+		if len(resp.key) == 0 {
+			break
+		}
+		stats[string(resp.key)] = string(resp.value)
+	}
+	if stats["cmd_set"] != "1" || stats["version"] != serverVersion {
+		t.Fatalf("Unexpected stats=%v", stats)
+	}
+	resp = c.call(binaryOpStat, 0, nil, []byte("items"), nil)
+	c.expectStatus(resp, binaryStatusOk)
+	if len(resp.key) != 0 {
+		t.Fatalf("Unexpected stat=[%s] in empty stats group", resp.key)
+	}
+	c.expectStatus(c.call(binaryOpStat, 0, nil, []byte("unknown"), nil), binaryStatusKeyNotFound)
+
+	// The connection must remain usable after unknown commands.
+	c.expectStatus(c.call(0x55, 0, nil, []byte("key"), nil), binaryStatusUnknownCommand)
+	c.expectValue([]byte("key"), []byte("value"), 0)
+
+	c.expectStatus(c.call(binaryOpQuit, 0, nil, nil, nil), binaryStatusOk)
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("Unexpected error=[%v] after quit. Expected [%s]", err, io.EOF)
+	}
+}
+
+func TestServer_Binary_Misc(t *testing.T) {
+	binaryTest_Run(binaryTest_Misc, t)
+}
+
+func TestServer_Binary_TextInterop(t *testing.T) {
+	client, s, cache := newClientServerCache(t)
+	defer cache.Close()
+	defer s.Stop()
+	client.Start()
+	defer client.Stop()
+
+	c := newBinaryTestConn(t)
+	defer c.Close()
+
+	key := []byte("key")
+	c.expectStatus(c.call(binaryOpSet, 0, binarySetExtras(123, 0), key, []byte("foo")), binaryStatusOk)
+	item := Item{
+		Key: key,
+	}
+	if err := client.Get(&item); err != nil {
+		t.Fatalf("Cannot obtain item stored via binary protocol: [%s]", err)
+	}
+	if string(item.Value) != "foo" || item.Flags != 123 {
+		t.Fatalf("Unexpected value=[%s], flags=[%d]. Expected [foo], [123]", item.Value, item.Flags)
+	}
+
+	item.Value = []byte("bar")
+	item.Flags = 456
+	if err := client.Set(&item); err != nil {
+		t.Fatal(err)
+	}
+	c.expectValue(key, []byte("bar"), 456)
+}
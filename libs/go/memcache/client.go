@@ -3,6 +3,7 @@ package memcache
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"io/ioutil"
@@ -21,6 +22,7 @@ var (
 	ErrNilValue             = errors.New("memcache.Client: nil value")
 	ErrNotModified          = errors.New("memcache.Client: item not modified")
 	ErrAlreadyExists        = errors.New("memcache.Client: the item already exists")
+	ErrNotNumeric           = errors.New("memcache.Client: the item's value isn't a decimal number")
 )
 
 const (
@@ -656,6 +658,12 @@ func (t *taskCgetDe) ReadResponse(r *bufio.Reader, scratchBuf *[]byte) bool {
 
 // Combines functionality of Client.Cget() and Client.GetDe().
 func (c *Client) CgetDe(item *Item, graceDuration time.Duration) error {
+	return c.CgetDeCtx(context.Background(), item, graceDuration)
+}
+
+// The same as Client.CgetDe(), but stops waiting for the item affected
+// by dogpile effect when ctx is done. See Client.GetDeCtx() for details.
+func (c *Client) CgetDeCtx(ctx context.Context, item *Item, graceDuration time.Duration) error {
 	if !validateKey(item.Key) {
 		return ErrMalformedKey
 	}
@@ -667,7 +675,9 @@ func (c *Client) CgetDe(item *Item, graceDuration time.Duration) error {
 			return err
 		}
 		if t.wouldBlock {
-			time.Sleep(time.Millisecond * time.Duration(100))
+			if err := sleepCtx(ctx, time.Millisecond*time.Duration(100)); err != nil {
+				return err
+			}
 			continue
 		}
 		if t.notModified {
@@ -718,6 +728,16 @@ func (t *taskGetDe) ReadResponse(r *bufio.Reader, scratchBuf *[]byte) bool {
 // will create and store in the cache an item on cache miss during the given
 // graceDuration interval.
 func (c *Client) GetDe(item *Item, graceDuration time.Duration) error {
+	return c.GetDeCtx(context.Background(), item, graceDuration)
+}
+
+// The same as Client.GetDe(), but stops waiting for the item affected
+// by dogpile effect when ctx is done. Returns ctx.Err() in this case.
+//
+// Requests already sent to the server aren't interrupted, so the call
+// may return ctx.Err() only after the server responds to the pending
+// request.
+func (c *Client) GetDeCtx(ctx context.Context, item *Item, graceDuration time.Duration) error {
 	if !validateKey(item.Key) {
 		return ErrMalformedKey
 	}
@@ -729,7 +749,9 @@ func (c *Client) GetDe(item *Item, graceDuration time.Duration) error {
 			return err
 		}
 		if t.wouldBlock {
-			time.Sleep(time.Millisecond * time.Duration(100))
+			if err := sleepCtx(ctx, time.Millisecond*time.Duration(100)); err != nil {
+				return err
+			}
 			continue
 		}
 		if !t.found {
@@ -796,6 +818,24 @@ func (c *Client) Set(item *Item) error {
 	return c.do(&t)
 }
 
+func readNotStoredResponse(r *bufio.Reader, scratchBuf *[]byte, cmd []byte) (notStored, ok bool) {
+	if !readLine(r, scratchBuf) {
+		return
+	}
+	line := *scratchBuf
+	if bytes.Equal(line, strStored) {
+		ok = true
+		return
+	}
+	if bytes.Equal(line, strNotStored) {
+		notStored = true
+		ok = true
+		return
+	}
+	log.Printf("Unexpected response for %s() command: [%s]", bytes.TrimSpace(cmd), line)
+	return
+}
+
 type taskAdd struct {
 	item      *Item
 	notStored bool
@@ -808,19 +848,9 @@ func (t *taskAdd) WriteRequest(w *bufio.Writer, scratchBuf *[]byte) bool {
 }
 
 func (t *taskAdd) ReadResponse(r *bufio.Reader, scratchBuf *[]byte) bool {
-	if !readLine(r, scratchBuf) {
-		return false
-	}
-	line := *scratchBuf
-	if bytes.Equal(line, strStored) {
-		return true
-	}
-	if bytes.Equal(line, strNotStored) {
-		t.notStored = true
-		return true
-	}
-	log.Printf("Unexpected response for add() command: [%s]", line)
-	return false
+	var ok bool
+	t.notStored, ok = readNotStoredResponse(r, scratchBuf, strAdd)
+	return ok
 }
 
 // Stores the given item only if the server doesn't already hold data
@@ -904,6 +934,306 @@ func (c *Client) Cas(item *Item) error {
 	return nil
 }
 
+type taskReplace struct {
+	cmd       []byte
+	item      *Item
+	notStored bool
+	taskSync
+}
+
+func (t *taskReplace) WriteRequest(w *bufio.Writer, scratchBuf *[]byte) bool {
+	return writeCommonSetParams(w, t.cmd, t.item, scratchBuf) &&
+		writeNoreplyAndValue(w, false, t.item.Value)
+}
+
+func (t *taskReplace) ReadResponse(r *bufio.Reader, scratchBuf *[]byte) bool {
+	var ok bool
+	t.notStored, ok = readNotStoredResponse(r, scratchBuf, t.cmd)
+	return ok
+}
+
+func (c *Client) replace(cmd []byte, item *Item) error {
+	if !validateKey(item.Key) {
+		return ErrMalformedKey
+	}
+	if item.Value == nil {
+		return ErrNilValue
+	}
+	var t taskReplace
+	t.cmd = cmd
+	t.item = item
+	if err := c.do(&t); err != nil {
+		return err
+	}
+	if t.notStored {
+		return ErrCacheMiss
+	}
+	return nil
+}
+
+// Stores the given item only if the server already holds data under
+// the item.Key.
+//
+// Returns ErrCacheMiss if the server has no item with such a key.
+func (c *Client) Replace(item *Item) error {
+	return c.replace(strReplace, item)
+}
+
+// Appends item.Value to the value of the item with the given item.Key
+// on the server. item.Flags and item.Expiration are ignored.
+//
+// Returns ErrCacheMiss if the server has no item with such a key.
+func (c *Client) Append(item *Item) error {
+	return c.replace(strAppend, item)
+}
+
+// Prepends item.Value to the value of the item with the given item.Key
+// on the server. item.Flags and item.Expiration are ignored.
+//
+// Returns ErrCacheMiss if the server has no item with such a key.
+func (c *Client) Prepend(item *Item) error {
+	return c.replace(strPrepend, item)
+}
+
+type taskIncr struct {
+	cmd        []byte
+	key        []byte
+	delta      uint64
+	value      uint64
+	notFound   bool
+	notNumeric bool
+	taskSync
+}
+
+func (t *taskIncr) WriteRequest(w *bufio.Writer, scratchBuf *[]byte) bool {
+	return writeStr(w, t.cmd) && writeStr(w, t.key) && writeWs(w) &&
+		writeUint64(w, t.delta, scratchBuf) && writeCrLf(w)
+}
+
+func (t *taskIncr) ReadResponse(r *bufio.Reader, scratchBuf *[]byte) bool {
+	if !readLine(r, scratchBuf) {
+		return false
+	}
+	line := *scratchBuf
+	if bytes.Equal(line, strNotFound) {
+		t.notFound = true
+		return true
+	}
+	if bytes.HasPrefix(line, strClientError) {
+		t.notNumeric = true
+		return true
+	}
+	var ok bool
+	t.value, ok = parseUint64(line)
+	if !ok {
+		log.Printf("Unexpected response for %s() command: [%s]", bytes.TrimSpace(t.cmd), line)
+	}
+	return ok
+}
+
+func (c *Client) incr(cmd, key []byte, delta uint64) (value uint64, err error) {
+	if !validateKey(key) {
+		err = ErrMalformedKey
+		return
+	}
+	var t taskIncr
+	t.cmd = cmd
+	t.key = key
+	t.delta = delta
+	if err = c.do(&t); err != nil {
+		return
+	}
+	if t.notFound {
+		err = ErrCacheMiss
+		return
+	}
+	if t.notNumeric {
+		err = ErrNotNumeric
+		return
+	}
+	value = t.value
+	return
+}
+
+// Adds delta to the decimal counter stored under the given key
+// on the server. Returns the updated counter value.
+//
+// Returns ErrCacheMiss if the server has no item with such a key.
+// Returns ErrNotNumeric if the item's value isn't a decimal number.
+func (c *Client) Incr(key []byte, delta uint64) (value uint64, err error) {
+	return c.incr(strIncr, key, delta)
+}
+
+// Subtracts delta from the decimal counter stored under the given key
+// on the server. The counter cannot go below zero.
+// Returns the updated counter value.
+//
+// Returns ErrCacheMiss if the server has no item with such a key.
+// Returns ErrNotNumeric if the item's value isn't a decimal number.
+func (c *Client) Decr(key []byte, delta uint64) (value uint64, err error) {
+	return c.incr(strDecr, key, delta)
+}
+
+type taskTouch struct {
+	key        []byte
+	expiration time.Duration
+	notFound   bool
+	taskSync
+}
+
+func (t *taskTouch) WriteRequest(w *bufio.Writer, scratchBuf *[]byte) bool {
+	return writeStr(w, strTouch) && writeStr(w, t.key) && writeWs(w) &&
+		writeExpiration(w, t.expiration, scratchBuf) && writeCrLf(w)
+}
+
+func (t *taskTouch) ReadResponse(r *bufio.Reader, scratchBuf *[]byte) bool {
+	if !readLine(r, scratchBuf) {
+		return false
+	}
+	line := *scratchBuf
+	if bytes.Equal(line, strTouched) {
+		return true
+	}
+	if bytes.Equal(line, strNotFound) {
+		t.notFound = true
+		return true
+	}
+	log.Printf("Unexpected response for touch() command: [%s]", line)
+	return false
+}
+
+// Updates expiration time for the item with the given key on the server.
+// Zero expiration means the item has no expiration time.
+//
+// Returns ErrCacheMiss if the server has no item with such a key.
+func (c *Client) Touch(key []byte, expiration time.Duration) error {
+	if !validateKey(key) {
+		return ErrMalformedKey
+	}
+	var t taskTouch
+	t.key = key
+	t.expiration = expiration
+	if err := c.do(&t); err != nil {
+		return err
+	}
+	if t.notFound {
+		return ErrCacheMiss
+	}
+	return nil
+}
+
+type taskGat struct {
+	cmd   []byte
+	item  *Item
+	found bool
+	taskSync
+}
+
+func (t *taskGat) WriteRequest(w *bufio.Writer, scratchBuf *[]byte) bool {
+	return writeStr(w, t.cmd) && writeExpiration(w, t.item.Expiration, scratchBuf) &&
+		writeWs(w) && writeStr(w, t.item.Key) && writeCrLf(w)
+}
+
+func (t *taskGat) ReadResponse(r *bufio.Reader, scratchBuf *[]byte) bool {
+	ok, eof, _, _ := readSingleItem(r, scratchBuf, t.item)
+	if !ok {
+		return false
+	}
+	t.found = !eof
+	return true
+}
+
+func (c *Client) gat(cmd []byte, item *Item) error {
+	if !validateKey(item.Key) {
+		return ErrMalformedKey
+	}
+	var t taskGat
+	t.cmd = cmd
+	t.item = item
+	if err := c.do(&t); err != nil {
+		return err
+	}
+	if !t.found {
+		return ErrCacheMiss
+	}
+	return nil
+}
+
+// Obtains item.Value and item.Flags for the given item.Key and updates
+// item's expiration time on the server to item.Expiration.
+//
+// item.Casid is reset to 0. Use Client.Gats() for obtaining item.Casid.
+//
+// Returns ErrCacheMiss on cache miss.
+func (c *Client) Gat(item *Item) error {
+	return c.gat(strGat, item)
+}
+
+// Obtains item.Value, item.Flags and item.Casid for the given item.Key
+// and updates item's expiration time on the server to item.Expiration.
+//
+// Returns ErrCacheMiss on cache miss.
+func (c *Client) Gats(item *Item) error {
+	return c.gat(strGats, item)
+}
+
+type taskVersion struct {
+	version string
+	taskSync
+}
+
+func (t *taskVersion) WriteRequest(w *bufio.Writer, scratchBuf *[]byte) bool {
+	return writeStr(w, strVersionCmd) && writeCrLf(w)
+}
+
+func (t *taskVersion) ReadResponse(r *bufio.Reader, scratchBuf *[]byte) bool {
+	if !readLine(r, scratchBuf) {
+		return false
+	}
+	line := *scratchBuf
+	if !bytes.HasPrefix(line, strVersion) {
+		log.Printf("Unexpected response for version() command: [%s]", line)
+		return false
+	}
+	t.version = string(line[len(strVersion):])
+	return true
+}
+
+// Returns the server version keyed by Client.ServerAddr.
+//
+// The returned map contains a single entry. It is compatible with
+// DistributedClient.Versions().
+func (c *Client) Versions() (versions map[string]string, err error) {
+	var t taskVersion
+	if err = c.do(&t); err != nil {
+		return
+	}
+	versions = map[string]string{
+		c.ServerAddr: t.version,
+	}
+	return
+}
+
+type taskVerbosity struct {
+	level int
+	taskSync
+}
+
+func (t *taskVerbosity) WriteRequest(w *bufio.Writer, scratchBuf *[]byte) bool {
+	return writeStr(w, strVerbosity) && writeInt(w, t.level, scratchBuf) && writeCrLf(w)
+}
+
+func (t *taskVerbosity) ReadResponse(r *bufio.Reader, scratchBuf *[]byte) bool {
+	return matchStr(r, strOkCrLf)
+}
+
+// Sets logging verbosity level on the server.
+func (c *Client) Verbosity(level int) error {
+	var t taskVerbosity
+	t.level = level
+	return c.do(&t)
+}
+
 type taskNowait struct{}
 
 func (t *taskNowait) Init() {}
@@ -2,8 +2,11 @@ package memcache
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/valyala/ybc/bindings/go/ybc"
+	"net"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -76,6 +79,48 @@ func TestServer_Wait(t *testing.T) {
 	s.Wait()
 }
 
+// Cache returning ybc.ErrCorrupted on each item lookup.
+type corruptedCache struct {
+	*ybc.Cache
+}
+
+func (cache corruptedCache) GetItem(key []byte) (*ybc.Item, error) {
+	return nil, ybc.ErrCorrupted
+}
+
+func (cache corruptedCache) GetDeAsyncItem(key []byte, graceDuration time.Duration) (*ybc.Item, error) {
+	return nil, ybc.ErrCorrupted
+}
+
+func TestServer_CorruptedItems(t *testing.T) {
+	cache := newCache(t)
+	defer cache.Close()
+	s := &Server{
+		Cache:      corruptedCache{cache},
+		ListenAddr: testAddr,
+	}
+	s.Start()
+	defer s.Stop()
+
+	// Corrupted items must be treated as missing.
+	c := newTextTestConn(t)
+	defer c.Close()
+	c.expect("set key 0 0 3\r\nfoo\r\n", "STORED\r\n")
+	c.expect("get key\r\n", "END\r\n")
+	c.expect("gets key\r\n", "END\r\n")
+	c.expect("getde key 1000\r\n", "END\r\n")
+	c.expect("cas key 0 0 3 1\r\nbar\r\n", "NOT_FOUND\r\n")
+	c.expect("mg key v\r\n", "EN\r\n")
+	c.expect("ms key 3 C1\r\nbar\r\n", "NF\r\n")
+
+	bc := newBinaryTestConn(t)
+	defer bc.Close()
+	bc.expectMiss([]byte("key"))
+
+	// The server must remain alive.
+	c.expect("set key 0 0 3\r\nfoo\r\n", "STORED\r\n")
+}
+
 func TestServer_StopBeforeWait(t *testing.T) {
 	s, cache := newServerCache(t)
 	defer cache.Close()
@@ -163,7 +208,7 @@ func expectPanic(t *testing.T, f func()) {
 	t.Fatalf("the function [%s] must panic!", f)
 }
 
-func cacher_StopWithoutStart(c Cacher, t *testing.T) {
+func cacher_StopWithoutStart(c fullCacher, t *testing.T) {
 	expectPanic(t, func() { c.Stop() })
 }
 
@@ -175,7 +220,13 @@ func TestClient_StopWithoutStart(t *testing.T) {
 	cacher_StopWithoutStart(c, t)
 }
 
-type cacherTestFunc func(c Cacher, t *testing.T)
+type fullCacher interface {
+	Cacher
+	CcacherCtx
+	MemcacherExt
+}
+
+type cacherTestFunc func(c fullCacher, t *testing.T)
 
 func client_RunTest(testFunc cacherTestFunc, t *testing.T) {
 	c, s, cache := newClientServerCache(t)
@@ -187,7 +238,7 @@ func client_RunTest(testFunc cacherTestFunc, t *testing.T) {
 	testFunc(c, t)
 }
 
-func cacher_GetSet(c Cacher, t *testing.T) {
+func cacher_GetSet(c fullCacher, t *testing.T) {
 	key := []byte("key")
 	value := []byte("value")
 	flags := uint32(12345)
@@ -221,7 +272,7 @@ func TestClient_GetSet(t *testing.T) {
 	client_RunTest(cacher_GetSet, t)
 }
 
-func cacher_Add(c Cacher, t *testing.T) {
+func cacher_Add(c fullCacher, t *testing.T) {
 	key := []byte("keybb")
 	value := []byte("value_addd")
 	flags := uint32(18932)
@@ -255,7 +306,7 @@ func TestClient_Add(t *testing.T) {
 	client_RunTest(cacher_Add, t)
 }
 
-func cacher_Cas(c Cacher, t *testing.T) {
+func cacher_Cas(c fullCacher, t *testing.T) {
 	key := []byte("keyaa")
 	value := []byte("value_cas")
 	flags := uint32(189832)
@@ -264,7 +315,12 @@ func cacher_Cas(c Cacher, t *testing.T) {
 		Key:   key,
 		Value: value,
 		Flags: flags,
+		Casid: 1,
 	}
+	if err := c.Cas(&item); err != ErrCacheMiss {
+		t.Fatalf("unexpected error returned from Cacher.Cas(): [%s]. Expected ErrCacheMiss", err)
+	}
+
 	if err := c.Set(&item); err != nil {
 		t.Fatalf("error in Cacher.Set(): [%s]", err)
 	}
@@ -328,7 +384,437 @@ func TestClient_Cas(t *testing.T) {
 	client_RunTest(cacher_Cas, t)
 }
 
-func cacher_GetDe(c Cacher, t *testing.T) {
+func cacher_Replace(c fullCacher, t *testing.T) {
+	item := Item{
+		Key:   []byte("key_replace"),
+		Value: []byte("value"),
+		Flags: 123,
+	}
+	if err := c.Replace(&item); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error returned from Cacher.Replace(): [%v]. Expected ErrCacheMiss", err)
+	}
+	if err := c.Set(&item); err != nil {
+		t.Fatalf("error in Cacher.Set(): [%s]", err)
+	}
+	item.Value = []byte("new_value")
+	item.Flags = 456
+	if err := c.Replace(&item); err != nil {
+		t.Fatalf("error in Cacher.Replace(): [%s]", err)
+	}
+
+	item.Value = nil
+	item.Flags = 0
+	if err := c.Get(&item); err != nil {
+		t.Fatalf("error in Cacher.Get(): [%s]", err)
+	}
+	if string(item.Value) != "new_value" {
+		t.Fatalf("Unexpected item.Value=[%s]. Expected [new_value]", item.Value)
+	}
+	if item.Flags != 456 {
+		t.Fatalf("Unexpected item.Flags=%d. Expected 456", item.Flags)
+	}
+}
+
+func TestClient_Replace(t *testing.T) {
+	client_RunTest(cacher_Replace, t)
+}
+
+func cacher_AppendPrepend(c fullCacher, t *testing.T) {
+	item := Item{
+		Key:   []byte("key_append"),
+		Value: []byte("bar"),
+	}
+	if err := c.Append(&item); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error returned from Cacher.Append(): [%v]. Expected ErrCacheMiss", err)
+	}
+	if err := c.Prepend(&item); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error returned from Cacher.Prepend(): [%v]. Expected ErrCacheMiss", err)
+	}
+	item.Flags = 789
+	if err := c.Set(&item); err != nil {
+		t.Fatalf("error in Cacher.Set(): [%s]", err)
+	}
+	item.Value = []byte("baz")
+	item.Flags = 0
+	if err := c.Append(&item); err != nil {
+		t.Fatalf("error in Cacher.Append(): [%s]", err)
+	}
+	item.Value = []byte("foo")
+	if err := c.Prepend(&item); err != nil {
+		t.Fatalf("error in Cacher.Prepend(): [%s]", err)
+	}
+
+	item.Value = nil
+	if err := c.Get(&item); err != nil {
+		t.Fatalf("error in Cacher.Get(): [%s]", err)
+	}
+	if string(item.Value) != "foobarbaz" {
+		t.Fatalf("Unexpected item.Value=[%s]. Expected [foobarbaz]", item.Value)
+	}
+	if item.Flags != 789 {
+		t.Fatalf("Unexpected item.Flags=%d. Expected 789", item.Flags)
+	}
+}
+
+func TestClient_AppendPrepend(t *testing.T) {
+	client_RunTest(cacher_AppendPrepend, t)
+}
+
+func cacher_IncrDecr(c fullCacher, t *testing.T) {
+	key := []byte("key_counter")
+	if _, err := c.Incr(key, 1); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error returned from Cacher.Incr(): [%v]. Expected ErrCacheMiss", err)
+	}
+	if _, err := c.Decr(key, 1); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error returned from Cacher.Decr(): [%v]. Expected ErrCacheMiss", err)
+	}
+
+	item := Item{
+		Key:   key,
+		Value: []byte("10"),
+	}
+	if err := c.Set(&item); err != nil {
+		t.Fatalf("error in Cacher.Set(): [%s]", err)
+	}
+	value, err := c.Incr(key, 5)
+	if err != nil {
+		t.Fatalf("error in Cacher.Incr(): [%s]", err)
+	}
+	if value != 15 {
+		t.Fatalf("Unexpected value=%d returned from Cacher.Incr(). Expected 15", value)
+	}
+	if value, err = c.Decr(key, 3); err != nil {
+		t.Fatalf("error in Cacher.Decr(): [%s]", err)
+	}
+	if value != 12 {
+		t.Fatalf("Unexpected value=%d returned from Cacher.Decr(). Expected 12", value)
+	}
+	if value, err = c.Decr(key, 100); err != nil {
+		t.Fatalf("error in Cacher.Decr(): [%s]", err)
+	}
+	if value != 0 {
+		t.Fatalf("Unexpected value=%d returned from Cacher.Decr(). Expected 0", value)
+	}
+
+	item.Value = nil
+	if err := c.Get(&item); err != nil {
+		t.Fatalf("error in Cacher.Get(): [%s]", err)
+	}
+	if string(item.Value) != "0" {
+		t.Fatalf("Unexpected item.Value=[%s]. Expected [0]", item.Value)
+	}
+
+	item.Value = []byte("foobar")
+	if err := c.Set(&item); err != nil {
+		t.Fatalf("error in Cacher.Set(): [%s]", err)
+	}
+	if _, err := c.Incr(key, 1); err != ErrNotNumeric {
+		t.Fatalf("Unexpected error returned from Cacher.Incr(): [%v]. Expected ErrNotNumeric", err)
+	}
+}
+
+func TestClient_IncrDecr(t *testing.T) {
+	client_RunTest(cacher_IncrDecr, t)
+}
+
+func cacher_Touch(c fullCacher, t *testing.T) {
+	key := []byte("key_touch")
+	if err := c.Touch(key, time.Second); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error returned from Cacher.Touch(): [%v]. Expected ErrCacheMiss", err)
+	}
+
+	item := Item{
+		Key:   key,
+		Value: []byte("value"),
+	}
+	if err := c.Set(&item); err != nil {
+		t.Fatalf("error in Cacher.Set(): [%s]", err)
+	}
+	if err := c.Touch(key, time.Second); err != nil {
+		t.Fatalf("error in Cacher.Touch(): [%s]", err)
+	}
+	time.Sleep(time.Millisecond * 1100)
+	if err := c.Get(&item); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error returned from Cacher.Get(): [%v]. Expected ErrCacheMiss", err)
+	}
+}
+
+func TestClient_Touch(t *testing.T) {
+	client_RunTest(cacher_Touch, t)
+}
+
+func cacher_Gat(c fullCacher, t *testing.T) {
+	item := Item{
+		Key:        []byte("key_gat"),
+		Expiration: time.Second,
+	}
+	if err := c.Gat(&item); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error returned from Cacher.Gat(): [%v]. Expected ErrCacheMiss", err)
+	}
+
+	item.Value = []byte("value")
+	item.Flags = 42
+	item.Expiration = 0
+	if err := c.Set(&item); err != nil {
+		t.Fatalf("error in Cacher.Set(): [%s]", err)
+	}
+	item.Value = nil
+	item.Flags = 0
+	item.Expiration = time.Second
+	if err := c.Gat(&item); err != nil {
+		t.Fatalf("error in Cacher.Gat(): [%s]", err)
+	}
+	if string(item.Value) != "value" {
+		t.Fatalf("Unexpected item.Value=[%s]. Expected [value]", item.Value)
+	}
+	if item.Flags != 42 {
+		t.Fatalf("Unexpected item.Flags=%d. Expected 42", item.Flags)
+	}
+	if item.Casid != 0 {
+		t.Fatalf("Cacher.Gat() mustn't return casid")
+	}
+
+	item.Value = nil
+	item.Flags = 0
+	if err := c.Gats(&item); err != nil {
+		t.Fatalf("error in Cacher.Gats(): [%s]", err)
+	}
+	if string(item.Value) != "value" {
+		t.Fatalf("Unexpected item.Value=[%s]. Expected [value]", item.Value)
+	}
+	if item.Flags != 42 {
+		t.Fatalf("Unexpected item.Flags=%d. Expected 42", item.Flags)
+	}
+	if item.Casid == 0 {
+		t.Fatalf("Cacher.Gats() must return non-zero casid")
+	}
+	time.Sleep(time.Millisecond * 1100)
+	if err := c.Get(&item); err != ErrCacheMiss {
+		t.Fatalf("Unexpected error returned from Cacher.Get(): [%v]. Expected ErrCacheMiss", err)
+	}
+}
+
+func TestClient_Gat(t *testing.T) {
+	client_RunTest(cacher_Gat, t)
+}
+
+func cacher_Verbosity(c fullCacher, t *testing.T) {
+	if err := c.Verbosity(1); err != nil {
+		t.Fatalf("error in Cacher.Verbosity(): [%s]", err)
+	}
+}
+
+func TestClient_Verbosity(t *testing.T) {
+	client_RunTest(cacher_Verbosity, t)
+}
+
+func TestClient_Versions(t *testing.T) {
+	c, s, cache := newClientServerCache(t)
+	defer cache.Close()
+	defer s.Stop()
+	c.Start()
+	defer c.Stop()
+
+	versions, err := c.Versions()
+	if err != nil {
+		t.Fatalf("error in Client.Versions(): [%s]", err)
+	}
+	if len(versions) != 1 {
+		t.Fatalf("Unexpected number of versions=%d. Expected 1", len(versions))
+	}
+	if version := versions[c.ServerAddr]; version != serverVersion {
+		t.Fatalf("Unexpected version=[%s]. Expected [%s]", version, serverVersion)
+	}
+}
+
+func TestServer_TextCommands(t *testing.T) {
+	s, cache := newServerCache(t)
+	defer cache.Close()
+	s.Start()
+	defer s.Stop()
+
+	c := newTextTestConn(t)
+	defer c.Close()
+
+	c.send("set foo 0 0 3\r\nbar\r\nset baz 0 0 1\r\n1\r\n")
+	c.expectResponse("STORED\r\nSTORED\r\n")
+	c.expect("gat 100 foo missing baz\r\n", "VALUE foo 0 3\r\nbar\r\nVALUE baz 0 1\r\n1\r\nEND\r\n")
+	c.send("incr baz 2 noreply\r\ndecr baz 1 noreply\r\nappend foo 0 0 1 noreply\r\nx\r\n" +
+		"replace missing 0 0 1 noreply\r\ny\r\ntouch foo 100 noreply\r\nverbosity 1 noreply\r\nget baz\r\n")
+	c.expectResponse("VALUE baz 0 1\r\n2\r\nEND\r\n")
+	c.expect("incr baz 18446744073709551614\r\n", "0\r\n")
+	c.expect("incr foo 1\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+	c.expect("prepend missing 0 0 1\r\nz\r\n", "NOT_STORED\r\n")
+	c.expect("touch missing 100\r\n", "NOT_FOUND\r\n")
+	c.expect("verbosity 1\r\n", "OK\r\n")
+	c.expect("version\r\n", "VERSION "+serverVersion+"\r\n")
+	c.send("quit\r\n")
+	c.expectClosed()
+}
+
+func TestServer_ConcurrentReplace(t *testing.T) {
+	s, cache := newServerCache(t)
+	defer cache.Close()
+	s.Start()
+	defer s.Stop()
+
+	c := &Client{
+		ServerAddr: testAddr,
+		ClientConfig: ClientConfig{
+			ConnectionsCount: 8,
+		},
+	}
+	c.Start()
+	defer c.Stop()
+
+	key := []byte("key")
+	if err := c.Set(&Item{Key: key, Value: []byte("value")}); err != nil {
+		t.Fatalf("Cannot set item: [%s]", err)
+	}
+
+	// Replace must succeed for existing item despite concurrent writes.
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			item := Item{
+				Key:   key,
+				Value: []byte(fmt.Sprintf("value_%d", i)),
+			}
+			for j := 0; j < 1000; j++ {
+				var err error
+				if i%2 == 0 {
+					err = c.Set(&item)
+				} else {
+					err = c.Replace(&item)
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Unexpected error: [%s]", err)
+	}
+}
+
+func TestServer_AppendHugeSize(t *testing.T) {
+	s, cache := newServerCache(t)
+	defer cache.Close()
+	s.Start()
+	defer s.Stop()
+
+	for _, request := range []string{
+		"append key 0 0 -1\r\n",
+		"prepend key 0 0 2000000000\r\nfoo",
+	} {
+		c := newTextTestConn(t)
+		c.send(request)
+		c.conn.(*net.TCPConn).CloseWrite()
+		c.expectClosed()
+		c.Close()
+	}
+
+	// The server must survive invalid requests.
+	c := newTextTestConn(t)
+	defer c.Close()
+	c.expect("set key 0 0 3\r\nfoo\r\n", "STORED\r\n")
+	c.expect("append key 0 0 3\r\nbar\r\n", "STORED\r\n")
+	c.expect("get key\r\n", "VALUE key 0 6\r\nfoobar\r\nEND\r\n")
+
+	// Payloads bigger than a single chunk must be read in full.
+	value := strings.Repeat("x", payloadChunkSize*2+123)
+	c.expect(fmt.Sprintf("append key 0 0 %d\r\n%s\r\n", len(value), value), "STORED\r\n")
+	c.expect("get key\r\n", fmt.Sprintf("VALUE key 0 %d\r\nfoobar%s\r\nEND\r\n", len(value)+6, value))
+}
+
+func (c *textTestConn) stats(group string) map[string]string {
+	c.send("stats" + group + "\r\n")
+	stats := make(map[string]string)
+	for {
+		line := strings.TrimRight(c.readLine(), "\r\n")
+		if line == "END" {
+			return stats
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 || fields[0] != "STAT" {
+			c.t.Fatalf("Unexpected stats line=[%q]", line)
+		}
+		stats[fields[1]] = fields[2]
+	}
+}
+
+func TestServer_Stats(t *testing.T) {
+	s, cache := newServerCache(t)
+	defer cache.Close()
+	s.Start()
+	defer s.Stop()
+
+	c := newTextTestConn(t)
+	defer c.Close()
+
+	c.expect("set foo 0 0 3\r\nbar\r\n", "STORED\r\n")
+	c.expect("add foo 0 0 3\r\nbar\r\n", "NOT_STORED\r\n")
+	c.expect("get foo missing\r\n", "VALUE foo 0 3\r\nbar\r\nEND\r\n")
+	c.expect("mg foo\r\n", "HD\r\n")
+
+	stats := c.stats("")
+	expected := map[string]string{
+		"version":           serverVersion,
+		"curr_connections":  "1",
+		"total_connections": "1",
+		"cmd_get":           "3",
+		"get_hits":          "2",
+		"get_misses":        "1",
+		"cmd_set":           "2",
+		"evictions":         "0",
+	}
+	for name, value := range expected {
+		if stats[name] != value {
+			t.Fatalf("Unexpected value=[%s] for stat [%s]. Expected [%s]", stats[name], name, value)
+		}
+	}
+	for _, name := range []string{"bytes_read", "bytes_written"} {
+		if stats[name] == "0" || stats[name] == "" {
+			t.Fatalf("Unexpected value=[%s] for stat [%s]. Expected non-zero value", stats[name], name)
+		}
+	}
+
+	settings := c.stats(" settings")
+	if settings["listen_addr"] != testAddr {
+		t.Fatalf("Unexpected listen_addr=[%s]. Expected [%s]", settings["listen_addr"], testAddr)
+	}
+
+	c.expect("stats items\r\n", "END\r\n")
+	c.expect("stats slabs\r\n", "END\r\n")
+
+	// Connection counters must be updated after the connection is closed.
+	c2 := newTextTestConn(t)
+	c2.expect("version\r\n", "VERSION "+serverVersion+"\r\n")
+	c2.Close()
+	for i := 0; i < 100; i++ {
+		stats = c.stats("")
+		if stats["curr_connections"] == "1" {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if stats["curr_connections"] != "1" || stats["total_connections"] != "2" {
+		t.Fatalf("Unexpected curr_connections=[%s], total_connections=[%s]. Expected [1], [2]",
+			stats["curr_connections"], stats["total_connections"])
+	}
+
+	c.send("stats unknown\r\n")
+	c.expectClosed()
+}
+
+func cacher_GetDe(c fullCacher, t *testing.T) {
 	item := Item{
 		Key: []byte("key"),
 	}
@@ -363,7 +849,57 @@ func TestClient_GetDe(t *testing.T) {
 	client_RunTest(cacher_GetDe, t)
 }
 
-func cacher_Cget(c Cacher, t *testing.T) {
+func expectCtxError(t *testing.T, expectedErr error, f func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	startTime := time.Now()
+	if err := f(ctx); err != expectedErr {
+		t.Fatalf("Unexpected err=[%v]. Expected [%s]", err, expectedErr)
+	}
+	if d := time.Since(startTime); d > time.Second {
+		t.Fatalf("Too long waiting for the context deadline: %s", d)
+	}
+}
+
+func cacher_GetDeCtx(c fullCacher, t *testing.T) {
+	item := Item{
+		Key: []byte("key"),
+	}
+	grace := time.Hour
+	if err := c.GetDeCtx(context.Background(), &item, grace); err != ErrCacheMiss {
+		t.Fatalf("Unexpected err=[%s] for client.GetDeCtx(key=%s, grace=%s)", err, item.Key, grace)
+	}
+
+	// The item is pending for an hour, so waiting for it must be
+	// interrupted by the context.
+	expectCtxError(t, context.DeadlineExceeded, func(ctx context.Context) error {
+		return c.GetDeCtx(ctx, &item, grace)
+	})
+	expectCtxError(t, context.DeadlineExceeded, func(ctx context.Context) error {
+		return c.CgetDeCtx(ctx, &item, grace)
+	})
+
+	item.Value = []byte("value")
+	if err := c.Set(&item); err != nil {
+		t.Fatalf("Cannot set value=[%s] for key=[%s]: [%s]", item.Value, item.Key, err)
+	}
+	item.Value = nil
+	if err := c.GetDeCtx(context.Background(), &item, grace); err != nil {
+		t.Fatalf("Cannot obtain value for key=[%s]: [%s]", item.Key, err)
+	}
+	if !bytes.Equal(item.Value, []byte("value")) {
+		t.Fatalf("Unexpected value obtained: [%s]. Expected [%s]", item.Value, "value")
+	}
+	if err := c.CgetDeCtx(context.Background(), &item, grace); err != ErrNotModified {
+		t.Fatalf("Unexpected err=[%v] for client.CgetDeCtx(key=[%s]). Expected ErrNotModified", err, item.Key)
+	}
+}
+
+func TestClient_GetDeCtx(t *testing.T) {
+	client_RunTest(cacher_GetDeCtx, t)
+}
+
+func cacher_Cget(c fullCacher, t *testing.T) {
 	key := []byte("key")
 	value := []byte("value")
 	expiration := time.Hour * 123343
@@ -411,7 +947,7 @@ func TestClient_Cget(t *testing.T) {
 	client_RunTest(cacher_Cget, t)
 }
 
-func cacher_CgetDe(c Cacher, t *testing.T) {
+func cacher_CgetDe(c fullCacher, t *testing.T) {
 	item := Item{
 		Key: []byte("key"),
 	}
@@ -485,7 +1021,7 @@ func checkItems(c Cacher, orig_items []Item, t *testing.T) {
 	}
 }
 
-func cacher_GetMulti_EmptyItems(c Cacher, t *testing.T) {
+func cacher_GetMulti_EmptyItems(c fullCacher, t *testing.T) {
 	if err := c.GetMulti([]Item{}); err != nil {
 		t.Fatalf("Unexpected error in client.GetMulti(): [%s]", err)
 	}
@@ -495,7 +1031,7 @@ func TestClient_GetMulti_EmptyItems(t *testing.T) {
 	client_RunTest(cacher_GetMulti_EmptyItems, t)
 }
 
-func cacher_GetMulti(c Cacher, t *testing.T) {
+func cacher_GetMulti(c fullCacher, t *testing.T) {
 	itemsCount := 100
 	items := make([]Item, itemsCount)
 	for i := 0; i < itemsCount; i++ {
@@ -514,7 +1050,7 @@ func TestClient_GetMulti(t *testing.T) {
 	client_RunTest(cacher_GetMulti, t)
 }
 
-func cacher_SetNowait(c Cacher, t *testing.T) {
+func cacher_SetNowait(c fullCacher, t *testing.T) {
 	itemsCount := 100
 	items := make([]Item, itemsCount)
 	for i := 0; i < itemsCount; i++ {
@@ -531,7 +1067,7 @@ func TestClient_SetNowait(t *testing.T) {
 	client_RunTest(cacher_SetNowait, t)
 }
 
-func cacher_Delete(c Cacher, t *testing.T) {
+func cacher_Delete(c fullCacher, t *testing.T) {
 	itemsCount := 100
 	var item Item
 	for i := 0; i < itemsCount; i++ {
@@ -556,7 +1092,7 @@ func TestClient_Delete(t *testing.T) {
 	client_RunTest(cacher_Delete, t)
 }
 
-func cacher_DeleteNowait(c Cacher, t *testing.T) {
+func cacher_DeleteNowait(c fullCacher, t *testing.T) {
 	itemsCount := 100
 	var item Item
 	for i := 0; i < itemsCount; i++ {
@@ -582,7 +1118,7 @@ func TestClient_DeleteNowait(t *testing.T) {
 	client_RunTest(cacher_DeleteNowait, t)
 }
 
-func cacher_FlushAll(c Cacher, t *testing.T) {
+func cacher_FlushAll(c fullCacher, t *testing.T) {
 	itemsCount := 100
 	var item Item
 	for i := 0; i < itemsCount; i++ {
@@ -606,7 +1142,7 @@ func TestClient_FlushAll(t *testing.T) {
 	client_RunTest(cacher_FlushAll, t)
 }
 
-func cacher_FlushAllDelayed(c Cacher, t *testing.T) {
+func cacher_FlushAllDelayed(c fullCacher, t *testing.T) {
 	itemsCount := 100
 	var item Item
 	for i := 0; i < itemsCount; i++ {
@@ -677,7 +1213,7 @@ func checkMalformedKey(c Cacher, key []byte, t *testing.T) {
 	}
 }
 
-func cacher_MalformedKey(c Cacher, t *testing.T) {
+func cacher_MalformedKey(c fullCacher, t *testing.T) {
 	checkMalformedKey(c, nil, t)
 	checkMalformedKey(c, []byte{}, t)
 	checkMalformedKey(c, []byte("malformed key with spaces"), t)
@@ -688,7 +1224,7 @@ func TestClient_MalformedKey(t *testing.T) {
 	client_RunTest(cacher_MalformedKey, t)
 }
 
-func cacher_NilValue(c Cacher, t *testing.T) {
+func cacher_NilValue(c fullCacher, t *testing.T) {
 	item := Item{
 		Key: []byte("test"),
 	}
@@ -701,7 +1237,7 @@ func TestClient_NilValue(t *testing.T) {
 	client_RunTest(cacher_NilValue, t)
 }
 
-func cacher_EmptyValue(c Cacher, t *testing.T) {
+func cacher_EmptyValue(c fullCacher, t *testing.T) {
 	flags := uint32(89832)
 	item := Item{
 		Key:   []byte("test"),
@@ -727,7 +1263,7 @@ func TestClient_EmptyValue(t *testing.T) {
 	client_RunTest(cacher_EmptyValue, t)
 }
 
-func cacher_NotStartedNoStop(c Cacher, t *testing.T) {
+func cacher_NotStartedNoStop(c fullCacher, t *testing.T) {
 	item := Item{
 		Key:   []byte("key"),
 		Value: []byte("value"),
@@ -767,7 +1303,7 @@ func cacher_NotStartedNoStop(c Cacher, t *testing.T) {
 	}
 }
 
-func cacher_NotStarted(c Cacher, t *testing.T) {
+func cacher_NotStarted(c fullCacher, t *testing.T) {
 	c.Stop()
 	defer c.Start()
 	cacher_NotStartedNoStop(c, t)
@@ -782,7 +1318,7 @@ func TestClient_NotStarted(t *testing.T) {
 	cacher_NotStartedNoStop(c, t)
 }
 
-func cacher_DoubleStartDoubleStop(c Cacher, t *testing.T) {
+func cacher_DoubleStartDoubleStop(c fullCacher, t *testing.T) {
 	expectPanic(t, func() { c.Start() })
 
 	c.Stop()
@@ -973,11 +1509,70 @@ func TestDistributedClient_Cas(t *testing.T) {
 	distributedClientStatic_RunTest(cacher_Cas, t)
 }
 
+func TestDistributedClient_Replace(t *testing.T) {
+	distributedClient_RunTest(cacher_Replace, t)
+	distributedClientStatic_RunTest(cacher_Replace, t)
+}
+
+func TestDistributedClient_AppendPrepend(t *testing.T) {
+	distributedClient_RunTest(cacher_AppendPrepend, t)
+	distributedClientStatic_RunTest(cacher_AppendPrepend, t)
+}
+
+func TestDistributedClient_IncrDecr(t *testing.T) {
+	distributedClient_RunTest(cacher_IncrDecr, t)
+	distributedClientStatic_RunTest(cacher_IncrDecr, t)
+}
+
+func TestDistributedClient_Touch(t *testing.T) {
+	distributedClient_RunTest(cacher_Touch, t)
+	distributedClientStatic_RunTest(cacher_Touch, t)
+}
+
+func TestDistributedClient_Gat(t *testing.T) {
+	distributedClient_RunTest(cacher_Gat, t)
+	distributedClientStatic_RunTest(cacher_Gat, t)
+}
+
+func TestDistributedClient_Verbosity(t *testing.T) {
+	distributedClient_RunTest(cacher_Verbosity, t)
+	distributedClientStatic_RunTest(cacher_Verbosity, t)
+}
+
+func TestDistributedClient_Versions(t *testing.T) {
+	c, ss, caches := newDistributedClientServersCaches(t)
+	defer closeCaches(caches)
+	defer stopServers(ss)
+	c.Start()
+	defer c.Stop()
+	for _, s := range ss {
+		c.AddServer(s.ListenAddr)
+	}
+
+	versions, err := c.Versions()
+	if err != nil {
+		t.Fatalf("error in DistributedClient.Versions(): [%s]", err)
+	}
+	if len(versions) != len(ss) {
+		t.Fatalf("Unexpected number of versions=%d. Expected %d", len(versions), len(ss))
+	}
+	for addr, version := range versions {
+		if version != serverVersion {
+			t.Fatalf("Unexpected version=[%s] for server [%s]. Expected [%s]", version, addr, serverVersion)
+		}
+	}
+}
+
 func TestDistributedClient_GetDe(t *testing.T) {
 	distributedClient_RunTest(cacher_GetDe, t)
 	distributedClientStatic_RunTest(cacher_GetDe, t)
 }
 
+func TestDistributedClient_GetDeCtx(t *testing.T) {
+	distributedClient_RunTest(cacher_GetDeCtx, t)
+	distributedClientStatic_RunTest(cacher_GetDeCtx, t)
+}
+
 func TestDistributedClient_Cget(t *testing.T) {
 	distributedClient_RunTest(cacher_Cget, t)
 	distributedClientStatic_RunTest(cacher_Cget, t)
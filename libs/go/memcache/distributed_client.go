@@ -1,6 +1,7 @@
 package memcache
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"time"
@@ -325,6 +326,18 @@ func (c *DistributedClient) GetDe(item *Item, graceDuration time.Duration) (err
 	return client.GetDe(item, graceDuration)
 }
 
+// See Client.GetDeCtx().
+func (c *DistributedClient) GetDeCtx(ctx context.Context, item *Item, graceDuration time.Duration) (err error) {
+	client, err := c.client(item.Key)
+	if err != nil {
+		return
+	}
+	if c.isDynamic {
+		defer handleRaceCondition(&err)
+	}
+	return client.GetDeCtx(ctx, item, graceDuration)
+}
+
 // See Client.CgetDe()
 func (c *DistributedClient) CgetDe(item *Item, graceDuration time.Duration) (err error) {
 	client, err := c.client(item.Key)
@@ -337,6 +350,18 @@ func (c *DistributedClient) CgetDe(item *Item, graceDuration time.Duration) (err
 	return client.CgetDe(item, graceDuration)
 }
 
+// See Client.CgetDeCtx()
+func (c *DistributedClient) CgetDeCtx(ctx context.Context, item *Item, graceDuration time.Duration) (err error) {
+	client, err := c.client(item.Key)
+	if err != nil {
+		return
+	}
+	if c.isDynamic {
+		defer handleRaceCondition(&err)
+	}
+	return client.CgetDeCtx(ctx, item, graceDuration)
+}
+
 // See Client.Set().
 func (c *DistributedClient) Set(item *Item) (err error) {
 	client, err := c.client(item.Key)
@@ -373,6 +398,102 @@ func (c *DistributedClient) Cas(item *Item) (err error) {
 	return client.Cas(item)
 }
 
+// See Client.Replace().
+func (c *DistributedClient) Replace(item *Item) (err error) {
+	client, err := c.client(item.Key)
+	if err != nil {
+		return
+	}
+	if c.isDynamic {
+		defer handleRaceCondition(&err)
+	}
+	return client.Replace(item)
+}
+
+// See Client.Append().
+func (c *DistributedClient) Append(item *Item) (err error) {
+	client, err := c.client(item.Key)
+	if err != nil {
+		return
+	}
+	if c.isDynamic {
+		defer handleRaceCondition(&err)
+	}
+	return client.Append(item)
+}
+
+// See Client.Prepend().
+func (c *DistributedClient) Prepend(item *Item) (err error) {
+	client, err := c.client(item.Key)
+	if err != nil {
+		return
+	}
+	if c.isDynamic {
+		defer handleRaceCondition(&err)
+	}
+	return client.Prepend(item)
+}
+
+// See Client.Incr().
+func (c *DistributedClient) Incr(key []byte, delta uint64) (value uint64, err error) {
+	client, err := c.client(key)
+	if err != nil {
+		return
+	}
+	if c.isDynamic {
+		defer handleRaceCondition(&err)
+	}
+	return client.Incr(key, delta)
+}
+
+// See Client.Decr().
+func (c *DistributedClient) Decr(key []byte, delta uint64) (value uint64, err error) {
+	client, err := c.client(key)
+	if err != nil {
+		return
+	}
+	if c.isDynamic {
+		defer handleRaceCondition(&err)
+	}
+	return client.Decr(key, delta)
+}
+
+// See Client.Touch().
+func (c *DistributedClient) Touch(key []byte, expiration time.Duration) (err error) {
+	client, err := c.client(key)
+	if err != nil {
+		return
+	}
+	if c.isDynamic {
+		defer handleRaceCondition(&err)
+	}
+	return client.Touch(key, expiration)
+}
+
+// See Client.Gat().
+func (c *DistributedClient) Gat(item *Item) (err error) {
+	client, err := c.client(item.Key)
+	if err != nil {
+		return
+	}
+	if c.isDynamic {
+		defer handleRaceCondition(&err)
+	}
+	return client.Gat(item)
+}
+
+// See Client.Gats().
+func (c *DistributedClient) Gats(item *Item) (err error) {
+	client, err := c.client(item.Key)
+	if err != nil {
+		return
+	}
+	if c.isDynamic {
+		defer handleRaceCondition(&err)
+	}
+	return client.Gats(item)
+}
+
 // See Client.SetNowait().
 func (c *DistributedClient) SetNowait(item *Item) {
 	client, err := c.client(item.Key)
@@ -489,3 +610,43 @@ func (c *DistributedClient) FlushAllNowait() {
 		client.FlushAllNowait()
 	}
 }
+
+// See Client.Verbosity().
+func (c *DistributedClient) Verbosity(level int) (err error) {
+	clients, err := c.allClients()
+	if err != nil {
+		return
+	}
+	if c.isDynamic {
+		defer handleRaceCondition(&err)
+	}
+	for _, client := range clients {
+		if err = client.Verbosity(level); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Returns versions of all the servers keyed by server address.
+//
+// See Client.Versions().
+func (c *DistributedClient) Versions() (versions map[string]string, err error) {
+	clients, err := c.allClients()
+	if err != nil {
+		return
+	}
+	if c.isDynamic {
+		defer handleRaceCondition(&err)
+	}
+	m := make(map[string]string, len(clients))
+	for _, client := range clients {
+		var v map[string]string
+		if v, err = client.Versions(); err != nil {
+			return
+		}
+		m[client.ServerAddr] = v[client.ServerAddr]
+	}
+	versions = m
+	return
+}
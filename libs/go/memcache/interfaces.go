@@ -1,6 +1,7 @@
 package memcache
 
 import (
+	"context"
 	"time"
 )
 
@@ -35,6 +36,12 @@ type Ccacher interface {
 	CgetDe(item *Item, graceDuration time.Duration) error
 }
 
+// Client and DistributedClient implement this interface.
+type CcacherCtx interface {
+	GetDeCtx(ctx context.Context, item *Item, graceDuration time.Duration) error
+	CgetDeCtx(ctx context.Context, item *Item, graceDuration time.Duration) error
+}
+
 // Client and DistributedClient implement this interface.
 type Cacher interface {
 	Ccacher
@@ -42,3 +49,17 @@ type Cacher interface {
 	Start()
 	Stop()
 }
+
+// Client and DistributedClient implement this interface.
+type MemcacherExt interface {
+	Replace(item *Item) error
+	Append(item *Item) error
+	Prepend(item *Item) error
+	Incr(key []byte, delta uint64) (value uint64, err error)
+	Decr(key []byte, delta uint64) (value uint64, err error)
+	Touch(key []byte, expiration time.Duration) error
+	Gat(item *Item) error
+	Gats(item *Item) error
+	Verbosity(level int) error
+	Versions() (versions map[string]string, err error)
+}
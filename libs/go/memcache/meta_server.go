@@ -0,0 +1,628 @@
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"github.com/valyala/ybc/bindings/go/ybc"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Server-side implementation of memcache meta commands.
+//
+// See https://github.com/memcached/memcached/wiki/MetaCommands
+// for commands description.
+//
+// Meta commands' flags related to recaching are mapped onto ybc dogpile
+// effect handling:
+//   * mg with N(ttl) flag on cache miss - the first client wins the right
+//     to create the item (W flag), while others obtain empty value
+//     with Z flag during ttl. See ybc.Cacher.GetDeAsyncItem().
+//   * mg with R(ttl) flag - the first client obtaining the item with
+//     remaining ttl less than the given ttl wins the right to recache
+//     the item (W flag), while others obtain Z flag.
+//   * stale items - see Server.StaleDuration.
+
+// A flag of meta command - a single char optionally followed by a token.
+type metaFlag struct {
+	name  byte
+	token []byte
+}
+
+// Item's attributes returned by meta commands via flags.
+type metaItemAttrs struct {
+	casid uint64
+	flags uint32
+	size  int
+	ttl   time.Duration
+}
+
+func parseMetaFlags(line []byte, n int) (flags []metaFlag, ok bool) {
+	for n < len(line) {
+		s := nextToken(line, &n, "flag")
+		if s == nil {
+			return
+		}
+		flags = append(flags, metaFlag{
+			name:  s[0],
+			token: s[1:],
+		})
+	}
+	ok = true
+	return
+}
+
+func writeMetaClientError(w *bufio.Writer, msg string) bool {
+	return writeStr(w, strClientError) && writeStr(w, []byte(msg)) && writeCrLf(w)
+}
+
+// Checks whether all the flags are allowed. Flags from tokenFlags
+// require tokens.
+//
+// Writes CLIENT_ERROR response for invalid flags.
+func checkMetaFlags(w *bufio.Writer, flags []metaFlag, allowedFlags, tokenFlags string) bool {
+	for _, f := range flags {
+		if bytes.IndexByte([]byte(allowedFlags), f.name) < 0 {
+			log.Printf("Unsupported meta flag=[%c]", f.name)
+			writeMetaClientError(w, "invalid flag")
+			return false
+		}
+		if bytes.IndexByte([]byte(tokenFlags), f.name) >= 0 && len(f.token) == 0 {
+			log.Printf("Missing token for meta flag=[%c]", f.name)
+			writeMetaClientError(w, "bad token in command line format")
+			return false
+		}
+	}
+	return true
+}
+
+func findMetaFlag(flags []metaFlag, name byte) (f *metaFlag) {
+	for i := range flags {
+		if flags[i].name == name {
+			return &flags[i]
+		}
+	}
+	return nil
+}
+
+func hasMetaFlag(flags []metaFlag, name byte) bool {
+	return findMetaFlag(flags, name) != nil
+}
+
+// Parses the token of the given flag as memcache expiration time.
+func parseMetaExpiration(flags []metaFlag, name byte) (expiration time.Duration, found, ok bool) {
+	f := findMetaFlag(flags, name)
+	if f == nil {
+		ok = true
+		return
+	}
+	found = true
+	expiration, ok = parseExpiration(f.token)
+	return
+}
+
+func parseMetaUint64(flags []metaFlag, name byte, defaultValue uint64) (n uint64, ok bool) {
+	f := findMetaFlag(flags, name)
+	if f == nil {
+		return defaultValue, true
+	}
+	return parseUint64(f.token)
+}
+
+// Decodes the key if the b flag is set.
+func decodeMetaKey(key []byte, flags []metaFlag) (decodedKey []byte, ok bool) {
+	if !hasMetaFlag(flags, 'b') {
+		return key, true
+	}
+	decodedKey = make([]byte, base64.StdEncoding.DecodedLen(len(key)))
+	n, err := base64.StdEncoding.Decode(decodedKey, key)
+	if err != nil || n == 0 {
+		log.Printf("Cannot decode base64 key=[%s]: [%v]", key, err)
+		return nil, false
+	}
+	return decodedKey[:n], true
+}
+
+// Converts ttl to meta commands format, where -1 means 'no expiration'.
+func metaTtlSeconds(ttl time.Duration) int64 {
+	if ttl > time.Second*maxExpirationSeconds {
+		return -1
+	}
+	return int64(ttl / time.Second)
+}
+
+// Appends return flags requested by flags to dst.
+//
+// Only b, k and O flags are returned if attrs is nil.
+func appendMetaRetFlags(dst []byte, flags []metaFlag, key []byte, attrs *metaItemAttrs) []byte {
+	for _, f := range flags {
+		switch f.name {
+		case 'b':
+			dst = append(dst, " b"...)
+		case 'k':
+			dst = append(dst, " k"...)
+			dst = append(dst, key...)
+		case 'O':
+			dst = append(dst, " O"...)
+			dst = append(dst, f.token...)
+		}
+		if attrs == nil {
+			continue
+		}
+		switch f.name {
+		case 'c':
+			dst = append(dst, " c"...)
+			dst = strconv.AppendUint(dst, attrs.casid, 10)
+		case 'f':
+			dst = append(dst, " f"...)
+			dst = strconv.AppendUint(dst, uint64(attrs.flags), 10)
+		case 's':
+			dst = append(dst, " s"...)
+			dst = strconv.AppendInt(dst, int64(attrs.size), 10)
+		case 't':
+			dst = append(dst, " t"...)
+			dst = strconv.AppendInt(dst, metaTtlSeconds(attrs.ttl), 10)
+		}
+	}
+	return dst
+}
+
+func writeMetaResponse(w *bufio.Writer, status []byte, flags []metaFlag, key []byte, attrs *metaItemAttrs) bool {
+	var buf [128]byte
+	b := append(buf[:0], status...)
+	b = appendMetaRetFlags(b, flags, key, attrs)
+	b = append(b, strCrLf...)
+	return writeStr(w, b)
+}
+
+/*******************************************************************************
+ * mg <key> <flags>*
+ ******************************************************************************/
+
+// Recache flags returned by mg.
+type metaRecacheFlags struct {
+	won       bool // W
+	stale     bool // X
+	tokenSent bool // Z
+}
+
+func (rf *metaRecacheFlags) appendTo(dst []byte) []byte {
+	if rf.won {
+		dst = append(dst, " W"...)
+	}
+	if rf.stale {
+		dst = append(dst, " X"...)
+	}
+	if rf.tokenSent {
+		dst = append(dst, " Z"...)
+	}
+	return dst
+}
+
+func writeMetaGetResponse(w *bufio.Writer, flags []metaFlag, key []byte, item *ybc.Item, attrs *metaItemAttrs, rf *metaRecacheFlags) bool {
+	shouldWriteValue := hasMetaFlag(flags, 'v')
+	var buf [128]byte
+	b := buf[:0]
+	if shouldWriteValue {
+		b = append(b, strMetaValue...)
+		b = strconv.AppendInt(b, int64(attrs.size), 10)
+	} else {
+		b = append(b, strMetaHit...)
+	}
+	b = appendMetaRetFlags(b, flags, key, attrs)
+	b = rf.appendTo(b)
+	b = append(b, strCrLf...)
+	if !writeStr(w, b) {
+		return false
+	}
+	if !shouldWriteValue {
+		return true
+	}
+	if item == nil {
+		return writeCrLf(w)
+	}
+	return writeItem(w, item, attrs.size)
+}
+
+// Obtains the item for mg command.
+//
+// Returns nil item without error if the client won the right to create
+// the missing item or if other client already won it.
+func metaGetItem(s *Server, key []byte, flags []metaFlag, rf *metaRecacheFlags) (item *ybc.Item, err error) {
+	cache := s.cache
+	if s.StaleDuration > 0 {
+		var isStale bool
+		item, isStale, err = cache.GetStaleItem(key, s.StaleDuration)
+		if err == nil && isStale {
+			rf.stale = true
+			rf.won = item.ShouldRefresh()
+			rf.tokenSent = !rf.won
+			return
+		}
+	} else {
+		item, err = cache.GetItem(key)
+	}
+
+	if err == ybc.ErrCacheMiss {
+		vivifyTtl, found, _ := parseMetaExpiration(flags, 'N')
+		if !found {
+			return
+		}
+		item, err = cache.GetDeAsyncItem(key, vivifyTtl)
+		switch err {
+		case ybc.ErrCacheMiss:
+			rf.won = true
+			return nil, nil
+		case ybc.ErrWouldBlock:
+			rf.tokenSent = true
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	recacheTtl, found, _ := parseMetaExpiration(flags, 'R')
+	if found && item.Ttl() < recacheTtl {
+		recacheItem, err := cache.GetDeAsyncItem(key, recacheTtl)
+		switch err {
+		case ybc.ErrCacheMiss:
+			rf.won = true
+		case nil:
+			recacheItem.Close()
+			rf.tokenSent = true
+		}
+	}
+	return item, nil
+}
+
+func processMetaGetCmd(c *bufio.ReadWriter, s *Server, line []byte) bool {
+	n := -1
+	encodedKey := nextToken(line, &n, "key")
+	if encodedKey == nil {
+		return false
+	}
+	flags, ok := parseMetaFlags(line, n)
+	if !ok {
+		return false
+	}
+	if !checkMetaFlags(c.Writer, flags, "bcfkOqstuvNRT", "ONRT") {
+		return false
+	}
+	for _, name := range []byte("NRT") {
+		if _, _, ok = parseMetaExpiration(flags, name); !ok {
+			return false
+		}
+	}
+	key, ok := decodeMetaKey(encodedKey, flags)
+	if !ok {
+		return false
+	}
+
+	var rf metaRecacheFlags
+	item, err := metaGetItem(s, key, flags, &rf)
+	err = corruptedToCacheMiss(err, key)
+	if err == ybc.ErrCacheMiss {
+		s.counters.countGet(false)
+		if hasMetaFlag(flags, 'q') {
+			return true
+		}
+		return writeStr(c.Writer, strMetaMissCrLf)
+	}
+	if err != nil {
+		log.Fatalf("Unexpected error returned when obtaining item for key=[%s]: [%s]", key, err)
+	}
+
+	var attrs metaItemAttrs
+	if item == nil {
+		// Empty placeholder for the missing item, which is going to be created.
+		s.counters.countGet(false)
+		attrs.ttl, _, _ = parseMetaExpiration(flags, 'N')
+		return writeMetaGetResponse(c.Writer, flags, encodedKey, nil, &attrs, &rf)
+	}
+	// do not use defer item.Close() for performance reasons
+
+	s.counters.countGet(true)
+	if attrs.casid, attrs.flags, ok = readItemCasidFlags(item); !ok {
+		item.Close()
+		return false
+	}
+	attrs.size = item.Available()
+	attrs.ttl = item.Ttl()
+	if ttl, found, _ := parseMetaExpiration(flags, 'T'); found {
+		if err = s.cache.Touch(key, ttl); err == nil {
+			attrs.ttl = ttl
+		}
+	}
+	ok = writeMetaGetResponse(c.Writer, flags, encodedKey, item, &attrs, &rf)
+	item.Close()
+	return ok
+}
+
+/*******************************************************************************
+ * ms <key> <datalen> <flags>*
+ ******************************************************************************/
+
+// Storage modes for ms command's M flag.
+var metaStoreModes = map[byte]int{
+	'S': storeModeSet,
+	's': storeModeSet,
+	'E': storeModeAdd,
+	'e': storeModeAdd,
+	'R': storeModeReplace,
+	'r': storeModeReplace,
+}
+
+func writeMetaStoreResponse(w *bufio.Writer, err error, flags []metaFlag, key []byte, casid uint64) bool {
+	switch err {
+	case nil:
+		if hasMetaFlag(flags, 'q') {
+			return true
+		}
+		return writeMetaResponse(w, strMetaHit, flags, key, &metaItemAttrs{casid: casid})
+	case ybc.ErrAlreadyExists, errNotStored:
+		return writeMetaResponse(w, strMetaNotStored, flags, key, nil)
+	case ybc.ErrCacheMiss:
+		return writeMetaResponse(w, strMetaNotFound, flags, key, nil)
+	case errCasidMismatch:
+		return writeMetaResponse(w, strMetaExists, flags, key, nil)
+	}
+	log.Printf("Cannot store item with key=[%s]: [%s]", key, err)
+	return writeMetaResponse(w, strMetaNotStored, flags, key, nil)
+}
+
+func processMetaSetCmd(c *bufio.ReadWriter, s *Server, line []byte) bool {
+	n := -1
+	encodedKey := nextToken(line, &n, "key")
+	if encodedKey == nil {
+		return false
+	}
+	size, ok := parseSizeToken(line, &n)
+	if !ok {
+		return false
+	}
+	flags, ok := parseMetaFlags(line, n)
+	if !ok {
+		return false
+	}
+	if !checkMetaFlags(c.Writer, flags, "bcCEFkOqTM", "CEFOTM") {
+		return false
+	}
+	key, ok := decodeMetaKey(encodedKey, flags)
+	if !ok {
+		return false
+	}
+	casid, ok := parseMetaUint64(flags, 'C', 0)
+	if !ok {
+		return false
+	}
+	newCasid, ok := parseMetaUint64(flags, 'E', 0)
+	if !ok {
+		return false
+	}
+	if newCasid == 0 {
+		newCasid = getCasid()
+	}
+	itemFlags, ok := parseMetaUint64(flags, 'F', 0)
+	if !ok || itemFlags >= (uint64(1)<<32) {
+		return false
+	}
+	expiration, found, ok := parseMetaExpiration(flags, 'T')
+	if !ok {
+		return false
+	}
+	if !found {
+		expiration = maxExpiration
+	}
+
+	mode := byte('S')
+	if f := findMetaFlag(flags, 'M'); f != nil {
+		mode = f.token[0]
+	}
+	if mode == 'A' || mode == 'a' || mode == 'P' || mode == 'p' {
+		data, ok := readPayload(c.Reader, size)
+		if !ok {
+			return false
+		}
+		isPrepend := (mode == 'P' || mode == 'p')
+		err := appendToItem(s.cache, key, data, isPrepend, casid, newCasid)
+		if err == ybc.ErrCacheMiss && casid == 0 {
+			err = errNotStored
+		}
+		return writeMetaStoreResponse(c.Writer, err, flags, encodedKey, newCasid)
+	}
+	storeMode, ok := metaStoreModes[mode]
+	if !ok {
+		log.Printf("Unsupported mode=[%c] for ms command", mode)
+		writeMetaClientError(c.Writer, "invalid mode for ms")
+		return false
+	}
+	if storeMode == storeModeReplace && casid == 0 {
+		value := appendItemMetadata(nil, newCasid, uint32(itemFlags))
+		if value, ok = appendPayload(value, c.Reader, size); !ok {
+			return false
+		}
+		if !matchCrLf(c.Reader) {
+			return false
+		}
+		err := replaceItem(s.cache, key, value, expiration)
+		if err == ybc.ErrCacheMiss {
+			err = errNotStored
+		}
+		return writeMetaStoreResponse(c.Writer, err, flags, encodedKey, newCasid)
+	}
+
+	txn := startSetTxnWithCasid(s.cache, key, newCasid, uint32(itemFlags), expiration, size)
+	if txn == nil {
+		if !discardPayload(c.Reader, size) {
+			return false
+		}
+		return writeStr(c.Writer, strTooLargeCrLf)
+	}
+	if !readValueToTxn(c.Reader, txn, size) {
+		txn.Rollback()
+		return false
+	}
+	err := commitStoreTxn(s.cache, txn, key, storeMode, casid)
+	if err == ybc.ErrCacheMiss && casid == 0 {
+		// Replacing missing item.
+		err = errNotStored
+	}
+	return writeMetaStoreResponse(c.Writer, err, flags, encodedKey, newCasid)
+}
+
+/*******************************************************************************
+ * md <key> <flags>*
+ ******************************************************************************/
+
+func processMetaDeleteCmd(c *bufio.ReadWriter, s *Server, line []byte) bool {
+	n := -1
+	encodedKey := nextToken(line, &n, "key")
+	if encodedKey == nil {
+		return false
+	}
+	flags, ok := parseMetaFlags(line, n)
+	if !ok {
+		return false
+	}
+	if !checkMetaFlags(c.Writer, flags, "bCIkOq", "CO") {
+		return false
+	}
+	key, ok := decodeMetaKey(encodedKey, flags)
+	if !ok {
+		return false
+	}
+	casid, ok := parseMetaUint64(flags, 'C', 0)
+	if !ok {
+		return false
+	}
+
+	cache := s.cache
+	status := strMetaHit
+	var version uint64
+	if casid != 0 {
+		casidOrig, versionOrig, cacheMiss, ok := getCasidForCachedItem(cache, key)
+		if !ok {
+			return false
+		}
+		if cacheMiss {
+			status = strMetaNotFound
+		} else if casidOrig != casid {
+			status = strMetaExists
+		}
+		version = versionOrig
+	}
+	if bytes.Equal(status, strMetaHit) {
+		if hasMetaFlag(flags, 'I') && s.StaleDuration > 0 {
+			// Make the item stale instead of deleting it. Touch() doesn't
+			// check the version, so the item modified after reading its'
+			// casid may be invalidated too.
+			if err := cache.Touch(key, 0); err != nil {
+				status = strMetaNotFound
+			}
+		} else if casid != 0 {
+			// The item may be modified after reading its' casid, so delete it
+			// only if it has the same version.
+			switch cache.CompareAndDelete(key, version) {
+			case ybc.ErrCacheMiss:
+				status = strMetaNotFound
+			case ybc.ErrVersionMismatch:
+				status = strMetaExists
+			}
+		} else if !cache.Delete(key) {
+			status = strMetaNotFound
+		}
+	}
+
+	if hasMetaFlag(flags, 'q') && !bytes.Equal(status, strMetaExists) {
+		return true
+	}
+	return writeMetaResponse(c.Writer, status, flags, encodedKey, nil)
+}
+
+/*******************************************************************************
+ * ma <key> <flags>*
+ ******************************************************************************/
+
+func processMetaArithmeticCmd(c *bufio.ReadWriter, s *Server, line []byte) bool {
+	n := -1
+	encodedKey := nextToken(line, &n, "key")
+	if encodedKey == nil {
+		return false
+	}
+	flags, ok := parseMetaFlags(line, n)
+	if !ok {
+		return false
+	}
+	if !checkMetaFlags(c.Writer, flags, "bNJDTMqOcvk", "NJDTMO") {
+		return false
+	}
+	key, ok := decodeMetaKey(encodedKey, flags)
+	if !ok {
+		return false
+	}
+	delta, ok := parseMetaUint64(flags, 'D', 1)
+	if !ok {
+		return false
+	}
+	initial, ok := parseMetaUint64(flags, 'J', 0)
+	if !ok {
+		return false
+	}
+	vivifyTtl, createIfMissing, ok := parseMetaExpiration(flags, 'N')
+	if !ok {
+		return false
+	}
+	ttl, shouldTouch, ok := parseMetaExpiration(flags, 'T')
+	if !ok {
+		return false
+	}
+	isDecr := false
+	if f := findMetaFlag(flags, 'M'); f != nil {
+		switch f.token[0] {
+		case 'I', 'i', '+':
+		case 'D', 'd', '-':
+			isDecr = true
+		default:
+			log.Printf("Unsupported mode=[%c] for ma command", f.token[0])
+			writeMetaClientError(c.Writer, "invalid mode for ma")
+			return false
+		}
+	}
+
+	cache := s.cache
+	value, casid, err := updateCounter(cache, key, delta, isDecr, initial, createIfMissing, vivifyTtl)
+	switch err {
+	case nil:
+	case ybc.ErrCacheMiss:
+		if hasMetaFlag(flags, 'q') {
+			return true
+		}
+		return writeMetaResponse(c.Writer, strMetaNotFound, flags, encodedKey, nil)
+	case ybc.ErrNotNumeric:
+		return writeMetaClientError(c.Writer, "cannot increment or decrement non-numeric value")
+	default:
+		log.Printf("Cannot update counter for key=[%s]: [%s]", key, err)
+		return writeMetaResponse(c.Writer, strMetaNotStored, flags, encodedKey, nil)
+	}
+	if shouldTouch {
+		cache.Touch(key, ttl)
+	}
+
+	attrs := metaItemAttrs{
+		casid: casid,
+	}
+	if !hasMetaFlag(flags, 'v') {
+		if hasMetaFlag(flags, 'q') {
+			return true
+		}
+		return writeMetaResponse(c.Writer, strMetaHit, flags, encodedKey, &attrs)
+	}
+	var buf [20]byte
+	v := strconv.AppendUint(buf[:0], value, 10)
+	status := strconv.AppendInt(append([]byte{}, strMetaValue...), int64(len(v)), 10)
+	return writeMetaResponse(c.Writer, status, flags, encodedKey, &attrs) &&
+		writeStr(c.Writer, v) && writeCrLf(c.Writer)
+}
@@ -0,0 +1,289 @@
+package memcache
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type textTestConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newTextTestConn(t *testing.T) *textTestConn {
+	conn, err := net.Dial("tcp", testAddr)
+	if err != nil {
+		t.Fatalf("Cannot connect to [%s]: [%s]", testAddr, err)
+	}
+	return &textTestConn{
+		t:    t,
+		conn: conn,
+		r:    bufio.NewReader(conn),
+	}
+}
+
+func (c *textTestConn) Close() {
+	c.conn.Close()
+}
+
+func (c *textTestConn) send(request string) {
+	if _, err := c.conn.Write([]byte(request)); err != nil {
+		c.t.Fatalf("Cannot send request=[%q]: [%s]", request, err)
+	}
+}
+
+func (c *textTestConn) readLine() string {
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("Cannot read response line: [%s]", err)
+	}
+	return line
+}
+
+// Sends the request and checks the response.
+func (c *textTestConn) expect(request, response string) {
+	c.send(request)
+	c.expectResponse(response)
+}
+
+func (c *textTestConn) expectResponse(response string) {
+	var actual string
+	for len(actual) < len(response) {
+		actual += c.readLine()
+	}
+	if actual != response {
+		c.t.Fatalf("Unexpected response=[%q]. Expected [%q]", actual, response)
+	}
+}
+
+func (c *textTestConn) expectClosed() {
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		c.t.Fatalf("Unexpected error=[%v]. Expected [%s]", err, io.EOF)
+	}
+}
+
+func metaTest_Run(testFunc func(c *textTestConn, t *testing.T), t *testing.T) {
+	s, cache := newServerCache(t)
+	defer cache.Close()
+	s.Start()
+	defer s.Stop()
+
+	c := newTextTestConn(t)
+	defer c.Close()
+	testFunc(c, t)
+}
+
+func metaTest_GetSet(c *textTestConn, t *testing.T) {
+	c.expect("mg key v\r\n", "EN\r\n")
+	c.expect("ms key 5 F123 T0\r\nvalue\r\n", "HD\r\n")
+	c.expect("mg key v\r\n", "VA 5\r\nvalue\r\n")
+	c.expect("mg key s f k Oabc t\r\n", "HD s5 f123 kkey Oabc t-1\r\n")
+	c.expect("mg key v Oxyz\r\n", "VA 5 Oxyz\r\nvalue\r\n")
+
+	c.expect("ms key 3 T100 c E777\r\nfoo\r\n", "HD c777\r\n")
+	c.expect("mg key c t f v\r\n", "VA 3 c777 t100 f0\r\nfoo\r\n")
+
+	// Casid's little-endian bytes match ybc's value header magic.
+	c.expect("ms key 3 E1667398143\r\nfoo\r\n", "HD\r\n")
+	c.expect("mg key c v\r\n", "VA 3 c1667398143\r\nfoo\r\n")
+
+	// Empty values must be supported.
+	c.expect("ms key 0\r\n\r\n", "HD\r\n")
+	c.expect("mg key v\r\n", "VA 0\r\n\r\n")
+
+	// Quiet mode must suppress misses and successful stores.
+	c.send("mg missing v q\r\nms key 3 q\r\nbar\r\nmg key v q\r\nmn\r\n")
+	c.expectResponse("VA 3\r\nbar\r\nMN\r\n")
+
+	// Touch.
+	c.expect("mg key T1 t\r\n", "HD t1\r\n")
+	time.Sleep(time.Millisecond * 1100)
+	c.expect("mg key\r\n", "EN\r\n")
+
+	// Base64-encoded keys.
+	key := base64.StdEncoding.EncodeToString([]byte("binary \x00 key"))
+	c.expect(fmt.Sprintf("ms %s 3 b\r\nbaz\r\n", key), "HD b\r\n")
+	c.expect(fmt.Sprintf("mg %s b k v\r\n", key), fmt.Sprintf("VA 3 b k%s\r\nbaz\r\n", key))
+
+	c.expect("mg key h\r\n", "CLIENT_ERROR invalid flag\r\n")
+	c.expectClosed()
+}
+
+func TestServer_Meta_GetSet(t *testing.T) {
+	metaTest_Run(metaTest_GetSet, t)
+}
+
+func metaTest_SetModes(c *textTestConn, t *testing.T) {
+	c.expect("ms key 3 MR\r\nfoo\r\n", "NS\r\n")
+	c.expect("ms key 3 MA\r\nfoo\r\n", "NS\r\n")
+	c.expect("ms key 3 ME\r\nfoo\r\n", "HD\r\n")
+	c.expect("ms key 3 ME\r\nbar\r\n", "NS\r\n")
+	c.expect("mg key v\r\n", "VA 3\r\nfoo\r\n")
+	c.expect("ms key 3 MR F5\r\nbar\r\n", "HD\r\n")
+	c.expect("mg key v f\r\n", "VA 3 f5\r\nbar\r\n")
+	c.expect("ms key 3 MA\r\nbaz\r\n", "HD\r\n")
+	c.expect("ms key 3 MP\r\nfoo\r\n", "HD\r\n")
+	c.expect("mg key v f\r\n", "VA 9 f5\r\nfoobarbaz\r\n")
+
+	// Compare-and-set.
+	c.expect("ms key 1 E100\r\na\r\n", "HD\r\n")
+	c.expect("ms key 1 C101\r\nb\r\n", "EX\r\n")
+	c.expect("ms key 1 C100 MA\r\nc\r\n", "HD\r\n")
+	c.expect("mg key v\r\n", "VA 2\r\nac\r\n")
+	c.expect("ms missing 1 C100 Oxx k\r\nb\r\n", "NF Oxx kmissing\r\n")
+
+	c.expect("ms key 1 MX\r\na\r\n", "CLIENT_ERROR invalid mode for ms\r\n")
+	c.expectClosed()
+}
+
+func TestServer_Meta_NegativeSize(t *testing.T) {
+	for _, mode := range []string{"MS", "MA", "MP"} {
+		metaTest_Run(func(c *textTestConn, t *testing.T) {
+			c.send("ms key -1 " + mode + "\r\n")
+			c.expectClosed()
+		}, t)
+	}
+}
+
+func TestServer_Meta_TooLarge(t *testing.T) {
+	metaTest_Run(func(c *textTestConn, t *testing.T) {
+		// The value exceeds the cache size.
+		value := strings.Repeat("x", 11*1000*1000)
+		c.expect(fmt.Sprintf("ms key %d\r\n%s\r\n", len(value), value), "SERVER_ERROR object too large for cache\r\n")
+
+		// The connection must remain usable after the error.
+		c.expect("ms key 3\r\nfoo\r\n", "HD\r\n")
+		c.expect("mg key v\r\n", "VA 3\r\nfoo\r\n")
+	}, t)
+}
+
+func TestServer_Meta_SetModes(t *testing.T) {
+	metaTest_Run(metaTest_SetModes, t)
+}
+
+func metaTest_Delete(c *textTestConn, t *testing.T) {
+	c.expect("md key\r\n", "NF\r\n")
+	c.expect("ms key 3 E100\r\nfoo\r\n", "HD\r\n")
+	c.expect("md key C101 Oabc\r\n", "EX Oabc\r\n")
+	c.expect("md key C100 Oabc\r\n", "HD Oabc\r\n")
+	c.expect("mg key\r\n", "EN\r\n")
+
+	// Invalidation deletes items if stale items are disabled.
+	c.expect("ms key 3\r\nfoo\r\n", "HD\r\n")
+	c.expect("md key I\r\n", "HD\r\n")
+	c.expect("mg key\r\n", "EN\r\n")
+
+	c.send("md key q\r\nmn\r\n")
+	c.expectResponse("MN\r\n")
+}
+
+func TestServer_Meta_Delete(t *testing.T) {
+	metaTest_Run(metaTest_Delete, t)
+}
+
+func metaTest_Arithmetic(c *textTestConn, t *testing.T) {
+	c.expect("ma counter\r\n", "NF\r\n")
+	c.expect("ma counter N0 J10 v\r\n", "VA 2\r\n10\r\n")
+	c.expect("ma counter v\r\n", "VA 2\r\n11\r\n")
+	c.send("ma counter D5 v c\r\n")
+	if line := c.readLine(); !strings.HasPrefix(line, "VA 2 c") {
+		t.Fatalf("Unexpected response=[%q]. Expected [VA 2 c...]", line)
+	}
+	c.expectResponse("16\r\n")
+	c.expect("ma counter MD D100 v\r\n", "VA 1\r\n0\r\n")
+	c.expect("ma counter M+ D7 Oabc\r\n", "HD Oabc\r\n")
+	c.expect("mg counter v\r\n", "VA 1\r\n7\r\n")
+	c.expect("ma counter T1 q\r\nmn\r\n", "MN\r\n")
+	time.Sleep(time.Millisecond * 1100)
+	c.expect("mg counter\r\n", "EN\r\n")
+
+	c.expect("ms key 3\r\nfoo\r\n", "HD\r\n")
+	c.expect("ma key\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+	c.expect("mn\r\n", "MN\r\n")
+}
+
+func TestServer_Meta_Arithmetic(t *testing.T) {
+	metaTest_Run(metaTest_Arithmetic, t)
+}
+
+func metaTest_Recache(c *textTestConn, t *testing.T) {
+	// The first client must win the right to create missing item,
+	// while others must obtain empty value with Z flag.
+	c.expect("mg key N30 v t\r\n", "VA 0 t30 W\r\n\r\n")
+	c.expect("mg key N30 v\r\n", "VA 0 Z\r\n\r\n")
+	c.expect("mg key N30 s\r\n", "HD s0 Z\r\n")
+	c.expect("ms key 3 T2\r\nfoo\r\n", "HD\r\n")
+	c.expect("mg key N30 v\r\n", "VA 3\r\nfoo\r\n")
+
+	// The first client must win the right to recache the item with ttl
+	// smaller than R.
+	c.expect("ms key2 3 T2\r\nfoo\r\n", "HD\r\n")
+	c.expect("mg key2 R1 v\r\n", "VA 3\r\nfoo\r\n")
+	c.expect("mg key2 R30 v\r\n", "VA 3 W\r\nfoo\r\n")
+	c.expect("mg key2 R30 v\r\n", "VA 3 Z\r\nfoo\r\n")
+	c.expect("ms key2 3\r\nbar\r\n", "HD\r\n")
+	c.expect("mg key2 R30 v\r\n", "VA 3\r\nbar\r\n")
+}
+
+func TestServer_Meta_Recache(t *testing.T) {
+	metaTest_Run(metaTest_Recache, t)
+}
+
+func TestServer_Meta_Stale(t *testing.T) {
+	s, cache := newServerCache(t)
+	defer cache.Close()
+	s.StaleDuration = time.Hour
+	s.Start()
+	defer s.Stop()
+
+	c := newTextTestConn(t)
+	defer c.Close()
+
+	c.expect("ms key 3 T1\r\nfoo\r\n", "HD\r\n")
+	c.expect("mg key v\r\n", "VA 3\r\nfoo\r\n")
+	time.Sleep(time.Millisecond * 1100)
+	c.expect("mg key v\r\n", "VA 3 W X\r\nfoo\r\n")
+	c.expect("mg key v\r\n", "VA 3 X Z\r\nfoo\r\n")
+	c.expect("ms key 3\r\nbar\r\n", "HD\r\n")
+	c.expect("mg key v\r\n", "VA 3\r\nbar\r\n")
+
+	// Invalidated items must become stale.
+	c.expect("ms key2 3\r\nfoo\r\n", "HD\r\n")
+	c.expect("md key2 I\r\n", "HD\r\n")
+	c.expect("mg key2 v\r\n", "VA 3 W X\r\nfoo\r\n")
+	c.expect("mg key2 v\r\n", "VA 3 X Z\r\nfoo\r\n")
+	c.expect("md key2\r\n", "HD\r\n")
+	c.expect("mg key2 v\r\n", "EN\r\n")
+}
+
+func TestServer_Meta_TextInterop(t *testing.T) {
+	client, s, cache := newClientServerCache(t)
+	defer cache.Close()
+	defer s.Stop()
+	client.Start()
+	defer client.Stop()
+
+	c := newTextTestConn(t)
+	defer c.Close()
+
+	c.expect("ms key 3 F123\r\nfoo\r\n", "HD\r\n")
+	item := Item{
+		Key: []byte("key"),
+	}
+	if err := client.Get(&item); err != nil {
+		t.Fatalf("Cannot obtain item stored via meta command: [%s]", err)
+	}
+	if string(item.Value) != "foo" || item.Flags != 123 {
+		t.Fatalf("Unexpected value=[%s], flags=[%d]. Expected [foo], [123]", item.Value, item.Flags)
+	}
+	c.expect("mg key c\r\n", fmt.Sprintf("HD c%d\r\n", item.Casid))
+}
@@ -0,0 +1,228 @@
+package memcache
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/valyala/ybc/bindings/go/ybc"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Minimum interval between cache occupancy refreshes in WriteMetrics().
+//
+// Obtaining cache occupancy requires scanning the whole cache index,
+// so it mustn't be performed on each metrics scrape.
+const occupancyRefreshInterval = 10 * time.Second
+
+// Upper bounds for command latency histogram buckets.
+var latencyBuckets = []time.Duration{
+	10 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// Text protocol commands with tracked latencies.
+var textCommandNames = []string{
+	"add", "append", "cas", "cget", "cgetde", "decr", "delete", "flush_all",
+	"gat", "gats", "get", "getde", "gets", "incr", "ma", "md", "mg", "mn",
+	"ms", "prepend", "quit", "replace", "set", "stats", "touch", "verbosity",
+	"version",
+}
+
+// Binary protocol commands with tracked latencies. Quiet opcodes
+// are accounted under their non-quiet counterparts.
+var binaryCommandNames = map[byte]string{
+	binaryOpGet:       "get",
+	binaryOpSet:       "set",
+	binaryOpAdd:       "add",
+	binaryOpReplace:   "replace",
+	binaryOpDelete:    "delete",
+	binaryOpIncrement: "increment",
+	binaryOpDecrement: "decrement",
+	binaryOpQuit:      "quit",
+	binaryOpFlush:     "flush",
+	binaryOpNoop:      "noop",
+	binaryOpVersion:   "version",
+	binaryOpGetK:      "getk",
+	binaryOpStat:      "stat",
+	binaryOpTouch:     "touch",
+}
+
+// Command latency histogram.
+//
+// The histogram is shared among connections, so it must be updated
+// atomically.
+type latencyHistogram struct {
+	buckets  []uint64
+	count    uint64
+	sumNanos uint64
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{
+		buckets: make([]uint64, len(latencyBuckets)),
+	}
+}
+
+func (h *latencyHistogram) update(d time.Duration) {
+	for i, bound := range latencyBuckets {
+		if d <= bound {
+			atomic.AddUint64(&h.buckets[i], 1)
+			break
+		}
+	}
+	atomic.AddUint64(&h.sumNanos, uint64(d))
+	atomic.AddUint64(&h.count, 1)
+}
+
+// Per-server command latencies.
+type serverLatencies struct {
+	text   map[string]*latencyHistogram
+	binary [256]*latencyHistogram
+}
+
+func newServerLatencies() *serverLatencies {
+	l := &serverLatencies{
+		text: make(map[string]*latencyHistogram, len(textCommandNames)),
+	}
+	for _, name := range textCommandNames {
+		l.text[name] = newLatencyHistogram()
+	}
+	for opcode := range binaryCommandNames {
+		l.binary[opcode] = newLatencyHistogram()
+	}
+	return l
+}
+
+// Returns latency histogram for the text command in the given line.
+//
+// Returns nil for unknown commands.
+func (l *serverLatencies) textCmd(line []byte) *latencyHistogram {
+	n := 0
+	for n < len(line) && line[n] != ' ' {
+		n++
+	}
+	return l.text[string(line[:n])]
+}
+
+// Cache occupancy shared among WriteMetrics() calls.
+type occupancyCache struct {
+	lock        sync.Mutex
+	occupancy   ybc.Occupancy
+	refreshTime time.Time
+}
+
+// Returns cache occupancy obtained at most occupancyRefreshInterval ago.
+//
+// Concurrent callers wait for a single refresh instead of scanning
+// the cache index simultaneously.
+func (c *occupancyCache) get(cache ybc.ScanCacher) ybc.Occupancy {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.refreshTime.IsZero() || time.Since(c.refreshTime) >= occupancyRefreshInterval {
+		c.occupancy = cache.Occupancy()
+		c.refreshTime = time.Now()
+	}
+	return c.occupancy
+}
+
+func writeMetricHeader(w *bufio.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func writeMetric(w *bufio.Writer, name, metricType, help string, value uint64) {
+	writeMetricHeader(w, name, metricType, help)
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+func writeCounterMetric(w *bufio.Writer, name, help string, counter *uint64) {
+	writeMetric(w, name, "counter", help, atomic.LoadUint64(counter))
+}
+
+func writeLatencyHistogram(w *bufio.Writer, name, labels string, h *latencyHistogram) {
+	// Read the count first, so buckets never exceed it in the output.
+	count := atomic.LoadUint64(&h.count)
+	sumNanos := atomic.LoadUint64(&h.sumNanos)
+	var cumulative uint64
+	for i, bound := range latencyBuckets {
+		cumulative += atomic.LoadUint64(&h.buckets[i])
+		if cumulative > count {
+			cumulative = count
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels,
+			strconv.FormatFloat(bound.Seconds(), 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels,
+		strconv.FormatFloat(time.Duration(sumNanos).Seconds(), 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, count)
+}
+
+// Writes server metrics to w in Prometheus text exposition format.
+//
+// The metrics include per-server counters, per-command latency histograms
+// and occupancy of the underlying cache. Only commands, which have been
+// executed at least once, are exported.
+//
+// Obtaining cache occupancy requires scanning the whole cache index,
+// so occupancy metrics are refreshed at most once per 10 seconds.
+//
+// The server must be started before calling this function.
+func (s *Server) WriteMetrics(w io.Writer) error {
+	counters := s.counters
+	bw := bufio.NewWriter(w)
+
+	uptime := time.Since(s.startTime)
+	writeMetric(bw, "memcache_uptime_seconds", "gauge", "Time since the server start.", uint64(uptime/time.Second))
+	writeMetric(bw, "memcache_current_connections", "gauge", "The number of open client connections.", atomic.LoadUint64(&counters.currConnections))
+	writeCounterMetric(bw, "memcache_connections_total", "The number of accepted client connections.", &counters.totalConnections)
+	writeCounterMetric(bw, "memcache_get_hits_total", "The number of cache hits for get commands.", &counters.getHits)
+	writeCounterMetric(bw, "memcache_get_misses_total", "The number of cache misses for get commands.", &counters.getMisses)
+	writeCounterMetric(bw, "memcache_set_commands_total", "The number of storage commands.", &counters.cmdSet)
+	writeCounterMetric(bw, "memcache_read_bytes_total", "The number of bytes read from clients.", &counters.bytesRead)
+	writeCounterMetric(bw, "memcache_written_bytes_total", "The number of bytes written to clients.", &counters.bytesWritten)
+
+	cacheStats := s.cache.Stats()
+	writeMetric(bw, "memcache_evictions_total", "counter", "The number of items evicted by newer items.", cacheStats.Evictions)
+	writeMetric(bw, "memcache_storage_wraps_total", "counter", "The number of data file wraps.", cacheStats.StorageWraps)
+
+	occupancy := s.occupancy.get(s.cache)
+	writeMetric(bw, "memcache_cache_items", "gauge", "The number of items in the cache.", occupancy.ItemsCount)
+	writeMetric(bw, "memcache_cache_used_bytes", "gauge", "The total size of items in the cache.", occupancy.UsedSize)
+	writeMetric(bw, "memcache_cache_size_bytes", "gauge", "The total size of cache data files.", occupancy.DataFileSize)
+
+	const latencyName = "memcache_command_duration_seconds"
+	writeMetricHeader(bw, latencyName, "histogram", "Command processing duration.")
+	latencies := s.latencies
+	for _, name := range textCommandNames {
+		h := latencies.text[name]
+		if atomic.LoadUint64(&h.count) > 0 {
+			writeLatencyHistogram(bw, latencyName, "protocol=\"text\",command=\""+name+"\"", h)
+		}
+	}
+	opcodes := make([]int, 0, len(binaryCommandNames))
+	for opcode := range binaryCommandNames {
+		opcodes = append(opcodes, int(opcode))
+	}
+	sort.Ints(opcodes)
+	for _, opcode := range opcodes {
+		h := latencies.binary[opcode]
+		if atomic.LoadUint64(&h.count) > 0 {
+			writeLatencyHistogram(bw, latencyName, "protocol=\"binary\",command=\""+binaryCommandNames[byte(opcode)]+"\"", h)
+		}
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,80 @@
+package memcache
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func writeMetricsToMap(s *Server, t *testing.T) map[string]string {
+	var buf bytes.Buffer
+	if err := s.WriteMetrics(&buf); err != nil {
+		t.Fatalf("Cannot write metrics: [%s]", err)
+	}
+	metrics := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+		if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		n := strings.LastIndex(line, " ")
+		if n < 0 {
+			t.Fatalf("Unexpected metrics line=[%q]", line)
+		}
+		metrics[line[:n]] = line[n+1:]
+	}
+	return metrics
+}
+
+func TestServer_WriteMetrics(t *testing.T) {
+	s, cache := newServerCache(t)
+	defer cache.Close()
+	s.Start()
+	defer s.Stop()
+
+	c := newTextTestConn(t)
+	defer c.Close()
+
+	c.expect("set foo 0 0 3\r\nbar\r\n", "STORED\r\n")
+	c.expect("get foo missing\r\n", "VALUE foo 0 3\r\nbar\r\nEND\r\n")
+	c.expect("mn\r\n", "MN\r\n")
+
+	b := newBinaryTestConn(t)
+	defer b.Close()
+	b.expectStatus(b.call(binaryOpNoop, 0, nil, nil, nil), binaryStatusOk)
+
+	metrics := writeMetricsToMap(s, t)
+	expected := map[string]string{
+		"memcache_current_connections": "2",
+		"memcache_connections_total":   "2",
+		"memcache_get_hits_total":      "1",
+		"memcache_get_misses_total":    "1",
+		"memcache_set_commands_total":  "1",
+		"memcache_evictions_total":     "0",
+		"memcache_cache_items":         "1",
+		"memcache_cache_size_bytes":    "10000000",
+
+		`memcache_command_duration_seconds_count{protocol="text",command="get"}`:            "1",
+		`memcache_command_duration_seconds_count{protocol="text",command="set"}`:            "1",
+		`memcache_command_duration_seconds_count{protocol="text",command="mn"}`:             "1",
+		`memcache_command_duration_seconds_count{protocol="binary",command="noop"}`:         "1",
+		`memcache_command_duration_seconds_bucket{protocol="text",command="get",le="+Inf"}`: "1",
+	}
+	for name, value := range expected {
+		if metrics[name] != value {
+			t.Fatalf("Unexpected value=[%s] for metric [%s]. Expected [%s]", metrics[name], name, value)
+		}
+	}
+	if metrics["memcache_cache_used_bytes"] == "0" {
+		t.Fatalf("Unexpected zero memcache_cache_used_bytes")
+	}
+	if _, ok := metrics[`memcache_command_duration_seconds_count{protocol="text",command="delete"}`]; ok {
+		t.Fatalf("Unexpected latency metrics for not executed command")
+	}
+
+	// Cache occupancy mustn't be refreshed on each call.
+	c.expect("set bar 0 0 3\r\nbaz\r\n", "STORED\r\n")
+	metrics = writeMetricsToMap(s, t)
+	if metrics["memcache_cache_items"] != "1" {
+		t.Fatalf("Unexpected value=[%s] for metric [memcache_cache_items]. Expected [1]", metrics["memcache_cache_items"])
+	}
+}
@@ -4,19 +4,25 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"github.com/valyala/ybc/bindings/go/ybc"
+	"io"
 	"log"
 	"net"
+	"os"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
 var (
-	casidCounter uint64
-	casidLock    sync.Mutex
+	errCasidMismatch = errors.New("memcache.Server: casid mismatch")
+	errNotStored     = errors.New("memcache.Server: the item hasn't been stored")
 )
 
+var casidCounter uint64
+
 func init() {
 	casidCounter = uint64(time.Now().UnixNano())
 }
@@ -25,7 +31,7 @@ func getCasid() uint64 {
 	return atomic.AddUint64(&casidCounter, 1)
 }
 
-func writeItem(w *bufio.Writer, item *ybc.Item, size int) bool {
+func writeItemPayload(w *bufio.Writer, item *ybc.Item, size int) bool {
 	n, err := item.WriteTo(w)
 	if err != nil {
 		log.Printf("Error when writing payload with size=[%d] to output stream: [%s]", size, err)
@@ -35,25 +41,36 @@ func writeItem(w *bufio.Writer, item *ybc.Item, size int) bool {
 		log.Printf("Invalid length of payload=[%d] written to output stream. Expected [%d]", n, size)
 		return false
 	}
-	return writeCrLf(w)
+	return true
 }
 
-func writeGetResponse(w *bufio.Writer, key []byte, item *ybc.Item, shouldWriteCasid bool, scratchBuf *[]byte) bool {
-	var casid uint64
+func writeItem(w *bufio.Writer, item *ybc.Item, size int) bool {
+	return writeItemPayload(w, item, size) && writeCrLf(w)
+}
+
+// Reads casid and flags stored in front of item's value.
+func readItemCasidFlags(item *ybc.Item) (casid uint64, flags uint32, ok bool) {
 	var buf [casidSize + flagsSize]byte
 	n, err := item.Read(buf[:])
 	if err != nil {
 		log.Printf("error when reading item metadata: [%s]", err)
-		return false
+		return
 	}
 	if n != len(buf) {
 		log.Printf("Unexpected result returned from ybc.Item.Read(): %d. Expected %d", n, len(buf))
-		return false
+		return
 	}
-	if shouldWriteCasid {
-		casid = binary.LittleEndian.Uint64(buf[:])
+	casid = binary.LittleEndian.Uint64(buf[:])
+	flags = binary.LittleEndian.Uint32(buf[casidSize:])
+	ok = true
+	return
+}
+
+func writeGetResponse(w *bufio.Writer, key []byte, item *ybc.Item, shouldWriteCasid bool, scratchBuf *[]byte) bool {
+	casid, flags, ok := readItemCasidFlags(item)
+	if !ok {
+		return false
 	}
-	flags := binary.LittleEndian.Uint32(buf[casidSize:])
 
 	size := item.Available()
 	if !writeStr(w, strValue) || !writeStr(w, key) || !writeWs(w) ||
@@ -71,16 +88,30 @@ func writeGetResponse(w *bufio.Writer, key []byte, item *ybc.Item, shouldWriteCa
 	return writeStr(w, strCrLf) && writeItem(w, item, size)
 }
 
-func getItemAndWriteResponse(w *bufio.Writer, cache ybc.Cacher, key []byte, shouldWriteCasid bool, scratchBuf *[]byte) bool {
-	item, err := cache.GetItem(key)
+// Converts ybc.ErrCorrupted into ybc.ErrCacheMiss, so corrupted items
+// are treated as missing instead of stopping the server.
+//
+// See ybc.Config.VerifyChecksums for details.
+func corruptedToCacheMiss(err error, key []byte) error {
+	if err == ybc.ErrCorrupted {
+		log.Printf("Corrupted item with key=[%s] is treated as missing", key)
+		return ybc.ErrCacheMiss
+	}
+	return err
+}
+
+func getItemAndWriteResponse(w *bufio.Writer, s *Server, key []byte, shouldWriteCasid bool, scratchBuf *[]byte) bool {
+	item, err := s.cache.GetItem(key)
 	if err != nil {
-		if err == ybc.ErrCacheMiss {
+		if corruptedToCacheMiss(err, key) == ybc.ErrCacheMiss {
+			s.counters.countGet(false)
 			return true
 		}
 		log.Fatalf("Unexpected error returned by cache.GetItem(key=[%s]): [%s]", key, err)
 	}
 	// do not use defer item.Close() for performance reasons
 
+	s.counters.countGet(true)
 	ok := writeGetResponse(w, key, item, shouldWriteCasid, scratchBuf)
 	item.Close()
 	return ok
@@ -94,7 +125,7 @@ func writeEndCrLf(w *bufio.Writer) bool {
 	return writeStr(w, strEndCrLf)
 }
 
-func processGetCmd(c *bufio.ReadWriter, cache ybc.Cacher, line []byte, scratchBuf *[]byte, shouldWriteCasid bool) bool {
+func processGetCmd(c *bufio.ReadWriter, s *Server, line []byte, scratchBuf *[]byte, shouldWriteCasid bool) bool {
 	last := -1
 	lineSize := len(line)
 	for last < lineSize {
@@ -109,14 +140,14 @@ func processGetCmd(c *bufio.ReadWriter, cache ybc.Cacher, line []byte, scratchBu
 			continue
 		}
 		key := line[first:last]
-		if !getItemAndWriteResponse(c.Writer, cache, key, shouldWriteCasid, scratchBuf) {
+		if !getItemAndWriteResponse(c.Writer, s, key, shouldWriteCasid, scratchBuf) {
 			return false
 		}
 	}
 	return writeEndCrLf(c.Writer)
 }
 
-func processGetDeCmd(c *bufio.ReadWriter, cache ybc.Cacher, line []byte, scratchBuf *[]byte) bool {
+func processGetDeCmd(c *bufio.ReadWriter, s *Server, line []byte, scratchBuf *[]byte) bool {
 	n := -1
 
 	key := nextToken(line, &n, "key")
@@ -131,17 +162,21 @@ func processGetDeCmd(c *bufio.ReadWriter, cache ybc.Cacher, line []byte, scratch
 		return false
 	}
 
-	item, err := cache.GetDeAsyncItem(key, graceDuration)
+	item, err := s.cache.GetDeAsyncItem(key, graceDuration)
+	err = corruptedToCacheMiss(err, key)
 	if err != nil {
 		if err == ybc.ErrWouldBlock {
+			s.counters.countGet(false)
 			return writeStr(c.Writer, strWouldBlockCrLf)
 		}
 		if err == ybc.ErrCacheMiss {
+			s.counters.countGet(false)
 			return writeEndCrLf(c.Writer)
 		}
 		log.Fatalf("Unexpected error returned by Cache.GetDeAsyncItem(): [%s]", err)
 	}
 	// do not use defer item.Close() for performance reasons
+	s.counters.countGet(true)
 
 	ok = writeGetResponseWithEof(c.Writer, key, item, scratchBuf)
 	item.Close()
@@ -171,7 +206,7 @@ func checkAndUpdateCasid(item *ybc.Item, casid *uint64) (isModified, ok bool) {
 	return
 }
 
-func processCgetCmd(c *bufio.ReadWriter, cache ybc.Cacher, line []byte, scratchBuf *[]byte) bool {
+func processCgetCmd(c *bufio.ReadWriter, s *Server, line []byte, scratchBuf *[]byte) bool {
 	n := -1
 
 	key := nextToken(line, &n, "key")
@@ -186,14 +221,17 @@ func processCgetCmd(c *bufio.ReadWriter, cache ybc.Cacher, line []byte, scratchB
 		return false
 	}
 
-	item, err := cache.GetItem(key)
+	item, err := s.cache.GetItem(key)
+	err = corruptedToCacheMiss(err, key)
 	if err == ybc.ErrCacheMiss {
+		s.counters.countGet(false)
 		return writeStr(c.Writer, strEndCrLf)
 	}
 	if err != nil {
 		log.Fatalf("Unexpected error returned: [%s]", err)
 	}
 	// do not use defer item.Close() for performance reasons
+	s.counters.countGet(true)
 
 	isModified, ok := checkAndUpdateCasid(item, &casid)
 	if !ok {
@@ -210,7 +248,7 @@ func processCgetCmd(c *bufio.ReadWriter, cache ybc.Cacher, line []byte, scratchB
 	return ok
 }
 
-func processCgetDeCmd(c *bufio.ReadWriter, cache ybc.Cacher, line []byte, scratchBuf *[]byte) bool {
+func processCgetDeCmd(c *bufio.ReadWriter, s *Server, line []byte, scratchBuf *[]byte) bool {
 	n := -1
 
 	key := nextToken(line, &n, "key")
@@ -229,17 +267,21 @@ func processCgetDeCmd(c *bufio.ReadWriter, cache ybc.Cacher, line []byte, scratc
 		return false
 	}
 
-	item, err := cache.GetDeAsyncItem(key, graceDuration)
+	item, err := s.cache.GetDeAsyncItem(key, graceDuration)
+	err = corruptedToCacheMiss(err, key)
 	if err == ybc.ErrWouldBlock {
+		s.counters.countGet(false)
 		return writeStr(c.Writer, strWouldBlockCrLf)
 	}
 	if err == ybc.ErrCacheMiss {
+		s.counters.countGet(false)
 		return writeStr(c.Writer, strEndCrLf)
 	}
 	if err != nil {
 		log.Fatalf("Unexpected error returned: [%s]", err)
 	}
 	// do not use defer item.Close() for performance reasons
+	s.counters.countGet(true)
 
 	isModified, ok := checkAndUpdateCasid(item, &casid)
 	if !ok {
@@ -308,7 +350,7 @@ func parseSetCmd(line []byte, shouldParseCasid bool) (key []byte, flags uint32,
 	return
 }
 
-func readValueToTxn(r *bufio.Reader, txn *ybc.SetTxn, size int) bool {
+func readPayloadToTxn(r *bufio.Reader, txn *ybc.SetTxn, size int) bool {
 	n, err := txn.ReadFrom(r)
 	if err != nil {
 		log.Printf("Error when reading payload with size=[%d]: [%s]", size, err)
@@ -318,9 +360,50 @@ func readValueToTxn(r *bufio.Reader, txn *ybc.SetTxn, size int) bool {
 		log.Printf("Unexpected payload size=[%d]. Expected [%d]", n, size)
 		return false
 	}
+	return true
+}
+
+// Appends the payload with the given size read from r to dst.
+func appendPayload(dst []byte, r *bufio.Reader, size int) (data []byte, ok bool) {
+	// Do not trust the size sent by client. Grow the buffer as payload
+	// arrives, so clients cannot exhaust server memory by sending huge sizes
+	// without payloads.
+	data = dst
+	for n := size; n > 0; n -= payloadChunkSize {
+		chunkSize := n
+		if chunkSize > payloadChunkSize {
+			chunkSize = payloadChunkSize
+		}
+		offset := len(data)
+		data = append(data, make([]byte, chunkSize)...)
+		if _, err := io.ReadFull(r, data[offset:]); err != nil {
+			log.Printf("Error when reading payload with size=[%d]: [%s]", size, err)
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+func readPayload(r *bufio.Reader, size int) (data []byte, ok bool) {
+	if data, ok = appendPayload([]byte{}, r, size); !ok {
+		return
+	}
+	return data, matchCrLf(r)
+}
+
+// Skips the payload with the given size, which cannot be stored in the cache.
+func discardPayload(r *bufio.Reader, size int) bool {
+	if _, err := r.Discard(size); err != nil {
+		log.Printf("Error when skipping payload with size=[%d]: [%s]", size, err)
+		return false
+	}
 	return matchCrLf(r)
 }
 
+func readValueToTxn(r *bufio.Reader, txn *ybc.SetTxn, size int) bool {
+	return readPayloadToTxn(r, txn, size) && matchCrLf(r)
+}
+
 func writeSetResponse(w *bufio.Writer, noreply bool) bool {
 	if noreply {
 		return true
@@ -329,7 +412,10 @@ func writeSetResponse(w *bufio.Writer, noreply bool) bool {
 }
 
 func startSetTxn(cache ybc.Cacher, key []byte, flags uint32, expiration time.Duration, size int) *ybc.SetTxn {
-	casid := getCasid()
+	return startSetTxnWithCasid(cache, key, getCasid(), flags, expiration, size)
+}
+
+func startSetTxnWithCasid(cache ybc.Cacher, key []byte, casid uint64, flags uint32, expiration time.Duration, size int) *ybc.SetTxn {
 	size += casidSize + flagsSize
 	txn, err := cache.NewSetTxn(key, size, expiration)
 	if err != nil {
@@ -374,17 +460,19 @@ func processSetCmd(c *bufio.ReadWriter, cache ybc.Cacher, line []byte, scratchBu
 	return readValueToTxnAndWriteResponse(c, txn, size, noreply)
 }
 
-func getCasidForCachedItem(cache ybc.Cacher, key []byte) (casid uint64, cacheMiss, ok bool) {
+func getCasidForCachedItem(cache ybc.Cacher, key []byte) (casid, version uint64, cacheMiss, ok bool) {
 	item, err := cache.GetItem(key)
 	if err != nil {
-		if err == ybc.ErrCacheMiss {
+		if corruptedToCacheMiss(err, key) == ybc.ErrCacheMiss {
 			cacheMiss = true
+			ok = true
 			return
 		}
 		log.Fatalf("Unexpected error returned from Cache.GetItem() for key=[%s]: [%s]", key, err)
 	}
 	// do not use defer item.Close() for performance reasons
 
+	version = item.Version()
 	var buf [casidSize]byte
 	n, err := item.Read(buf[:])
 	item.Close()
@@ -401,16 +489,145 @@ func getCasidForCachedItem(cache ybc.Cacher, key []byte) (casid uint64, cacheMis
 	return
 }
 
-func cachedItemExists(cache ybc.Cacher, key []byte) bool {
-	item, err := cache.GetItem(key)
-	if err == ybc.ErrCacheMiss {
-		return false
+// Storage modes for commitStoreTxn().
+const (
+	storeModeSet = iota
+	storeModeAdd
+	storeModeReplace
+)
+
+// Commits the txn for the item with the given key according to
+// the given storage mode.
+//
+// Set and replace modes commit the txn only if the cached item has
+// the given casid if casid isn't zero. Add mode ignores casid.
+// Use replaceItem() for replacing items without casid check, since the txn
+// cannot be retried after concurrent modification of the item.
+//
+// Returns ybc.ErrAlreadyExists if the item exists in add mode.
+// Returns ybc.ErrCacheMiss if the item is missing in replace mode
+// or if casid is set.
+// Returns errCasidMismatch if the item has distinct casid.
+// Returns errNotStored if the item has been concurrently modified.
+func commitStoreTxn(cache ybc.Cacher, txn *ybc.SetTxn, key []byte, mode int, casid uint64) error {
+	if mode == storeModeAdd {
+		err := txn.CommitAdd()
+		if err != nil && err != ybc.ErrAlreadyExists {
+			log.Printf("Unexpected error in SetTxn.CommitAdd() for key=[%s]: [%s]", key, err)
+			return errNotStored
+		}
+		return err
 	}
-	if err != nil {
-		log.Fatalf("Unexpected error returned from Cacher.GetItem(): [%s]", err)
+	if mode == storeModeSet && casid == 0 {
+		if err := txn.Commit(); err != nil {
+			log.Printf("Unexpected error returned from SetTxn.Commit() for key=[%s]: [%s]", key, err)
+			return errNotStored
+		}
+		return nil
+	}
+
+	casidOrig, version, cacheMiss, ok := getCasidForCachedItem(cache, key)
+	if !ok {
+		txn.Rollback()
+		return errNotStored
+	}
+	if cacheMiss {
+		txn.Rollback()
+		return ybc.ErrCacheMiss
+	}
+	if casid != 0 && casidOrig != casid {
+		txn.Rollback()
+		return errCasidMismatch
+	}
+	// The item may be modified after casid check, so commit the txn
+	// only if the item remains the same.
+	switch err := txn.CompareAndCommit(version); err {
+	case nil, ybc.ErrCacheMiss:
+		return err
+	case ybc.ErrVersionMismatch:
+		if casid != 0 {
+			return errCasidMismatch
+		}
+		return errNotStored
+	default:
+		log.Printf("Unexpected error in SetTxn.CompareAndCommit() for key=[%s]: [%s]", key, err)
+		return errNotStored
+	}
+}
+
+// Replaces the value of the existing item with the given key.
+//
+// The value must start with item metadata.
+//
+// Returns ybc.ErrCacheMiss if the item is missing.
+func replaceItem(cache serverCacher, key, value []byte, expiration time.Duration) error {
+	for {
+		item, err := cache.GetItem(key)
+		if err != nil {
+			return err
+		}
+		version := item.Version()
+		item.Close()
+
+		err = cache.CompareAndSet(key, value, expiration, version)
+		if err == ybc.ErrVersionMismatch {
+			// The item has been modified concurrently. Try again.
+			continue
+		}
+		return err
+	}
+}
+
+// Appends data to the value of the item with the given key or prepends
+// data to it if isPrepend is set. The updated item obtains newCasid.
+//
+// Checks item's casid before the update if casid isn't zero.
+//
+// Returns ybc.ErrCacheMiss if the item is missing.
+// Returns errCasidMismatch if the item has distinct casid.
+func appendToItem(cache serverCacher, key, data []byte, isPrepend bool, casid, newCasid uint64) error {
+	var buf []byte
+	for {
+		item, err := cache.GetItem(key)
+		if err != nil {
+			return err
+		}
+		version := item.Version()
+		ttl := item.Ttl()
+		oldCasid, flags, ok := readItemCasidFlags(item)
+		if !ok {
+			item.Close()
+			return ybc.ErrCorrupted
+		}
+		if casid != 0 && oldCasid != casid {
+			item.Close()
+			return errCasidMismatch
+		}
+		buf = appendItemMetadata(buf[:0], newCasid, flags)
+		if isPrepend {
+			buf = append(buf, data...)
+		}
+		n := len(buf)
+		buf = append(buf, make([]byte, item.Available())...)
+		_, err = item.Read(buf[n:])
+		item.Close()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if !isPrepend {
+			buf = append(buf, data...)
+		}
+
+		err = cache.CompareAndSet(key, buf, ttl, version)
+		if err == ybc.ErrVersionMismatch {
+			if casid != 0 {
+				return errCasidMismatch
+			}
+			// The item has been modified concurrently. Try again.
+			continue
+		}
+		return err
 	}
-	item.Close()
-	return true
 }
 
 func processAddCmd(c *bufio.ReadWriter, cache ybc.Cacher, line []byte, scratchBuf *[]byte) bool {
@@ -428,21 +645,16 @@ func processAddCmd(c *bufio.ReadWriter, cache ybc.Cacher, line []byte, scratchBu
 		return false
 	}
 
-	casidLock.Lock()
-	// do not use defer casid.Unlock() for performance reasons
-
-	if cachedItemExists(cache, key) {
-		casidLock.Unlock()
-		txn.Rollback()
+	err := txn.CommitAdd()
+	if err == ybc.ErrAlreadyExists {
 		if noreply {
 			return true
 		}
 		return writeStr(c.Writer, strNotStoredCrLf)
 	}
-	if err := txn.Commit(); err != nil {
-		log.Fatalf("Unexpected error in SetTxn.Commit(): [%s]", err)
+	if err != nil {
+		log.Fatalf("Unexpected error in SetTxn.CommitAdd(): [%s]", err)
 	}
-	casidLock.Unlock()
 	return writeSetResponse(c.Writer, noreply)
 }
 
@@ -461,36 +673,36 @@ func processCasCmd(c *bufio.ReadWriter, cache ybc.Cacher, line []byte, scratchBu
 		return false
 	}
 
-	casidLock.Lock()
-	// do not use defer casidLock.Unlock() for performance reasons
-
-	casidOrig, cacheMiss, ok := getCasidForCachedItem(cache, key)
+	casidOrig, version, cacheMiss, ok := getCasidForCachedItem(cache, key)
 	if !ok {
-		casidLock.Unlock()
 		txn.Rollback()
 		return false
 	}
+	response := strStoredCrLf
 	if cacheMiss {
-		casidLock.Unlock()
 		txn.Rollback()
-		if noreply {
-			return true
-		}
-		return writeStr(c.Writer, strNotFoundCrLf)
-	}
-	if casidOrig != casid {
-		casidLock.Unlock()
+		response = strNotFoundCrLf
+	} else if casidOrig != casid {
 		txn.Rollback()
-		if noreply {
-			return true
+		response = strExistsCrLf
+	} else {
+		// The item may be modified after casid check, so commit the txn
+		// only if the item remains the same.
+		switch err := txn.CompareAndCommit(version); err {
+		case nil:
+		case ybc.ErrCacheMiss:
+			response = strNotFoundCrLf
+		case ybc.ErrVersionMismatch:
+			response = strExistsCrLf
+		default:
+			log.Fatalf("Unexpected error in SetTxn.CompareAndCommit(): [%s]", err)
 		}
-		return writeStr(c.Writer, strExistsCrLf)
 	}
-	if err := txn.Commit(); err != nil {
-		log.Fatalf("Unexpected error in SetTxn.Commit(): [%s]", err)
+
+	if noreply {
+		return true
 	}
-	casidLock.Unlock()
-	return writeSetResponse(c.Writer, noreply)
+	return writeStr(c.Writer, response)
 }
 
 func processDeleteCmd(c *bufio.ReadWriter, cache ybc.Cacher, line []byte, scratchBuf *[]byte) bool {
@@ -537,6 +749,404 @@ func processDeleteCmd(c *bufio.ReadWriter, cache ybc.Cacher, line []byte, scratc
 	return writeStr(c.Writer, response)
 }
 
+func processReplaceCmd(c *bufio.ReadWriter, cache serverCacher, line []byte, scratchBuf *[]byte) bool {
+	key, flags, expiration, size, _, noreply, ok := parseSetCmd(line, false)
+	if !ok {
+		return false
+	}
+	value := appendItemMetadata(nil, getCasid(), flags)
+	if value, ok = appendPayload(value, c.Reader, size); !ok {
+		return false
+	}
+	if !matchCrLf(c.Reader) {
+		return false
+	}
+
+	response := strStoredCrLf
+	switch err := replaceItem(cache, key, value, expiration); err {
+	case nil:
+	case ybc.ErrCacheMiss:
+		response = strNotStoredCrLf
+	default:
+		log.Printf("Cannot replace the item with key=[%s]: [%s]", key, err)
+		response = strNotStoredCrLf
+	}
+	if noreply {
+		return true
+	}
+	return writeStr(c.Writer, response)
+}
+
+func processAppendCmd(c *bufio.ReadWriter, cache serverCacher, line []byte, scratchBuf *[]byte, isPrepend bool) bool {
+	// Flags and expiration are ignored by append and prepend commands.
+	key, _, _, size, _, noreply, ok := parseSetCmd(line, false)
+	if !ok {
+		return false
+	}
+	data, ok := readPayload(c.Reader, size)
+	if !ok {
+		return false
+	}
+
+	response := strStoredCrLf
+	switch err := appendToItem(cache, key, data, isPrepend, 0, getCasid()); err {
+	case nil:
+	case ybc.ErrCacheMiss:
+		response = strNotStoredCrLf
+	default:
+		log.Printf("Cannot append data to the item with key=[%s]: [%s]", key, err)
+		response = strNotStoredCrLf
+	}
+	if noreply {
+		return true
+	}
+	return writeStr(c.Writer, response)
+}
+
+func parseKeyNumberCmd(line []byte, numberName string) (key []byte, number []byte, noreply bool, ok bool) {
+	n := -1
+
+	if key = nextToken(line, &n, "key"); key == nil {
+		return
+	}
+	if number = nextToken(line, &n, numberName); number == nil {
+		return
+	}
+	if n < len(line) {
+		if !expectNoreply(line, &n) {
+			return
+		}
+		noreply = true
+	}
+	ok = expectEof(line, n)
+	return
+}
+
+func processIncrCmd(c *bufio.ReadWriter, cache serverCacher, line []byte, scratchBuf *[]byte, isDecr bool) bool {
+	key, deltaStr, noreply, ok := parseKeyNumberCmd(line, "delta")
+	if !ok {
+		return false
+	}
+	delta, ok := parseUint64(deltaStr)
+	if !ok {
+		return false
+	}
+
+	value, _, err := updateCounter(cache, key, delta, isDecr, 0, false, 0)
+	if noreply {
+		return true
+	}
+	switch err {
+	case nil:
+		return writeUint64(c.Writer, value, scratchBuf) && writeCrLf(c.Writer)
+	case ybc.ErrCacheMiss:
+		return writeStr(c.Writer, strNotFoundCrLf)
+	case ybc.ErrNotNumeric:
+		return writeStr(c.Writer, strClientError) &&
+			writeStr(c.Writer, strNonNumericValueCrLf)
+	}
+	log.Printf("Cannot update counter for key=[%s]: [%s]", key, err)
+	return false
+}
+
+func processTouchCmd(c *bufio.ReadWriter, cache serverCacher, line []byte, scratchBuf *[]byte) bool {
+	key, expirationStr, noreply, ok := parseKeyNumberCmd(line, "expiration")
+	if !ok {
+		return false
+	}
+	expiration, ok := parseExpiration(expirationStr)
+	if !ok {
+		return false
+	}
+
+	response := strTouchedCrLf
+	if err := cache.Touch(key, expiration); err != nil {
+		if err != ybc.ErrCacheMiss {
+			log.Printf("Unexpected error returned by cache.Touch(key=[%s]): [%s]", key, err)
+		}
+		response = strNotFoundCrLf
+	}
+	if noreply {
+		return true
+	}
+	return writeStr(c.Writer, response)
+}
+
+func processGatCmd(c *bufio.ReadWriter, s *Server, line []byte, scratchBuf *[]byte, shouldWriteCasid bool) bool {
+	n := -1
+	expiration, ok := parseExpirationToken(line, &n)
+	if !ok {
+		return false
+	}
+
+	last := n
+	lineSize := len(line)
+	for last < lineSize {
+		first := last + 1
+		last = bytes.IndexByte(line[first:], ' ')
+		if last == -1 {
+			last = lineSize
+		} else {
+			last += first
+		}
+		if first == last {
+			continue
+		}
+		key := line[first:last]
+		if err := s.cache.Touch(key, expiration); err != nil {
+			if err != ybc.ErrCacheMiss {
+				log.Printf("Unexpected error returned by cache.Touch(key=[%s]): [%s]", key, err)
+			}
+			s.counters.countGet(false)
+			continue
+		}
+		if !getItemAndWriteResponse(c.Writer, s, key, shouldWriteCasid, scratchBuf) {
+			return false
+		}
+	}
+	return writeEndCrLf(c.Writer)
+}
+
+func processVerbosityCmd(c *bufio.ReadWriter, line []byte) bool {
+	n := -1
+
+	// The server has no adjustable verbosity, so the level is ignored.
+	if nextToken(line, &n, "level") == nil {
+		return false
+	}
+	if n < len(line) {
+		if !expectNoreply(line, &n) || !expectEof(line, n) {
+			return false
+		}
+		return true
+	}
+	return writeStr(c.Writer, strOkCrLf)
+}
+
+func processVersionCmd(c *bufio.ReadWriter) bool {
+	return writeStr(c.Writer, strVersion) && writeStr(c.Writer, []byte(serverVersion)) &&
+		writeCrLf(c.Writer)
+}
+
+// Adds delta to the decimal counter stored under the given key
+// or subtracts delta from it if isDecr is set. The counter cannot go
+// below zero.
+//
+// The missing counter is created with the initial value and the given
+// expiration if createIfMissing is set. Otherwise ybc.ErrCacheMiss
+// is returned.
+//
+// Returns ybc.ErrNotNumeric if the item's value isn't a decimal number.
+//
+// Items' values are prefixed by casid and flags, so ybc.Cacher.Incr()
+// cannot be used here. The counter is updated with compare-and-set instead.
+func updateCounter(cache serverCacher, key []byte, delta uint64, isDecr bool, initial uint64, createIfMissing bool, expiration time.Duration) (value, casid uint64, err error) {
+	var buf []byte
+	for {
+		casid = getCasid()
+		item, err := cache.GetItem(key)
+		if err == ybc.ErrCacheMiss {
+			if !createIfMissing {
+				return 0, 0, err
+			}
+			value = initial
+			buf = appendCounter(buf[:0], casid, 0, value)
+			if err = cache.Add(key, buf, expiration); err == ybc.ErrAlreadyExists {
+				// The counter has been created concurrently.
+				continue
+			}
+			return value, casid, err
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+
+		version := item.Version()
+		ttl := item.Ttl()
+		_, flags, ok := readItemCasidFlags(item)
+		if !ok {
+			item.Close()
+			return 0, 0, ybc.ErrCorrupted
+		}
+		if value, ok = parseCounter(item); !ok {
+			item.Close()
+			return 0, 0, ybc.ErrNotNumeric
+		}
+		item.Close()
+
+		if !isDecr {
+			value += delta
+		} else if value > delta {
+			value -= delta
+		} else {
+			value = 0
+		}
+		buf = appendCounter(buf[:0], casid, flags, value)
+		err = cache.CompareAndSet(key, buf, ttl, version)
+		if err == ybc.ErrVersionMismatch || err == ybc.ErrCacheMiss {
+			// The counter has been modified concurrently. Try again.
+			continue
+		}
+		return value, casid, err
+	}
+}
+
+// Parses the counter from the remaining item's value.
+func parseCounter(item *ybc.Item) (value uint64, ok bool) {
+	var buf [maxCounterSize]byte
+	size := item.Available()
+	if size == 0 || size > len(buf) {
+		return
+	}
+	if _, err := item.Read(buf[:size]); err != nil {
+		return
+	}
+	value, err := strconv.ParseUint(string(bytes.TrimRight(buf[:size], " ")), 10, 64)
+	ok = (err == nil)
+	return
+}
+
+func appendCounter(dst []byte, casid uint64, flags uint32, value uint64) []byte {
+	dst = appendItemMetadata(dst, casid, flags)
+	return strconv.AppendUint(dst, value, 10)
+}
+
+// Appends casid and flags stored in front of item's value to dst.
+func appendItemMetadata(dst []byte, casid uint64, flags uint32) []byte {
+	var buf [casidSize + flagsSize]byte
+	binary.LittleEndian.PutUint64(buf[:casidSize], casid)
+	binary.LittleEndian.PutUint32(buf[casidSize:], flags)
+	return append(dst, buf[:]...)
+}
+
+// Per-server statistics counters.
+//
+// The counters are shared among connections, so they must be updated
+// atomically.
+type serverCounters struct {
+	currConnections  uint64
+	totalConnections uint64
+	getHits          uint64
+	getMisses        uint64
+	cmdSet           uint64
+	bytesRead        uint64
+	bytesWritten     uint64
+}
+
+func (c *serverCounters) countGet(isHit bool) {
+	if isHit {
+		atomic.AddUint64(&c.getHits, 1)
+	} else {
+		atomic.AddUint64(&c.getMisses, 1)
+	}
+}
+
+func (c *serverCounters) countSet() {
+	atomic.AddUint64(&c.cmdSet, 1)
+}
+
+// Counts bytes read from and written to the underlying connection.
+type countingConn struct {
+	net.Conn
+	counters *serverCounters
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddUint64(&c.counters.bytesRead, uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddUint64(&c.counters.bytesWritten, uint64(n))
+	return n, err
+}
+
+// Server statistics item.
+type serverStat struct {
+	name  string
+	value string
+}
+
+func formatCounter(counter *uint64) string {
+	return strconv.FormatUint(atomic.LoadUint64(counter), 10)
+}
+
+// Returns server statistics in memcache format.
+func serverStats(s *Server) []serverStat {
+	counters := s.counters
+	getHits := atomic.LoadUint64(&counters.getHits)
+	getMisses := atomic.LoadUint64(&counters.getMisses)
+	cacheStats := s.cache.Stats()
+	now := time.Now()
+	return []serverStat{
+		{"pid", strconv.Itoa(os.Getpid())},
+		{"uptime", strconv.FormatInt(int64(now.Sub(s.startTime)/time.Second), 10)},
+		{"time", strconv.FormatInt(now.Unix(), 10)},
+		{"version", serverVersion},
+		{"pointer_size", strconv.Itoa(strconv.IntSize)},
+		{"curr_connections", formatCounter(&counters.currConnections)},
+		{"total_connections", formatCounter(&counters.totalConnections)},
+		{"cmd_get", strconv.FormatUint(getHits+getMisses, 10)},
+		{"cmd_set", formatCounter(&counters.cmdSet)},
+		{"get_hits", strconv.FormatUint(getHits, 10)},
+		{"get_misses", strconv.FormatUint(getMisses, 10)},
+		{"bytes_read", formatCounter(&counters.bytesRead)},
+		{"bytes_written", formatCounter(&counters.bytesWritten)},
+
+		{"evictions", strconv.FormatUint(cacheStats.Evictions, 10)},
+		{"storage_wraps", strconv.FormatUint(cacheStats.StorageWraps, 10)},
+	}
+}
+
+// Returns server settings in memcache format.
+func serverSettings(s *Server) []serverStat {
+	return []serverStat{
+		{"listen_addr", s.ListenAddr},
+		{"binding_protocol", "auto-negotiate"},
+		{"cas_enabled", "yes"},
+		{"read_buffer_size", strconv.Itoa(s.ReadBufferSize)},
+		{"write_buffer_size", strconv.Itoa(s.WriteBufferSize)},
+		{"os_read_buffer_size", strconv.Itoa(s.OSReadBufferSize)},
+		{"os_write_buffer_size", strconv.Itoa(s.OSWriteBufferSize)},
+		{"stale_duration", strconv.FormatInt(int64(s.StaleDuration/time.Second), 10)},
+	}
+}
+
+// Returns statistics for the given stats group.
+//
+// Empty group means general statistics. ybc has no slabs, so 'items'
+// and 'slabs' groups are always empty.
+func serverStatsGroup(s *Server, group []byte) (stats []serverStat, ok bool) {
+	switch string(group) {
+	case "":
+		return serverStats(s), true
+	case "settings":
+		return serverSettings(s), true
+	case "items", "slabs":
+		return nil, true
+	}
+	return nil, false
+}
+
+func processStatsCmd(c *bufio.ReadWriter, s *Server, line []byte) bool {
+	group := bytes.TrimLeft(line, " ")
+	stats, ok := serverStatsGroup(s, group)
+	if !ok {
+		log.Printf("Unsupported stats group=[%s]", group)
+		return false
+	}
+	w := c.Writer
+	for _, st := range stats {
+		if !writeStr(w, strStat) || !writeStr(w, []byte(st.name)) || !writeWs(w) ||
+			!writeStr(w, []byte(st.value)) || !writeCrLf(w) {
+			return false
+		}
+	}
+	return writeEndCrLf(w)
+}
+
 func parseFlushAllCmd(line []byte) (expiration time.Duration, noreply bool, ok bool) {
 	if len(line) == 0 {
 		noreply = false
@@ -584,19 +1194,23 @@ func processFlushAllCmd(c *bufio.ReadWriter, cache ybc.Cacher, line []byte, flus
 	if !ok {
 		return false
 	}
+	flushAll(cache, expiration, flushAllTimer)
+	if noreply {
+		return true
+	}
+	return writeStr(c.Writer, strOkCrLf)
+}
+
+func flushAll(cache ybc.Cacher, expiration time.Duration, flushAllTimer **time.Timer) {
 	(*flushAllTimer).Stop()
 	if expiration <= 0 {
 		cache.Clear()
 	} else {
 		*flushAllTimer = time.AfterFunc(expiration, cacheClearFunc(cache))
 	}
-	if noreply {
-		return true
-	}
-	return writeStr(c.Writer, strOkCrLf)
 }
 
-func processRequest(c *bufio.ReadWriter, cache ybc.Cacher, scratchBuf *[]byte, flushAllTimer **time.Timer) bool {
+func processRequest(c *bufio.ReadWriter, s *Server, scratchBuf *[]byte, flushAllTimer **time.Timer) bool {
 	if !readLine(c.Reader, scratchBuf) {
 		return false
 	}
@@ -604,28 +1218,43 @@ func processRequest(c *bufio.ReadWriter, cache ybc.Cacher, scratchBuf *[]byte, f
 	if len(line) == 0 {
 		return false
 	}
+	h := s.latencies.textCmd(line)
+	if h == nil {
+		return processTextCmd(c, s, line, scratchBuf, flushAllTimer)
+	}
+	startTime := time.Now()
+	ok := processTextCmd(c, s, line, scratchBuf, flushAllTimer)
+	h.update(time.Since(startTime))
+	return ok
+}
+
+func processTextCmd(c *bufio.ReadWriter, s *Server, line []byte, scratchBuf *[]byte, flushAllTimer **time.Timer) bool {
+	cache := s.cache
 	if bytes.HasPrefix(line, strGet) {
-		return processGetCmd(c, cache, line[len(strGet):], scratchBuf, false)
+		return processGetCmd(c, s, line[len(strGet):], scratchBuf, false)
 	}
 	if bytes.HasPrefix(line, strGets) {
-		return processGetCmd(c, cache, line[len(strGets):], scratchBuf, true)
+		return processGetCmd(c, s, line[len(strGets):], scratchBuf, true)
 	}
 	if bytes.HasPrefix(line, strGetDe) {
-		return processGetDeCmd(c, cache, line[len(strGetDe):], scratchBuf)
+		return processGetDeCmd(c, s, line[len(strGetDe):], scratchBuf)
 	}
 	if bytes.HasPrefix(line, strCget) {
-		return processCgetCmd(c, cache, line[len(strCget):], scratchBuf)
+		return processCgetCmd(c, s, line[len(strCget):], scratchBuf)
 	}
 	if bytes.HasPrefix(line, strCgetDe) {
-		return processCgetDeCmd(c, cache, line[len(strCgetDe):], scratchBuf)
+		return processCgetDeCmd(c, s, line[len(strCgetDe):], scratchBuf)
 	}
 	if bytes.HasPrefix(line, strSet) {
+		s.counters.countSet()
 		return processSetCmd(c, cache, line[len(strSet):], scratchBuf)
 	}
 	if bytes.HasPrefix(line, strCas) {
+		s.counters.countSet()
 		return processCasCmd(c, cache, line[len(strCas):], scratchBuf)
 	}
 	if bytes.HasPrefix(line, strAdd) {
+		s.counters.countSet()
 		return processAddCmd(c, cache, line[len(strAdd):], scratchBuf)
 	}
 	if bytes.HasPrefix(line, strDelete) {
@@ -634,24 +1263,96 @@ func processRequest(c *bufio.ReadWriter, cache ybc.Cacher, scratchBuf *[]byte, f
 	if bytes.HasPrefix(line, strFlushAll) {
 		return processFlushAllCmd(c, cache, line[len(strFlushAll):], flushAllTimer)
 	}
+	if bytes.HasPrefix(line, strReplace) {
+		s.counters.countSet()
+		return processReplaceCmd(c, cache, line[len(strReplace):], scratchBuf)
+	}
+	if bytes.HasPrefix(line, strAppend) {
+		s.counters.countSet()
+		return processAppendCmd(c, cache, line[len(strAppend):], scratchBuf, false)
+	}
+	if bytes.HasPrefix(line, strPrepend) {
+		s.counters.countSet()
+		return processAppendCmd(c, cache, line[len(strPrepend):], scratchBuf, true)
+	}
+	if bytes.HasPrefix(line, strIncr) {
+		return processIncrCmd(c, cache, line[len(strIncr):], scratchBuf, false)
+	}
+	if bytes.HasPrefix(line, strDecr) {
+		return processIncrCmd(c, cache, line[len(strDecr):], scratchBuf, true)
+	}
+	if bytes.HasPrefix(line, strTouch) {
+		return processTouchCmd(c, cache, line[len(strTouch):], scratchBuf)
+	}
+	if bytes.HasPrefix(line, strGat) {
+		return processGatCmd(c, s, line[len(strGat):], scratchBuf, false)
+	}
+	if bytes.HasPrefix(line, strGats) {
+		return processGatCmd(c, s, line[len(strGats):], scratchBuf, true)
+	}
+	if bytes.HasPrefix(line, strVerbosity) {
+		return processVerbosityCmd(c, line[len(strVerbosity):])
+	}
+	if bytes.Equal(line, strStats) || bytes.HasPrefix(line, strStatsWs) {
+		return processStatsCmd(c, s, line[len(strStats):])
+	}
+	if bytes.Equal(line, strVersionCmd) {
+		return processVersionCmd(c)
+	}
+	if bytes.Equal(line, strQuit) {
+		return false
+	}
+	if bytes.HasPrefix(line, strMetaGet) {
+		return processMetaGetCmd(c, s, line[len(strMetaGet):])
+	}
+	if bytes.HasPrefix(line, strMetaSet) {
+		s.counters.countSet()
+		return processMetaSetCmd(c, s, line[len(strMetaSet):])
+	}
+	if bytes.HasPrefix(line, strMetaDelete) {
+		return processMetaDeleteCmd(c, s, line[len(strMetaDelete):])
+	}
+	if bytes.HasPrefix(line, strMetaArithmetic) {
+		return processMetaArithmeticCmd(c, s, line[len(strMetaArithmetic):])
+	}
+	if bytes.Equal(line, strMetaNoop) {
+		return writeStr(c.Writer, strMetaNoopCrLf)
+	}
 	log.Printf("Unrecognized command=[%s]", line)
 	return false
 }
 
-func handleConn(conn net.Conn, cache ybc.Cacher, readBufferSize, writeBufferSize int, done *sync.WaitGroup) {
+func handleConn(conn net.Conn, s *Server, done *sync.WaitGroup) {
 	defer conn.Close()
 	defer done.Done()
-	r := bufio.NewReaderSize(conn, readBufferSize)
-	w := bufio.NewWriterSize(conn, writeBufferSize)
+
+	counters := s.counters
+	atomic.AddUint64(&counters.currConnections, 1)
+	atomic.AddUint64(&counters.totalConnections, 1)
+	defer atomic.AddUint64(&counters.currConnections, ^uint64(0))
+
+	cc := &countingConn{
+		Conn:     conn,
+		counters: counters,
+	}
+	r := bufio.NewReaderSize(cc, s.ReadBufferSize)
+	w := bufio.NewWriterSize(cc, s.WriteBufferSize)
 	c := bufio.NewReadWriter(r, w)
 	defer w.Flush()
 
 	flushAllTimer := time.NewTimer(0)
 	defer flushAllTimer.Stop()
 
+	// Clients speaking binary protocol start each request with the magic byte,
+	// which cannot start text protocol commands.
+	processRequestFunc := processRequest
+	if isBinaryConn(r) {
+		processRequestFunc = processBinaryRequest
+	}
+
 	scratchBuf := make([]byte, 0, 1024)
 	for {
-		if !processRequest(c, cache, &scratchBuf, &flushAllTimer) {
+		if !processRequestFunc(c, s, &scratchBuf, &flushAllTimer) {
 			break
 		}
 		if r.Buffered() == 0 {
@@ -660,6 +1361,15 @@ func handleConn(conn net.Conn, cache ybc.Cacher, readBufferSize, writeBufferSize
 	}
 }
 
+// Capabilities required from Server.Cache.
+type serverCacher interface {
+	ybc.Cacher
+	ybc.StatsCacher
+	ybc.CasCacher
+	ybc.StaleCacher
+	ybc.ScanCacher
+}
+
 // Memcache server.
 //
 // Usage:
@@ -682,6 +1392,8 @@ type Server struct {
 	// The cache must be initialized before passing it here.
 	//
 	// Currently ybc.Cache and ybc.Cluster may be passed here.
+	// The cache must also implement ybc.StatsCacher, ybc.CasCacher,
+	// ybc.StaleCacher and ybc.ScanCacher.
 	Cache ybc.Cacher
 
 	// TCP address to listen to. Must be in the form addr:port.
@@ -706,12 +1418,35 @@ type Server struct {
 	// Optional parameter.
 	OSWriteBufferSize int
 
+	// The maximum duration expired items may be returned by 'mg' meta
+	// command after their expiration.
+	//
+	// Such items are returned with X flag, i.e. they are stale.
+	// The first client obtaining the stale item additionally obtains
+	// W flag, i.e. it wins the right to recache the item, while other clients
+	// obtain Z flag. 'md' meta command with I flag makes the item stale
+	// instead of deleting it.
+	//
+	// Optional parameter. Stale items aren't returned by default.
+	StaleDuration time.Duration
+
+	cache        serverCacher
 	listenSocket *net.TCPListener
 	done         sync.WaitGroup
 	err          error
+	counters     *serverCounters
+	latencies    *serverLatencies
+	occupancy    *occupancyCache
+	startTime    time.Time
 }
 
 func (s *Server) init() {
+	cache, ok := s.Cache.(serverCacher)
+	if !ok {
+		log.Fatalf("Server.Cache of type %T must implement ybc.StatsCacher, ybc.CasCacher, ybc.StaleCacher and ybc.ScanCacher", s.Cache)
+	}
+	s.cache = cache
+
 	if s.ReadBufferSize == 0 {
 		s.ReadBufferSize = defaultReadBufferSize
 	}
@@ -724,6 +1459,10 @@ func (s *Server) init() {
 	if s.OSWriteBufferSize == 0 {
 		s.OSWriteBufferSize = defaultOSWriteBufferSize
 	}
+	s.counters = &serverCounters{}
+	s.latencies = newServerLatencies()
+	s.occupancy = &occupancyCache{}
+	s.startTime = time.Now()
 
 	listenAddr, err := net.ResolveTCPAddr("tcp", s.ListenAddr)
 	if err != nil {
@@ -768,7 +1507,7 @@ func (s *Server) run() {
 			log.Fatalf("Cannot set TCP write buffer size to %d: [%s]", s.OSWriteBufferSize, err)
 		}
 		connsDone.Add(1)
-		go handleConn(conn, s.Cache, s.ReadBufferSize, s.WriteBufferSize, connsDone)
+		go handleConn(conn, s, connsDone)
 	}
 }
 
@@ -117,6 +117,16 @@ static void p_lock_lock(struct p_lock *lock);
  */
 static void p_lock_unlock(struct p_lock *lock);
 
+/*
+ * Atomically adds delta to the counter pointed by dst.
+ */
+static void p_atomic_add(uint64_t *dst, uint64_t delta);
+
+/*
+ * Atomically loads the counter pointed by src.
+ */
+static uint64_t p_atomic_load(const uint64_t *src);
+
 /*
  * Event structure. Each platform may define arbitrary contents
  * for this structure.
@@ -212,6 +212,16 @@ static void p_lock_unlock(struct p_lock *const lock)
   (void)rv;
 }
 
+static void p_atomic_add(uint64_t *const dst, const uint64_t delta)
+{
+  (void)__atomic_fetch_add(dst, delta, __ATOMIC_RELAXED);
+}
+
+static uint64_t p_atomic_load(const uint64_t *const src)
+{
+  return __atomic_load_n(src, __ATOMIC_RELAXED);
+}
+
 struct p_event
 {
   pthread_cond_t cond;
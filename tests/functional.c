@@ -534,6 +534,85 @@ static void test_dogpile_effect_hashtable(struct ybc *const cache)
   }
 }
 
+static void expect_item_get_stale(struct ybc *const cache,
+    const struct ybc_key *const key,
+    const struct ybc_value *const expected_value, const uint64_t stale_ttl,
+    const int expected_is_stale, const int expected_should_refresh)
+{
+  char item_buf[ybc_item_get_size()];
+  struct ybc_item *const item = (struct ybc_item *)item_buf;
+  int is_stale, should_refresh;
+
+  if (!ybc_item_get_stale(cache, item, key, stale_ttl, &is_stale,
+      &should_refresh)) {
+    M_ERROR("cannot find expected item");
+  }
+  if (is_stale != expected_is_stale) {
+    M_ERROR("unexpected is_stale returned from ybc_item_get_stale()");
+  }
+  if (should_refresh != expected_should_refresh) {
+    M_ERROR("unexpected should_refresh returned from ybc_item_get_stale()");
+  }
+  expect_value(item, expected_value);
+  ybc_item_release(item);
+}
+
+static void test_stale_ops(struct ybc *const cache)
+{
+  char item_buf[ybc_item_get_size()];
+  struct ybc_item *const item = (struct ybc_item *)item_buf;
+  int is_stale, should_refresh;
+
+  m_open_anonymous(cache);
+
+  const struct ybc_key key = {
+      .ptr = "foo",
+      .size = 3,
+  };
+  const struct ybc_value value = {
+      .ptr = "bar",
+      .size = 3,
+      .ttl = 200,
+  };
+
+  /* Missing items aren't returned. */
+  if (ybc_item_get_stale(cache, item, &key, 10 * 1000, &is_stale,
+      &should_refresh)) {
+    M_ERROR("unexpected item found");
+  }
+
+  /* Not-yet expired items aren't stale. */
+  expect_item_set(cache, &key, &value);
+  expect_item_get_stale(cache, &key, &value, 10 * 1000, 0, 0);
+
+  p_sleep(300);
+  expect_item_miss(cache, &key);
+
+  /*
+   * Only the first caller should be asked refreshing the expired item,
+   * while other callers should receive the stale item.
+   */
+  expect_item_get_stale(cache, &key, &value, 1000, 1, 1);
+  expect_item_get_stale(cache, &key, &value, 1000, 1, 0);
+  expect_item_get_stale(cache, &key, &value, 1000, 1, 0);
+
+  /* The item expired more than stale_ttl ago mustn't be returned. */
+  if (ybc_item_get_stale(cache, item, &key, 50, &is_stale,
+      &should_refresh)) {
+    M_ERROR("unexpected item found");
+  }
+
+  /* The refresh should be requested again after grace period. */
+  p_sleep(1100);
+  expect_item_get_stale(cache, &key, &value, 10 * 1000, 1, 1);
+
+  /* Refreshed item isn't stale. */
+  expect_item_set(cache, &key, &value);
+  expect_item_get_stale(cache, &key, &value, 10 * 1000, 0, 0);
+
+  ybc_close(cache);
+}
+
 static void test_cluster_ops(const size_t cluster_size,
     const size_t iterations_count)
 {
@@ -788,26 +867,666 @@ static void test_instant_clear(struct ybc *const cache)
   struct ybc_key key;
   struct ybc_value value;
 
-  /* Add a lot of items to the cache */
+  /* Add a lot of items to the cache */
+  value.ttl = YBC_MAX_TTL;
+  for (size_t i = 0; i < 1000; ++i) {
+    key.ptr = &i;
+    key.size = sizeof(i);
+    value.ptr = &i;
+    value.size = sizeof(i);
+    expect_item_set(cache, &key, &value);
+  }
+
+  ybc_clear(cache);
+
+  /* Test that the cache doesn't contain any items after the clearance. */
+  for (size_t i = 0; i < 1000; ++i) {
+    key.ptr = &i;
+    key.size = sizeof(i);
+    expect_item_miss(cache, &key);
+  }
+
+  ybc_close(cache);
+}
+
+static size_t m_iterate_count(struct ybc *const cache)
+{
+  char item_buf[ybc_item_get_size()];
+  struct ybc_item *const item = (struct ybc_item *)item_buf;
+  struct ybc_key key;
+  struct ybc_value value;
+  size_t cursor = 0;
+  size_t items_count = 0;
+
+  while (ybc_iterate(cache, item, &key, &cursor)) {
+    ybc_item_get_value(item, &value);
+
+    /* Keys and values are equal in test_iterate(). */
+    assert(key.size == sizeof(size_t));
+    assert(value.size == sizeof(size_t));
+    assert(memcmp(key.ptr, value.ptr, key.size) == 0);
+
+    ybc_item_release(item);
+    ++items_count;
+  }
+  return items_count;
+}
+
+static void test_iterate(struct ybc *const cache)
+{
+  m_open_anonymous(cache);
+
+  assert(m_iterate_count(cache) == 0);
+
+  struct ybc_key key;
+  struct ybc_value value;
+
+  value.ttl = YBC_MAX_TTL;
+  for (size_t i = 0; i < 100; ++i) {
+    key.ptr = &i;
+    key.size = sizeof(i);
+    value.ptr = &i;
+    value.size = sizeof(i);
+    expect_item_set_no_acquire(cache, &key, &value);
+  }
+  assert(m_iterate_count(cache) == 100);
+
+  for (size_t i = 0; i < 10; ++i) {
+    key.ptr = &i;
+    key.size = sizeof(i);
+    expect_item_remove(cache, &key);
+  }
+  assert(m_iterate_count(cache) == 90);
+
+  /* Expired items must be skipped. */
+  value.ttl = 100;
+  for (size_t i = 100; i < 110; ++i) {
+    key.ptr = &i;
+    key.size = sizeof(i);
+    value.ptr = &i;
+    value.size = sizeof(i);
+    expect_item_set_no_acquire(cache, &key, &value);
+  }
+  assert(m_iterate_count(cache) == 100);
+  p_sleep(200);
+  assert(m_iterate_count(cache) == 90);
+
+  /* Cleared items must be skipped. */
+  ybc_clear(cache);
+  assert(m_iterate_count(cache) == 0);
+
+  ybc_close(cache);
+}
+
+static void test_occupancy(struct ybc *const cache)
+{
+  m_open_anonymous(cache);
+
+  struct ybc_occupancy occupancy;
+  ybc_get_occupancy(cache, &occupancy);
+  assert(occupancy.items_count == 0);
+  assert(occupancy.used_size == 0);
+  assert(occupancy.data_file_size > 0);
+
+  struct ybc_key key;
+  struct ybc_value value;
+
+  value.ttl = YBC_MAX_TTL;
+  for (size_t i = 0; i < 100; ++i) {
+    key.ptr = &i;
+    key.size = sizeof(i);
+    value.ptr = &i;
+    value.size = sizeof(i);
+    expect_item_set_no_acquire(cache, &key, &value);
+  }
+  ybc_get_occupancy(cache, &occupancy);
+  assert(occupancy.items_count == 100);
+  assert(occupancy.used_size >= 100 * (key.size + value.size));
+  assert(occupancy.used_size <= occupancy.data_file_size);
+
+  for (size_t i = 0; i < 10; ++i) {
+    key.ptr = &i;
+    key.size = sizeof(i);
+    expect_item_remove(cache, &key);
+  }
+  ybc_get_occupancy(cache, &occupancy);
+  assert(occupancy.items_count == 90);
+
+  /* Cleared items mustn't occupy the cache. */
+  ybc_clear(cache);
+  ybc_get_occupancy(cache, &occupancy);
+  assert(occupancy.items_count == 0);
+  assert(occupancy.used_size == 0);
+
+  ybc_close(cache);
+}
+
+static void test_stats(struct ybc *const cache)
+{
+  m_open_anonymous(cache);
+
+  struct ybc_stats stats;
+  ybc_get_stats(cache, &stats);
+  assert(stats.gets == 0);
+  assert(stats.sets == 0);
+
+  struct ybc_key key = {
+      .ptr = "aaa",
+      .size = 3,
+  };
+  const struct ybc_value value = {
+      .ptr = "1234",
+      .size = 4,
+      .ttl = YBC_MAX_TTL,
+  };
+
+  expect_item_miss(cache, &key);
+  expect_item_set_no_acquire(cache, &key, &value);
+  expect_item_remove(cache, &key);
+
+  char set_txn_buf[ybc_set_txn_get_size()];
+  struct ybc_set_txn *const txn = (struct ybc_set_txn *)set_txn_buf;
+  test_set_txn_rollback(cache, txn, &key, value.size);
+
+  ybc_get_stats(cache, &stats);
+  /*
+   * expect_item_set_no_acquire(), expect_item_remove()
+   * and test_set_txn_rollback() perform one lookup each in addition
+   * to the explicit miss above.
+   */
+  assert(stats.gets == 4);
+  assert(stats.hits == 1);
+  assert(stats.misses == 3);
+  assert(stats.sets == 1);
+  assert(stats.removes == 1);
+  assert(stats.set_txn_rollbacks == 1);
+  assert(stats.gets == stats.hits + stats.misses);
+
+  /*
+   * Waiting for the item must be counted only once regardless
+   * of the number of retries.
+   */
+  char item_buf[ybc_item_get_size()];
+  struct ybc_item *const item = (struct ybc_item *)item_buf;
+  if (ybc_item_get_de_async(cache, item, &key, 1000) != YBC_DE_NOTFOUND) {
+    M_ERROR("unexpected item found");
+  }
+  if (ybc_item_get_de_async(cache, item, &key, 1000) != YBC_DE_WOULDBLOCK) {
+    M_ERROR("the item must be pending");
+  }
+  for (int i = 0; i < 10; ++i) {
+    if (ybc_item_get_de_async_retry(cache, item, &key, 1000) !=
+        YBC_DE_WOULDBLOCK) {
+      M_ERROR("the item must be pending");
+    }
+  }
+  ybc_get_stats(cache, &stats);
+  assert(stats.gets == 6);
+  assert(stats.misses == 5);
+  assert(stats.de_waits == 1);
+
+  ybc_close(cache);
+}
+
+static uint64_t m_get_version(struct ybc *const cache,
+    const struct ybc_key *const key)
+{
+  char item_buf[ybc_item_get_size()];
+  struct ybc_item *const item = (struct ybc_item *)item_buf;
+
+  if (ybc_item_get(cache, item, key) != 1) {
+    M_ERROR("cannot find expected item");
+  }
+  const uint64_t version = ybc_item_get_version(item);
+  ybc_item_release(item);
+  return version;
+}
+
+static void test_item_versions(struct ybc *const cache)
+{
+  m_open_anonymous(cache);
+
+  struct ybc_key key = {
+      .ptr = "aaa",
+      .size = 3,
+  };
+  const struct ybc_value value1 = {
+      .ptr = "1234",
+      .size = 4,
+      .ttl = YBC_MAX_TTL,
+  };
+  const struct ybc_value value2 = {
+      .ptr = "qwerty",
+      .size = 6,
+      .ttl = YBC_MAX_TTL,
+  };
+
+  /* Conditional set must fail for missing item. */
+  if (ybc_item_set_if_version(cache, &key, &value1, 0) != YBC_SET_NOTFOUND) {
+    M_ERROR("conditional set must fail for missing item");
+  }
+  expect_item_miss(cache, &key);
+
+  expect_item_set(cache, &key, &value1);
+  const uint64_t version1 = m_get_version(cache, &key);
+  if (m_get_version(cache, &key) != version1) {
+    M_ERROR("version mustn't change on item read");
+  }
+
+  expect_item_set(cache, &key, &value1);
+  const uint64_t version2 = m_get_version(cache, &key);
+  if (version2 == version1) {
+    M_ERROR("version must change on item update");
+  }
+
+  /* Conditional set with outdated version must fail. */
+  if (ybc_item_set_if_version(cache, &key, &value2, version1) !=
+      YBC_SET_EXISTS) {
+    M_ERROR("conditional set must fail for outdated version");
+  }
+  expect_item_hit(cache, &key, &value1);
+
+  /* Conditional set with actual version must succeed. */
+  if (ybc_item_set_if_version(cache, &key, &value2, version2) !=
+      YBC_SET_SUCCESS) {
+    M_ERROR("conditional set must succeed for actual version");
+  }
+  expect_item_hit(cache, &key, &value2);
+  const uint64_t version3 = m_get_version(cache, &key);
+  if (version3 == version2) {
+    M_ERROR("version must change on conditional update");
+  }
+
+  /* Conditional commit for 'set' transaction. */
+  char set_txn_buf[ybc_set_txn_get_size()];
+  struct ybc_set_txn *const txn = (struct ybc_set_txn *)set_txn_buf;
+  struct ybc_set_txn_value txn_value;
+
+  if (!ybc_set_txn_begin(cache, txn, &key, value1.size, value1.ttl)) {
+    M_ERROR("error when starting 'set' transaction");
+  }
+  ybc_set_txn_get_value(txn, &txn_value);
+  memcpy(txn_value.ptr, value1.ptr, value1.size);
+  if (ybc_set_txn_commit_if_version(txn, version2) != YBC_SET_EXISTS) {
+    M_ERROR("conditional commit must fail for outdated version");
+  }
+  expect_item_hit(cache, &key, &value2);
+
+  if (!ybc_set_txn_begin(cache, txn, &key, value1.size, value1.ttl)) {
+    M_ERROR("error when starting 'set' transaction");
+  }
+  ybc_set_txn_get_value(txn, &txn_value);
+  memcpy(txn_value.ptr, value1.ptr, value1.size);
+  if (ybc_set_txn_commit_if_version(txn, version3) != YBC_SET_SUCCESS) {
+    M_ERROR("conditional commit must succeed for actual version");
+  }
+  expect_item_hit(cache, &key, &value1);
+
+  /* Conditional set must fail for removed item. */
+  const uint64_t version4 = m_get_version(cache, &key);
+  expect_item_remove(cache, &key);
+  if (ybc_item_set_if_version(cache, &key, &value2, version4) !=
+      YBC_SET_NOTFOUND) {
+    M_ERROR("conditional set must fail for removed item");
+  }
+  expect_item_miss(cache, &key);
+
+  /* Conditional remove. */
+  expect_item_set(cache, &key, &value1);
+  const uint64_t version5 = m_get_version(cache, &key);
+  if (ybc_item_remove_if_version(cache, &key, version4) != YBC_SET_EXISTS) {
+    M_ERROR("conditional remove must fail for outdated version");
+  }
+  expect_item_hit(cache, &key, &value1);
+  if (ybc_item_remove_if_version(cache, &key, version5) != YBC_SET_SUCCESS) {
+    M_ERROR("conditional remove must succeed for actual version");
+  }
+  expect_item_miss(cache, &key);
+  if (ybc_item_remove_if_version(cache, &key, version5) != YBC_SET_NOTFOUND) {
+    M_ERROR("conditional remove must fail for removed item");
+  }
+
+  ybc_close(cache);
+}
+
+static void test_item_add(struct ybc *const cache)
+{
+  m_open_anonymous(cache);
+
+  struct ybc_key key = {
+      .ptr = "aaa",
+      .size = 3,
+  };
+  const struct ybc_value value1 = {
+      .ptr = "1234",
+      .size = 4,
+      .ttl = YBC_MAX_TTL,
+  };
+  const struct ybc_value value2 = {
+      .ptr = "qwerty",
+      .size = 6,
+      .ttl = YBC_MAX_TTL,
+  };
+
+  /* Add must succeed for missing item. */
+  if (ybc_item_add(cache, &key, &value1) != YBC_SET_SUCCESS) {
+    M_ERROR("add must succeed for missing item");
+  }
+  expect_item_hit(cache, &key, &value1);
+
+  /* Add mustn't overwrite existing item. */
+  if (ybc_item_add(cache, &key, &value2) != YBC_SET_EXISTS) {
+    M_ERROR("add must fail for existing item");
+  }
+  expect_item_hit(cache, &key, &value1);
+
+  /* Add-only commit for 'set' transaction. */
+  char set_txn_buf[ybc_set_txn_get_size()];
+  struct ybc_set_txn *const txn = (struct ybc_set_txn *)set_txn_buf;
+  struct ybc_set_txn_value txn_value;
+
+  if (!ybc_set_txn_begin(cache, txn, &key, value2.size, value2.ttl)) {
+    M_ERROR("error when starting 'set' transaction");
+  }
+  ybc_set_txn_get_value(txn, &txn_value);
+  memcpy(txn_value.ptr, value2.ptr, value2.size);
+  if (ybc_set_txn_commit_add(txn) != YBC_SET_EXISTS) {
+    M_ERROR("add-only commit must fail for existing item");
+  }
+  expect_item_hit(cache, &key, &value1);
+
+  expect_item_remove(cache, &key);
+  if (!ybc_set_txn_begin(cache, txn, &key, value2.size, value2.ttl)) {
+    M_ERROR("error when starting 'set' transaction");
+  }
+  ybc_set_txn_get_value(txn, &txn_value);
+  memcpy(txn_value.ptr, value2.ptr, value2.size);
+  if (ybc_set_txn_commit_add(txn) != YBC_SET_SUCCESS) {
+    M_ERROR("add-only commit must succeed for removed item");
+  }
+  expect_item_hit(cache, &key, &value2);
+
+  /* Add must overwrite expired item. */
+  key.ptr = "bbb";
+  const struct ybc_value short_value = {
+      .ptr = "1234",
+      .size = 4,
+      .ttl = 200,
+  };
+  expect_item_set(cache, &key, &short_value);
+  p_sleep(300);
+  if (ybc_item_add(cache, &key, &value2) != YBC_SET_SUCCESS) {
+    M_ERROR("add must succeed for expired item");
+  }
+  expect_item_hit(cache, &key, &value2);
+
+  ybc_close(cache);
+}
+
+static void expect_item_incr(struct ybc *const cache,
+    const struct ybc_key *const key, const uint64_t delta,
+    const int is_decrement, const uint64_t expected_value)
+{
+  uint64_t value;
+
+  if (ybc_item_incr(cache, key, delta, is_decrement, &value) !=
+      YBC_SET_SUCCESS) {
+    M_ERROR("cannot increment the counter");
+  }
+  if (value != expected_value) {
+    M_ERROR("unexpected counter value");
+  }
+}
+
+static void test_item_incr(struct ybc *const cache)
+{
+  m_open_anonymous(cache);
+
+  struct ybc_key key = {
+      .ptr = "aaa",
+      .size = 3,
+  };
+  struct ybc_value value = {
+      .ptr = "10",
+      .size = 2,
+      .ttl = YBC_MAX_TTL,
+  };
+  uint64_t n;
+
+  /* Missing counters mustn't be created. */
+  if (ybc_item_incr(cache, &key, 1, 0, &n) != YBC_SET_NOTFOUND) {
+    M_ERROR("increment must fail for missing counter");
+  }
+  expect_item_miss(cache, &key);
+
+  expect_item_set(cache, &key, &value);
+  expect_item_incr(cache, &key, 5, 0, 15);
+  expect_item_incr(cache, &key, 100, 0, 115);
+  expect_item_incr(cache, &key, 15, 1, 100);
+  value.ptr = "100";
+  value.size = 3;
+  expect_item_hit(cache, &key, &value);
+
+  /* Decrement mustn't underflow. */
+  expect_item_incr(cache, &key, 1000, 1, 0);
+  value.ptr = "0";
+  value.size = 1;
+  expect_item_hit(cache, &key, &value);
+
+  /* Increment must wrap around on overflow. */
+  value.ptr = "18446744073709551615";
+  value.size = 20;
+  expect_item_set(cache, &key, &value);
+  expect_item_incr(cache, &key, 2, 0, 1);
+
+  /* Non-numeric values mustn't be modified. */
+  const char *const bad_values[] = {
+      "", "abc", "-1", "1 ", "12a", "18446744073709551616",
+      "000000000000000000001",
+  };
+  for (size_t i = 0; i < sizeof(bad_values) / sizeof(bad_values[0]); ++i) {
+    value.ptr = bad_values[i];
+    value.size = strlen(bad_values[i]);
+    expect_item_set(cache, &key, &value);
+    if (ybc_item_incr(cache, &key, 1, 0, &n) != YBC_SET_NOTNUMERIC) {
+      M_ERROR("increment must fail for non-numeric value");
+    }
+    expect_item_hit(cache, &key, &value);
+  }
+
+  /* Counters must retain expiration time. */
+  key.ptr = "bbb";
+  value.ptr = "1";
+  value.size = 1;
+  value.ttl = 200;
+  expect_item_set(cache, &key, &value);
+  expect_item_incr(cache, &key, 1, 0, 2);
+  p_sleep(300);
+  expect_item_miss(cache, &key);
+
+  ybc_close(cache);
+}
+
+static void test_item_touch(struct ybc *const cache)
+{
+  m_open_anonymous(cache);
+
+  const struct ybc_key key = {
+      .ptr = "aaa",
+      .size = 3,
+  };
+  const struct ybc_value value = {
+      .ptr = "1234",
+      .size = 4,
+      .ttl = 200,
+  };
+  const struct ybc_value touched_value = {
+      .ptr = value.ptr,
+      .size = value.size,
+      .ttl = YBC_MAX_TTL,
+  };
+
+  /* Touch must fail for missing item. */
+  if (ybc_item_touch(cache, &key, YBC_MAX_TTL)) {
+    M_ERROR("touch must fail for missing item");
+  }
+  expect_item_miss(cache, &key);
+
+  expect_item_set(cache, &key, &value);
+
+  /* Move the item into hot items' cache before touching it. */
+  for (size_t i = 0; i < 10; ++i) {
+    expect_item_hit(cache, &key, &value);
+  }
+  const uint64_t version = m_get_version(cache, &key);
+
+  if (!ybc_item_touch(cache, &key, YBC_MAX_TTL)) {
+    M_ERROR("cannot touch the item");
+  }
+  if (m_get_version(cache, &key) != version) {
+    M_ERROR("version mustn't change on touch");
+  }
+  p_sleep(300);
+
+  /* The item mustn't expire after the touch. */
+  expect_item_hit(cache, &key, &touched_value);
+
+  if (!ybc_item_touch(cache, &key, 100)) {
+    M_ERROR("cannot touch the item");
+  }
+  p_sleep(200);
+  expect_item_miss(cache, &key);
+  if (ybc_item_touch(cache, &key, YBC_MAX_TTL)) {
+    M_ERROR("touch must fail for expired item");
+  }
+
+  ybc_close(cache);
+}
+
+struct evict_ctx
+{
+  size_t counts[4];
+  char last_key[8];
+  size_t last_key_size;
+};
+
+static void evict_callback(void *const ctx, const struct ybc_key *const key,
+    const enum ybc_evict_reason reason)
+{
+  struct evict_ctx *const ec = ctx;
+
+  assert((size_t)reason < sizeof(ec->counts) / sizeof(ec->counts[0]));
+  ++ec->counts[reason];
+
+  assert(key->size <= sizeof(ec->last_key));
+  memcpy(ec->last_key, key->ptr, key->size);
+  ec->last_key_size = key->size;
+}
+
+static void expect_eviction(struct evict_ctx *const ec,
+    const enum ybc_evict_reason reason, const size_t expected_count,
+    const struct ybc_key *const expected_key)
+{
+  if (ec->counts[reason] != expected_count) {
+    M_ERROR("unexpected number of evicted items");
+  }
+  if (expected_key != NULL && (ec->last_key_size != expected_key->size ||
+      memcmp(ec->last_key, expected_key->ptr, expected_key->size) != 0)) {
+    M_ERROR("unexpected key of evicted item");
+  }
+}
+
+static void test_evict_callback(struct ybc *const cache)
+{
+  char config_buf[ybc_config_get_size()];
+  struct ybc_config *const config = (struct ybc_config *)config_buf;
+  struct evict_ctx ec;
+
+  memset(&ec, 0, sizeof(ec));
+  ybc_config_init(config);
+  ybc_config_set_max_items_count(config, 1000);
+  ybc_config_set_data_file_size(config, 4096);
+  ybc_config_set_evict_callback(config, evict_callback, &ec);
+
+  if (!ybc_open(cache, config, 1)) {
+    M_ERROR("cannot create anonymous cache");
+  }
+
+  struct ybc_key key = {
+      .ptr = "aaa",
+      .size = 3,
+  };
+  struct ybc_value value = {
+      .ptr = "1234",
+      .size = 4,
+      .ttl = YBC_MAX_TTL,
+  };
+
+  /* Removed items must be reported. */
+  expect_item_set(cache, &key, &value);
+  expect_item_remove(cache, &key);
+  expect_eviction(&ec, YBC_EVICT_REMOVE, 1, &key);
+
+  /* Replaced items mustn't be reported. */
+  expect_item_set(cache, &key, &value);
+  expect_item_set(cache, &key, &value);
+  expect_eviction(&ec, YBC_EVICT_REMOVE, 1, NULL);
+  expect_eviction(&ec, YBC_EVICT_EXPIRED, 0, NULL);
+  expect_eviction(&ec, YBC_EVICT_WRAP, 0, NULL);
+
+  /* Expired items must be reported only once on lookup. */
+  key.ptr = "bbb";
+  value.ttl = 100;
+  expect_item_set(cache, &key, &value);
+  p_sleep(200);
+  expect_item_miss(cache, &key);
+  expect_item_miss(cache, &key);
+  expect_eviction(&ec, YBC_EVICT_EXPIRED, 1, &key);
+
+  /* Overwritten items must be reported only once on lookup. */
+  key.ptr = "ccc";
   value.ttl = YBC_MAX_TTL;
-  for (size_t i = 0; i < 1000; ++i) {
-    key.ptr = &i;
-    key.size = sizeof(i);
-    value.ptr = &i;
-    value.size = sizeof(i);
-    expect_item_set(cache, &key, &value);
+  expect_item_set(cache, &key, &value);
+
+  char buf[100];
+  memset(buf, 'x', sizeof(buf));
+  struct ybc_key tmp_key;
+  const struct ybc_value tmp_value = {
+      .ptr = buf,
+      .size = sizeof(buf),
+      .ttl = YBC_MAX_TTL,
+  };
+  for (size_t i = 0; i < 100; ++i) {
+    tmp_key.ptr = &i;
+    tmp_key.size = sizeof(i);
+    expect_item_set_no_acquire(cache, &tmp_key, &tmp_value);
   }
+  expect_item_miss(cache, &key);
+  expect_item_miss(cache, &key);
+  expect_eviction(&ec, YBC_EVICT_WRAP, 1, &key);
 
-  ybc_clear(cache);
+  /* Only overwritten items must be counted as evictions. */
+  struct ybc_stats stats;
+  ybc_get_stats(cache, &stats);
+  assert(stats.evictions == 1);
+  ybc_close(cache);
 
-  /* Test that the cache doesn't contain any items after the clearance. */
-  for (size_t i = 0; i < 1000; ++i) {
-    key.ptr = &i;
-    key.size = sizeof(i);
-    expect_item_miss(cache, &key);
+  /* Cleared items must be reported. */
+  if (!ybc_open(cache, config, 1)) {
+    M_ERROR("cannot create anonymous cache");
   }
-
+  key.ptr = "ddd";
+  expect_item_set(cache, &key, &value);
+  key.ptr = "eee";
+  expect_item_set(cache, &key, &value);
+  ybc_clear(cache);
+  expect_eviction(&ec, YBC_EVICT_CLEAR, 2, NULL);
+  expect_item_miss(cache, &key);
+  expect_eviction(&ec, YBC_EVICT_EXPIRED, 1, NULL);
+  expect_eviction(&ec, YBC_EVICT_WRAP, 1, NULL);
   ybc_close(cache);
+
+  ybc_config_destroy(config);
 }
 
 static void expect_persistent_survival(struct ybc *const cache,
@@ -869,6 +1588,169 @@ static void test_persistent_survival(struct ybc *const cache)
   expect_persistent_survival(cache, 0);
 }
 
+static void m_open_persistent_checksums(struct ybc *const cache,
+    struct ybc_config *const config, const int has_value_checksums)
+{
+  ybc_config_init(config);
+
+  ybc_config_set_index_file(config, "./tmp_cache.index");
+  ybc_config_set_data_file(config, "./tmp_cache.data");
+  ybc_config_set_max_items_count(config, 10);
+  ybc_config_set_data_file_size(config, 1024);
+  if (has_value_checksums) {
+    ybc_config_enable_value_checksums(config);
+  }
+
+  if (!ybc_open(cache, config, 1)) {
+    M_ERROR("cannot open persistent cache");
+  }
+}
+
+static void test_value_checksums(struct ybc *const cache)
+{
+  char config_buf[ybc_config_get_size()];
+  struct ybc_config *const config = (struct ybc_config *)config_buf;
+  char item_buf[ybc_item_get_size()];
+  struct ybc_item *const item = (struct ybc_item *)item_buf;
+
+  const struct ybc_key key = {
+      .ptr = "foobar",
+      .size = 6,
+  };
+  const struct ybc_value value = {
+      .ptr = "qwert",
+      .size = 5,
+      .ttl = YBC_MAX_TTL,
+  };
+
+  m_open_persistent_checksums(cache, config, 1);
+
+  expect_item_set(cache, &key, &value);
+  expect_item_set_no_acquire(cache, &key, &value);
+
+  /* Corrupt item's value. */
+  if (ybc_item_get(cache, item, &key) != 1) {
+    M_ERROR("cannot find expected item");
+  }
+  struct ybc_value tmp_value;
+  ybc_item_get_value(item, &tmp_value);
+  ((char *)tmp_value.ptr)[2] ^= 1;
+  ybc_item_release(item);
+
+  /* Corrupted item must be detected and evicted. */
+  if (ybc_item_get(cache, item, &key) != -1) {
+    M_ERROR("corrupted item must be detected");
+  }
+  expect_item_miss(cache, &key);
+
+  struct ybc_stats stats;
+  ybc_get_stats(cache, &stats);
+  assert(stats.checksum_mismatches == 1);
+
+  /* The same key can be stored again. */
+  expect_item_set(cache, &key, &value);
+
+  ybc_close(cache);
+  ybc_config_destroy(config);
+
+  /* Items with checksums must be invisible if checksums are disabled. */
+  m_open_persistent_checksums(cache, config, 0);
+  expect_item_miss(cache, &key);
+  expect_item_set(cache, &key, &value);
+  ybc_close(cache);
+  ybc_config_destroy(config);
+
+  /* Items without checksums must be invisible if checksums are enabled. */
+  m_open_persistent_checksums(cache, config, 1);
+  expect_item_miss(cache, &key);
+  ybc_close(cache);
+
+  ybc_remove(config);
+  ybc_config_destroy(config);
+}
+
+static void test_migrate(struct ybc *const cache)
+{
+  char configs_buf[ybc_config_get_size() * 2];
+  struct ybc_config *const old_config = YBC_CONFIG_GET(configs_buf, 0);
+  struct ybc_config *const new_config = YBC_CONFIG_GET(configs_buf, 1);
+
+  ybc_config_init(old_config);
+  ybc_config_set_index_file(old_config, "./tmp_cache.index");
+  ybc_config_set_data_file(old_config, "./tmp_cache.data");
+  ybc_config_set_max_items_count(old_config, 10);
+  ybc_config_set_data_file_size(old_config, 1024);
+
+  ybc_config_init(new_config);
+  ybc_config_set_index_file(new_config, "./tmp_cache_new.index");
+  ybc_config_set_data_file(new_config, "./tmp_cache_new.data");
+  ybc_config_set_max_items_count(new_config, 1000);
+  ybc_config_set_data_file_size(new_config, 64 * 1024);
+
+  /* Missing cache cannot be migrated. */
+  if (ybc_migrate(old_config, new_config)) {
+    M_ERROR("missing cache mustn't be migrated");
+  }
+
+  struct ybc_key key = {
+      .ptr = "foo",
+      .size = 3,
+  };
+  const struct ybc_value value = {
+      .ptr = "bar",
+      .size = 3,
+      .ttl = YBC_MAX_TTL,
+  };
+  const struct ybc_value short_value = {
+      .ptr = "baz",
+      .size = 3,
+      .ttl = 200,
+  };
+
+  if (!ybc_open(cache, old_config, 1)) {
+    M_ERROR("cannot create persistent cache");
+  }
+  expect_item_set(cache, &key, &value);
+  key.ptr = "aaa";
+  expect_item_set(cache, &key, &short_value);
+  ybc_close(cache);
+
+  /* A cache cannot be migrated into itself. */
+  if (ybc_migrate(old_config, old_config)) {
+    M_ERROR("cache mustn't be migrated into itself");
+  }
+
+  if (!ybc_migrate(old_config, new_config)) {
+    M_ERROR("cannot migrate the cache");
+  }
+
+  if (!ybc_open(cache, new_config, 0)) {
+    M_ERROR("cannot open migrated cache");
+  }
+  key.ptr = "foo";
+  expect_item_hit(cache, &key, &value);
+  key.ptr = "aaa";
+  expect_item_hit(cache, &key, &short_value);
+
+  /* Migrated items must retain expiration time. */
+  p_sleep(300);
+  expect_item_miss(cache, &key);
+  ybc_close(cache);
+
+  /* The old cache must remain intact. */
+  if (!ybc_open(cache, old_config, 0)) {
+    M_ERROR("cannot open the old cache");
+  }
+  key.ptr = "foo";
+  expect_item_hit(cache, &key, &value);
+  ybc_close(cache);
+
+  ybc_remove(new_config);
+  ybc_remove(old_config);
+  ybc_config_destroy(new_config);
+  ybc_config_destroy(old_config);
+}
+
 static void test_broken_index_handling(struct ybc *const cache)
 {
   char config_buf[ybc_config_get_size()];
@@ -1345,6 +2227,212 @@ static void test_multithreaded_access(struct ybc *const cache,
   ybc_close(cache);
 }
 
+struct versions_thread_task
+{
+  struct ybc *const cache;
+  const size_t increments_count;
+};
+
+static void versions_thread_func(void *const ctx)
+{
+  struct versions_thread_task *const task = ctx;
+
+  char item_buf[ybc_item_get_size()];
+  struct ybc_item *const item = (struct ybc_item *)item_buf;
+
+  const struct ybc_key key = {
+      .ptr = "counter",
+      .size = 7,
+  };
+  struct ybc_value value;
+  uint64_t counter;
+
+  for (size_t i = 0; i < task->increments_count; ++i) {
+    enum ybc_set_status status;
+    do {
+      if (ybc_item_get(task->cache, item, &key) != 1) {
+        M_ERROR("cannot find the counter");
+      }
+      const uint64_t version = ybc_item_get_version(item);
+      ybc_item_get_value(item, &value);
+      assert(value.size == sizeof(counter));
+      memcpy(&counter, value.ptr, sizeof(counter));
+      ybc_item_release(item);
+
+      ++counter;
+      value.ptr = &counter;
+      value.ttl = YBC_MAX_TTL;
+      status = ybc_item_set_if_version(task->cache, &key, &value, version);
+    } while (status == YBC_SET_EXISTS);
+
+    if (status != YBC_SET_SUCCESS) {
+      M_ERROR("cannot update the counter");
+    }
+  }
+}
+
+static void test_multithreaded_versions(struct ybc *const cache,
+    const size_t threads_count)
+{
+  m_open_anonymous(cache);
+
+  const struct ybc_key key = {
+      .ptr = "counter",
+      .size = 7,
+  };
+  uint64_t counter = 0;
+  const struct ybc_value value = {
+      .ptr = &counter,
+      .size = sizeof(counter),
+      .ttl = YBC_MAX_TTL,
+  };
+  expect_item_set(cache, &key, &value);
+
+  struct p_thread threads[threads_count];
+  struct versions_thread_task task = {
+      .cache = cache,
+      .increments_count = 1000,
+  };
+
+  for (size_t i = 0; i < threads_count; ++i) {
+    p_thread_init_and_start(&threads[i], versions_thread_func, &task);
+  }
+  for (size_t i = 0; i < threads_count; ++i) {
+    p_thread_join_and_destroy(&threads[i]);
+  }
+
+  /* Concurrent conditional updates mustn't be lost. */
+  counter = threads_count * task.increments_count;
+  expect_item_hit(cache, &key, &value);
+
+  ybc_close(cache);
+}
+
+struct incr_thread_task
+{
+  struct ybc *const cache;
+  const size_t increments_count;
+};
+
+static void incr_thread_func(void *const ctx)
+{
+  struct incr_thread_task *const task = ctx;
+
+  const struct ybc_key key = {
+      .ptr = "counter",
+      .size = 7,
+  };
+  uint64_t value;
+
+  for (size_t i = 0; i < task->increments_count; ++i) {
+    if (ybc_item_incr(task->cache, &key, 3, 0, &value) != YBC_SET_SUCCESS ||
+        ybc_item_incr(task->cache, &key, 1, 1, &value) != YBC_SET_SUCCESS) {
+      M_ERROR("cannot update the counter");
+    }
+  }
+}
+
+static void test_multithreaded_incr(struct ybc *const cache,
+    const size_t threads_count)
+{
+  m_open_anonymous(cache);
+
+  const struct ybc_key key = {
+      .ptr = "counter",
+      .size = 7,
+  };
+  const struct ybc_value value = {
+      .ptr = "0",
+      .size = 1,
+      .ttl = YBC_MAX_TTL,
+  };
+  expect_item_set(cache, &key, &value);
+
+  struct p_thread threads[threads_count];
+  struct incr_thread_task task = {
+      .cache = cache,
+      .increments_count = 1000,
+  };
+
+  for (size_t i = 0; i < threads_count; ++i) {
+    p_thread_init_and_start(&threads[i], incr_thread_func, &task);
+  }
+  for (size_t i = 0; i < threads_count; ++i) {
+    p_thread_join_and_destroy(&threads[i]);
+  }
+
+  /* Concurrent increments and decrements mustn't be lost. */
+  expect_item_incr(cache, &key, 0, 0,
+      threads_count * task.increments_count * 2);
+
+  ybc_close(cache);
+}
+
+struct add_thread_task
+{
+  struct ybc *const cache;
+  const size_t keys_count;
+  size_t added_count;
+};
+
+static void add_thread_func(void *const ctx)
+{
+  struct add_thread_task *const task = ctx;
+
+  struct ybc_key key;
+  const struct ybc_value value = {
+      .ptr = "value",
+      .size = 5,
+      .ttl = YBC_MAX_TTL,
+  };
+
+  for (size_t i = 0; i < task->keys_count; ++i) {
+    key.ptr = &i;
+    key.size = sizeof(i);
+    const enum ybc_set_status status = ybc_item_add(task->cache, &key,
+        &value);
+    if (status == YBC_SET_SUCCESS) {
+      ++task->added_count;
+    }
+    else if (status != YBC_SET_EXISTS) {
+      M_ERROR("cannot add an item");
+    }
+  }
+}
+
+static void test_multithreaded_add(struct ybc *const cache,
+    const size_t threads_count)
+{
+  m_open_anonymous(cache);
+
+  struct p_thread threads[threads_count];
+  struct add_thread_task tasks[threads_count];
+  const size_t keys_count = 1000;
+
+  for (size_t i = 0; i < threads_count; ++i) {
+    struct add_thread_task task = {
+        .cache = cache,
+        .keys_count = keys_count,
+        .added_count = 0,
+    };
+    memcpy(&tasks[i], &task, sizeof(task));
+    p_thread_init_and_start(&threads[i], add_thread_func, &tasks[i]);
+  }
+
+  size_t added_count = 0;
+  for (size_t i = 0; i < threads_count; ++i) {
+    p_thread_join_and_destroy(&threads[i]);
+    added_count += tasks[i].added_count;
+  }
+
+  /* Each item must be added exactly once. */
+  if (added_count != keys_count) {
+    M_ERROR("unexpected number of added items");
+  }
+
+  ybc_close(cache);
+}
+
 int main(void)
 {
   char cache_buf[ybc_get_size()];
@@ -1359,13 +2447,24 @@ int main(void)
   test_dogpile_effect_ops_async(cache);
   test_dogpile_effect_ops(cache);
   test_dogpile_effect_hashtable(cache);
+  test_stale_ops(cache);
   test_cluster_ops(5, 1000);
   test_simple_ops(cache);
 
   test_overlapped_acquirements(cache, 1000);
   test_interleaved_sets(cache);
   test_instant_clear(cache);
+  test_stats(cache);
+  test_iterate(cache);
+  test_occupancy(cache);
+  test_item_versions(cache);
+  test_item_add(cache);
+  test_item_incr(cache);
+  test_item_touch(cache);
+  test_evict_callback(cache);
   test_persistent_survival(cache);
+  test_value_checksums(cache);
+  test_migrate(cache);
   test_broken_index_handling(cache);
   test_large_cache(cache);
   test_overwrite_protection(cache);
@@ -1378,6 +2477,9 @@ int main(void)
   test_disabled_syncing(cache);
 
   test_multithreaded_access(cache, 100);
+  test_multithreaded_versions(cache, 10);
+  test_multithreaded_add(cache, 10);
+  test_multithreaded_incr(cache, 10);
 
   printf("All functional tests done\n");
   return 0;
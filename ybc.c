@@ -217,6 +217,13 @@ struct m_storage
    */
   uint64_t hash_seed;
 
+  /*
+   * Whether items' metadata contains value checksums.
+   *
+   * See ybc_config_enable_value_checksums() for details.
+   */
+  int has_value_checksums;
+
   /*
    * A pointer to the beginning of the storage.
    */
@@ -631,48 +638,117 @@ static int m_storage_payload_check(const struct m_storage *const storage,
   return 1;
 }
 
-static size_t m_storage_metadata_get_size(const size_t key_size) {
+static int m_storage_payload_equal(const struct m_storage_payload *const a,
+    const struct m_storage_payload *const b)
+{
+  return (a->cursor.wrap_count == b->cursor.wrap_count &&
+      a->cursor.offset == b->cursor.offset &&
+      a->expiration_time == b->expiration_time && a->size == b->size);
+}
+
+/*
+ * Storage format version.
+ *
+ * It must be incremented on each change in payload metadata layout,
+ * so items stored in older formats appear as missing instead of being
+ * misinterpreted.
+ *
+ * Versions:
+ * 0 - the initial format.
+ * 1 - item version has been added to payload metadata.
+ */
+static const size_t M_STORAGE_FORMAT_VERSION = 1;
+
+static size_t m_storage_metadata_get_size(
+    const struct m_storage *const storage, const size_t key_size)
+{
   /*
    * Payload metadata contains the following fields:
    * - digest (key size ^ payload size ^ hash seed)
+   * - item version
    * - key data
+   * - value checksum (only if value checksums are enabled)
    */
-  static const size_t const_metadata_size = sizeof(size_t);
+  const size_t const_metadata_size = sizeof(size_t) + sizeof(uint64_t) +
+      (storage->has_value_checksums ? sizeof(uint64_t) : 0);
 
   assert(key_size <= SIZE_MAX - const_metadata_size);
   return key_size + const_metadata_size;
 }
 
-static size_t m_storage_metadata_get_digest(const uint64_t hash_seed,
-    const size_t key_size, const size_t payload_size)
+static size_t m_storage_metadata_get_digest(
+    const struct m_storage *const storage, const size_t key_size,
+    const size_t payload_size)
+{
+  /*
+   * Mix the storage format version into the digest, so items
+   * stored in other formats fail digest validation. The format version 0
+   * leaves the digest intact for compatibility with the initial format.
+   */
+  const size_t format_salt = M_STORAGE_FORMAT_VERSION * (size_t)0x9e3779b9;
+  const size_t digest = (size_t)storage->hash_seed ^ format_salt ^ key_size ^
+      payload_size;
+
+  /*
+   * Items with and without value checksums have distinct metadata layout,
+   * so make their digests distinct. This prevents from misinterpreting items
+   * stored before value checksums have been enabled or disabled.
+   */
+  return storage->has_value_checksums ? ~digest : digest;
+}
+
+static uint64_t m_storage_checksum_get(const void *const ptr,
+    const size_t size)
 {
-  return (size_t)hash_seed ^ key_size ^ payload_size;
+  return m_hash_get(0, ptr, size);
 }
 
 static void m_storage_metadata_save(
     const struct m_storage *const storage,
     const struct m_storage_payload *const payload,
-    const struct ybc_key *const key)
+    const struct ybc_key *const key, const uint64_t version)
 {
-  const size_t metadata_size = m_storage_metadata_get_size(key->size);
+  const size_t metadata_size = m_storage_metadata_get_size(storage, key->size);
   char *ptr = m_storage_get_ptr(storage, payload->cursor.offset);
   assert(((uintptr_t)ptr) <= UINTPTR_MAX - metadata_size);
   (void)metadata_size;
 
-  const size_t digest = m_storage_metadata_get_digest(storage->hash_seed,
-      key->size, payload->size);
+  const size_t digest = m_storage_metadata_get_digest(storage, key->size,
+      payload->size);
   memcpy(ptr, &digest, sizeof(digest));
 
   ptr += sizeof(digest);
+  memcpy(ptr, &version, sizeof(version));
+
+  ptr += sizeof(version);
   memcpy(ptr, key->ptr, key->size);
 }
 
+static uint64_t m_storage_metadata_get_version(
+    const struct m_storage *const storage,
+    const struct m_storage_payload *const payload)
+{
+  uint64_t version;
+
+  const char *const ptr = m_storage_get_ptr(storage, payload->cursor.offset);
+  memcpy(&version, ptr + sizeof(size_t), sizeof(version));
+  return version;
+}
+
+static void m_storage_metadata_set_version(
+    const struct m_storage *const storage,
+    const struct m_storage_payload *const payload, const uint64_t version)
+{
+  char *const ptr = m_storage_get_ptr(storage, payload->cursor.offset);
+  memcpy(ptr + sizeof(size_t), &version, sizeof(version));
+}
+
 static void m_storage_metadata_update_payload_size(
     const struct m_storage *const storage,
     const struct m_storage_payload *const payload,
     const size_t old_payload_size, const size_t key_size)
 {
-  const size_t metadata_size = m_storage_metadata_get_size(key_size);
+  const size_t metadata_size = m_storage_metadata_get_size(storage, key_size);
   char *ptr = m_storage_get_ptr(storage, payload->cursor.offset);
   assert(((uintptr_t)ptr) <= UINTPTR_MAX - metadata_size);
   (void)metadata_size;
@@ -683,6 +759,53 @@ static void m_storage_metadata_update_payload_size(
   memcpy(ptr, &digest, sizeof(digest));
 }
 
+/*
+ * Returns a pointer to the key in the metadata for an item with the given
+ * payload.
+ */
+static const char *m_storage_metadata_get_key_ptr(
+    const struct m_storage *const storage,
+    const struct m_storage_payload *const payload)
+{
+  const char *const ptr = m_storage_get_ptr(storage, payload->cursor.offset);
+  return ptr + sizeof(size_t) + sizeof(uint64_t);
+}
+
+/*
+ * Extracts the key from metadata for an item with the given payload.
+ *
+ * The key size is recovered from the digest stored in the metadata.
+ * The returned key points to the storage, so it remains valid only while
+ * the corresponding item is acquired.
+ *
+ * Returns non-zero on success, zero if the metadata is broken.
+ */
+static int m_storage_metadata_get_key(const struct m_storage *const storage,
+    const struct m_storage_payload *const payload, struct ybc_key *const key)
+{
+  size_t digest;
+  const size_t const_metadata_size = m_storage_metadata_get_size(storage, 0);
+
+  if (payload->size < const_metadata_size) {
+    return 0;
+  }
+
+  const char *const ptr = m_storage_get_ptr(storage, payload->cursor.offset);
+  memcpy(&digest, ptr, sizeof(digest));
+
+  /* See m_storage_metadata_get_digest() for digest's structure. */
+  const size_t key_size = digest ^ m_storage_metadata_get_digest(storage, 0,
+      payload->size);
+  if (key_size > payload->size - const_metadata_size) {
+    /* Invalid key size. */
+    return 0;
+  }
+
+  key->ptr = m_storage_metadata_get_key_ptr(storage, payload);
+  key->size = key_size;
+  return 1;
+}
+
 /*
  * Checks metadata correctness for an item with the given payload.
  *
@@ -695,7 +818,7 @@ static int m_storage_metadata_check(const struct m_storage *const storage,
     const struct m_storage_payload *const payload,
     const struct ybc_key *const key)
 {
-  const size_t metadata_size = m_storage_metadata_get_size(key->size);
+  const size_t metadata_size = m_storage_metadata_get_size(storage, key->size);
 
   if (payload->size < metadata_size) {
     /*
@@ -708,15 +831,16 @@ static int m_storage_metadata_check(const struct m_storage *const storage,
   const char *ptr = m_storage_get_ptr(storage, payload->cursor.offset);
   assert(((uintptr_t)ptr) <= UINTPTR_MAX - metadata_size);
 
-  const size_t digest = m_storage_metadata_get_digest(storage->hash_seed,
-      key->size, payload->size);
+  const size_t digest = m_storage_metadata_get_digest(storage, key->size,
+      payload->size);
 
   if (memcmp(ptr, &digest, sizeof(digest))) {
     /* Invalid digest. */
     return 0;
   }
 
-  ptr += sizeof(digest);
+  /* Skip item version. */
+  ptr += sizeof(digest) + sizeof(uint64_t);
   if (memcmp(ptr, key->ptr, key->size)) {
     /* Invalid key data. */
     return 0;
@@ -725,6 +849,75 @@ static int m_storage_metadata_check(const struct m_storage *const storage,
   return 1;
 }
 
+/*
+ * Returns a pointer to the value checksum in the metadata for an item
+ * with the given payload.
+ *
+ * Value checksums must be enabled.
+ */
+static char *m_storage_metadata_get_checksum_ptr(
+    const struct m_storage *const storage,
+    const struct m_storage_payload *const payload, const size_t key_size)
+{
+  assert(storage->has_value_checksums);
+
+  const size_t metadata_size = m_storage_metadata_get_size(storage, key_size);
+  assert(payload->size >= metadata_size);
+  assert(payload->cursor.offset <= SIZE_MAX - metadata_size);
+  (void)metadata_size;
+
+  return m_storage_get_ptr(storage,
+      payload->cursor.offset + sizeof(size_t) + sizeof(uint64_t) + key_size);
+}
+
+/*
+ * Calculates value checksum for an item with the given payload.
+ */
+static uint64_t m_storage_metadata_calculate_checksum(
+    const struct m_storage *const storage,
+    const struct m_storage_payload *const payload, const size_t key_size)
+{
+  const size_t metadata_size = m_storage_metadata_get_size(storage, key_size);
+  const char *const value_ptr = m_storage_get_ptr(storage,
+      payload->cursor.offset + metadata_size);
+  return m_storage_checksum_get(value_ptr, payload->size - metadata_size);
+}
+
+/*
+ * Stores value checksum in the metadata for an item with the given payload.
+ *
+ * Value checksums must be enabled.
+ */
+static void m_storage_metadata_save_checksum(
+    const struct m_storage *const storage,
+    const struct m_storage_payload *const payload, const size_t key_size)
+{
+  const uint64_t checksum = m_storage_metadata_calculate_checksum(storage,
+      payload, key_size);
+  char *const ptr = m_storage_metadata_get_checksum_ptr(storage, payload,
+      key_size);
+  memcpy(ptr, &checksum, sizeof(checksum));
+}
+
+/*
+ * Verifies value checksum for an item with the given payload.
+ *
+ * The function reads the whole value, so it is much slower than
+ * m_storage_metadata_check(). Value checksums must be enabled.
+ *
+ * Returns non-zero on successful check, zero on checksum mismatch.
+ */
+static int m_storage_metadata_check_checksum(
+    const struct m_storage *const storage,
+    const struct m_storage_payload *const payload, const size_t key_size)
+{
+  const uint64_t checksum = m_storage_metadata_calculate_checksum(storage,
+      payload, key_size);
+  const char *const ptr = m_storage_metadata_get_checksum_ptr(storage, payload,
+      key_size);
+  return !memcmp(ptr, &checksum, sizeof(checksum));
+}
+
 
 /*******************************************************************************
  * Working set defragmentation API.
@@ -759,28 +952,6 @@ static void m_ws_fix_hot_data_size(size_t *const hot_data_size,
   }
 }
 
-/*
- * Defragments the given item, i.e. moves it into the front of storage's
- * free space.
- *
- * There is a race condition possible when another thread adds new item
- * with the given key before the defragmentation for this item is complete.
- * In this case new item will become overwritten by the old item after
- * the defragmentation is complete. But since this is a cache, not a persistent
- * storage, this should be OK - subsequent readers should notice old value
- * and overwrite it with new value.
- *
- * Since this operation can be quite costly, avoid performing it in hot paths.
- */
-static void m_ws_defragment(struct ybc *const cache,
-    const struct ybc_item *const item, const struct ybc_key *const key)
-{
-  struct ybc_value value;
-
-  ybc_item_get_value(item, &value);
-  (void)ybc_item_set(cache, key, &value);
-}
-
 /*
  * Checks whether the item pointed by the given payload should be defragmented.
  *
@@ -1410,6 +1581,13 @@ struct m_sync
    * A thread responsible for data syncing.
    */
   struct p_thread sync_thread;
+
+  /*
+   * The number of data flushes performed by the sync_thread.
+   *
+   * It is updated only by the sync_thread, so it may be read without locking.
+   */
+  uint64_t flushes_count;
 };
 
 /*
@@ -1529,6 +1707,7 @@ static void m_sync_thread_func(void *const ctx)
   while (!p_event_wait_with_timeout(&sc->stop_event, sc->sync_interval)) {
     m_sync_flush_data(sc->cache_lock, sc->storage, sc->acquired_items_head,
         sc->sync_cursor, sc->has_overwrite_protection);
+    p_atomic_add(&sc->flushes_count, 1);
   }
 
   m_sync_flush_data(sc->cache_lock, sc->storage, sc->acquired_items_head,
@@ -1548,6 +1727,7 @@ static void m_sync_init(struct m_sync *const sc,
   sc->storage = storage;
   sc->acquired_items_head = acquired_items_head;
   sc->cache_lock = cache_lock;
+  sc->flushes_count = 0;
 
   if (sync_interval > 0) {
     p_event_init(&sc->stop_event);
@@ -1745,6 +1925,9 @@ struct ybc_config
   size_t de_hashtable_size;
   uint64_t sync_interval;
   int has_overwrite_protection;
+  int has_value_checksums;
+  ybc_evict_callback evict_callback;
+  void *evict_callback_ctx;
 };
 
 size_t ybc_config_get_size(void)
@@ -1763,6 +1946,9 @@ void ybc_config_init(struct ybc_config *const config)
   config->de_hashtable_size = C_CONFIG_DEFAULT_DE_HASHTABLE_SIZE;
   config->sync_interval = C_CONFIG_DEFAULT_SYNC_INTERVAL;
   config->has_overwrite_protection = 1;
+  config->has_value_checksums = 0;
+  config->evict_callback = NULL;
+  config->evict_callback_ctx = NULL;
 }
 
 void ybc_config_destroy(struct ybc_config *const config)
@@ -1828,6 +2014,18 @@ void ybc_config_disable_overwrite_protection(struct ybc_config *const config)
   config->has_overwrite_protection = 0;
 }
 
+void ybc_config_enable_value_checksums(struct ybc_config *const config)
+{
+  config->has_value_checksums = 1;
+}
+
+void ybc_config_set_evict_callback(struct ybc_config *const config,
+    const ybc_evict_callback callback, void *const ctx)
+{
+  config->evict_callback = callback;
+  config->evict_callback_ctx = ctx;
+}
+
 
 /*******************************************************************************
  * Cache management API
@@ -1844,8 +2042,27 @@ struct ybc
   struct m_de de;
   struct ybc_item acquired_items_head;
   struct ybc_item acquired_items_tail;
+  struct ybc_stats stats;
+  size_t initial_wrap_count;
   size_t hot_data_size;
   int has_overwrite_protection;
+
+  /*
+   * Serialize index updates for keys mapped to the same lock, so conditional
+   * updates such as ybc_item_set_if_version() and ybc_item_add() are atomic.
+   */
+  struct p_lock commit_locks[C_COMMIT_LOCKS_COUNT];
+
+  /*
+   * The last version assigned to an item. Guarded by the lock.
+   */
+  uint64_t last_version;
+
+  /*
+   * See ybc_config_set_evict_callback().
+   */
+  ybc_evict_callback evict_callback;
+  void *evict_callback_ctx;
 };
 
 static int m_open(struct ybc *const cache,
@@ -1858,6 +2075,9 @@ static int m_open(struct ybc *const cache,
 
   cache->has_overwrite_protection = config->has_overwrite_protection;
   cache->storage.size = config->data_file_size;
+  cache->storage.has_value_checksums = config->has_value_checksums;
+  cache->evict_callback = config->evict_callback;
+  cache->evict_callback_ctx = config->evict_callback_ctx;
   m_storage_fix_size(&cache->storage.size);
 
   size_t map_slots_count = config->map_slots_count;
@@ -1877,6 +2097,7 @@ static int m_open(struct ybc *const cache,
 
   cache->storage.next_cursor = *next_cursor;
   cache->storage.hash_seed = *cache->index.hash_seed_ptr;
+  cache->initial_wrap_count = next_cursor->wrap_count;
 
   if (!m_storage_open(&cache->storage, &cache->storage_file, config->data_file,
       force, &is_storage_file_created)) {
@@ -1891,10 +2112,19 @@ static int m_open(struct ybc *const cache,
       &cache->acquired_items_tail, cache->storage.size);
 
   /*
-   * Do not move initialization of the lock above, because it must be destroyed
+   * Do not move initialization of locks above, because they must be destroyed
    * in the error paths above, i.e. more lines of code is required.
    */
   p_lock_init(&cache->lock);
+  for (size_t i = 0; i < C_COMMIT_LOCKS_COUNT; ++i) {
+    p_lock_init(&cache->commit_locks[i]);
+  }
+
+  /*
+   * The version counter isn't persisted, so start it from the current time
+   * in order to minimize chances of version reuse after cache re-opening.
+   */
+  cache->last_version = p_get_current_time() * 1000 * 1000;
 
   m_sync_init(&cache->sc, config->sync_interval, next_cursor, &cache->storage,
       &cache->acquired_items_head, &cache->lock,
@@ -1904,6 +2134,8 @@ static int m_open(struct ybc *const cache,
   cache->hot_data_size = config->hot_data_size;
   m_ws_fix_hot_data_size(&cache->hot_data_size, cache->storage.size);
 
+  memset(&cache->stats, 0, sizeof(cache->stats));
+
   return 1;
 }
 
@@ -1935,6 +2167,9 @@ void ybc_close(struct ybc *const cache)
 
   m_sync_destroy(&cache->sc);
 
+  for (size_t i = 0; i < C_COMMIT_LOCKS_COUNT; ++i) {
+    p_lock_destroy(&cache->commit_locks[i]);
+  }
   p_lock_destroy(&cache->lock);
 
   m_storage_close(&cache->storage, &cache->storage_file);
@@ -1944,6 +2179,21 @@ void ybc_close(struct ybc *const cache)
 
 void ybc_clear(struct ybc *const cache)
 {
+  if (cache->evict_callback != NULL) {
+    /*
+     * The cleared items cannot be found after the hash seed change,
+     * so report them beforehand.
+     */
+    struct ybc_item item;
+    struct ybc_key key;
+    size_t cursor = 0;
+
+    while (ybc_iterate(cache, &item, &key, &cursor)) {
+      cache->evict_callback(cache->evict_callback_ctx, &key, YBC_EVICT_CLEAR);
+      ybc_item_release(&item);
+    }
+  }
+
   /*
    * New hash seed automatically invalidates all the items stored in the cache.
    */
@@ -1958,6 +2208,51 @@ void ybc_remove(const struct ybc_config *const config)
   m_file_remove_if_exists(config->data_file);
 }
 
+void ybc_get_stats(struct ybc *const cache, struct ybc_stats *const stats)
+{
+  /*
+   * Counters are updated atomically without holding cache lock, so they
+   * may be mutually inconsistent under concurrent access. This is OK
+   * for statistics.
+   */
+  const struct ybc_stats *const s = &cache->stats;
+  stats->gets = p_atomic_load(&s->gets);
+  stats->hits = p_atomic_load(&s->hits);
+  stats->misses = p_atomic_load(&s->misses);
+  stats->sets = p_atomic_load(&s->sets);
+  stats->removes = p_atomic_load(&s->removes);
+  stats->set_txn_rollbacks = p_atomic_load(&s->set_txn_rollbacks);
+  stats->de_waits = p_atomic_load(&s->de_waits);
+  stats->defragmentations = p_atomic_load(&s->defragmentations);
+  stats->checksum_mismatches = p_atomic_load(&s->checksum_mismatches);
+  stats->evictions = p_atomic_load(&s->evictions);
+
+  p_lock_lock(&cache->lock);
+  stats->storage_wraps = cache->storage.next_cursor.wrap_count -
+      cache->initial_wrap_count;
+  p_lock_unlock(&cache->lock);
+
+  stats->sync_flushes = p_atomic_load(&cache->sc.flushes_count);
+}
+
+void ybc_get_occupancy(struct ybc *const cache,
+    struct ybc_occupancy *const occupancy)
+{
+  struct ybc_item item;
+  struct ybc_key key;
+  size_t cursor = 0;
+
+  occupancy->items_count = 0;
+  occupancy->used_size = 0;
+  while (ybc_iterate(cache, &item, &key, &cursor)) {
+    ++occupancy->items_count;
+    occupancy->used_size += item.payload.size;
+    ybc_item_release(&item);
+  }
+
+  occupancy->data_file_size = cache->storage.size;
+}
+
 
 /*******************************************************************************
  * 'Add' transaction API.
@@ -1969,9 +2264,104 @@ struct ybc_set_txn
   struct ybc_item item;
 };
 
+/*
+ * Returns the lock serializing index updates for the given key_digest.
+ */
+static struct p_lock *m_cache_get_commit_lock(struct ybc *const cache,
+    const struct m_key_digest *const key_digest)
+{
+  return &cache->commit_locks[m_key_digest_mod(key_digest,
+      C_COMMIT_LOCKS_COUNT)];
+}
+
+/*
+ * Adds the given payload with the given key_digest into the cache index.
+ *
+ * Index updates must be performed only via this function
+ * and m_cache_map_remove(), so they are serialized with conditional updates.
+ */
+static void m_cache_map_set(struct ybc *const cache,
+    const struct m_key_digest *const key_digest,
+    const struct m_storage_payload *const payload)
+{
+  struct p_lock *const commit_lock = m_cache_get_commit_lock(cache,
+      key_digest);
+  p_lock_lock(commit_lock);
+  m_map_cache_set(&cache->index.map, &cache->index.map_cache, key_digest,
+      payload);
+  p_lock_unlock(commit_lock);
+}
+
+static int m_cache_map_remove(struct ybc *const cache,
+    const struct m_key_digest *const key_digest)
+{
+  struct p_lock *const commit_lock = m_cache_get_commit_lock(cache,
+      key_digest);
+  p_lock_lock(commit_lock);
+  const int is_removed = m_map_cache_remove(&cache->index.map,
+      &cache->index.map_cache, key_digest);
+  p_lock_unlock(commit_lock);
+  return is_removed;
+}
+
+/*
+ * Removes a stale payload with the given key_digest from the map cache.
+ *
+ * The map cache may obtain a stale payload if the item is concurrently
+ * updated while being added into the map cache in m_map_cache_get().
+ * The stale payload remains in the map cache until the next update
+ * of the item, so conditional updates relying on the version of the stale
+ * item would fail until then.
+ */
+static void m_cache_map_cache_invalidate(struct ybc *const cache,
+    const struct m_key_digest *const key_digest)
+{
+  const struct m_map *const map_cache = &cache->index.map_cache;
+  struct m_storage_payload cached_payload, payload;
+
+  if (map_cache->slots_count == 0) {
+    return;
+  }
+
+  struct p_lock *const commit_lock = m_cache_get_commit_lock(cache,
+      key_digest);
+  p_lock_lock(commit_lock);
+  if (m_map_get(map_cache, key_digest, &cached_payload) &&
+      (!m_map_get(&cache->index.map, key_digest, &payload) ||
+          !m_storage_payload_equal(&cached_payload, &payload))) {
+    (void)m_map_remove(map_cache, key_digest);
+  }
+  p_lock_unlock(commit_lock);
+}
+
+/*
+ * Removes the given payload with the given key_digest from the map only if
+ * the map still contains the payload for the key_digest.
+ *
+ * Returns non-zero if the payload has been removed.
+ */
+static int m_cache_map_remove_payload(struct ybc *const cache,
+    const struct m_key_digest *const key_digest,
+    const struct m_storage_payload *const payload)
+{
+  struct m_storage_payload current_payload;
+
+  struct p_lock *const commit_lock = m_cache_get_commit_lock(cache,
+      key_digest);
+  p_lock_lock(commit_lock);
+  const int is_removed = m_map_get(&cache->index.map, key_digest,
+      &current_payload) &&
+      m_storage_payload_equal(&current_payload, payload) &&
+      m_map_cache_remove(&cache->index.map, &cache->index.map_cache,
+          key_digest);
+  p_lock_unlock(commit_lock);
+  return is_removed;
+}
+
 static size_t m_item_get_offset(const struct ybc_item *const item)
 {
-  const size_t metadata_size = m_storage_metadata_get_size(item->key_size);
+  const size_t metadata_size = m_storage_metadata_get_size(
+      &item->cache->storage, item->key_size);
   assert(item->payload.size >= metadata_size);
   const size_t offset = item->payload.cursor.offset;
   assert(offset <= SIZE_MAX - metadata_size);
@@ -1980,7 +2370,8 @@ static size_t m_item_get_offset(const struct ybc_item *const item)
 
 static size_t m_item_get_size(const struct ybc_item *const item)
 {
-  const size_t metadata_size = m_storage_metadata_get_size(item->key_size);
+  const size_t metadata_size = m_storage_metadata_get_size(
+      &item->cache->storage, item->key_size);
   assert(item->payload.size >= metadata_size);
   return item->payload.size - metadata_size;
 }
@@ -2001,6 +2392,12 @@ static uint64_t m_item_get_ttl(const struct ybc_item *const item)
   return item->payload.expiration_time - current_time;
 }
 
+static uint64_t m_item_get_expiration_time(const uint64_t ttl)
+{
+  const uint64_t current_time = p_get_current_time();
+  return (ttl > UINT64_MAX - current_time) ? UINT64_MAX : (ttl + current_time);
+}
+
 static void m_item_register(struct ybc_item *const item,
     struct ybc_item *const acquired_items_head)
 {
@@ -2056,24 +2453,24 @@ int ybc_set_txn_begin(struct ybc *const cache, struct ybc_set_txn *const txn,
   txn->item.key_size = key->size;
   txn->item.is_set_txn = 1;
 
-  const size_t metadata_size = m_storage_metadata_get_size(key->size);
+  const size_t metadata_size = m_storage_metadata_get_size(&cache->storage,
+      key->size);
   assert(value_size <= SIZE_MAX - metadata_size);
   txn->item.payload.size = metadata_size + value_size;
 
-  const uint64_t current_time = p_get_current_time();
-  txn->item.payload.expiration_time = (ttl > UINT64_MAX - current_time) ?
-      UINT64_MAX : (ttl + current_time);
+  txn->item.payload.expiration_time = m_item_get_expiration_time(ttl);
 
   p_lock_lock(&cache->lock);
   int is_success = m_storage_allocate(&cache->storage,
       &cache->acquired_items_head, &txn->item, cache->has_overwrite_protection);
+  const uint64_t version = ++cache->last_version;
   p_lock_unlock(&cache->lock);
 
   if (!is_success) {
     return 0;
   }
 
-  m_storage_metadata_save(&cache->storage, &txn->item.payload, key);
+  m_storage_metadata_save(&cache->storage, &txn->item.payload, key, version);
 
   return 1;
 }
@@ -2081,8 +2478,10 @@ int ybc_set_txn_begin(struct ybc *const cache, struct ybc_set_txn *const txn,
 void ybc_set_txn_update_value_size(struct ybc_set_txn *const txn,
     const size_t value_size)
 {
+  struct ybc *const cache = txn->item.cache;
   const size_t key_size = txn->item.key_size;
-  const size_t metadata_size = m_storage_metadata_get_size(key_size);
+  const size_t metadata_size = m_storage_metadata_get_size(&cache->storage,
+      key_size);
   struct m_storage_payload *const payload = &txn->item.payload;
 
   assert(payload->size >= metadata_size);
@@ -2090,8 +2489,6 @@ void ybc_set_txn_update_value_size(struct ybc_set_txn *const txn,
   const size_t old_payload_size = payload->size;
   payload->size = metadata_size + value_size;
 
-  struct ybc *const cache = txn->item.cache;
-
   m_storage_metadata_update_payload_size(&cache->storage, payload,
       old_payload_size, key_size);
 
@@ -2105,12 +2502,24 @@ void ybc_set_txn_update_value_size(struct ybc_set_txn *const txn,
   p_lock_unlock(&cache->lock);
 }
 
+static void m_set_txn_save_checksum(const struct ybc_set_txn *const txn)
+{
+  const struct m_storage *const storage = &txn->item.cache->storage;
+
+  if (storage->has_value_checksums) {
+    m_storage_metadata_save_checksum(storage, &txn->item.payload,
+        txn->item.key_size);
+  }
+}
+
 void ybc_set_txn_commit(struct ybc_set_txn *const txn)
 {
   struct ybc *const cache = txn->item.cache;
 
-  m_map_cache_set(&cache->index.map, &cache->index.map_cache, &txn->key_digest,
-      &txn->item.payload);
+  m_set_txn_save_checksum(txn);
+
+  p_atomic_add(&cache->stats.sets, 1);
+  m_cache_map_set(cache, &txn->key_digest, &txn->item.payload);
 
   m_item_release(&txn->item);
 }
@@ -2120,6 +2529,8 @@ void ybc_set_txn_commit_item(struct ybc_set_txn *const txn,
 {
   struct ybc *const cache = txn->item.cache;
 
+  m_set_txn_save_checksum(txn);
+
   if (cache->has_overwrite_protection) {
     p_lock_lock(&cache->lock);
     m_item_relocate(item, &txn->item);
@@ -2129,8 +2540,8 @@ void ybc_set_txn_commit_item(struct ybc_set_txn *const txn,
   }
   item->is_set_txn = 0;
 
-  m_map_cache_set(&cache->index.map, &cache->index.map_cache, &txn->key_digest,
-      &item->payload);
+  p_atomic_add(&cache->stats.sets, 1);
+  m_cache_map_set(cache, &txn->key_digest, &item->payload);
 }
 
 void ybc_set_txn_rollback(struct ybc_set_txn *const txn)
@@ -2146,6 +2557,7 @@ void ybc_set_txn_rollback(struct ybc_set_txn *const txn)
   }
   p_lock_unlock(&cache->lock);
 
+  p_atomic_add(&cache->stats.set_txn_rollbacks, 1);
   m_item_release(&txn->item);
 }
 
@@ -2161,57 +2573,378 @@ void ybc_set_txn_get_value(const struct ybc_set_txn *const txn,
  * Cache API.
  ******************************************************************************/
 
-static int m_item_acquire(struct ybc *const cache, struct ybc_item *const item,
-    const struct ybc_key *const key,
-    const struct m_key_digest *const key_digest)
+/*
+ * Verifies value checksum for the acquired item if value checksums
+ * are enabled.
+ *
+ * The item is evicted from the cache and released on checksum mismatch.
+ *
+ * Returns non-zero on successful check, zero on checksum mismatch.
+ */
+static int m_item_check_checksum(struct ybc *const cache,
+    struct ybc_item *const item, const struct m_key_digest *const key_digest)
 {
-  item->cache = cache;
-  item->key_size = key->size;
-  item->is_set_txn = 0;
-
-  if (!m_map_cache_get(&cache->index.map, &cache->index.map_cache,
-      key_digest, &item->payload)) {
-    return 0;
+  if (!cache->storage.has_value_checksums ||
+      m_storage_metadata_check_checksum(&cache->storage, &item->payload,
+          item->key_size)) {
+    return 1;
   }
 
-
   /*
-   * Race condition is possible when makin a copy of cache->storage.next_cursor
-   * if it is concurrently updated by other thread in m_storage_allocate().
-   * In this case copied next_cursor may have corrupted value. This is OK.
-   * Two innocent things may occur if next_cursor contains invalid value:
-   * - 'no item' may be returned for existing item;
-   * - superflouos defragmentation may occur for the item.
+   * The item's value is corrupted, so evict it from the cache.
    *
-   * This racy copy significantly improves scalability of 'get item' operation
-   * if overwrite protection is disabled ( cache->has_overwrite_protection = 0).
+   * Remove only the corrupted payload, so a new item with the same key
+   * concurrently stored in the cache isn't evicted. The mismatch is counted
+   * only once if multiple threads concurrently detect it.
    */
-  const struct m_storage_cursor next_cursor = cache->storage.next_cursor;
-
-  const uint64_t current_time = p_get_current_time();
-  if (!m_storage_payload_check(&cache->storage, &next_cursor, &item->payload,
-      current_time)) {
-    return 0;
-  }
-  if (cache->has_overwrite_protection) {
-    p_lock_lock(&cache->lock);
-    m_item_register(item, &cache->acquired_items_head);
-    p_lock_unlock(&cache->lock);
-  }
-
-  if (!m_storage_metadata_check(&cache->storage, &item->payload, key)) {
-    m_item_release(item);
-    return 0;
+  if (m_cache_map_remove_payload(cache, key_digest, &item->payload)) {
+    p_atomic_add(&cache->stats.checksum_mismatches, 1);
   }
-
-  if (m_ws_should_defragment(&cache->storage, &next_cursor, &item->payload,
-      cache->hot_data_size)) {
-    m_ws_defragment(cache, item, key);
+  else {
+    /*
+     * The corrupted payload may be obtained from the map cache, which
+     * hasn't been updated yet. See m_cache_map_cache_invalidate().
+     */
+    m_cache_map_cache_invalidate(cache, key_digest);
+  }
+  m_item_release(item);
+  return 0;
+}
+
+/*
+ * Reports the item pointed by the given payload to the evict callback
+ * and counts it in cache stats if it has been overwritten by newer items.
+ *
+ * The payload must fail m_storage_payload_check() on the lookup
+ * of the given key. The item is removed from the map, so it is reported
+ * only once.
+ */
+static void m_item_report_eviction(struct ybc *const cache,
+    const struct m_storage_payload *const payload,
+    const struct ybc_key *const key,
+    const struct m_key_digest *const key_digest, const uint64_t current_time)
+{
+  enum ybc_evict_reason reason = YBC_EVICT_EXPIRED;
+  if (payload->expiration_time >= current_time) {
+    /*
+     * Re-check the payload with consistent next_cursor, since the check
+     * could fail due to the racy copy of next_cursor.
+     * See m_item_acquire_payload() for details.
+     */
+    p_lock_lock(&cache->lock);
+    const struct m_storage_cursor next_cursor = cache->storage.next_cursor;
+    p_lock_unlock(&cache->lock);
+
+    if (m_storage_payload_check(&cache->storage, &next_cursor, payload,
+        current_time)) {
+      return;
+    }
+    reason = YBC_EVICT_WRAP;
+  }
+  else if (cache->evict_callback == NULL) {
+    /*
+     * Keep expired items in the map if they aren't reported,
+     * so ybc_item_get_stale() may return them.
+     */
+    return;
+  }
+
+  if (!m_cache_map_remove_payload(cache, key_digest, payload)) {
+    return;
+  }
+  if (reason == YBC_EVICT_WRAP) {
+    p_atomic_add(&cache->stats.evictions, 1);
+  }
+  if (cache->evict_callback != NULL) {
+    cache->evict_callback(cache->evict_callback_ctx, key, reason);
+  }
+}
+
+/*
+ * Acquires an item pointed by item->payload.
+ *
+ * Items expired less than stale_ttl milliseconds ago are acquired too.
+ *
+ * Returns 1 on success. Returns zero if the payload points to outdated,
+ * expired or broken item. Returns -1 if item's value is corrupted.
+ */
+static int m_item_acquire_payload(struct ybc *const cache,
+    struct ybc_item *const item, const struct ybc_key *const key,
+    const struct m_key_digest *const key_digest, const uint64_t stale_ttl)
+{
+  item->cache = cache;
+  item->key_size = key->size;
+  item->is_set_txn = 0;
+
+  /*
+   * Race condition is possible when makin a copy of cache->storage.next_cursor
+   * if it is concurrently updated by other thread in m_storage_allocate().
+   * In this case copied next_cursor may have corrupted value. This is OK.
+   * Two innocent things may occur if next_cursor contains invalid value:
+   * - 'no item' may be returned for existing item;
+   * - superflouos defragmentation may occur for the item.
+   *
+   * This racy copy significantly improves scalability of 'get item' operation
+   * if overwrite protection is disabled ( cache->has_overwrite_protection = 0).
+   */
+  const struct m_storage_cursor next_cursor = cache->storage.next_cursor;
+
+  const uint64_t current_time = p_get_current_time();
+  const uint64_t check_time = (current_time > stale_ttl) ?
+      (current_time - stale_ttl) : 0;
+  if (!m_storage_payload_check(&cache->storage, &next_cursor, &item->payload,
+      check_time)) {
+    m_item_report_eviction(cache, &item->payload, key, key_digest,
+        check_time);
+    return 0;
+  }
+  if (cache->has_overwrite_protection) {
+    p_lock_lock(&cache->lock);
+    m_item_register(item, &cache->acquired_items_head);
+    p_lock_unlock(&cache->lock);
+  }
+
+  if (!m_storage_metadata_check(&cache->storage, &item->payload, key)) {
+    m_item_release(item);
+    return 0;
+  }
+
+  if (!m_item_check_checksum(cache, item, key_digest)) {
+    return -1;
   }
 
   return 1;
 }
 
+static uint64_t m_item_get_version(const struct ybc_item *const item)
+{
+  return m_storage_metadata_get_version(&item->cache->storage, &item->payload);
+}
+
+/*
+ * Checks whether an item with the given key meets the given condition:
+ * - if version is NULL, then the item must be missing in the cache;
+ * - otherwise the item must exist in the cache and have the given version.
+ *
+ * The current item is looked up in the map bypassing the map cache, since
+ * the map cache may contain stale payloads. The observed map slot is stored
+ * into observed_payload and is_observed, so the caller may verify under
+ * the commit lock that the slot hasn't been changed since the check.
+ *
+ * Returns YBC_SET_SUCCESS if the condition is met.
+ */
+static enum ybc_set_status m_cache_check_version(struct ybc *const cache,
+    const struct ybc_key *const key,
+    const struct m_key_digest *const key_digest,
+    const uint64_t *const version,
+    struct m_storage_payload *const observed_payload, int *const is_observed)
+{
+  const struct m_map *const map = &cache->index.map;
+  struct ybc_item item;
+
+  for (;;) {
+    *is_observed = m_map_get(map, key_digest, observed_payload);
+    int is_found = 0;
+    uint64_t current_version = 0;
+
+    if (*is_observed) {
+      item.payload = *observed_payload;
+      const int rv = m_item_acquire_payload(cache, &item, key, key_digest, 0);
+      if (rv == -1) {
+        /*
+         * The corrupted item has been removed from the map. Look it up again.
+         */
+        continue;
+      }
+      if (rv == 1) {
+        is_found = 1;
+        current_version = m_item_get_version(&item);
+        m_item_release(&item);
+      }
+    }
+
+    if (version == NULL) {
+      return is_found ? YBC_SET_EXISTS : YBC_SET_SUCCESS;
+    }
+    if (!is_found) {
+      return YBC_SET_NOTFOUND;
+    }
+    if (current_version != *version) {
+      /*
+       * The version may be obtained from a stale item in the map cache.
+       */
+      m_cache_map_cache_invalidate(cache, key_digest);
+      return YBC_SET_EXISTS;
+    }
+    return YBC_SET_SUCCESS;
+  }
+}
+
+/*
+ * Returns non-zero if the map slot for the given key_digest remains the same
+ * as observed by m_cache_check_version().
+ *
+ * Must be called under the commit lock for the given key_digest.
+ */
+static int m_map_is_unchanged(const struct m_map *const map,
+    const struct m_key_digest *const key_digest,
+    const struct m_storage_payload *const observed_payload,
+    const int is_observed)
+{
+  struct m_storage_payload current_payload;
+  const int is_current = m_map_get(map, key_digest, &current_payload);
+  return (is_current == is_observed) &&
+      (!is_current ||
+          m_storage_payload_equal(&current_payload, observed_payload));
+}
+
+/*
+ * Commits the given 'set' transaction only if an item with the transaction's
+ * key meets the given condition. See m_cache_check_version() for details.
+ *
+ * Only the map is consulted under the commit lock, so storage data isn't
+ * accessed while holding the lock.
+ *
+ * The transaction is rolled back on failure.
+ */
+static enum ybc_set_status m_set_txn_commit_if(struct ybc_set_txn *const txn,
+    const uint64_t *const version)
+{
+  struct ybc *const cache = txn->item.cache;
+  const struct m_map *const map = &cache->index.map;
+  const struct ybc_key key = {
+      .ptr = m_storage_metadata_get_key_ptr(&cache->storage,
+          &txn->item.payload),
+      .size = txn->item.key_size,
+  };
+
+  m_set_txn_save_checksum(txn);
+
+  for (;;) {
+    struct m_storage_payload observed_payload;
+    int is_observed;
+    const enum ybc_set_status status = m_cache_check_version(cache, &key,
+        &txn->key_digest, version, &observed_payload, &is_observed);
+    if (status != YBC_SET_SUCCESS) {
+      ybc_set_txn_rollback(txn);
+      return status;
+    }
+
+    /*
+     * The item may be concurrently updated after the check, so verify
+     * the map slot hasn't been changed since the item has been observed.
+     */
+    struct p_lock *const commit_lock = m_cache_get_commit_lock(cache,
+        &txn->key_digest);
+    p_lock_lock(commit_lock);
+    const int is_unchanged = m_map_is_unchanged(map, &txn->key_digest,
+        &observed_payload, is_observed);
+    if (is_unchanged) {
+      m_map_cache_set(map, &cache->index.map_cache, &txn->key_digest,
+          &txn->item.payload);
+    }
+    p_lock_unlock(commit_lock);
+
+    if (is_unchanged) {
+      p_atomic_add(&cache->stats.sets, 1);
+      m_item_release(&txn->item);
+      return YBC_SET_SUCCESS;
+    }
+
+    /*
+     * The item has been concurrently updated after the check.
+     * Check it again.
+     */
+  }
+}
+
+/*
+ * Defragments the given item, i.e. moves it into the front of storage's
+ * free space.
+ *
+ * The moved item retains its' version. The item isn't moved if it has been
+ * concurrently updated by another thread.
+ *
+ * Since this operation can be quite costly, avoid performing it in hot paths.
+ *
+ * Returns non-zero if the item has been moved.
+ */
+static int m_ws_defragment(struct ybc *const cache,
+    const struct ybc_item *const item, const struct ybc_key *const key)
+{
+  struct ybc_set_txn txn;
+  struct ybc_value value;
+
+  ybc_item_get_value(item, &value);
+  if (!ybc_set_txn_begin(cache, &txn, key, value.size, value.ttl)) {
+    return 0;
+  }
+
+  const uint64_t version = m_item_get_version(item);
+  m_storage_metadata_set_version(&cache->storage, &txn.item.payload, version);
+
+  void *const dst = m_item_get_value_ptr(&txn.item);
+  memcpy(dst, value.ptr, value.size);
+  return (m_set_txn_commit_if(&txn, &version) == YBC_SET_SUCCESS);
+}
+
+/*
+ * Acquires the item with the given key.
+ *
+ * Lookups aren't counted in cache stats if is_counted is zero.
+ */
+static int m_item_acquire_counted(struct ybc *const cache,
+    struct ybc_item *const item, const struct ybc_key *const key,
+    const struct m_key_digest *const key_digest, const uint64_t stale_ttl,
+    const int is_counted)
+{
+  if (is_counted) {
+    p_atomic_add(&cache->stats.gets, 1);
+  }
+
+  if (!m_map_cache_get(&cache->index.map, &cache->index.map_cache,
+      key_digest, &item->payload)) {
+    if (is_counted) {
+      p_atomic_add(&cache->stats.misses, 1);
+    }
+    return 0;
+  }
+
+  const int rv = m_item_acquire_payload(cache, item, key, key_digest,
+      stale_ttl);
+  if (rv != 1) {
+    if (is_counted) {
+      p_atomic_add(&cache->stats.misses, 1);
+    }
+    return rv;
+  }
+  if (is_counted) {
+    p_atomic_add(&cache->stats.hits, 1);
+  }
+
+  /*
+   * Do not defragment stale items, since the defragmented item
+   * would obtain new expiration time.
+   *
+   * See m_item_acquire_payload() for details regarding the racy copy.
+   */
+  const struct m_storage_cursor next_cursor = cache->storage.next_cursor;
+  if ((stale_ttl == 0 || m_item_get_ttl(item) > 0) &&
+      m_ws_should_defragment(&cache->storage, &next_cursor, &item->payload,
+          cache->hot_data_size) && m_ws_defragment(cache, item, key)) {
+    p_atomic_add(&cache->stats.defragmentations, 1);
+  }
+
+  return 1;
+}
+
+static int m_item_acquire(struct ybc *const cache, struct ybc_item *const item,
+    const struct ybc_key *const key,
+    const struct m_key_digest *const key_digest, const uint64_t stale_ttl)
+{
+  return m_item_acquire_counted(cache, item, key, key_digest, stale_ttl, 1);
+}
+
 size_t ybc_item_get_size(void)
 {
   return sizeof(struct ybc_item);
@@ -2257,8 +2990,66 @@ int ybc_item_remove(struct ybc *const cache, const struct ybc_key *const key)
   struct m_key_digest key_digest;
 
   m_key_digest_get(&key_digest, cache->storage.hash_seed, key);
-  return m_map_cache_remove(&cache->index.map, &cache->index.map_cache,
-      &key_digest);
+  if (!m_cache_map_remove(cache, &key_digest)) {
+    return 0;
+  }
+  p_atomic_add(&cache->stats.removes, 1);
+
+  if (cache->evict_callback != NULL) {
+    cache->evict_callback(cache->evict_callback_ctx, key, YBC_EVICT_REMOVE);
+  }
+  return 1;
+}
+
+int ybc_item_touch(struct ybc *const cache, const struct ybc_key *const key,
+    const uint64_t ttl)
+{
+  const struct m_map *const map = &cache->index.map;
+  struct m_key_digest key_digest;
+  struct ybc_item item;
+
+  m_key_digest_get(&key_digest, cache->storage.hash_seed, key);
+
+  for (;;) {
+    /*
+     * Item's expiration time is stored in the map only, so there is no need
+     * in touching the storage. Just update the map slot if it hasn't been
+     * concurrently changed since the item has been checked.
+     * See m_set_txn_commit_if() for details.
+     */
+    struct m_storage_payload observed_payload;
+    if (!m_map_get(map, &key_digest, &observed_payload)) {
+      return 0;
+    }
+    item.payload = observed_payload;
+    const int rv = m_item_acquire_payload(cache, &item, key, &key_digest, 0);
+    if (rv == -1) {
+      continue;
+    }
+    if (rv == 0) {
+      return 0;
+    }
+    m_item_release(&item);
+
+    struct m_storage_payload new_payload = observed_payload;
+    new_payload.expiration_time = m_item_get_expiration_time(ttl);
+
+    struct p_lock *const commit_lock = m_cache_get_commit_lock(cache,
+        &key_digest);
+    p_lock_lock(commit_lock);
+    struct m_storage_payload current_payload;
+    const int is_unchanged = m_map_get(map, &key_digest, &current_payload) &&
+        m_storage_payload_equal(&current_payload, &observed_payload);
+    if (is_unchanged) {
+      m_map_cache_set(map, &cache->index.map_cache, &key_digest,
+          &new_payload);
+    }
+    p_lock_unlock(commit_lock);
+
+    if (is_unchanged) {
+      return 1;
+    }
+  }
 }
 
 int ybc_item_get(struct ybc *const cache, struct ybc_item *const item,
@@ -2267,7 +3058,7 @@ int ybc_item_get(struct ybc *const cache, struct ybc_item *const item,
   struct m_key_digest key_digest;
 
   m_key_digest_get(&key_digest, cache->storage.hash_seed, key);
-  return m_item_acquire(cache, item, key, &key_digest);
+  return m_item_acquire(cache, item, key, &key_digest, 0);
 }
 
 static uint64_t m_item_adjust_grace_ttl(const uint64_t grace_ttl)
@@ -2282,13 +3073,20 @@ static uint64_t m_item_adjust_grace_ttl(const uint64_t grace_ttl)
   return adjusted_grace_ttl;
 }
 
+/*
+ * Retries aren't counted in cache stats if is_retry is set, since the caller
+ * already has been counted as waiting for the item.
+ */
 static enum ybc_de_status m_item_acquire_de_async(struct ybc *const cache,
     struct ybc_item *const item, const struct ybc_key *const key,
-    const struct m_key_digest *const key_digest, const uint64_t grace_ttl)
+    const struct m_key_digest *const key_digest, const uint64_t grace_ttl,
+    const int is_retry)
 {
-  if (!m_item_acquire(cache, item, key, key_digest)) {
+  if (m_item_acquire_counted(cache, item, key, key_digest, 0,
+      !is_retry) != 1) {
     /*
-     * The item is missing in the cache.
+     * The item is missing in the cache. Corrupted items are evicted
+     * from the cache, so they are treated as missing.
      * Try registering the item in dogpile effect container. If the item
      * is successfully registered there, then allow the caller adding new item
      * by returning YBC_DE_NOTFOUND. Otherwise suggest the caller waiting
@@ -2298,6 +3096,9 @@ static enum ybc_de_status m_item_acquire_de_async(struct ybc *const cache,
       return YBC_DE_NOTFOUND;
     }
 
+    if (!is_retry) {
+      p_atomic_add(&cache->stats.de_waits, 1);
+    }
     return YBC_DE_WOULDBLOCK;
   }
 
@@ -2328,7 +3129,20 @@ enum ybc_de_status ybc_item_get_de_async(struct ybc *const cache,
   m_key_digest_get(&key_digest, cache->storage.hash_seed, key);
 
   return m_item_acquire_de_async(cache, item, key, &key_digest,
-      adjusted_grace_ttl);
+      adjusted_grace_ttl, 0);
+}
+
+enum ybc_de_status ybc_item_get_de_async_retry(struct ybc *const cache,
+    struct ybc_item *const item, const struct ybc_key *const key,
+    const uint64_t grace_ttl)
+{
+  struct m_key_digest key_digest;
+  const uint64_t adjusted_grace_ttl = m_item_adjust_grace_ttl(grace_ttl);
+
+  m_key_digest_get(&key_digest, cache->storage.hash_seed, key);
+
+  return m_item_acquire_de_async(cache, item, key, &key_digest,
+      adjusted_grace_ttl, 1);
 }
 
 enum ybc_de_status ybc_item_get_de(struct ybc *const cache,
@@ -2340,12 +3154,17 @@ enum ybc_de_status ybc_item_get_de(struct ybc *const cache,
 
   m_key_digest_get(&key_digest, cache->storage.hash_seed, key);
 
+  /*
+   * The wait is counted in cache stats only once by the first attempt.
+   */
+  int is_retry = 0;
   for (;;) {
     enum ybc_de_status status = m_item_acquire_de_async(cache, item, key,
-        &key_digest, adjusted_grace_ttl);
+        &key_digest, adjusted_grace_ttl, is_retry);
     if (status != YBC_DE_WOULDBLOCK) {
       return status;
     }
+    is_retry = 1;
 
     /*
      * Though it looks like waiting on a condition (event) would be better
@@ -2359,6 +3178,36 @@ enum ybc_de_status ybc_item_get_de(struct ybc *const cache,
   }
 }
 
+int ybc_item_get_stale(struct ybc *const cache, struct ybc_item *const item,
+    const struct ybc_key *const key, const uint64_t stale_ttl,
+    int *const is_stale, int *const should_refresh)
+{
+  struct m_key_digest key_digest;
+
+  m_key_digest_get(&key_digest, cache->storage.hash_seed, key);
+  *is_stale = 0;
+  *should_refresh = 0;
+
+  const int rv = m_item_acquire(cache, item, key, &key_digest, stale_ttl);
+  if (rv != 1) {
+    return rv;
+  }
+
+  if (m_item_get_ttl(item) == 0) {
+    /*
+     * The item is expired. Try registering it in dogpile effect container.
+     * If the item is successfully registered there, then ask the caller
+     * refreshing the item. Otherwise the item is already being refreshed
+     * by somebody else, so just return the stale item.
+     */
+    const uint64_t grace_ttl = m_item_adjust_grace_ttl(stale_ttl);
+    *is_stale = 1;
+    *should_refresh = m_de_item_register(&cache->de, &key_digest, grace_ttl);
+  }
+
+  return 1;
+}
+
 void ybc_item_release(struct ybc_item *const item)
 {
   m_item_release(item);
@@ -2373,6 +3222,366 @@ void ybc_item_get_value(const struct ybc_item *const item,
 }
 
 
+/*******************************************************************************
+ * Optimistic concurrency control API.
+ ******************************************************************************/
+
+uint64_t ybc_item_get_version(const struct ybc_item *const item)
+{
+  return m_item_get_version(item);
+}
+
+enum ybc_set_status ybc_set_txn_commit_if_version(
+    struct ybc_set_txn *const txn, const uint64_t version)
+{
+  return m_set_txn_commit_if(txn, &version);
+}
+
+enum ybc_set_status ybc_item_set_if_version(struct ybc *const cache,
+    const struct ybc_key *const key, const struct ybc_value *const value,
+    const uint64_t version)
+{
+  struct ybc_set_txn txn;
+
+  if (!ybc_set_txn_begin(cache, &txn, key, value->size, value->ttl)) {
+    return YBC_SET_NOSPACE;
+  }
+
+  void *const dst = m_item_get_value_ptr(&txn.item);
+  memcpy(dst, value->ptr, value->size);
+  return m_set_txn_commit_if(&txn, &version);
+}
+
+enum ybc_set_status ybc_item_remove_if_version(struct ybc *const cache,
+    const struct ybc_key *const key, const uint64_t version)
+{
+  const struct m_map *const map = &cache->index.map;
+  struct m_key_digest key_digest;
+
+  m_key_digest_get(&key_digest, cache->storage.hash_seed, key);
+
+  for (;;) {
+    struct m_storage_payload observed_payload;
+    int is_observed;
+    const enum ybc_set_status status = m_cache_check_version(cache, key,
+        &key_digest, &version, &observed_payload, &is_observed);
+    if (status != YBC_SET_SUCCESS) {
+      return status;
+    }
+
+    /*
+     * The item may be concurrently updated after the check, so remove it
+     * only if the map slot hasn't been changed since it has been observed.
+     */
+    struct p_lock *const commit_lock = m_cache_get_commit_lock(cache,
+        &key_digest);
+    p_lock_lock(commit_lock);
+    const int is_unchanged = m_map_is_unchanged(map, &key_digest,
+        &observed_payload, is_observed);
+    if (is_unchanged) {
+      (void)m_map_cache_remove(map, &cache->index.map_cache, &key_digest);
+    }
+    p_lock_unlock(commit_lock);
+
+    if (is_unchanged) {
+      p_atomic_add(&cache->stats.removes, 1);
+      if (cache->evict_callback != NULL) {
+        cache->evict_callback(cache->evict_callback_ctx, key,
+            YBC_EVICT_REMOVE);
+      }
+      return YBC_SET_SUCCESS;
+    }
+
+    /*
+     * The item has been concurrently updated after the check.
+     * Check it again.
+     */
+  }
+}
+
+enum ybc_set_status ybc_set_txn_commit_add(struct ybc_set_txn *const txn)
+{
+  return m_set_txn_commit_if(txn, NULL);
+}
+
+enum ybc_set_status ybc_item_add(struct ybc *const cache,
+    const struct ybc_key *const key, const struct ybc_value *const value)
+{
+  struct ybc_set_txn txn;
+
+  if (!ybc_set_txn_begin(cache, &txn, key, value->size, value->ttl)) {
+    return YBC_SET_NOSPACE;
+  }
+
+  void *const dst = m_item_get_value_ptr(&txn.item);
+  memcpy(dst, value->ptr, value->size);
+  return m_set_txn_commit_if(&txn, NULL);
+}
+
+
+/*******************************************************************************
+ * Counters API.
+ ******************************************************************************/
+
+/*
+ * The maximum number of decimal digits in uint64_t value.
+ */
+#define M_COUNTER_MAX_SIZE 20
+
+/*
+ * Parses decimal number from the given buffer.
+ *
+ * Returns non-zero on success. Returns zero if the buffer doesn't contain
+ * a valid decimal number fitting uint64_t.
+ */
+static int m_counter_parse(const char *const ptr, const size_t size,
+    uint64_t *const value)
+{
+  if (size == 0 || size > M_COUNTER_MAX_SIZE) {
+    return 0;
+  }
+
+  uint64_t n = 0;
+  for (size_t i = 0; i < size; ++i) {
+    if (ptr[i] < '0' || ptr[i] > '9') {
+      return 0;
+    }
+    const uint64_t digit = (uint64_t)(ptr[i] - '0');
+    if (n > (UINT64_MAX - digit) / 10) {
+      return 0;
+    }
+    n = n * 10 + digit;
+  }
+  *value = n;
+  return 1;
+}
+
+/*
+ * Formats the given value as decimal number into the given buffer, which
+ * must be at least M_COUNTER_MAX_SIZE bytes long.
+ *
+ * Returns the number of bytes written.
+ */
+static size_t m_counter_format(char *const ptr, uint64_t value)
+{
+  char buf[M_COUNTER_MAX_SIZE];
+  size_t i = sizeof(buf);
+
+  do {
+    buf[--i] = (char)('0' + value % 10);
+    value /= 10;
+  } while (value != 0);
+
+  const size_t size = sizeof(buf) - i;
+  memcpy(ptr, buf + i, size);
+  return size;
+}
+
+enum ybc_set_status ybc_item_incr(struct ybc *const cache,
+    const struct ybc_key *const key, const uint64_t delta,
+    const int is_decrement, uint64_t *const value)
+{
+  char item_buf[ybc_item_get_size()];
+  struct ybc_item *const item = (struct ybc_item *)item_buf;
+  struct ybc_value current_value;
+  struct ybc_set_txn txn;
+  char buf[M_COUNTER_MAX_SIZE];
+  uint64_t n;
+
+  for (;;) {
+    if (ybc_item_get(cache, item, key) != 1) {
+      return YBC_SET_NOTFOUND;
+    }
+    ybc_item_get_value(item, &current_value);
+    const uint64_t version = m_item_get_version(item);
+    const uint64_t expiration_time = item->payload.expiration_time;
+    const int is_number = m_counter_parse(current_value.ptr,
+        current_value.size, &n);
+    ybc_item_release(item);
+
+    if (!is_number) {
+      return YBC_SET_NOTNUMERIC;
+    }
+
+    if (is_decrement) {
+      /* Decrement saturates at zero like in memcache. */
+      n = (n > delta) ? (n - delta) : 0;
+    }
+    else {
+      /* Increment wraps around on overflow like in memcache. */
+      n += delta;
+    }
+
+    const size_t size = m_counter_format(buf, n);
+    if (!ybc_set_txn_begin(cache, &txn, key, size, current_value.ttl)) {
+      return YBC_SET_NOSPACE;
+    }
+    memcpy(m_item_get_value_ptr(&txn.item), buf, size);
+    txn.item.payload.expiration_time = expiration_time;
+
+    const enum ybc_set_status status = m_set_txn_commit_if(&txn, &version);
+    if (status != YBC_SET_EXISTS) {
+      if (status == YBC_SET_SUCCESS) {
+        *value = n;
+      }
+      return status;
+    }
+
+    /* The item has been concurrently modified. Try again. */
+  }
+}
+
+
+/*******************************************************************************
+ * Iteration API.
+ ******************************************************************************/
+
+/*
+ * Acquires an item pointed by the map slot with the given index.
+ *
+ * Returns non-zero on success. Returns zero if the slot is empty or points
+ * to outdated, expired or broken item.
+ */
+static int m_item_acquire_by_slot(struct ybc *const cache,
+    struct ybc_item *const item, struct ybc_key *const key,
+    const size_t slot_index)
+{
+  const struct m_map *const map = &cache->index.map;
+  assert(slot_index < map->slots_count);
+
+  const struct m_key_digest key_digest = map->key_digests[slot_index];
+  if (m_key_digest_is_empty(&key_digest)) {
+    return 0;
+  }
+
+  item->cache = cache;
+  item->is_set_txn = 0;
+  item->payload = map->payloads[slot_index];
+
+  /*
+   * See m_item_acquire_payload() for details regarding the racy copy
+   * of next_cursor.
+   */
+  const struct m_storage_cursor next_cursor = cache->storage.next_cursor;
+
+  const uint64_t current_time = p_get_current_time();
+  if (!m_storage_payload_check(&cache->storage, &next_cursor, &item->payload,
+      current_time)) {
+    return 0;
+  }
+  if (cache->has_overwrite_protection) {
+    p_lock_lock(&cache->lock);
+    m_item_register(item, &cache->acquired_items_head);
+    p_lock_unlock(&cache->lock);
+  }
+
+  if (!m_storage_metadata_get_key(&cache->storage, &item->payload, key)) {
+    m_item_release(item);
+    return 0;
+  }
+  item->key_size = key->size;
+
+  /*
+   * Make sure the item belongs to the slot. This filters out items
+   * invalidated by ybc_clear() and slots overwritten concurrently.
+   */
+  struct m_key_digest actual_key_digest;
+  m_key_digest_get(&actual_key_digest, cache->storage.hash_seed, key);
+  if (!m_key_digest_equal(&actual_key_digest, &key_digest)) {
+    m_item_release(item);
+    return 0;
+  }
+
+  return m_item_check_checksum(cache, item, &key_digest);
+}
+
+int ybc_iterate(struct ybc *const cache, struct ybc_item *const item,
+    struct ybc_key *const key, size_t *const cursor)
+{
+  const size_t slots_count = cache->index.map.slots_count;
+
+  while (*cursor < slots_count) {
+    const size_t slot_index = *cursor;
+    ++*cursor;
+    if (m_item_acquire_by_slot(cache, item, key, slot_index)) {
+      return 1;
+    }
+  }
+
+  return 0;
+}
+
+
+/*******************************************************************************
+ * Migration API.
+ ******************************************************************************/
+
+/*
+ * Copies the given item into the dst cache.
+ *
+ * The copy retains item's expiration time.
+ *
+ * Returns non-zero on success, zero if there is no space for the item
+ * in the dst cache.
+ */
+static int m_migrate_item(struct ybc *const dst,
+    const struct ybc_item *const item, const struct ybc_key *const key)
+{
+  struct ybc_set_txn txn;
+  struct ybc_value value;
+
+  ybc_item_get_value(item, &value);
+  if (!ybc_set_txn_begin(dst, &txn, key, value.size, value.ttl)) {
+    return 0;
+  }
+  memcpy(m_item_get_value_ptr(&txn.item), value.ptr, value.size);
+  txn.item.payload.expiration_time = item->payload.expiration_time;
+  ybc_set_txn_commit(&txn);
+  return 1;
+}
+
+static int m_file_is_same(const char *const a, const char *const b)
+{
+  return (a != NULL && b != NULL && strcmp(a, b) == 0);
+}
+
+int ybc_migrate(const struct ybc_config *const old_config,
+    const struct ybc_config *const new_config)
+{
+  char item_buf[ybc_item_get_size()];
+  struct ybc_item *const item = (struct ybc_item *)item_buf;
+  struct ybc src, dst;
+  struct ybc_key key;
+  size_t cursor = 0;
+
+  if (m_file_is_same(old_config->index_file, new_config->index_file) ||
+      m_file_is_same(old_config->data_file, new_config->data_file)) {
+    return 0;
+  }
+
+  if (!ybc_open(&src, old_config, 0)) {
+    return 0;
+  }
+  if (!ybc_open(&dst, new_config, 1)) {
+    ybc_close(&src);
+    return 0;
+  }
+
+  while (ybc_iterate(&src, item, &key, &cursor)) {
+    /*
+     * Skip items, which don't fit the new cache. This is OK, since this
+     * is a cache, not a permanent storage.
+     */
+    (void)m_migrate_item(&dst, item, &key);
+    ybc_item_release(item);
+  }
+
+  ybc_close(&dst);
+  ybc_close(&src);
+  return 1;
+}
+
+
 /*******************************************************************************
  * Cache cluster API.
  ******************************************************************************/
@@ -2584,7 +3793,7 @@ int ybc_simple_get(struct ybc *const cache, const struct ybc_key *const key,
     struct ybc_value *const value)
 {
   struct ybc_item item;
-  if (!ybc_item_get(cache, &item, key)) {
+  if (ybc_item_get(cache, &item, key) != 1) {
     return 0;
   }
 
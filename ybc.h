@@ -254,6 +254,93 @@ YBC_API void ybc_config_set_sync_interval(struct ybc_config *config,
  */
 YBC_API void ybc_config_disable_overwrite_protection(struct ybc_config *config);
 
+/*
+ * Enables end-to-end checksums for items' values.
+ *
+ * By default checksums are disabled, so data file corruption may go unnoticed.
+ *
+ * If checksums are enabled, then a checksum of item's value is stored
+ * in the cache when the item is committed. The checksum is verified each time
+ * the item is acquired. Items with checksum mismatch are evicted
+ * from the cache and ybc_item_get() returns -1 for such items.
+ *
+ * Checksums have the following costs:
+ *   * each item occupies additional 8 bytes in the data file;
+ *   * the whole value is read and hashed on each item's store and lookup.
+ *
+ * Items stored with checksums disabled are invisible to a cache opened
+ * with checksums enabled and vice versa.
+ */
+YBC_API void ybc_config_enable_value_checksums(struct ybc_config *config);
+
+/*
+ * Reasons for item eviction passed to ybc_evict_callback.
+ */
+enum ybc_evict_reason
+{
+  /*
+   * The item has been overwritten by newer items, since the storage
+   * is a ring buffer.
+   */
+  YBC_EVICT_WRAP,
+
+  /*
+   * The item has been expired.
+   */
+  YBC_EVICT_EXPIRED,
+
+  /*
+   * The item has been removed via ybc_item_remove()
+   * or ybc_item_remove_if_version().
+   */
+  YBC_EVICT_REMOVE,
+
+  /*
+   * The item has been removed via ybc_clear().
+   */
+  YBC_EVICT_CLEAR,
+};
+
+/*
+ * Cache item's key. See the definition below.
+ */
+struct ybc_key;
+
+/*
+ * Callback, which is called for each evicted item.
+ *
+ * ctx is the value passed to ybc_config_set_evict_callback().
+ * The key is valid only until the callback returns.
+ */
+typedef void (*ybc_evict_callback)(void *ctx, const struct ybc_key *key,
+    enum ybc_evict_reason reason);
+
+/*
+ * Sets a callback, which is called for each evicted item.
+ *
+ * By default the callback isn't set, so items are evicted silently.
+ *
+ * The callback is called synchronously by the thread, which notices
+ * the eviction:
+ *   * ybc_item_remove() and ybc_item_remove_if_version() report the removed
+ *     item;
+ *   * ybc_clear() reports all the live items in the cache. This requires
+ *     walking the whole cache, so ybc_clear() is no longer instant. Items
+ *     added concurrently with ybc_clear() may be cleared without reporting;
+ *   * item lookups report expired items and items overwritten by newer items.
+ *     The storage doesn't keep track of overwritten items' keys, so such items
+ *     are reported lazily on the first lookup of their keys. Items, which
+ *     aren't looked up after the eviction, aren't reported.
+ *
+ * Each evicted item is reported at most once. Items replaced by newer values
+ * under the same key aren't reported.
+ *
+ * The callback may be called concurrently by multiple threads. The callback
+ * may access the cache.
+ */
+YBC_API void ybc_config_set_evict_callback(struct ybc_config *config,
+    ybc_evict_callback callback, void *ctx);
+
 
 /*******************************************************************************
  * Cache management API.
@@ -321,7 +408,8 @@ YBC_API void ybc_close(struct ybc *cache);
  * Discards all the items in the cache.
  *
  * This function is very fast and its' speed doesn't depend on the number
- * and the size of items stored in the cache.
+ * and the size of items stored in the cache unless evict callback is set.
+ * See ybc_config_set_evict_callback() for details.
  *
  * Unlike ybc_remove(), this function doesn't remove files associated
  * with the cache.
@@ -337,6 +425,131 @@ YBC_API void ybc_clear(struct ybc *cache);
  */
 YBC_API void ybc_remove(const struct ybc_config *config);
 
+/*
+ * Cache statistics, which is returned by ybc_get_stats().
+ *
+ * All the counters are accumulated since the cache has been opened.
+ */
+struct ybc_stats
+{
+  /*
+   * The number of item lookups, i.e. gets = hits + misses.
+   */
+  uint64_t gets;
+
+  /*
+   * The number of lookups, which found the item in the cache.
+   */
+  uint64_t hits;
+
+  /*
+   * The number of lookups, which didn't find the item in the cache.
+   */
+  uint64_t misses;
+
+  /*
+   * The number of items stored in the cache via committed 'set' transactions.
+   *
+   * Items re-stored by working set defragmentation are counted here too.
+   */
+  uint64_t sets;
+
+  /*
+   * The number of items removed via ybc_item_remove()
+   * and ybc_item_remove_if_version().
+   */
+  uint64_t removes;
+
+  /*
+   * The number of rolled back 'set' transactions.
+   */
+  uint64_t set_txn_rollbacks;
+
+  /*
+   * The number of times ybc_item_get_de*() had to wait for an item,
+   * which is being built by another thread.
+   */
+  uint64_t de_waits;
+
+  /*
+   * The number of items moved to the front of the storage by working set
+   * defragmentation. See ybc_config_set_hot_data_size() for details.
+   */
+  uint64_t defragmentations;
+
+  /*
+   * The number of items evicted due to value checksum mismatch.
+   * See ybc_config_enable_value_checksums() for details.
+   *
+   * Lookups for such items are counted as misses too.
+   */
+  uint64_t checksum_mismatches;
+
+  /*
+   * The number of items evicted by newer items, since the storage is a ring
+   * buffer.
+   *
+   * The storage doesn't keep track of evicted items' keys, so evicted items
+   * are counted lazily on the first lookup of their keys. Items, which
+   * aren't looked up after the eviction, aren't counted.
+   * See also storage_wraps.
+   */
+  uint64_t evictions;
+
+  /*
+   * The number of times the storage wrapped around its' end.
+   *
+   * Each wrap evicts old items from the beginning of data file.
+   */
+  uint64_t storage_wraps;
+
+  /*
+   * The number of data flushes performed by the syncing thread.
+   * See ybc_config_set_sync_interval() for details.
+   */
+  uint64_t sync_flushes;
+};
+
+/*
+ * Populates stats with the current statistics for the given cache.
+ *
+ * Counters are updated without synchronization in order to keep cache
+ * operations fast, so they may be slightly inaccurate under concurrent access.
+ */
+YBC_API void ybc_get_stats(struct ybc *cache, struct ybc_stats *stats);
+
+/*
+ * Cache occupancy, which is returned by ybc_get_occupancy().
+ */
+struct ybc_occupancy
+{
+  /*
+   * The number of live items in the cache.
+   */
+  size_t items_count;
+
+  /*
+   * The total size of live items in the data file, including keys
+   * and items' metadata.
+   */
+  size_t used_size;
+
+  /*
+   * The data file size.
+   */
+  size_t data_file_size;
+};
+
+/*
+ * Populates occupancy for the given cache.
+ *
+ * Unlike ybc_get_stats(), this function scans the whole cache index,
+ * so its' running time is proportional to the maximum number of items
+ * in the cache. Avoid calling it in hot paths.
+ */
+YBC_API void ybc_get_occupancy(struct ybc *cache,
+    struct ybc_occupancy *occupancy);
+
 
 /*******************************************************************************
  * 'Add' transaction API.
@@ -541,8 +754,9 @@ YBC_API void ybc_set_txn_get_value(const struct ybc_set_txn *txn,
  * struct ybc_value value;
  * size_t item_size;
  * ...
- * if (!ybc_item_get(cache, item, &key)) {
- *   // The value is missing in the cache.
+ * if (ybc_item_get(cache, item, &key) != 1) {
+ *   // The value is missing in the cache or it has been evicted due to
+ *   // corruption.
  *   // Build new value (i.e. obtain it from backends, prepare, serialize, etc.)
  *   // and insert it into the cache.
  *   build_new_value(&value);
@@ -593,6 +807,41 @@ enum ybc_de_status
   YBC_DE_WOULDBLOCK,
 };
 
+/*
+ * Status returned by conditional 'set' functions such as
+ * ybc_item_set_if_version(). It is also returned by
+ * ybc_item_remove_if_version().
+ */
+enum ybc_set_status
+{
+  /*
+   * The item cannot be stored in the cache due to lack of space.
+   */
+  YBC_SET_NOSPACE,
+
+  /*
+   * The item has been stored in the cache.
+   */
+  YBC_SET_SUCCESS,
+
+  /*
+   * The item with the given key is missing in the cache.
+   */
+  YBC_SET_NOTFOUND,
+
+  /*
+   * The item with the given key exists in the cache, but it doesn't satisfy
+   * the condition. For instance, it has distinct version or it is passed
+   * to ybc_item_add().
+   */
+  YBC_SET_EXISTS,
+
+  /*
+   * The item's value isn't a decimal number. See ybc_item_incr().
+   */
+  YBC_SET_NOTNUMERIC,
+};
+
 /*
  * Returns the size of ybc_item structure in bytes.
  *
@@ -666,11 +915,26 @@ YBC_API int ybc_item_set_item(struct ybc *cache, struct ybc_item *item,
  */
 YBC_API int ybc_item_remove(struct ybc *cache, const struct ybc_key *key);
 
+/*
+ * Sets ttl in milliseconds for an item with the given key.
+ *
+ * Unlike ybc_item_set(), this function doesn't copy item's value, so it is
+ * cheap even for large items. Item's value and version remain unchanged.
+ * Set ttl to YBC_MAX_TTL for items without expiration time.
+ *
+ * Returns zero if the item wasn't in the cache, otherwise returns non-zero.
+ */
+YBC_API int ybc_item_touch(struct ybc *cache, const struct ybc_key *key,
+    uint64_t ttl);
+
 /*
  * Acquires an item with the given key.
  *
- * Returns non-zero on success.
+ * Returns 1 on success.
  * Returns zero if an item with the given key isn't found.
+ * Returns -1 if item's value is corrupted. Such item is evicted
+ * from the cache. This is possible only if value checksums are enabled
+ * via ybc_config_enable_value_checksums().
  *
  * Item's value can be obtained via ybc_item_get_value() call.
  *
@@ -748,6 +1012,52 @@ YBC_API enum ybc_de_status ybc_item_get_de(struct ybc *cache,
 YBC_API enum ybc_de_status ybc_item_get_de_async(struct ybc *cache,
     struct ybc_item *item, const struct ybc_key *key, uint64_t grace_ttl);
 
+/*
+ * The same as ybc_item_get_de_async(), but must be used for re-trying
+ * to obtain the item after YBC_DE_WOULDBLOCK has been returned.
+ *
+ * Retries aren't counted in cache stats, so a caller waiting for the item
+ * is counted in ybc_stats.gets, ybc_stats.misses and ybc_stats.de_waits
+ * only once.
+ */
+YBC_API enum ybc_de_status ybc_item_get_de_async_retry(struct ybc *cache,
+    struct ybc_item *item, const struct ybc_key *key, uint64_t grace_ttl);
+
+/*
+ * Acquires an item with stale-while-revalidate semantics.
+ *
+ * The function is almost equivalent to ybc_item_get(), except that it also
+ * returns items, which expired less than stale_ttl milliseconds ago.
+ *
+ * is_stale is set to non-zero for expired items.
+ *
+ * The first caller obtaining a stale item receives non-zero should_refresh,
+ * so it must refresh the item. Other callers receive the stale item with zero
+ * should_refresh until the item is refreshed or stale_ttl period of time
+ * passes, i.e. only a single thread refreshes the item, while other threads
+ * aren't blocked. Pending items are tracked in the same container
+ * as in ybc_item_get_de(), so should_refresh isn't set if the item is
+ * being refreshed after YBC_DE_NOTFOUND returned by ybc_item_get_de*().
+ *
+ * Stale_ttl is set in milliseconds.
+ *
+ * Expired items are evicted by ybc_item_get*() lookups if evict callback
+ * is set via ybc_config_set_evict_callback(), so they cannot be returned
+ * by this function after that.
+ *
+ * Returns 1 on success. is_stale and should_refresh are set to zero
+ * for not-yet expired items.
+ * The ttl for stale items returned by ybc_item_get_value() is zero.
+ * Returns zero if an item with the given key isn't found or it expired
+ * more than stale_ttl milliseconds ago.
+ * Returns -1 if item's value is corrupted. See ybc_item_get() for details.
+ *
+ * Acquired items MUST be released with ybc_item_release().
+ */
+YBC_API int ybc_item_get_stale(struct ybc *cache, struct ybc_item *item,
+    const struct ybc_key *key, uint64_t stale_ttl, int *is_stale,
+    int *should_refresh);
+
 /*
  * Releases acquired item.
  *
@@ -776,6 +1086,270 @@ YBC_API void ybc_item_get_value(const struct ybc_item *item,
     struct ybc_value *value);
 
 
+/*******************************************************************************
+ * Optimistic concurrency control API.
+ *
+ * Each item stored in the cache has a version. The version changes whenever
+ * a new value is stored under the item's key. The API allows atomically
+ * updating the item only if it wasn't modified since it has been read,
+ * i.e. it provides compare-and-swap (CAS) semantics. It also allows
+ * atomically adding the item only if it is missing in the cache.
+ *
+ *
+ * Usage:
+ *
+ * char item_buf[ybc_item_get_size()];
+ * struct ybc_item *const item = (struct ybc_item *)item_buf;
+ * struct ybc_value value;
+ *
+ * for (;;) {
+ *   if (ybc_item_get(cache, item, &key) != 1) {
+ *     // The item is missing in the cache.
+ *     break;
+ *   }
+ *   const uint64_t version = ybc_item_get_version(item);
+ *   ybc_item_get_value(item, &value);
+ *   build_new_value(&value, &new_value);
+ *   ybc_item_release(item);
+ *
+ *   if (ybc_item_set_if_version(cache, &key, &new_value, version) !=
+ *       YBC_SET_EXISTS) {
+ *     // The item has been updated, evicted or there is no space for it.
+ *     break;
+ *   }
+ *   // The item has been concurrently modified. Try again.
+ * }
+ ******************************************************************************/
+
+/*
+ * Returns item's version.
+ *
+ * Versions are unique among items stored under the same key in the currently
+ * open cache. Versions are stored in items' metadata, so they survive cache
+ * re-opening. The version counter isn't persisted, but a re-opened cache starts
+ * it from the current time, so versions are unlikely to be reused.
+ *
+ * Moving items in the storage by working set defragmentation doesn't change
+ * their versions. See ybc_config_set_hot_data_size() for details.
+ */
+YBC_API uint64_t ybc_item_get_version(const struct ybc_item *item);
+
+/*
+ * Stores the given value with the given key in the cache only if the item
+ * with the given key exists in the cache and has the given version.
+ *
+ * The check and the store are performed atomically with respect to other
+ * modifications of the item.
+ *
+ * Returns YBC_SET_SUCCESS on success.
+ * Returns YBC_SET_NOTFOUND if the item with the given key isn't found.
+ * Returns YBC_SET_EXISTS if the item has distinct version.
+ * Returns YBC_SET_NOSPACE if there is no space for the value in the cache.
+ */
+YBC_API enum ybc_set_status ybc_item_set_if_version(struct ybc *cache,
+    const struct ybc_key *key, const struct ybc_value *value,
+    uint64_t version);
+
+/*
+ * Commits the given 'set' transaction only if the item with the transaction's
+ * key exists in the cache and has the given version.
+ *
+ * The transaction is rolled back if the condition isn't met, so it mustn't
+ * be used after this call irregardless of the returned status.
+ *
+ * Returns YBC_SET_SUCCESS on success.
+ * Returns YBC_SET_NOTFOUND if the item with the given key isn't found.
+ * Returns YBC_SET_EXISTS if the item has distinct version.
+ */
+YBC_API enum ybc_set_status ybc_set_txn_commit_if_version(
+    struct ybc_set_txn *txn, uint64_t version);
+
+/*
+ * Removes an item with the given key from the cache only if the item exists
+ * in the cache and has the given version.
+ *
+ * The check and the removal are performed atomically with respect to other
+ * modifications of the item.
+ *
+ * Returns YBC_SET_SUCCESS if the item has been removed.
+ * Returns YBC_SET_NOTFOUND if the item with the given key isn't found.
+ * Returns YBC_SET_EXISTS if the item has distinct version.
+ */
+YBC_API enum ybc_set_status ybc_item_remove_if_version(struct ybc *cache,
+    const struct ybc_key *key, uint64_t version);
+
+/*
+ * Stores the given value with the given key in the cache only if there is
+ * no live item with the given key in the cache.
+ *
+ * Expired and evicted items aren't considered live, so they are overwritten.
+ * The check and the store are performed atomically with respect to other
+ * modifications of the item.
+ *
+ * Returns YBC_SET_SUCCESS on success.
+ * Returns YBC_SET_EXISTS if the item with the given key exists in the cache.
+ * Returns YBC_SET_NOSPACE if there is no space for the value in the cache.
+ */
+YBC_API enum ybc_set_status ybc_item_add(struct ybc *cache,
+    const struct ybc_key *key, const struct ybc_value *value);
+
+/*
+ * Commits the given 'set' transaction only if there is no live item with
+ * the transaction's key in the cache.
+ *
+ * The transaction is rolled back if the condition isn't met, so it mustn't
+ * be used after this call irregardless of the returned status.
+ *
+ * Returns YBC_SET_SUCCESS on success.
+ * Returns YBC_SET_EXISTS if the item with the given key exists in the cache.
+ */
+YBC_API enum ybc_set_status ybc_set_txn_commit_add(struct ybc_set_txn *txn);
+
+
+/*******************************************************************************
+ * Counters API.
+ *
+ * Counters are items with values containing unsigned 64-bit integers
+ * in decimal ASCII representation without leading sign and trailing
+ * whitespace. This is compatible with memcache's incr/decr commands.
+ *
+ *
+ * Usage:
+ *
+ * const struct ybc_value value = {
+ *     .ptr = "0",
+ *     .size = 1,
+ *     .ttl = YBC_MAX_TTL,
+ * };
+ * if (!ybc_item_set(cache, &key, &value)) {
+ *   log_error("cannot create the counter");
+ * }
+ *
+ * uint64_t n;
+ * if (ybc_item_incr(cache, &key, 10, 0, &n) != YBC_SET_SUCCESS) {
+ *   log_error("cannot increment the counter");
+ * }
+ * // n contains 10 here.
+ ******************************************************************************/
+
+/*
+ * Atomically adds delta to (or subtracts delta from if is_decrement
+ * is non-zero) the counter stored under the given key.
+ *
+ * Increment wraps around on 64-bit overflow, while decrement stops at zero,
+ * i.e. it never underflows. The counter retains its' expiration time.
+ * The counter isn't created if it is missing in the cache.
+ *
+ * Concurrent increments and decrements of the same counter are never lost.
+ *
+ * Returns YBC_SET_SUCCESS on success and stores the new counter's value
+ * into value.
+ * Returns YBC_SET_NOTFOUND if the counter isn't found.
+ * Returns YBC_SET_NOTNUMERIC if the item's value isn't a decimal number.
+ * Returns YBC_SET_NOSPACE if there is no space for the counter in the cache.
+ */
+YBC_API enum ybc_set_status ybc_item_incr(struct ybc *cache,
+    const struct ybc_key *key, uint64_t delta, int is_decrement,
+    uint64_t *value);
+
+
+/*******************************************************************************
+ * Iteration API.
+ *
+ * The API allows walking live (i.e. not expired and not evicted) items
+ * in the cache. This may be useful for cache dumps, debugging and migrating
+ * items between caches.
+ *
+ *
+ * Usage:
+ *
+ * char item_buf[ybc_item_get_size()];
+ * struct ybc_item *const item = (struct ybc_item *)item_buf;
+ * struct ybc_key key;
+ * struct ybc_value value;
+ * size_t cursor = 0;
+ *
+ * while (ybc_iterate(cache, item, &key, &cursor)) {
+ *   ybc_item_get_value(item, &value);
+ *   use_key_and_value(&key, &value);
+ *   ybc_item_release(item);
+ * }
+ ******************************************************************************/
+
+/*
+ * Acquires the next live item in the cache starting from the given cursor.
+ *
+ * The cursor must be set to 0 before the first call. The function advances
+ * the cursor, so the next call returns the next item.
+ *
+ * Sets key to item's key. The key points to item's contents, so it may be used
+ * only until the item is released.
+ *
+ * Items added, removed or evicted concurrently with the iteration may be
+ * either skipped or returned.
+ *
+ * Returns non-zero on success. The acquired item MUST be released
+ * via ybc_item_release() call.
+ * Returns zero if there are no more items in the cache.
+ */
+YBC_API int ybc_iterate(struct ybc *cache, struct ybc_item *item,
+    struct ybc_key *key, size_t *cursor);
+
+
+/*******************************************************************************
+ * Migration API.
+ *
+ * The API allows changing cache geometry (i.e. max_items_count
+ * and data_file_size) without losing cached items. Opening existing cache
+ * with distinct geometry via ybc_open(force=1) just drops all the items.
+ *
+ *
+ * Usage:
+ *
+ * char configs_buf[ybc_config_get_size() * 2];
+ * struct ybc_config *const old_config = YBC_CONFIG_GET(configs_buf, 0);
+ * struct ybc_config *const new_config = YBC_CONFIG_GET(configs_buf, 1);
+ *
+ * ybc_config_init(old_config);
+ * ybc_config_set_max_items_count(old_config, 1000 * 1000);
+ * ybc_config_set_data_file_size(old_config, (size_t)1024 * 1024 * 1024);
+ * ybc_config_set_index_file(old_config, "/ssd/cache.index");
+ * ybc_config_set_data_file(old_config, "/ssd/cache.data");
+ *
+ * ybc_config_init(new_config);
+ * ybc_config_set_max_items_count(new_config, 10 * 1000 * 1000);
+ * ybc_config_set_data_file_size(new_config, (size_t)10 * 1024 * 1024 * 1024);
+ * ybc_config_set_index_file(new_config, "/ssd/cache.index.new");
+ * ybc_config_set_data_file(new_config, "/ssd/cache.data.new");
+ *
+ * if (!ybc_migrate(old_config, new_config)) {
+ *   log_error("cannot migrate the cache");
+ * }
+ * ybc_remove(old_config);
+ *
+ * ybc_config_destroy(new_config);
+ * ybc_config_destroy(old_config);
+ ******************************************************************************/
+
+/*
+ * Copies all the live items from the cache with old_config into the cache
+ * with new_config.
+ *
+ * The old cache must exist. The new cache is created if it is missing.
+ * Both caches mustn't be opened during the migration. The old cache files
+ * remain intact, so they may be removed with ybc_remove() after successful
+ * migration.
+ *
+ * Migrated items retain their expiration times. Items, which don't fit
+ * the new cache, are skipped.
+ *
+ * Returns non-zero on success, 0 if the old cache cannot be opened, the new
+ * cache cannot be created or both caches share the same files.
+ */
+YBC_API int ybc_migrate(const struct ybc_config *old_config,
+    const struct ybc_config *new_config);
+
+
 /*******************************************************************************
  * Cache cluster API.
  *